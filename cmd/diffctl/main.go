@@ -0,0 +1,498 @@
+// Command diffctl creates, downloads, lists and deletes the diffs of a running diffd
+// service through its HTTP API.
+//
+// Usage:
+//
+//	diffctl [-endpoint url] <command> [flags]
+//
+// The commands are create, wait, get, status, list and delete. Run a command with -h to list
+// its flags.
+//
+// Times are given either as RFC3339 timestamps, as now, or relative to now, such as now-24h
+// or -90m. Instead of the four bounds of a diff, -window selects the two consecutive windows
+// of that length which end at -end, so -window 24h compares yesterday with the day before.
+//
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/client"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/storage"
+)
+
+const (
+	defaultEndpoint = "http://localhost:8080"
+	endpointEnv     = "DIFFD_ENDPOINT"
+
+	formatDOT = "dot"
+)
+
+// errUsage is returned when a command is given invalid arguments. The usage of the command
+// has already been printed when it is returned.
+var errUsage = errors.New("invalid usage")
+
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, env *environment, args []string) error
+}
+
+var commands = []command{
+	{"create", "Queue a diff, optionally waiting for it and downloading it.", create},
+	{"wait", "Wait until a diff is complete.", wait},
+	{"get", "Download a complete diff.", get},
+	{"status", "Print the status of a diff.", status},
+	{"list", "List the diffs stored by the service.", list},
+	{"delete", "Delete a diff.", remove},
+}
+
+// environment is what a command runs against.
+type environment struct {
+	client *client.Client
+	stdout io.Writer
+	stderr io.Writer
+	now    time.Time
+}
+
+func usage(out io.Writer, flags *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(out, "Usage: diffctl [-endpoint url] <command> [flags]\n\nCommands:\n")
+		for _, c := range commands {
+			fmt.Fprintf(out, "  %-8s %s\n", c.name, c.summary)
+		}
+		fmt.Fprintf(out, "\nFlags:\n")
+		flags.PrintDefaults()
+	}
+}
+
+func main() {
+	ctx := context.Background()
+	err := run(ctx, os.Args[1:], os.Stdout, os.Stderr, time.Now())
+	switch err {
+	case nil:
+		return
+	case errUsage:
+		os.Exit(2)
+	}
+	fmt.Fprintln(os.Stderr, err.Error())
+	os.Exit(1)
+}
+
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer, now time.Time) error {
+	flags := flag.NewFlagSet("diffctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = usage(stderr, flags)
+	endpoint := flags.String("endpoint", "", fmt.Sprintf("The endpoint of the diffd API. Defaults to $%s, or %s.", endpointEnv, defaultEndpoint))
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errUsage
+	}
+	if *endpoint == "" {
+		*endpoint = os.Getenv(endpointEnv)
+	}
+	if *endpoint == "" {
+		*endpoint = defaultEndpoint
+	}
+	u, err := url.Parse(*endpoint)
+	if err != nil {
+		return err
+	}
+	env := &environment{
+		client: &client.Client{Endpoint: u, Client: http.DefaultClient},
+		stdout: stdout,
+		stderr: stderr,
+		now:    now,
+	}
+	name := flags.Arg(0)
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(ctx, env, flags.Args()[1:])
+		}
+	}
+	fmt.Fprintf(stderr, "unknown command %q\n\n", name)
+	flags.Usage()
+	return errUsage
+}
+
+// rangeFlags are the flags which select a diff by its time ranges.
+type rangeFlags struct {
+	previousStart *string
+	previousStop  *string
+	nextStart     *string
+	nextStop      *string
+	window        *time.Duration
+	end           *string
+}
+
+func addRangeFlags(flags *flag.FlagSet) *rangeFlags {
+	return &rangeFlags{
+		previousStart: flags.String("previous-start", "", "The start of the previous window."),
+		previousStop:  flags.String("previous-stop", "", "The stop of the previous window."),
+		nextStart:     flags.String("next-start", "", "The start of the next window."),
+		nextStop:      flags.String("next-stop", "", "The stop of the next window."),
+		window:        flags.Duration("window", 0, "The length of two consecutive windows ending at -end, used instead of the four bounds."),
+		end:           flags.String("end", "now", "The stop of the next window when -window is used."),
+	}
+}
+
+// diff returns the Diff selected by the flags.
+func (f *rangeFlags) diff(now time.Time) (domain.Diff, error) {
+	if *f.window > 0 {
+		if *f.previousStart != "" || *f.previousStop != "" || *f.nextStart != "" || *f.nextStop != "" {
+			return domain.Diff{}, errors.New("-window cannot be combined with the bounds of the windows")
+		}
+		end, err := parseTime(*f.end, now)
+		if err != nil {
+			return domain.Diff{}, err
+		}
+		return domain.Diff{
+			PreviousStart: end.Add(-2 * *f.window),
+			PreviousStop:  end.Add(-*f.window),
+			NextStart:     end.Add(-*f.window),
+			NextStop:      end,
+		}, nil
+	}
+	var d domain.Diff
+	for _, bound := range []struct {
+		name  string
+		value string
+		t     *time.Time
+	}{
+		{"-previous-start", *f.previousStart, &d.PreviousStart},
+		{"-previous-stop", *f.previousStop, &d.PreviousStop},
+		{"-next-start", *f.nextStart, &d.NextStart},
+		{"-next-stop", *f.nextStop, &d.NextStop},
+	} {
+		if bound.value == "" {
+			return domain.Diff{}, fmt.Errorf("%s is required unless -window is given", bound.name)
+		}
+		t, err := parseTime(bound.value, now)
+		if err != nil {
+			return domain.Diff{}, fmt.Errorf("%s: %s", bound.name, err.Error())
+		}
+		*bound.t = t
+	}
+	return d, nil
+}
+
+// parseTime parses an RFC3339 timestamp, now, or a duration relative to now such as now-24h
+// or -24h.
+func parseTime(value string, now time.Time) (time.Time, error) {
+	now = now.UTC()
+	relative := strings.TrimPrefix(value, "now")
+	if relative == "" {
+		return now, nil
+	}
+	if relative[0] == '-' || relative[0] == '+' {
+		offset, err := time.ParseDuration(relative)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(offset), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// outputFlags are the flags which select where and how a diff is downloaded.
+type outputFlags struct {
+	output *string
+	format *string
+}
+
+func addOutputFlags(flags *flag.FlagSet) *outputFlags {
+	return &outputFlags{
+		output: flags.String("o", "", "The file to write the diff to. Defaults to stdout."),
+		format: flags.String("format", formatDOT, "The format of the diff. One of dot, gzip for a gzipped DOT graph, or zstd for a Zstandard compressed DOT graph."),
+	}
+}
+
+func (f *outputFlags) validate() error {
+	switch *f.format {
+	case formatDOT, storage.EncodingGzip, storage.EncodingZstd:
+		return nil
+	}
+	return fmt.Errorf("unsupported format %s", *f.format)
+}
+
+// waitFlags are the flags which control waiting for a diff.
+type waitFlags struct {
+	interval *time.Duration
+	timeout  *time.Duration
+}
+
+func addWaitFlags(flags *flag.FlagSet) *waitFlags {
+	return &waitFlags{
+		interval: flags.Duration("interval", 5*time.Second, "The time to wait in between polls of the diff."),
+		timeout:  flags.Duration("timeout", 30*time.Minute, "The time after which to stop waiting."),
+	}
+}
+
+func newFlagSet(env *environment, name string, arguments string, summary string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(env.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(env.stderr, "Usage: diffctl %s %s\n\n%s\n\nFlags:\n", name, arguments, summary)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parse parses the arguments of a command, which only accepts flags, and returns the diff
+// selected by its range flags, if it has any.
+func parse(env *environment, flags *flag.FlagSet, args []string, ranges *rangeFlags) (domain.Diff, error) {
+	if err := flags.Parse(args); err != nil {
+		return domain.Diff{}, errUsage
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(env.stderr, "unexpected arguments %s\n\n", strings.Join(flags.Args(), " "))
+		flags.Usage()
+		return domain.Diff{}, errUsage
+	}
+	if ranges == nil {
+		return domain.Diff{}, nil
+	}
+	return ranges.diff(env.now)
+}
+
+func create(ctx context.Context, env *environment, args []string) error {
+	flags := newFlagSet(env, "create", "[range flags] [-force] [-ttl duration] [-wait [-o file] [-format format]]",
+		"Queues a diff. With -wait, waits until the diff is complete and downloads it.")
+	ranges := addRangeFlags(flags)
+	force := flags.Bool("force", false, "Create the diff again if it already exists.")
+	ttl := flags.Duration("ttl", 0, "How long the diff is kept. Defaults to the retention policy of the service.")
+	shouldWait := flags.Bool("wait", false, "Wait until the diff is complete and download it.")
+	waits := addWaitFlags(flags)
+	outputs := addOutputFlags(flags)
+	d, err := parse(env, flags, args, ranges)
+	if err != nil {
+		return err
+	}
+	if err := outputs.validate(); err != nil {
+		return err
+	}
+
+	err = env.client.Create(ctx, d, client.CreateOptions{Force: *force, TTL: *ttl})
+	if errResponse, ok := err.(client.ErrResponse); ok && errResponse.StatusCode == http.StatusConflict && *shouldWait {
+		// the diff exists or is being created, either of which can be waited for
+		fmt.Fprintf(env.stderr, "%s\n", errResponse.Message)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	if !*shouldWait {
+		fmt.Fprintf(env.stderr, "diff of %s queued\n", describe(d))
+		return nil
+	}
+	if err := waitFor(ctx, env, d, waits); err != nil {
+		return err
+	}
+	return download(ctx, env, d, outputs)
+}
+
+func wait(ctx context.Context, env *environment, args []string) error {
+	flags := newFlagSet(env, "wait", "[range flags] [-interval duration] [-timeout duration]",
+		"Waits until a diff is complete, printing its progress.")
+	ranges := addRangeFlags(flags)
+	waits := addWaitFlags(flags)
+	d, err := parse(env, flags, args, ranges)
+	if err != nil {
+		return err
+	}
+	return waitFor(ctx, env, d, waits)
+}
+
+func get(ctx context.Context, env *environment, args []string) error {
+	flags := newFlagSet(env, "get", "[range flags] [-o file] [-format format]", "Downloads a complete diff.")
+	ranges := addRangeFlags(flags)
+	outputs := addOutputFlags(flags)
+	d, err := parse(env, flags, args, ranges)
+	if err != nil {
+		return err
+	}
+	if err := outputs.validate(); err != nil {
+		return err
+	}
+	return download(ctx, env, d, outputs)
+}
+
+func status(ctx context.Context, env *environment, args []string) error {
+	flags := newFlagSet(env, "status", "[range flags]", "Prints the status of a diff: complete, in_progress or expired.")
+	ranges := addRangeFlags(flags)
+	d, err := parse(env, flags, args, ranges)
+	if err != nil {
+		return err
+	}
+	s, err := env.client.Status(ctx, d)
+	if err != nil {
+		return diffError(err, d)
+	}
+	fmt.Fprintln(env.stdout, s)
+	return nil
+}
+
+func list(ctx context.Context, env *environment, args []string) error {
+	flags := newFlagSet(env, "list", "[-start time] [-stop time] [-created-after time] [-created-before time] [-limit n] [-all]",
+		"Lists the diffs stored by the service, most recently created first.")
+	start := flags.String("start", "", "Only list diffs with a previous or next window which ends after this time.")
+	stop := flags.String("stop", "", "Only list diffs with a previous or next window which starts before this time.")
+	createdAfter := flags.String("created-after", "", "Only list diffs created after this time.")
+	createdBefore := flags.String("created-before", "", "Only list diffs created before this time.")
+	limit := flags.Int("limit", 0, "The number of diffs per page. Defaults to the page size of the service.")
+	all := flags.Bool("all", false, "List every page rather than only the first.")
+	if _, err := parse(env, flags, args, nil); err != nil {
+		return err
+	}
+	filter := domain.ListFilter{Limit: *limit}
+	for _, param := range []struct {
+		name  string
+		value string
+		t     *time.Time
+	}{
+		{"-start", *start, &filter.Start},
+		{"-stop", *stop, &filter.Stop},
+		{"-created-after", *createdAfter, &filter.CreatedAfter},
+		{"-created-before", *createdBefore, &filter.CreatedBefore},
+	} {
+		if param.value == "" {
+			continue
+		}
+		t, err := parseTime(param.value, env.now)
+		if err != nil {
+			return fmt.Errorf("%s: %s", param.name, err.Error())
+		}
+		*param.t = t
+	}
+
+	w := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tPREVIOUS\tNEXT\tCREATED\tADDED\tREMOVED")
+	for {
+		page, err := env.client.List(ctx, filter)
+		if err != nil {
+			return err
+		}
+		for _, d := range page.Diffs {
+			fmt.Fprintf(w, "%s\t%s\t%s/%s\t%s/%s\t%s\t%d\t%d\n", d.ID, d.Status,
+				formatTime(d.PreviousStart), formatTime(d.PreviousStop),
+				formatTime(d.NextStart), formatTime(d.NextStop),
+				formatTime(d.Created), d.Added, d.Removed)
+		}
+		if !*all || page.Next == "" {
+			break
+		}
+		filter.Cursor = page.Next
+	}
+	return w.Flush()
+}
+
+func remove(ctx context.Context, env *environment, args []string) error {
+	flags := newFlagSet(env, "delete", "[-id id | range flags]", "Deletes a diff by its ID or its time ranges.")
+	ranges := addRangeFlags(flags)
+	id := flags.String("id", "", "The ID of the diff, used instead of its time ranges.")
+	if _, err := parse(env, flags, args, nil); err != nil {
+		return err
+	}
+	d := domain.Diff{ID: *id}
+	if d.ID == "" {
+		var err error
+		if d, err = ranges.diff(env.now); err != nil {
+			return err
+		}
+	}
+	if err := env.client.Delete(ctx, d); err != nil {
+		return err
+	}
+	fmt.Fprintln(env.stderr, "diff deleted")
+	return nil
+}
+
+// waitFor polls the diff until it is complete, printing its progress to stderr.
+func waitFor(ctx context.Context, env *environment, d domain.Diff, waits *waitFlags) error {
+	ctx, cancel := context.WithTimeout(ctx, *waits.timeout)
+	defer cancel()
+	started := time.Now()
+	err := env.client.Wait(ctx, d, *waits.interval, func(s string) {
+		fmt.Fprintf(env.stderr, "diff of %s: %s (%s)\n", describe(d), s, time.Since(started).Round(time.Second))
+	})
+	if err == context.DeadlineExceeded {
+		return fmt.Errorf("diff of %s was not complete after %s", describe(d), *waits.timeout)
+	}
+	return diffError(err, d)
+}
+
+// download writes the diff in the format of the flags, compressing it locally if the service
+// did not store it with that encoding.
+func download(ctx context.Context, env *environment, d domain.Diff, outputs *outputFlags) error {
+	var encodings []string
+	if *outputs.format != formatDOT {
+		encodings = append(encodings, *outputs.format)
+	}
+	body, encoding, err := env.client.Get(ctx, d, encodings...)
+	if err != nil {
+		return diffError(err, d)
+	}
+	defer body.Close()
+	var diff io.Reader = body
+	if *outputs.format != formatDOT && encoding != *outputs.format {
+		decoded, err := storage.Decode(body, encoding)
+		if err != nil {
+			return err
+		}
+		encoded, err := storage.Encode(decoded, *outputs.format)
+		if err != nil {
+			return err
+		}
+		defer encoded.Close()
+		diff = encoded
+	}
+
+	var out io.Writer = env.stdout
+	if *outputs.output != "" {
+		f, err := os.Create(*outputs.output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	_, err = io.Copy(out, diff)
+	return err
+}
+
+// diffError describes the diff by its time ranges in the errors of a diff which is missing,
+// as the ID of the diff is only known to the service.
+func diffError(err error, d domain.Diff) error {
+	switch err.(type) {
+	case domain.ErrNotFound:
+		return fmt.Errorf("diff of %s was not found", describe(d))
+	case domain.ErrExpired:
+		return fmt.Errorf("diff of %s has expired", describe(d))
+	case domain.ErrInProgress:
+		return fmt.Errorf("diff of %s is in progress", describe(d))
+	}
+	return err
+}
+
+func describe(d domain.Diff) string {
+	return fmt.Sprintf("%s/%s against %s/%s",
+		formatTime(d.NextStart), formatTime(d.NextStop), formatTime(d.PreviousStart), formatTime(d.PreviousStop))
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
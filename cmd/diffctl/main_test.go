@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testNow = time.Date(2019, 1, 3, 12, 30, 0, 0, time.UTC)
+
+func TestParseTime(t *testing.T) {
+	tc := []struct {
+		value    string
+		expected time.Time
+		err      bool
+	}{
+		{"now", testNow, false},
+		{"now-24h", testNow.Add(-24 * time.Hour), false},
+		{"-90m", testNow.Add(-90 * time.Minute), false},
+		{"now+1h", testNow.Add(time.Hour), false},
+		{"2019-01-01T00:00:00Z", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"yesterday", time.Time{}, true},
+		{"now-1y", time.Time{}, true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.value, func(t *testing.T) {
+			actual, err := parseTime(tt.value, testNow)
+			assert.Equal(t, tt.err, err != nil)
+			assert.True(t, tt.expected.Equal(actual))
+		})
+	}
+}
+
+// runAgainst runs diffctl against a server with the given handler, and returns its stdout
+// and stderr.
+func runAgainst(t *testing.T, handler http.HandlerFunc, args ...string) (string, string, error) {
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), append([]string{"-endpoint", ts.URL}, args...), &stdout, &stderr, testNow)
+	return stdout.String(), stderr.String(), err
+}
+
+func TestCreateWindow(t *testing.T) {
+	var query url.Values
+	_, stderr, err := runAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		query = r.URL.Query()
+		w.WriteHeader(http.StatusAccepted)
+	}, "create", "-window", "24h", "-end", "2019-01-03T00:00:00Z")
+	require.Nil(t, err)
+	assert.Contains(t, stderr, "queued")
+	assert.Equal(t, "2019-01-01T00:00:00Z", query.Get("previous_start"))
+	assert.Equal(t, "2019-01-02T00:00:00Z", query.Get("previous_stop"))
+	assert.Equal(t, "2019-01-02T00:00:00Z", query.Get("next_start"))
+	assert.Equal(t, "2019-01-03T00:00:00Z", query.Get("next_stop"))
+}
+
+func TestCreateWaitGzip(t *testing.T) {
+	polls := 0
+	stdout, stderr, err := runAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"message":"diff is in progress"}`))
+		case http.MethodGet:
+			polls++
+			if polls < 2 {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			// the diff is stored uncompressed, so diffctl compresses it
+			_, _ = w.Write([]byte("digraph {}"))
+		}
+	}, "create", "-previous-start", "now-48h", "-previous-stop", "now-24h", "-next-start", "now-24h", "-next-stop", "now",
+		"-wait", "-interval", "1ms", "-format", "gzip")
+	require.Nil(t, err)
+	assert.Contains(t, stderr, "diff is in progress")
+	assert.Contains(t, stderr, domain.DiffStatusInProgress)
+	assert.Contains(t, stderr, "complete")
+
+	gz, err := gzip.NewReader(strings.NewReader(stdout))
+	require.Nil(t, err)
+	data, err := ioutil.ReadAll(gz)
+	require.Nil(t, err)
+	assert.Equal(t, "digraph {}", string(data))
+}
+
+func TestStatusNotFound(t *testing.T) {
+	_, _, err := runAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}, "status", "-window", "1h")
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "was not found")
+}
+
+func TestList(t *testing.T) {
+	var cursors []string
+	stdout, _, err := runAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/diffs", r.URL.Path)
+		cursor := r.URL.Query().Get("cursor")
+		cursors = append(cursors, cursor)
+		if cursor == "" {
+			_, _ = w.Write([]byte(`{"diffs":[{"id":"first","status":"complete","added":2,"removed":1}],"next":"more"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"diffs":[{"id":"second","status":"in_progress"}]}`))
+	}, "list", "-all")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"", "more"}, cursors)
+	assert.Contains(t, stdout, "first")
+	assert.Contains(t, stdout, "second")
+}
+
+func TestDeleteByID(t *testing.T) {
+	var query url.Values
+	_, _, err := runAgainst(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodDelete, r.Method)
+		query = r.URL.Query()
+		w.WriteHeader(http.StatusNoContent)
+	}, "delete", "-id", "some-id")
+	require.Nil(t, err)
+	assert.Equal(t, url.Values{"id": []string{"some-id"}}, query)
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"unknown"},
+		{"status", "extra"},
+		{"create", "-unknown"},
+	} {
+		_, _, err := runAgainst(t, func(w http.ResponseWriter, r *http.Request) {}, args...)
+		assert.Equal(t, errUsage, err, strings.Join(args, " "))
+	}
+	_, _, err := runAgainst(t, func(w http.ResponseWriter, r *http.Request) {}, "status")
+	assert.NotNil(t, err)
+	_, _, err = runAgainst(t, func(w http.ResponseWriter, r *http.Request) {}, "get", "-window", "1h", "-format", "png")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,140 @@
+// Command vpcflow-diff writes the diff of two DOT graphs on disk, without running the
+// service or a grapher. The diff is created by the same differ as the service, so it is
+// identical to the diff the service would store for the same graphs.
+//
+// Usage:
+//
+//	vpcflow-diff [-o output] [-encoding gzip|zstd] [-accounts id,...] [-summary] previous.dot next.dot
+//
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/differ"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/storage"
+)
+
+// The windows of the diff are arbitrary, as they only select which file is read.
+var (
+	previousWindow = time.Unix(0, 0).UTC()
+	nextWindow     = previousWindow.Add(time.Second)
+)
+
+// fileGrapher is a Grapher which reads the graph of the previous window from one file, and
+// the graph of every other window from another.
+type fileGrapher struct {
+	Previous string
+	Next     string
+}
+
+// Graph opens the file of the window which starts at the given time.
+func (g *fileGrapher) Graph(ctx context.Context, start, stop time.Time) (io.ReadCloser, error) {
+	if start.Equal(previousWindow) {
+		return os.Open(g.Previous)
+	}
+	return os.Open(g.Next)
+}
+
+// options are the flags of the command.
+type options struct {
+	// Output is the file to write the diff to, or stdout if empty.
+	Output string
+	// Encoding compresses the diff, as the service stores it, if not empty.
+	Encoding string
+	// Accounts restrict the diff to the edges of the accounts, if not empty.
+	Accounts []string
+	// Summary prints the number of edges added and removed to stderr.
+	Summary bool
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [-o output] [-encoding gzip|zstd] [-accounts id,...] [-summary] previous.dot next.dot\n\n", os.Args[0])
+	fmt.Fprintf(out, "Writes the edges added in next.dot and removed from previous.dot as a DOT graph.\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var opts options
+	var accounts string
+	flag.StringVar(&opts.Output, "o", "", "The file to write the diff to. Defaults to stdout.")
+	flag.StringVar(&opts.Encoding, "encoding", "", "Compress the diff with gzip or zstd. Defaults to none.")
+	flag.StringVar(&accounts, "accounts", "", "A comma separated list of the accounts to restrict the diff to. Defaults to all accounts.")
+	flag.BoolVar(&opts.Summary, "summary", false, "Print the number of edges added and removed to stderr.")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if accounts != "" {
+		opts.Accounts = strings.Split(accounts, ",")
+	}
+	if err := run(flag.Arg(0), flag.Arg(1), opts); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(previous string, next string, opts options) error {
+	if err := storage.ValidateEncoding(opts.Encoding); err != nil {
+		return err
+	}
+	d := &differ.DOTDiffer{Grapher: &fileGrapher{Previous: previous, Next: next}}
+	diff, err := d.Diff(context.Background(), domain.Diff{
+		PreviousStart: previousWindow,
+		PreviousStop:  previousWindow,
+		NextStart:     nextWindow,
+		NextStop:      nextWindow,
+		Scope:         domain.NewScope("", opts.Accounts),
+	})
+	if err != nil {
+		return err
+	}
+	defer diff.Close()
+
+	summary := &differ.Summary{ReadCloser: diff}
+	var data io.Reader = summary
+	if opts.Encoding != "" {
+		encoded, err := storage.Encode(summary, opts.Encoding)
+		if err != nil {
+			return err
+		}
+		defer encoded.Close()
+		data = encoded
+	}
+
+	if err := write(opts.Output, data); err != nil {
+		return err
+	}
+	if opts.Summary {
+		fmt.Fprintf(os.Stderr, "added %d edges, removed %d edges\n", summary.Added, summary.Removed)
+	}
+	return nil
+}
+
+// write copies the data to the file, or to stdout if the file is empty. Errors of closing the
+// file are returned, as they may be the first to report a failed write.
+func write(output string, data io.Reader) error {
+	if output == "" {
+		_, err := io.Copy(os.Stdout, data)
+		return err
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	previousGraph = `digraph {
+n1 [label="10.0.0.1"]
+n2 [label="10.0.0.2"]
+n3 [label="10.0.0.3"]
+n1 -> n2 [govpc_accountID="1" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a"]
+n1 -> n3 [govpc_accountID="1" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a"]
+}
+`
+	nextGraph = `digraph {
+n1 [label="10.0.0.1"]
+n2 [label="10.0.0.2"]
+n4 [label="10.0.0.4"]
+n1 -> n2 [govpc_accountID="1" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a"]
+n1 -> n4 [govpc_accountID="1" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a"]
+}
+`
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vpcflow-diff")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	previous := filepath.Join(dir, "previous.dot")
+	next := filepath.Join(dir, "next.dot")
+	output := filepath.Join(dir, "diff.dot")
+	require.Nil(t, ioutil.WriteFile(previous, []byte(previousGraph), 0644))
+	require.Nil(t, ioutil.WriteFile(next, []byte(nextGraph), 0644))
+
+	require.Nil(t, run(previous, next, options{Output: output}))
+	diff, err := ioutil.ReadFile(output)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(diff), "digraph {\n"))
+	assert.Contains(t, string(diff), `n1 -> n4 [govpc_accountID="1" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a\ndiff=ADDED" govpc_diff="ADDED"]`)
+	assert.Contains(t, string(diff), `n1 -> n3 [govpc_accountID="1" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a\ndiff=REMOVED" govpc_diff="REMOVED"]`)
+	assert.NotContains(t, string(diff), "n1 -> n2")
+}
+
+func TestRunAccounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vpcflow-diff")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	previous := filepath.Join(dir, "previous.dot")
+	next := filepath.Join(dir, "next.dot")
+	output := filepath.Join(dir, "diff.dot")
+	require.Nil(t, ioutil.WriteFile(previous, []byte(previousGraph), 0644))
+	require.Nil(t, ioutil.WriteFile(next, []byte(nextGraph), 0644))
+
+	require.Nil(t, run(previous, next, options{Output: output, Accounts: []string{"2"}}))
+	diff, err := ioutil.ReadFile(output)
+	require.Nil(t, err)
+	assert.NotContains(t, string(diff), "n1 -> n4")
+	assert.NotContains(t, string(diff), "n1 -> n3")
+}
+
+func TestRunEncoding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vpcflow-diff")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	previous := filepath.Join(dir, "previous.dot")
+	next := filepath.Join(dir, "next.dot")
+	output := filepath.Join(dir, "diff.dot.gz")
+	require.Nil(t, ioutil.WriteFile(previous, []byte(previousGraph), 0644))
+	require.Nil(t, ioutil.WriteFile(next, []byte(nextGraph), 0644))
+
+	require.Nil(t, run(previous, next, options{Output: output, Encoding: storage.EncodingGzip}))
+	f, err := os.Open(output)
+	require.Nil(t, err)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	require.Nil(t, err)
+	diff, err := ioutil.ReadAll(r)
+	require.Nil(t, err)
+	assert.Contains(t, string(diff), `n1 -> n4`)
+	assert.Contains(t, string(diff), `n1 -> n3`)
+}
+
+func TestRunUnsupportedEncoding(t *testing.T) {
+	err := run("previous.dot", "next.dot", options{Encoding: "br"})
+	assert.Equal(t, storage.ErrUnsupportedEncoding{Encoding: "br"}, err)
+}
+
+func TestRunOutputError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vpcflow-diff")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	previous := filepath.Join(dir, "previous.dot")
+	next := filepath.Join(dir, "next.dot")
+	require.Nil(t, ioutil.WriteFile(previous, []byte(previousGraph), 0644))
+	require.Nil(t, ioutil.WriteFile(next, []byte(nextGraph), 0644))
+
+	assert.NotNil(t, run(previous, next, options{Output: filepath.Join(dir, "missing", "diff.dot")}))
+}
+
+func TestRunMissingFile(t *testing.T) {
+	assert.NotNil(t, run("missing.dot", "missing.dot", options{}))
+}
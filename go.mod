@@ -1,6 +1,6 @@
 module github.com/asecurityteam/vpcflow-diffd
 
-go 1.12
+go 1.18
 
 require (
 	github.com/armon/go-radix v1.0.0
@@ -9,15 +9,41 @@ require (
 	github.com/asecurityteam/settings v0.1.0
 	github.com/asecurityteam/transport v0.0.0-20190225122138-b848ebf618ee
 	github.com/aws/aws-sdk-go v1.17.5
-	github.com/fatih/structs v1.1.0 // indirect
+	github.com/getkin/kin-openapi v0.118.0
 	github.com/go-chi/chi v4.0.2+incompatible
-	github.com/go-yaml/yaml v2.1.0+incompatible // indirect
 	github.com/golang/mock v1.2.0
 	github.com/google/uuid v1.1.0
+	github.com/invopop/yaml v0.1.0
+	github.com/klauspost/compress v1.10.3
+	github.com/robfig/cron v1.2.0
+	github.com/rs/xstats v0.0.0-20170813190920-c67367528e16
+	github.com/stretchr/testify v1.8.2 // the minimum required by go.opentelemetry.io/otel
+	go.opentelemetry.io/otel v1.14.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.14.0
+	go.opentelemetry.io/otel/sdk v1.14.0
+	go.opentelemetry.io/otel/trace v1.14.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/fatih/structs v1.1.0 // indirect
+	github.com/go-logr/logr v1.2.3 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/go-openapi/jsonpointer v0.19.5 // indirect
+	github.com/go-openapi/swag v0.19.5 // indirect
+	github.com/go-yaml/yaml v2.1.0+incompatible // indirect
+	github.com/gorilla/mux v1.8.0 // indirect
+	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
+	github.com/josharian/intern v1.0.0 // indirect
+	github.com/mailru/easyjson v0.7.7 // indirect
+	github.com/mohae/deepcopy v0.0.0-20170929034955-c48cc78d4826 // indirect
+	github.com/perimeterx/marshmallow v1.1.4 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rs/xhandler v0.0.0-20151224012956-d9d9599b6aaf // indirect
-	github.com/rs/xstats v0.0.0-20170813190920-c67367528e16 // indirect
 	github.com/rs/zerolog v1.11.0 // indirect
 	github.com/spf13/cast v1.3.0 // indirect
-	github.com/stretchr/testify v1.3.0
 	golang.org/x/net v0.0.0-20190225153610-fe579d43d832 // indirect
+	golang.org/x/sys v0.7.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -2,34 +2,87 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"os"
+	"time"
 
+	"github.com/asecurityteam/logevent"
 	"github.com/asecurityteam/runhttp"
 	"github.com/asecurityteam/settings"
 	diffd "github.com/asecurityteam/vpcflow-diffd/pkg"
 	"github.com/go-chi/chi"
+	"github.com/rs/xstats"
 )
 
+// usage prints every setting of the service, with its default, as environment variables.
+func usage() {
+	conf := diffd.NewConfig()
+	groups, err := conf.Groups()
+	if err != nil {
+		panic(err.Error())
+	}
+	runtimeGroup, err := settings.GroupFromComponent(&runhttp.Component{})
+	if err != nil {
+		panic(err.Error())
+	}
+	groups = append([]settings.Group{runtimeGroup}, groups...)
+	// groups are rendered last to first
+	for x, y := 0, len(groups)-1; x < y; x, y = x+1, y-1 {
+		groups[x], groups[y] = groups[y], groups[x]
+	}
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n\n", os.Args[0])
+	fmt.Fprintf(out, "The service is configured with the following environment variables:\n\n")
+	fmt.Fprint(out, settings.ExampleEnvGroups(groups))
+}
+
 func main() {
-	router := chi.NewRouter()
-	service := &diffd.Service{}
-	if err := service.BindRoutes(router); err != nil {
+	flag.Usage = usage
+	flag.Parse()
+
+	ctx := context.Background()
+	environ, deprecated := diffd.MigrateEnviron(os.Environ())
+	source, err := settings.NewEnvSource(environ)
+	if err != nil {
 		panic(err.Error())
 	}
 
-	source, err := settings.NewEnvSource(os.Environ())
+	conf, err := diffd.LoadConfig(ctx, source)
 	if err != nil {
 		panic(err.Error())
 	}
+	router := chi.NewRouter()
+	service := &diffd.Service{Config: conf}
+	if err := service.BindRoutes(router); err != nil {
+		panic(err.Error())
+	}
 
 	// Load the runtime using the Source and Handler.
-	rt, err := runhttp.New(context.Background(), source, router)
+	rt, err := runhttp.New(ctx, source, router)
 	if err != nil {
 		panic(err.Error())
 	}
 
+	// deprecated variables are still read, but are logged so that they are replaced
+	for _, d := range deprecated {
+		rt.Logger.Warn(d)
+	}
+
+	// The background jobs report through the same logger and stats as the handlers, and
+	// are stopped, and the remaining spans flushed, once the HTTP server has shut down.
+	jobsCtx := logevent.NewContext(ctx, rt.Logger)
+	jobsCtx = xstats.NewContext(jobsCtx, rt.Stats)
+	service.Start(jobsCtx)
+
 	// Run the HTTP server.
-	if err := rt.Run(); err != nil {
+	err = rt.Run()
+	stopCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if stopErr := service.Stop(stopCtx); err == nil {
+		err = stopErr
+	}
+	if err != nil {
 		panic(err.Error())
 	}
 }
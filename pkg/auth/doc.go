@@ -0,0 +1,4 @@
+// Package auth decides the scope of the diffs the caller of a request may
+// access, by identity headers set by an authenticating proxy.
+//
+package auth
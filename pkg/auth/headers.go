@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+)
+
+const (
+	// DefaultTenantHeader is the header which names the tenant of the caller if none is set.
+	DefaultTenantHeader = "X-Tenant-ID"
+
+	// DefaultAccountsHeader is the header which lists the accounts of the caller if none is
+	// set.
+	DefaultAccountsHeader = "X-Account-IDs"
+
+	// allAccounts is the value of the accounts header of a caller who is permitted every
+	// account of their tenant
+	allAccounts = "*"
+)
+
+// tenants are the names a tenant may have. Tenants are part of the storage keys of their
+// diffs, so they are restricted to characters which are safe in a key.
+var tenants = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Headers is a domain.Authorizer which permits the caller of a request the tenant and accounts
+// named by its headers. The headers must be set by an authenticating proxy in front of the
+// service, which removes them from the requests of clients, as they are trusted as they are.
+type Headers struct {
+	// TenantHeader names the tenant of the caller. If unset, DefaultTenantHeader is used.
+	TenantHeader string
+
+	// AccountsHeader is the comma separated list of the accounts of the caller, or * if the
+	// caller is permitted every account of their tenant. If unset, DefaultAccountsHeader is
+	// used.
+	AccountsHeader string
+}
+
+// Authorize returns the scope named by the headers of the request. If either header is
+// missing, or the tenant is not a valid name, an error of type domain.ErrForbidden is
+// returned.
+func (h *Headers) Authorize(r *http.Request) (domain.Scope, error) {
+	tenantHeader := h.TenantHeader
+	if tenantHeader == "" {
+		tenantHeader = DefaultTenantHeader
+	}
+	accountsHeader := h.AccountsHeader
+	if accountsHeader == "" {
+		accountsHeader = DefaultAccountsHeader
+	}
+
+	tenant := strings.TrimSpace(r.Header.Get(tenantHeader))
+	if tenant == "" {
+		return domain.Scope{}, domain.ErrForbidden{Reason: fmt.Sprintf("missing %s header", tenantHeader)}
+	}
+	if !tenants.MatchString(tenant) {
+		return domain.Scope{}, domain.ErrForbidden{Reason: fmt.Sprintf("invalid tenant %s", tenant)}
+	}
+	accounts := strings.TrimSpace(r.Header.Get(accountsHeader))
+	if accounts == allAccounts {
+		return domain.Scope{Tenant: tenant}, nil
+	}
+	scope := domain.NewScope(tenant, strings.Split(accounts, ","))
+	if len(scope.Accounts) == 0 {
+		return domain.Scope{}, domain.ErrForbidden{Reason: fmt.Sprintf("missing %s header", accountsHeader)}
+	}
+	return scope, nil
+}
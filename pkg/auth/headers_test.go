@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaders(t *testing.T) {
+	tc := []struct {
+		Name      string
+		Tenant    string
+		Accounts  string
+		Expected  domain.Scope
+		Forbidden bool
+	}{
+		{"all accounts", "acme", "*", domain.Scope{Tenant: "acme"}, false},
+		{"some accounts", "acme", "2, 1,2,", domain.Scope{Tenant: "acme", Accounts: []string{"1", "2"}}, false},
+		{"missing tenant", "", "*", domain.Scope{}, true},
+		{"invalid tenant", "acme/other", "*", domain.Scope{}, true},
+		{"missing accounts", "acme", "", domain.Scope{}, true},
+		{"empty accounts", "acme", ",", domain.Scope{}, true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(DefaultTenantHeader, tt.Tenant)
+			r.Header.Set(DefaultAccountsHeader, tt.Accounts)
+			scope, err := (&Headers{}).Authorize(r)
+			if tt.Forbidden {
+				assert.IsType(t, domain.ErrForbidden{}, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.Expected, scope)
+		})
+	}
+}
+
+func TestHeadersCustomNames(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Org", "acme")
+	r.Header.Set("X-Accounts", "1")
+	r.Header.Set(DefaultTenantHeader, "other")
+	scope, err := (&Headers{TenantHeader: "X-Org", AccountsHeader: "X-Accounts"}).Authorize(r)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.Scope{Tenant: "acme", Accounts: []string{"1"}}, scope)
+}
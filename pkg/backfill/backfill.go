@@ -0,0 +1,413 @@
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/scheduler"
+	"github.com/google/uuid"
+	"github.com/rs/xstats"
+)
+
+const (
+	defaultMaxConcurrency = 4
+	defaultMaxDiffs       = 1000
+	defaultSaveInterval   = 5 * time.Second
+	defaultResumeInterval = time.Minute
+
+	// a running backfill which has not been saved for this many save intervals is no longer
+	// being run
+	staleIntervals = 3
+
+	statQueued = "backfill.queued"
+)
+
+// Backfiller queues the diffs of historical ranges in the background, as a POST of each diff
+// would. Diffs which already exist or are in progress are not queued again, so a backfill
+// which was interrupted can be started again without repeating its work.
+//
+// The progress of each backfill is saved to the Store as it runs, and once it completes. While
+// Run is called, backfills which were interrupted, such as by a restart of the instance which
+// ran them, are resumed from the Store.
+type Backfiller struct {
+	Store        Store
+	Storage      domain.Storage
+	Queuer       domain.Queuer
+	Marker       domain.Marker
+	LogProvider  domain.LogFn
+	StatProvider domain.StatFn
+
+	// MaxConcurrency caps the number of diffs each backfill checks and queues at once, and is
+	// the concurrency of backfills which do not request one. It defaults to 4.
+	MaxConcurrency int
+
+	// MaxDiffs is the largest number of diffs a single backfill may queue. It defaults to
+	// 1000.
+	MaxDiffs int
+
+	// SaveInterval is how often the progress of a running backfill is saved. It defaults to
+	// five seconds.
+	SaveInterval time.Duration
+
+	// ResumeInterval is how often Run looks for interrupted backfills. It defaults to a
+	// minute.
+	ResumeInterval time.Duration
+
+	// now returns the current time, and defaults to time.Now
+	now func() time.Time
+
+	running sync.WaitGroup
+
+	lock sync.Mutex
+	// finished are the IDs of backfills known to be complete or failed, which are not
+	// loaded again when looking for interrupted backfills
+	finished map[string]bool
+	// parent is the context of every running backfill, which is cancelled by Cancel
+	parent context.Context
+	cancel context.CancelFunc
+}
+
+// Start validates the request and starts queuing its diffs. The returned Backfill is the
+// progress of the backfill before any diff is queued.
+func (b *Backfiller) Start(ctx context.Context, r domain.BackfillRequest) (domain.Backfill, error) {
+	diffs, backfill, err := b.plan(r)
+	if err != nil {
+		return domain.Backfill{}, err
+	}
+	if err := b.Store.Save(ctx, backfill); err != nil {
+		return domain.Backfill{}, err
+	}
+	b.start(ctx, backfill, diffs)
+	return backfill, nil
+}
+
+// start runs the backfill in the background.
+func (b *Backfiller) start(ctx context.Context, backfill domain.Backfill, diffs []domain.Diff) {
+	// the backfill outlives the request, but reports through the same logger and stats
+	runCtx := logevent.NewContext(b.parentContext(), b.LogProvider(ctx))
+	runCtx = xstats.NewContext(runCtx, b.StatProvider(ctx))
+	b.running.Add(1)
+	go func() {
+		defer b.running.Done()
+		b.run(runCtx, backfill, diffs)
+	}()
+}
+
+// Run resumes interrupted backfills once every interval until the context is cancelled.
+func (b *Backfiller) Run(ctx context.Context) {
+	interval := b.ResumeInterval
+	if interval <= 0 {
+		interval = defaultResumeInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := b.Resume(ctx); err != nil && ctx.Err() == nil {
+			b.LogProvider(ctx).Error(logs.DependencyFailure{Dependency: logs.DependencyBackfills, Reason: err.Error()})
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Resume restarts every running backfill which is no longer being run by any instance. Its
+// counts start again from zero, and the diffs it already queued are counted as existing or in
+// progress.
+func (b *Backfiller) Resume(ctx context.Context) error {
+	ids, err := b.Store.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		b.lock.Lock()
+		finished := b.finished[id]
+		b.lock.Unlock()
+		if finished {
+			continue
+		}
+		backfill, err := b.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		switch backfill.Status {
+		case domain.BackfillStatusInterrupted:
+		case domain.BackfillStatusRunning:
+			continue
+		default:
+			b.lock.Lock()
+			if b.finished == nil {
+				b.finished = make(map[string]bool)
+			}
+			b.finished[id] = true
+			b.lock.Unlock()
+			continue
+		}
+		if err := b.resume(ctx, backfill); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resume restarts the interrupted backfill.
+func (b *Backfiller) resume(ctx context.Context, backfill domain.Backfill) error {
+	diffs := b.diffs(backfill, 0)
+	backfill.Status = domain.BackfillStatusRunning
+	backfill.Total = len(diffs)
+	backfill.Queued, backfill.Existing, backfill.InProgress, backfill.Failed = 0, 0, 0, 0
+	backfill.LastError = ""
+	backfill.Updated = b.timeNow()
+	if err := b.Store.Save(ctx, backfill); err != nil {
+		return err
+	}
+	b.LogProvider(ctx).Info(logs.Resumed{ID: backfill.ID})
+	b.start(ctx, backfill, diffs)
+	return nil
+}
+
+// Get returns the progress of a backfill. A backfill which is no longer being run by any
+// instance, such as one which was running when the service restarted, is reported as
+// interrupted.
+func (b *Backfiller) Get(ctx context.Context, id string) (domain.Backfill, error) {
+	backfill, err := b.Store.Load(ctx, id)
+	if err != nil {
+		return domain.Backfill{}, err
+	}
+	if backfill.Status == domain.BackfillStatusRunning && b.timeNow().Sub(backfill.Updated) > staleIntervals*b.saveInterval() {
+		backfill.Status = domain.BackfillStatusInterrupted
+	}
+	return backfill, nil
+}
+
+// Wait blocks until every backfill started by the Backfiller has finished.
+func (b *Backfiller) Wait() {
+	b.running.Wait()
+}
+
+// Cancel stops every running backfill without waiting for it to finish. A cancelled backfill
+// is left running in the Store, so it is resumed once it is reported as interrupted. Any
+// backfill started after Cancel is cancelled immediately.
+func (b *Backfiller) Cancel() {
+	b.parentContext()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.cancel()
+}
+
+// parentContext returns the context every backfill runs under.
+func (b *Backfiller) parentContext() context.Context {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.parent == nil {
+		b.parent, b.cancel = context.WithCancel(context.Background())
+	}
+	return b.parent
+}
+
+// plan returns the diffs of the request, and the Backfill which tracks them.
+func (b *Backfiller) plan(r domain.BackfillRequest) ([]domain.Diff, domain.Backfill, error) {
+	window, step := r.Window, r.Step
+	if r.Template != "" {
+		t, err := scheduler.LookupTemplate(r.Template)
+		if err != nil {
+			return nil, domain.Backfill{}, domain.ErrInvalidBackfill{Reason: err.Error()}
+		}
+		window, step = t.Window, t.Window
+	}
+	if step == 0 {
+		step = window
+	}
+	switch {
+	case r.Start.IsZero() || r.Stop.IsZero():
+		return nil, domain.Backfill{}, domain.ErrInvalidBackfill{Reason: "start and stop are required"}
+	case !r.Start.Before(r.Stop):
+		return nil, domain.Backfill{}, domain.ErrInvalidBackfill{Reason: "start should be before stop"}
+	case window < time.Minute || step < time.Minute:
+		return nil, domain.Backfill{}, domain.ErrInvalidBackfill{Reason: "a template, or a window and step of at least a minute, is required"}
+	}
+	start, stop := r.Start.UTC().Truncate(time.Minute), r.Stop.UTC().Truncate(time.Minute)
+
+	maxDiffs := b.MaxDiffs
+	if maxDiffs <= 0 {
+		maxDiffs = defaultMaxDiffs
+	}
+	diffs := b.diffs(domain.Backfill{Start: start, Stop: stop, Window: window, Step: step, Scope: r.Scope}, maxDiffs+1)
+	if len(diffs) > maxDiffs {
+		return nil, domain.Backfill{}, domain.ErrInvalidBackfill{Reason: fmt.Sprintf("the range has more than %d diffs", maxDiffs)}
+	}
+	if len(diffs) == 0 {
+		return nil, domain.Backfill{}, domain.ErrInvalidBackfill{Reason: "the range is shorter than two windows"}
+	}
+
+	concurrency := b.MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultMaxConcurrency
+	}
+	if r.Concurrency > 0 && r.Concurrency < concurrency {
+		concurrency = r.Concurrency
+	}
+	now := b.timeNow()
+	return diffs, domain.Backfill{
+		ID:          uuid.New().String(),
+		Start:       start,
+		Stop:        stop,
+		Window:      window,
+		Step:        step,
+		Concurrency: concurrency,
+		Status:      domain.BackfillStatusRunning,
+		Scope:       r.Scope,
+		Total:       len(diffs),
+		Created:     now,
+		Updated:     now,
+	}, nil
+}
+
+// diffs returns the diffs of the range of the backfill, in its scope, up to the limit if it is
+// positive.
+func (b *Backfiller) diffs(backfill domain.Backfill, limit int) []domain.Diff {
+	var diffs []domain.Diff
+	// each diff compares a window with the one before it, and both are within the range
+	for next := backfill.Start.Add(backfill.Window); !next.Add(backfill.Window).After(backfill.Stop); next = next.Add(backfill.Step) {
+		if limit > 0 && len(diffs) == limit {
+			break
+		}
+		diffs = append(diffs, domain.NewScopedDiff(next.Add(-backfill.Window), next, next, next.Add(backfill.Window), backfill.Scope))
+	}
+	return diffs
+}
+
+// run queues the diffs of the backfill, saving its progress as it goes.
+func (b *Backfiller) run(ctx context.Context, backfill domain.Backfill, diffs []domain.Diff) {
+	var lock sync.Mutex
+	save := func() {
+		lock.Lock()
+		backfill.Updated = b.timeNow()
+		current := backfill
+		lock.Unlock()
+		if err := b.Store.Save(ctx, current); err != nil {
+			b.LogProvider(ctx).Error(logs.DependencyFailure{Dependency: logs.DependencyBackfills, Reason: err.Error()})
+		}
+	}
+
+	done := make(chan struct{})
+	saved := make(chan struct{})
+	go func() {
+		defer close(saved)
+		ticker := time.NewTicker(b.saveInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				save()
+			}
+		}
+	}()
+
+	jobs := make(chan domain.Diff)
+	var workers sync.WaitGroup
+	for x := 0; x < backfill.Concurrency; x++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for diff := range jobs {
+				outcome, err := b.queue(ctx, diff)
+				lock.Lock()
+				switch {
+				case err != nil:
+					backfill.Failed++
+					backfill.LastError = err.Error()
+				case outcome == domain.DiffStatusComplete:
+					backfill.Existing++
+				case outcome == domain.DiffStatusInProgress:
+					backfill.InProgress++
+				default:
+					backfill.Queued++
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, diff := range diffs {
+		select {
+		case jobs <- diff:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	workers.Wait()
+	close(done)
+	<-saved
+	if ctx.Err() != nil {
+		// the backfill was cancelled, and is resumed once it is reported as interrupted
+		return
+	}
+
+	backfill.Status = domain.BackfillStatusComplete
+	if backfill.Failed > 0 {
+		backfill.Status = domain.BackfillStatusFailed
+	}
+	backfill.Completed = b.timeNow()
+	save()
+	b.LogProvider(ctx).Info(logs.Backfilled{
+		ID:         backfill.ID,
+		Total:      backfill.Total,
+		Queued:     backfill.Queued,
+		Existing:   backfill.Existing,
+		InProgress: backfill.InProgress,
+		Failed:     backfill.Failed,
+	})
+}
+
+// queue queues the diff unless it already exists or is in progress. The outcome is the status
+// of a diff which was not queued, or empty if it was.
+func (b *Backfiller) queue(ctx context.Context, diff domain.Diff) (string, error) {
+	logger := b.LogProvider(ctx)
+	exists, err := b.Storage.Exists(ctx, diff.Key())
+	switch err.(type) {
+	case nil:
+	case domain.ErrInProgress:
+		return domain.DiffStatusInProgress, nil
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		return "", err
+	}
+	if exists {
+		return domain.DiffStatusComplete, nil
+	}
+	if err := b.Queuer.Queue(ctx, diff); err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyQueuer, Reason: err.Error()})
+		return "", err
+	}
+	// as for a POST, the diff is queued even if it cannot be marked
+	if err := b.Marker.Mark(ctx, diff.Key()); err != nil {
+		logger.Info(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+	}
+	b.StatProvider(ctx).Count(statQueued, 1)
+	return "", nil
+}
+
+func (b *Backfiller) saveInterval() time.Duration {
+	if b.SaveInterval <= 0 {
+		return defaultSaveInterval
+	}
+	return b.SaveInterval
+}
+
+func (b *Backfiller) timeNow() time.Time {
+	if b.now == nil {
+		return time.Now().UTC()
+	}
+	return b.now().UTC()
+}
@@ -0,0 +1,296 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testNow = time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+
+func newBackfiller(ctrl *gomock.Controller) (*Backfiller, *MockStorage, *MockQueuer, *MockMarker) {
+	storage := NewMockStorage(ctrl)
+	queuer := NewMockQueuer(ctrl)
+	marker := NewMockMarker(ctrl)
+	logger := logevent.New(logevent.Config{Output: ioutil.Discard})
+	return &Backfiller{
+		Store:        &MemoryStore{},
+		Storage:      storage,
+		Queuer:       queuer,
+		Marker:       marker,
+		LogProvider:  func(context.Context) domain.Logger { return logger },
+		StatProvider: domain.StatFromContext,
+		now:          func() time.Time { return testNow },
+	}, storage, queuer, marker
+}
+
+func TestStart(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	b, storage, queuer, marker := newBackfiller(ctrl)
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	stop := start.Add(5 * 24 * time.Hour)
+	var lock sync.Mutex
+	var queued []domain.Diff
+	storage.EXPECT().Exists(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, id string) (bool, error) {
+		switch id {
+		case domain.NewDiff(start, start.Add(24*time.Hour), start.Add(24*time.Hour), start.Add(48*time.Hour)).ID:
+			return true, nil
+		case domain.NewDiff(start.Add(24*time.Hour), start.Add(48*time.Hour), start.Add(48*time.Hour), start.Add(72*time.Hour)).ID:
+			return false, domain.ErrInProgress{Key: id}
+		}
+		return false, nil
+	}).Times(4)
+	queuer.EXPECT().Queue(gomock.Any(), gomock.Any()).Do(func(ctx context.Context, d domain.Diff) {
+		lock.Lock()
+		defer lock.Unlock()
+		queued = append(queued, d)
+	}).Return(nil).Times(2)
+	marker.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+	backfill, err := b.Start(context.Background(), domain.BackfillRequest{
+		Start:       start,
+		Stop:        stop.Add(30 * time.Second),
+		Template:    "daily",
+		Concurrency: 10,
+	})
+	require.Nil(t, err)
+	assert.Equal(t, 4, backfill.Total)
+	assert.Equal(t, domain.BackfillStatusRunning, backfill.Status)
+	assert.Equal(t, defaultMaxConcurrency, backfill.Concurrency)
+	assert.Equal(t, stop, backfill.Stop)
+	b.Wait()
+
+	backfill, err = b.Get(context.Background(), backfill.ID)
+	require.Nil(t, err)
+	assert.Equal(t, domain.BackfillStatusComplete, backfill.Status)
+	assert.Equal(t, 2, backfill.Queued)
+	assert.Equal(t, 1, backfill.Existing)
+	assert.Equal(t, 1, backfill.InProgress)
+	assert.Equal(t, 0, backfill.Failed)
+	assert.Equal(t, testNow, backfill.Completed)
+	assert.ElementsMatch(t, []domain.Diff{
+		domain.NewDiff(start.Add(48*time.Hour), start.Add(72*time.Hour), start.Add(72*time.Hour), start.Add(96*time.Hour)),
+		domain.NewDiff(start.Add(72*time.Hour), start.Add(96*time.Hour), start.Add(96*time.Hour), stop),
+	}, queued)
+}
+
+func TestStartStep(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	b, storage, queuer, marker := newBackfiller(ctrl)
+	b.MaxConcurrency = 1
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	var queued []domain.Diff
+	storage.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil).Times(3)
+	queuer.EXPECT().Queue(gomock.Any(), gomock.Any()).Do(func(ctx context.Context, d domain.Diff) {
+		queued = append(queued, d)
+	}).Return(nil).Times(3)
+	marker.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(errors.New("marking is best effort")).Times(3)
+
+	// day-over-day diffs, one every 12 hours
+	_, err := b.Start(context.Background(), domain.BackfillRequest{
+		Start:  start,
+		Stop:   start.Add(3 * 24 * time.Hour),
+		Window: 24 * time.Hour,
+		Step:   12 * time.Hour,
+	})
+	require.Nil(t, err)
+	b.Wait()
+	require.Len(t, queued, 3)
+	assert.Equal(t, start.Add(24*time.Hour), queued[0].NextStart)
+	assert.Equal(t, start.Add(36*time.Hour), queued[1].NextStart)
+	assert.Equal(t, start.Add(48*time.Hour), queued[2].NextStart)
+}
+
+func TestStartScoped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	b, storage, queuer, marker := newBackfiller(ctrl)
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	scope := domain.NewScope("acme", []string{"1"})
+	expected := domain.NewScopedDiff(start, start.Add(time.Hour), start.Add(time.Hour), start.Add(2*time.Hour), scope)
+	storage.EXPECT().Exists(gomock.Any(), "tenants/acme/"+expected.ID).Return(false, nil)
+	queuer.EXPECT().Queue(gomock.Any(), expected).Return(nil)
+	marker.EXPECT().Mark(gomock.Any(), "tenants/acme/"+expected.ID).Return(nil)
+
+	backfill, err := b.Start(context.Background(), domain.BackfillRequest{
+		Start:    start,
+		Stop:     start.Add(2 * time.Hour),
+		Template: "hourly",
+		Scope:    scope,
+	})
+	require.Nil(t, err)
+	assert.Equal(t, scope, backfill.Scope)
+	b.Wait()
+}
+
+func TestStartFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	b, storage, queuer, _ := newBackfiller(ctrl)
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	storage.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil)
+	queuer.EXPECT().Queue(gomock.Any(), gomock.Any()).Return(errors.New("unavailable"))
+
+	backfill, err := b.Start(context.Background(), domain.BackfillRequest{
+		Start:    start,
+		Stop:     start.Add(2 * time.Hour),
+		Template: "hourly",
+	})
+	require.Nil(t, err)
+	b.Wait()
+	backfill, err = b.Get(context.Background(), backfill.ID)
+	require.Nil(t, err)
+	assert.Equal(t, domain.BackfillStatusFailed, backfill.Status)
+	assert.Equal(t, 1, backfill.Failed)
+	assert.Equal(t, "unavailable", backfill.LastError)
+}
+
+func TestCancel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	b, storage, _, _ := newBackfiller(ctrl)
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	checking := make(chan struct{}, 1)
+	storage.EXPECT().Exists(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, id string) (bool, error) {
+		select {
+		case checking <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		return false, ctx.Err()
+	}).AnyTimes()
+
+	backfill, err := b.Start(context.Background(), domain.BackfillRequest{
+		Start:       start,
+		Stop:        start.Add(5 * time.Hour),
+		Template:    "hourly",
+		Concurrency: 1,
+	})
+	require.Nil(t, err)
+	<-checking
+	b.Cancel()
+	b.Wait()
+
+	// the cancelled backfill is left running, to be resumed once it is interrupted
+	backfill, err = b.Get(context.Background(), backfill.ID)
+	require.Nil(t, err)
+	assert.Equal(t, domain.BackfillStatusRunning, backfill.Status)
+	assert.Equal(t, 0, backfill.Queued)
+}
+
+func TestStartInvalid(t *testing.T) {
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	tc := []struct {
+		name    string
+		request domain.BackfillRequest
+	}{
+		{"no range", domain.BackfillRequest{Template: "daily"}},
+		{"reversed", domain.BackfillRequest{Start: start, Stop: start.Add(-time.Hour), Template: "hourly"}},
+		{"no window", domain.BackfillRequest{Start: start, Stop: start.Add(time.Hour)}},
+		{"unknown template", domain.BackfillRequest{Start: start, Stop: start.Add(time.Hour), Template: "monthly"}},
+		{"too short", domain.BackfillRequest{Start: start, Stop: start.Add(time.Hour), Template: "hourly"}},
+		{"too long", domain.BackfillRequest{Start: start, Stop: start.Add(100 * time.Hour), Template: "hourly"}},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			b, _, _, _ := newBackfiller(ctrl)
+			b.MaxDiffs = 10
+			_, err := b.Start(context.Background(), tt.request)
+			assert.IsType(t, domain.ErrInvalidBackfill{}, err)
+		})
+	}
+}
+
+func TestGetInterrupted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	b, _, _, _ := newBackfiller(ctrl)
+	require.Nil(t, b.Store.Save(context.Background(), domain.Backfill{
+		ID:      "recent",
+		Status:  domain.BackfillStatusRunning,
+		Updated: testNow.Add(-time.Second),
+	}))
+	require.Nil(t, b.Store.Save(context.Background(), domain.Backfill{
+		ID:      "stale",
+		Status:  domain.BackfillStatusRunning,
+		Updated: testNow.Add(-time.Hour),
+	}))
+
+	backfill, err := b.Get(context.Background(), "recent")
+	require.Nil(t, err)
+	assert.Equal(t, domain.BackfillStatusRunning, backfill.Status)
+	backfill, err = b.Get(context.Background(), "stale")
+	require.Nil(t, err)
+	assert.Equal(t, domain.BackfillStatusInterrupted, backfill.Status)
+	_, err = b.Get(context.Background(), "missing")
+	assert.Equal(t, domain.ErrNotFound{ID: "missing"}, err)
+}
+
+func TestResume(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	b, storage, queuer, marker := newBackfiller(ctrl)
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	interrupted := domain.Backfill{
+		ID:          "stale",
+		Start:       start,
+		Stop:        start.Add(3 * time.Hour),
+		Window:      time.Hour,
+		Step:        time.Hour,
+		Concurrency: 1,
+		Status:      domain.BackfillStatusRunning,
+		Total:       2,
+		Queued:      1,
+		Updated:     testNow.Add(-time.Hour),
+	}
+	require.Nil(t, b.Store.Save(context.Background(), interrupted))
+	require.Nil(t, b.Store.Save(context.Background(), domain.Backfill{ID: "complete", Status: domain.BackfillStatusComplete}))
+	require.Nil(t, b.Store.Save(context.Background(), domain.Backfill{ID: "recent", Status: domain.BackfillStatusRunning, Updated: testNow}))
+
+	// the diff queued before the interruption is now in progress
+	gomock.InOrder(
+		storage.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, domain.ErrInProgress{}),
+		storage.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil),
+	)
+	queuer.EXPECT().Queue(gomock.Any(), domain.NewDiff(start.Add(time.Hour), start.Add(2*time.Hour), start.Add(2*time.Hour), start.Add(3*time.Hour))).Return(nil)
+	marker.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil)
+	require.Nil(t, b.Resume(context.Background()))
+	b.Wait()
+
+	backfill, err := b.Get(context.Background(), "stale")
+	require.Nil(t, err)
+	assert.Equal(t, domain.BackfillStatusComplete, backfill.Status)
+	assert.Equal(t, 2, backfill.Total)
+	assert.Equal(t, 1, backfill.Queued)
+	assert.Equal(t, 1, backfill.InProgress)
+	backfill, err = b.Get(context.Background(), "recent")
+	require.Nil(t, err)
+	assert.Equal(t, domain.BackfillStatusRunning, backfill.Status)
+
+	// finished backfills are not resumed again
+	require.Nil(t, b.Resume(context.Background()))
+	b.Wait()
+	assert.True(t, b.finished["stale"])
+	assert.True(t, b.finished["complete"])
+}
@@ -0,0 +1,3 @@
+// Package backfill queues the diffs of historical ranges.
+//
+package backfill
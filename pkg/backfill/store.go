@@ -0,0 +1,206 @@
+package backfill
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const backfillPrefix = "backfills/"
+
+// Store persists the progress of backfills, so that it can be read by any instance of the
+// service.
+type Store interface {
+	// Load returns the backfill. An error of type domain.ErrNotFound is returned if it does
+	// not exist.
+	Load(ctx context.Context, id string) (domain.Backfill, error)
+
+	// Save records the backfill, replacing its previous progress.
+	Save(ctx context.Context, b domain.Backfill) error
+
+	// List returns the IDs of every backfill.
+	List(ctx context.Context) ([]string, error)
+}
+
+type storedBackfill struct {
+	ID          string    `json:"id"`
+	Start       time.Time `json:"start"`
+	Stop        time.Time `json:"stop"`
+	Window      string    `json:"window"`
+	Step        string    `json:"step"`
+	Concurrency int       `json:"concurrency"`
+	Status      string    `json:"status"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Accounts    []string  `json:"accounts,omitempty"`
+	Total       int       `json:"total"`
+	Queued      int       `json:"queued"`
+	Existing    int       `json:"existing"`
+	InProgress  int       `json:"inProgress"`
+	Failed      int       `json:"failed"`
+	LastError   string    `json:"lastError,omitempty"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	Completed   time.Time `json:"completed,omitempty"`
+}
+
+// S3Store stores each backfill as a JSON object in an S3 bucket. The objects are written under
+// the backfills/ prefix, so the bucket may be shared with progress markers.
+type S3Store struct {
+	Bucket string
+	Client s3iface.S3API
+}
+
+// Load returns the backfill. An error of type domain.ErrNotFound is returned if it does not
+// exist.
+func (s *S3Store) Load(ctx context.Context, id string) (domain.Backfill, error) {
+	res, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(backfillPrefix + id),
+	})
+	if err != nil {
+		if aErr, ok := err.(awserr.Error); ok && aErr.Code() == s3.ErrCodeNoSuchKey {
+			return domain.Backfill{}, domain.ErrNotFound{ID: id}
+		}
+		return domain.Backfill{}, err
+	}
+	defer res.Body.Close()
+	var stored storedBackfill
+	if err := json.NewDecoder(res.Body).Decode(&stored); err != nil {
+		return domain.Backfill{}, err
+	}
+	window, err := time.ParseDuration(stored.Window)
+	if err != nil {
+		return domain.Backfill{}, err
+	}
+	step, err := time.ParseDuration(stored.Step)
+	if err != nil {
+		return domain.Backfill{}, err
+	}
+	return domain.Backfill{
+		ID:          stored.ID,
+		Start:       stored.Start,
+		Stop:        stored.Stop,
+		Window:      window,
+		Step:        step,
+		Concurrency: stored.Concurrency,
+		Status:      stored.Status,
+		Scope:       domain.NewScope(stored.Tenant, stored.Accounts),
+		Total:       stored.Total,
+		Queued:      stored.Queued,
+		Existing:    stored.Existing,
+		InProgress:  stored.InProgress,
+		Failed:      stored.Failed,
+		LastError:   stored.LastError,
+		Created:     stored.Created,
+		Updated:     stored.Updated,
+		Completed:   stored.Completed,
+	}, nil
+}
+
+// Save records the backfill, replacing its previous progress.
+func (s *S3Store) Save(ctx context.Context, b domain.Backfill) error {
+	data, err := json.Marshal(storedBackfill{
+		ID:          b.ID,
+		Start:       b.Start,
+		Stop:        b.Stop,
+		Window:      b.Window.String(),
+		Step:        b.Step.String(),
+		Concurrency: b.Concurrency,
+		Status:      b.Status,
+		Tenant:      b.Scope.Tenant,
+		Accounts:    b.Scope.Accounts,
+		Total:       b.Total,
+		Queued:      b.Queued,
+		Existing:    b.Existing,
+		InProgress:  b.InProgress,
+		Failed:      b.Failed,
+		LastError:   b.LastError,
+		Created:     b.Created,
+		Updated:     b.Updated,
+		Completed:   b.Completed,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(backfillPrefix + b.ID),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// List returns the IDs of every backfill.
+func (s *S3Store) List(ctx context.Context) ([]string, error) {
+	var ids []string
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(backfillPrefix),
+	}
+	for {
+		res, err := s.Client.ListObjectsV2WithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range res.Contents {
+			ids = append(ids, strings.TrimPrefix(aws.StringValue(obj.Key), backfillPrefix))
+		}
+		if !aws.BoolValue(res.IsTruncated) {
+			return ids, nil
+		}
+		input.ContinuationToken = res.NextContinuationToken
+	}
+}
+
+// MemoryStore keeps backfills in memory, so their progress is only known to the instance
+// which runs them.
+type MemoryStore struct {
+	lock      sync.Mutex
+	backfills map[string]domain.Backfill
+}
+
+// Load returns the backfill. An error of type domain.ErrNotFound is returned if it does not
+// exist.
+func (s *MemoryStore) Load(ctx context.Context, id string) (domain.Backfill, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	b, ok := s.backfills[id]
+	if !ok {
+		return domain.Backfill{}, domain.ErrNotFound{ID: id}
+	}
+	return b, nil
+}
+
+// Save records the backfill, replacing its previous progress.
+func (s *MemoryStore) Save(ctx context.Context, b domain.Backfill) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.backfills == nil {
+		s.backfills = make(map[string]domain.Backfill)
+	}
+	s.backfills[b.ID] = b
+	return nil
+}
+
+// List returns the IDs of every backfill.
+func (s *MemoryStore) List(ctx context.Context) ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ids := make([]string, 0, len(s.backfills))
+	for id := range s.backfills {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
@@ -0,0 +1,109 @@
+package backfill
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3Store(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	backfill := domain.Backfill{
+		ID:          "id",
+		Start:       time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		Stop:        time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC),
+		Window:      24 * time.Hour,
+		Step:        24 * time.Hour,
+		Concurrency: 4,
+		Status:      domain.BackfillStatusRunning,
+		Scope:       domain.NewScope("acme", []string{"1", "2"}),
+		Total:       89,
+		Queued:      10,
+		Created:     time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC),
+		Updated:     time.Date(2019, 4, 1, 12, 0, 5, 0, time.UTC),
+	}
+	var stored []byte
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().PutObjectWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx aws.Context, input *s3.PutObjectInput, opts ...interface{}) (*s3.PutObjectOutput, error) {
+			assert.Equal(t, "bucket", aws.StringValue(input.Bucket))
+			assert.Equal(t, "backfills/id", aws.StringValue(input.Key))
+			stored, _ = ioutil.ReadAll(input.Body)
+			return &s3.PutObjectOutput{}, nil
+		})
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("backfills/id"),
+	}).DoAndReturn(func(ctx aws.Context, input *s3.GetObjectInput, opts ...interface{}) (*s3.GetObjectOutput, error) {
+		return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(stored))}, nil
+	})
+
+	store := &S3Store{Bucket: "bucket", Client: mockS3}
+	require.Nil(t, store.Save(context.Background(), backfill))
+	actual, err := store.Load(context.Background(), "id")
+	require.Nil(t, err)
+	assert.Equal(t, backfill, actual)
+}
+
+func TestS3StoreLoadError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	gomock.InOrder(
+		mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "", nil)),
+		mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("denied")),
+	)
+
+	store := &S3Store{Bucket: "bucket", Client: mockS3}
+	_, err := store.Load(context.Background(), "id")
+	assert.Equal(t, domain.ErrNotFound{ID: "id"}, err)
+	_, err = store.Load(context.Background(), "id")
+	assert.NotNil(t, err)
+	assert.IsType(t, errors.New(""), err)
+}
+
+func TestS3StoreList(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	gomock.InOrder(
+		mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+			Bucket: aws.String("bucket"),
+			Prefix: aws.String("backfills/"),
+		}).Return(&s3.ListObjectsV2Output{
+			Contents:              []*s3.Object{{Key: aws.String("backfills/a")}},
+			IsTruncated:           aws.Bool(true),
+			NextContinuationToken: aws.String("next"),
+		}, nil),
+		mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+			Bucket:            aws.String("bucket"),
+			Prefix:            aws.String("backfills/"),
+			ContinuationToken: aws.String("next"),
+		}).Return(&s3.ListObjectsV2Output{
+			Contents: []*s3.Object{{Key: aws.String("backfills/b")}},
+		}, nil),
+		mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("denied")),
+	)
+
+	store := &S3Store{Bucket: "bucket", Client: mockS3}
+	ids, err := store.List(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, ids)
+	_, err = store.List(context.Background())
+	assert.NotNil(t, err)
+}
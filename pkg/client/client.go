@@ -0,0 +1,277 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+)
+
+// ErrResponse is returned when the service responds with an unexpected status code.
+type ErrResponse struct {
+	StatusCode int
+	Message    string
+}
+
+func (e ErrResponse) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("unexpected response from diffd: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("unexpected response from diffd: %d: %s", e.StatusCode, e.Message)
+}
+
+// CreateOptions are the optional parameters of a new diff.
+type CreateOptions struct {
+	// Force creates the diff again if it already exists.
+	Force bool
+
+	// TTL is how long the diff is kept once it is created. Zero leaves the diff subject to
+	// the retention policy of the service.
+	TTL time.Duration
+}
+
+// Client calls the diff API of a diffd service.
+type Client struct {
+	Endpoint *url.URL
+	Client   *http.Client
+}
+
+// Create queues a diff of the time ranges of the given Diff. An ErrResponse with status 409
+// is returned if the diff already exists or is in progress, unless the diff is forced.
+func (c *Client) Create(ctx context.Context, d domain.Diff, opts CreateOptions) error {
+	q := rangeQuery(d)
+	if opts.Force {
+		q.Set("force", "true")
+	}
+	if opts.TTL > 0 {
+		q.Set("ttl", opts.TTL.String())
+	}
+	res, err := c.do(ctx, http.MethodPost, "/", q, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		return errResponse(res)
+	}
+	return nil
+}
+
+// Get returns the content of a complete diff of the time ranges of the given Diff. If any
+// encodings are given, the diff is returned compressed with one of them if it was stored that
+// way, along with the encoding. An error of type domain.ErrInProgress, domain.ErrNotFound or
+// domain.ErrExpired is returned if the diff is not complete.
+func (c *Client) Get(ctx context.Context, d domain.Diff, encodings ...string) (io.ReadCloser, string, error) {
+	header := make(http.Header)
+	for _, encoding := range encodings {
+		header.Add("Accept-Encoding", encoding)
+	}
+	res, err := c.do(ctx, http.MethodGet, "/", rangeQuery(d), header)
+	if err != nil {
+		return nil, "", err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res.Body, res.Header.Get("Content-Encoding"), nil
+	case http.StatusNoContent:
+		err = domain.ErrInProgress{Key: d.ID}
+	case http.StatusNotFound:
+		err = domain.ErrNotFound{ID: d.ID}
+	case http.StatusGone:
+		err = domain.ErrExpired{ID: d.ID}
+	default:
+		err = errResponse(res)
+	}
+	_ = res.Body.Close()
+	return nil, "", err
+}
+
+// Status returns the status of the diff of the time ranges of the given Diff, which is one of
+// the domain diff statuses. An error of type domain.ErrNotFound is returned if the diff does
+// not exist.
+func (c *Client) Status(ctx context.Context, d domain.Diff) (string, error) {
+	body, _, err := c.Get(ctx, d)
+	switch err.(type) {
+	case nil:
+		// only the status is needed, so the content is not downloaded
+		_ = body.Close()
+		return domain.DiffStatusComplete, nil
+	case domain.ErrInProgress:
+		return domain.DiffStatusInProgress, nil
+	case domain.ErrExpired:
+		return domain.DiffStatusExpired, nil
+	}
+	return "", err
+}
+
+// Delete removes the diff with the given ID or, if the ID is empty, the diff of the time
+// ranges of the given Diff.
+func (c *Client) Delete(ctx context.Context, d domain.Diff) error {
+	q := make(url.Values)
+	if d.ID != "" {
+		q.Set("id", d.ID)
+	} else {
+		q = rangeQuery(d)
+	}
+	res, err := c.do(ctx, http.MethodDelete, "/", q, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return errResponse(res)
+	}
+	return nil
+}
+
+type diffListItem struct {
+	ID            string    `json:"id"`
+	PreviousStart time.Time `json:"previousStart"`
+	PreviousStop  time.Time `json:"previousStop"`
+	NextStart     time.Time `json:"nextStart"`
+	NextStop      time.Time `json:"nextStop"`
+	Created       time.Time `json:"created"`
+	Status        string    `json:"status"`
+	Added         int       `json:"added"`
+	Removed       int       `json:"removed"`
+	Expires       time.Time `json:"expires"`
+}
+
+type diffList struct {
+	Diffs []diffListItem `json:"diffs"`
+	Next  string         `json:"next"`
+}
+
+// List returns a page of the diffs selected by the filter.
+func (c *Client) List(ctx context.Context, filter domain.ListFilter) (domain.DiffPage, error) {
+	q := make(url.Values)
+	for _, param := range []struct {
+		name  string
+		value time.Time
+	}{
+		{"start", filter.Start},
+		{"stop", filter.Stop},
+		{"created_after", filter.CreatedAfter},
+		{"created_before", filter.CreatedBefore},
+	} {
+		if !param.value.IsZero() {
+			q.Set(param.name, param.value.Format(time.RFC3339Nano))
+		}
+	}
+	if filter.Limit > 0 {
+		q.Set("limit", strconv.Itoa(filter.Limit))
+	}
+	if filter.Cursor != "" {
+		q.Set("cursor", filter.Cursor)
+	}
+	res, err := c.do(ctx, http.MethodGet, "/diffs", q, nil)
+	if err != nil {
+		return domain.DiffPage{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return domain.DiffPage{}, errResponse(res)
+	}
+	var list diffList
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		return domain.DiffPage{}, err
+	}
+	page := domain.DiffPage{Diffs: make([]domain.DiffMetadata, 0, len(list.Diffs)), Next: list.Next}
+	for _, item := range list.Diffs {
+		page.Diffs = append(page.Diffs, domain.DiffMetadata{
+			Diff: domain.Diff{
+				ID:            item.ID,
+				PreviousStart: item.PreviousStart,
+				PreviousStop:  item.PreviousStop,
+				NextStart:     item.NextStart,
+				NextStop:      item.NextStop,
+			},
+			Created: item.Created,
+			Status:  item.Status,
+			Added:   item.Added,
+			Removed: item.Removed,
+			Expires: item.Expires,
+		})
+	}
+	return page, nil
+}
+
+// Wait polls the status of the diff of the time ranges of the given Diff until it is complete.
+// The status is passed to the progress function, if one is given, after each poll. An error
+// is returned if the diff does not exist or has expired, or once the context is done.
+func (c *Client) Wait(ctx context.Context, d domain.Diff, interval time.Duration, progress func(status string)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, err := c.Status(ctx, d)
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(status)
+		}
+		switch status {
+		case domain.DiffStatusComplete:
+			return nil
+		case domain.DiffStatusExpired:
+			return domain.ErrExpired{ID: d.ID}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, q url.Values, header http.Header) (*http.Response, error) {
+	u := *c.Endpoint
+	u.Path = singleJoin(u.Path, path)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	return c.Client.Do(req.WithContext(ctx))
+}
+
+// singleJoin joins two URL paths with exactly one slash between them.
+func singleJoin(base string, path string) string {
+	for len(base) > 0 && base[len(base)-1] == '/' {
+		base = base[:len(base)-1]
+	}
+	return base + path
+}
+
+// rangeQuery returns the time range query parameters of a diff.
+func rangeQuery(d domain.Diff) url.Values {
+	q := make(url.Values)
+	q.Set("previous_start", d.PreviousStart.Format(time.RFC3339Nano))
+	q.Set("previous_stop", d.PreviousStop.Format(time.RFC3339Nano))
+	q.Set("next_start", d.NextStart.Format(time.RFC3339Nano))
+	q.Set("next_stop", d.NextStop.Format(time.RFC3339Nano))
+	return q
+}
+
+// errResponse returns an ErrResponse with the message of the JSON error body of the response,
+// if it has one.
+func errResponse(res *http.Response) error {
+	data, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	var msg struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		msg.Message = string(data)
+	}
+	return ErrResponse{StatusCode: res.StatusCode, Message: msg.Message}
+}
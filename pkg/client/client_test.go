@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDiff = domain.Diff{
+	ID:            "id",
+	PreviousStart: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+	PreviousStop:  time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC),
+	NextStart:     time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC),
+	NextStop:      time.Date(2019, 1, 3, 0, 0, 0, 0, time.UTC),
+}
+
+func newClient(t *testing.T, handler http.HandlerFunc) (*Client, func()) {
+	ts := httptest.NewServer(handler)
+	endpoint, err := url.Parse(ts.URL + "/diffd/")
+	require.Nil(t, err)
+	return &Client{Endpoint: endpoint, Client: ts.Client()}, ts.Close
+}
+
+func TestCreate(t *testing.T) {
+	c, closeServer := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/diffd/", r.URL.Path)
+		q := r.URL.Query()
+		assert.Equal(t, "2019-01-01T00:00:00Z", q.Get("previous_start"))
+		assert.Equal(t, "2019-01-03T00:00:00Z", q.Get("next_stop"))
+		assert.Equal(t, "true", q.Get("force"))
+		assert.Equal(t, "1h0m0s", q.Get("ttl"))
+		w.WriteHeader(http.StatusAccepted)
+	})
+	defer closeServer()
+
+	assert.Nil(t, c.Create(context.Background(), testDiff, CreateOptions{Force: true, TTL: time.Hour}))
+}
+
+func TestCreateConflict(t *testing.T) {
+	c, closeServer := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"message":"diff already exists"}`))
+	})
+	defer closeServer()
+
+	err := c.Create(context.Background(), testDiff, CreateOptions{})
+	assert.Equal(t, ErrResponse{StatusCode: http.StatusConflict, Message: "diff already exists"}, err)
+}
+
+func TestGet(t *testing.T) {
+	tc := []struct {
+		name   string
+		status int
+		err    error
+	}{
+		{"complete", http.StatusOK, nil},
+		{"in progress", http.StatusNoContent, domain.ErrInProgress{Key: testDiff.ID}},
+		{"not found", http.StatusNotFound, domain.ErrNotFound{ID: testDiff.ID}},
+		{"expired", http.StatusGone, domain.ErrExpired{ID: testDiff.ID}},
+		{"unexpected", http.StatusInternalServerError, ErrResponse{StatusCode: http.StatusInternalServerError}},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			c, closeServer := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodGet, r.Method)
+				assert.Equal(t, "gzip", r.Header.Get("Accept-Encoding"))
+				if tt.status == http.StatusOK {
+					w.Header().Set("Content-Encoding", "gzip")
+				}
+				w.WriteHeader(tt.status)
+				if tt.status == http.StatusOK {
+					_, _ = w.Write([]byte("diff"))
+				}
+			})
+			defer closeServer()
+
+			body, encoding, err := c.Get(context.Background(), testDiff, "gzip")
+			assert.Equal(t, tt.err, err)
+			if tt.err != nil {
+				return
+			}
+			defer body.Close()
+			assert.Equal(t, "gzip", encoding)
+			data, _ := ioutil.ReadAll(body)
+			assert.Equal(t, "diff", string(data))
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tc := []struct {
+		name     string
+		status   int
+		expected string
+		err      error
+	}{
+		{"complete", http.StatusOK, domain.DiffStatusComplete, nil},
+		{"in progress", http.StatusNoContent, domain.DiffStatusInProgress, nil},
+		{"expired", http.StatusGone, domain.DiffStatusExpired, nil},
+		{"not found", http.StatusNotFound, "", domain.ErrNotFound{ID: testDiff.ID}},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			c, closeServer := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			})
+			defer closeServer()
+
+			status, err := c.Status(context.Background(), testDiff)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.expected, status)
+		})
+	}
+}
+
+func TestWait(t *testing.T) {
+	polls := 0
+	c, closeServer := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		if polls < 3 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	defer closeServer()
+
+	var statuses []string
+	err := c.Wait(context.Background(), testDiff, time.Millisecond, func(status string) {
+		statuses = append(statuses, status)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{domain.DiffStatusInProgress, domain.DiffStatusInProgress, domain.DiffStatusComplete}, statuses)
+}
+
+func TestWaitTimeout(t *testing.T) {
+	c, closeServer := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	defer closeServer()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.NotNil(t, c.Wait(ctx, testDiff, time.Millisecond, nil))
+}
+
+func TestDelete(t *testing.T) {
+	var query url.Values
+	c, closeServer := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodDelete, r.Method)
+		query = r.URL.Query()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	defer closeServer()
+
+	assert.Nil(t, c.Delete(context.Background(), testDiff))
+	assert.Equal(t, url.Values{"id": []string{"id"}}, query)
+
+	byRange := testDiff
+	byRange.ID = ""
+	assert.Nil(t, c.Delete(context.Background(), byRange))
+	assert.Equal(t, "2019-01-01T00:00:00Z", query.Get("previous_start"))
+	assert.Empty(t, query.Get("id"))
+}
+
+func TestList(t *testing.T) {
+	c, closeServer := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/diffd/diffs", r.URL.Path)
+		q := r.URL.Query()
+		assert.Equal(t, "2019-01-01T00:00:00Z", q.Get("start"))
+		assert.Empty(t, q.Get("stop"))
+		assert.Equal(t, "10", q.Get("limit"))
+		assert.Equal(t, "cursor", q.Get("cursor"))
+		_, _ = w.Write([]byte(`{"diffs":[{"id":"id","previousStart":"2019-01-01T00:00:00Z","previousStop":"2019-01-02T00:00:00Z","nextStart":"2019-01-02T00:00:00Z","nextStop":"2019-01-03T00:00:00Z","created":"2019-01-03T00:00:00Z","status":"complete","added":2,"removed":1}],"next":"more"}`))
+	})
+	defer closeServer()
+
+	page, err := c.List(context.Background(), domain.ListFilter{Start: testDiff.PreviousStart, Limit: 10, Cursor: "cursor"})
+	require.Nil(t, err)
+	assert.Equal(t, "more", page.Next)
+	require.Len(t, page.Diffs, 1)
+	d := page.Diffs[0]
+	assert.Equal(t, testDiff.ID, d.ID)
+	assert.True(t, testDiff.NextStop.Equal(d.NextStop))
+	assert.Equal(t, domain.DiffStatusComplete, d.Status)
+	assert.Equal(t, 2, d.Added)
+	assert.Equal(t, 1, d.Removed)
+}
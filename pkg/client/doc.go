@@ -0,0 +1,3 @@
+// Package client is a client of the diff API described in api.yaml.
+//
+package client
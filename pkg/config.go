@@ -0,0 +1,532 @@
+package diffd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asecurityteam/settings"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/auth"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/grapher"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/logs"
+)
+
+// Config is the configuration of the built in modules of the service. Each field is
+// loaded as a separate top-level group, so a setting such as the Bucket of the Storage
+// within the Diff group is read from the DIFF_STORAGE_BUCKET environment variable.
+type Config struct {
+	Service    *ServiceConfig
+	Health     *HealthConfig
+	Validation *ValidationConfig
+	Tenancy    *TenancyConfig
+	AWS        *AWSConfig
+	Diff       *DiffConfig
+	Stream     *StreamConfig
+	Grapher    *GrapherConfig
+	Scheduler  *SchedulerConfig
+	Backfill   *BackfillConfig
+	Tracing    *TracingConfig
+}
+
+// NewConfig returns a Config with all defaults set.
+func NewConfig() *Config {
+	return &Config{
+		Service: &ServiceConfig{
+			Mode: ModeAll,
+			Jobs: true,
+		},
+		Health: &HealthConfig{
+			Timeout:  2 * time.Second,
+			CacheTTL: 10 * time.Second,
+		},
+		Validation: &ValidationConfig{
+			Requests:  true,
+			Responses: true,
+		},
+		Tenancy: &TenancyConfig{
+			TenantHeader:   auth.DefaultTenantHeader,
+			AccountsHeader: auth.DefaultAccountsHeader,
+		},
+		AWS: &AWSConfig{
+			Credentials: &AWSCredentialsConfig{},
+		},
+		Diff: &DiffConfig{
+			Storage: &StorageConfig{},
+			Progress: &ProgressConfig{
+				Timeout: 5 * time.Minute,
+				Reap: &ReapConfig{
+					MaxRequeues: 3,
+				},
+			},
+			Retention: &RetentionConfig{
+				SweepInterval: time.Hour,
+			},
+		},
+		Stream: &StreamConfig{
+			Appliance: &ApplianceConfig{},
+		},
+		Grapher: &GrapherConfig{
+			Polling: &PollingConfig{
+				Timeout:  time.Minute,
+				Interval: time.Second,
+			},
+			Storage: &GrapherStorageConfig{},
+			FlowLog: &FlowLogConfig{
+				DeliveryDelay: grapher.DefaultDeliveryDelay,
+			},
+			Cache: &CacheConfig{},
+			Spool: &SpoolConfig{},
+		},
+		Scheduler: &SchedulerConfig{
+			Interval:   time.Minute,
+			MaxCatchUp: 24,
+		},
+		Backfill: &BackfillConfig{
+			MaxConcurrency: 4,
+			MaxDiffs:       1000,
+		},
+		Tracing: &TracingConfig{},
+	}
+}
+
+// Groups returns the settings groups of the Config. Loading the groups sets the values of
+// the Config.
+func (c *Config) Groups() ([]settings.Group, error) {
+	values := []interface{}{c.Service, c.Health, c.Validation, c.Tenancy, c.AWS, c.Diff, c.Stream, c.Grapher, c.Scheduler, c.Backfill, c.Tracing}
+	groups := make([]settings.Group, 0, len(values))
+	for _, v := range values {
+		g, err := settings.Convert(v)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// LoadConfig returns a Config with the values of the given source, and defaults for every
+// value missing from it. An error is returned if a value cannot be converted to the type of
+// its setting.
+func LoadConfig(ctx context.Context, source settings.Source) (*Config, error) {
+	conf := NewConfig()
+	groups, err := conf.Groups()
+	if err != nil {
+		return nil, err
+	}
+	if err := settings.LoadGroups(ctx, source, groups); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// renamedVariables maps the environment variables read by earlier releases to the variables
+// which replaced them. The earlier names are still read, but are deprecated.
+var renamedVariables = map[string]string{
+	"USE_IAM":                     "AWS_USEIAM",
+	"DIFF_STORAGE_BUCKET_REGION":  "DIFF_STORAGE_REGION",
+	"DIFF_PROGRESS_BUCKET_REGION": "DIFF_PROGRESS_REGION",
+}
+
+// millisecondVariables are the durations which earlier releases read as a whole number of
+// milliseconds. Such numbers are still read as milliseconds, but are deprecated.
+var millisecondVariables = []string{
+	"DIFF_PROGRESS_TIMEOUT",
+	"GRAPHER_POLLING_TIMEOUT",
+	"GRAPHER_POLLING_INTERVAL",
+}
+
+// MigrateEnviron returns the environment with the deprecated variables of earlier releases
+// replaced by the variables LoadConfig reads, along with an event to log for each deprecated
+// variable which was found. A renamed variable is only read if its replacement is unset, and
+// a duration which is a whole number is read as a number of milliseconds.
+func MigrateEnviron(environ []string) ([]string, []logs.Deprecated) {
+	values := make(map[string]string, len(environ))
+	for _, e := range environ {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) == 2 {
+			values[parts[0]] = parts[1]
+		}
+	}
+	var deprecated []logs.Deprecated
+	renamed := make([]string, 0, len(renamedVariables))
+	for name := range renamedVariables {
+		renamed = append(renamed, name)
+	}
+	sort.Strings(renamed)
+	for _, name := range renamed {
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+		replacement := renamedVariables[name]
+		reason := fmt.Sprintf("%s is deprecated, use %s", name, replacement)
+		if _, ok := values[replacement]; ok {
+			reason = fmt.Sprintf("%s is deprecated, and ignored as %s is set", name, replacement)
+		} else {
+			values[replacement] = value
+		}
+		// the deprecated name is removed, as it may conflict with the replacement's group
+		delete(values, name)
+		deprecated = append(deprecated, logs.Deprecated{Setting: name, Reason: reason})
+	}
+	for _, name := range millisecondVariables {
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			continue
+		}
+		values[name] = value + "ms"
+		deprecated = append(deprecated, logs.Deprecated{
+			Setting: name,
+			Reason:  fmt.Sprintf("%s is a number of milliseconds, which is deprecated, use a duration such as %sms", name, value),
+		})
+	}
+	migrated := make([]string, 0, len(values))
+	for name, value := range values {
+		migrated = append(migrated, name+"="+value)
+	}
+	sort.Strings(migrated)
+	return migrated, deprecated
+}
+
+const (
+	// ModeAll serves both the public API and the worker.
+	ModeAll = "all"
+	// ModeAPI only serves the public API, which queues diffs and serves them once created.
+	ModeAPI = "api"
+	// ModeWorker only serves the worker, which creates the diffs queued by the API.
+	ModeWorker = "worker"
+)
+
+// ServiceConfig is the container for the configuration of the routes the service mounts.
+type ServiceConfig struct {
+	Mode string `description:"The routes to serve. One of api, worker, or all."`
+	Jobs bool   `description:"Whether the background jobs of the API, such as the sweeper, reaper and scheduler, run on this instance."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*ServiceConfig) Name() string {
+	return "service"
+}
+
+// Description returns the help information for the configuration root.
+func (*ServiceConfig) Description() string {
+	return "Service mode configuration."
+}
+
+// HealthConfig is the container for the configuration of the readiness check.
+type HealthConfig struct {
+	Timeout  time.Duration `description:"The time after which a dependency which has not responded is considered unavailable."`
+	CacheTTL time.Duration `description:"How long the outcome of the readiness check is reused for."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*HealthConfig) Name() string {
+	return "health"
+}
+
+// Description returns the help information for the configuration root.
+func (*HealthConfig) Description() string {
+	return "Readiness check configuration."
+}
+
+// ValidationConfig is the container for the configuration of the validation of requests
+// and responses against the API document.
+type ValidationConfig struct {
+	Requests  bool `description:"Reject requests which do not match the API document with a 400 Bad Request."`
+	Responses bool `description:"Log responses which do not match the API document."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*ValidationConfig) Name() string {
+	return "validation"
+}
+
+// Description returns the help information for the configuration root.
+func (*ValidationConfig) Description() string {
+	return "API document validation configuration."
+}
+
+// TenancyConfig is the container for the configuration of the isolation of the diffs of
+// tenants and accounts.
+type TenancyConfig struct {
+	Enabled        bool   `description:"Scope diffs to the tenant and accounts named by the identity headers of each request."`
+	TenantHeader   string `description:"The header which names the tenant of the caller."`
+	AccountsHeader string `description:"The header which lists the accounts of the caller, or * for all accounts."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*TenancyConfig) Name() string {
+	return "tenancy"
+}
+
+// Description returns the help information for the configuration root.
+func (*TenancyConfig) Description() string {
+	return "Tenant and account isolation configuration."
+}
+
+// AWSConfig is the container for the credentials used by every S3 client.
+type AWSConfig struct {
+	UseIAM      bool `description:"Assume the IAM role of the host to access the S3 buckets, which is recommended on ec2 instances."`
+	Credentials *AWSCredentialsConfig
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*AWSConfig) Name() string {
+	return "aws"
+}
+
+// Description returns the help information for the configuration root.
+func (*AWSConfig) Description() string {
+	return "AWS credentials configuration."
+}
+
+// AWSCredentialsConfig is the container for the shared credentials used if no IAM role is
+// assumed.
+type AWSCredentialsConfig struct {
+	File    string `description:"The shared credentials file to read if no IAM role is used."`
+	Profile string `description:"The profile of the shared credentials file to use."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*AWSCredentialsConfig) Name() string {
+	return "credentials"
+}
+
+// DiffConfig is the container for the configuration of the diffs produced by the service.
+type DiffConfig struct {
+	Storage   *StorageConfig
+	Progress  *ProgressConfig
+	Retention *RetentionConfig
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*DiffConfig) Name() string {
+	return "diff"
+}
+
+// Description returns the help information for the configuration root.
+func (*DiffConfig) Description() string {
+	return "Diff storage configuration."
+}
+
+// StorageConfig is the container for the configuration of the built in Storage.
+type StorageConfig struct {
+	Bucket   string `description:"The name of the S3 bucket used to store diffs."`
+	Region   string `description:"The region of the S3 bucket used to store diffs."`
+	Encoding string `description:"The compression of stored diffs. One of gzip, zstd, or empty for none."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*StorageConfig) Name() string {
+	return "storage"
+}
+
+// ProgressConfig is the container for the configuration of the built in Marker.
+type ProgressConfig struct {
+	Bucket  string        `description:"The name of the S3 bucket used to store progress markers."`
+	Region  string        `description:"The region of the S3 bucket used to store progress markers."`
+	Timeout time.Duration `description:"The time after which a progress marker is considered invalid."`
+	Reap    *ReapConfig
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*ProgressConfig) Name() string {
+	return "progress"
+}
+
+// ReapConfig is the container for the configuration of the reaper of expired progress
+// markers.
+type ReapConfig struct {
+	Interval    time.Duration `description:"How often expired progress markers are removed. The reaper is disabled if zero."`
+	Requeue     bool          `description:"Queue the diffs of expired progress markers again."`
+	MaxRequeues int           `description:"How many times the diff of an expired progress marker is queued again before it is dropped."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*ReapConfig) Name() string {
+	return "reap"
+}
+
+// RetentionConfig is the container for the retention policy of stored diffs.
+type RetentionConfig struct {
+	MaxAge        time.Duration `description:"How long a diff is kept after it is created. Diffs are kept forever if zero."`
+	MaxCount      int           `description:"How many diffs are kept, removing the oldest first. Unlimited if zero."`
+	SweepInterval time.Duration `description:"How often the retention policy is enforced."`
+	Lifecycle     bool          `description:"Whether the maximum age of diffs in the built in storage is enforced by a lifecycle rule, which is installed on the bucket when the jobs start, rather than by the sweeper."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*RetentionConfig) Name() string {
+	return "retention"
+}
+
+// StreamConfig is the container for the configuration of the built in Queuer.
+type StreamConfig struct {
+	Appliance *ApplianceConfig
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*StreamConfig) Name() string {
+	return "stream"
+}
+
+// Description returns the help information for the configuration root.
+func (*StreamConfig) Description() string {
+	return "Diff queue configuration."
+}
+
+// ApplianceConfig is the container for the configuration of the stream appliance to which
+// diffs are queued.
+type ApplianceConfig struct {
+	Endpoint string `description:"The endpoint of the service which queues diffs to be created."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*ApplianceConfig) Name() string {
+	return "appliance"
+}
+
+// GrapherConfig is the container for the configuration of the built in Grapher.
+type GrapherConfig struct {
+	Endpoint string `description:"The endpoint of the vpcflow-grapherd API."`
+	Polling  *PollingConfig
+	Storage  *GrapherStorageConfig
+	FlowLog  *FlowLogConfig
+	Cache    *CacheConfig
+	Spool    *SpoolConfig
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*GrapherConfig) Name() string {
+	return "grapher"
+}
+
+// Description returns the help information for the configuration root.
+func (*GrapherConfig) Description() string {
+	return "Graph source configuration."
+}
+
+// PollingConfig is the container for the configuration of polling the grapher service.
+type PollingConfig struct {
+	Timeout  time.Duration `description:"The total time to continue polling the grapher."`
+	Interval time.Duration `description:"The time to wait in between poll attempts."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*PollingConfig) Name() string {
+	return "polling"
+}
+
+// GrapherStorageConfig is the container for the configuration of the bucket to which the
+// grapher service writes graphs.
+type GrapherStorageConfig struct {
+	Bucket string `description:"The name of the S3 bucket to read created graphs from directly."`
+	Region string `description:"The region of the S3 bucket of created graphs."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*GrapherStorageConfig) Name() string {
+	return "storage"
+}
+
+// FlowLogConfig is the container for the configuration of the raw flow logs from which
+// graphs are built in-process.
+type FlowLogConfig struct {
+	Directory     string        `description:"The local directory to read flow log files from."`
+	Bucket        string        `description:"The name of the S3 bucket to read flow log files from."`
+	Region        string        `description:"The region of the S3 bucket of flow log files."`
+	Prefix        string        `description:"The key prefix of flow log files in the S3 bucket."`
+	DeliveryDelay time.Duration `description:"How long after a flow log record starts its file may be delivered."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*FlowLogConfig) Name() string {
+	return "flowlog"
+}
+
+// CacheConfig is the container for the configuration of the graph cache.
+type CacheConfig struct {
+	Directory string        `description:"The local directory to cache graphs in."`
+	Bucket    string        `description:"The name of the S3 bucket to cache graphs in."`
+	Region    string        `description:"The region of the S3 bucket to cache graphs in."`
+	MaxBytes  int64         `description:"The maximum total size of cached graphs. Unlimited if zero."`
+	TTL       time.Duration `description:"How long a graph is cached. Forever if zero."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*CacheConfig) Name() string {
+	return "cache"
+}
+
+// SpoolConfig is the container for the configuration of spooling graphs to disk.
+type SpoolConfig struct {
+	Directory string `description:"The local directory to spool graphs to instead of streaming them."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*SpoolConfig) Name() string {
+	return "spool"
+}
+
+// SchedulerConfig is the container for the configuration of recurring diffs.
+type SchedulerConfig struct {
+	Schedules  string        `description:"Recurring diffs as name:template:cron spec, optionally followed by :tenant and :accounts, separated by semicolons. The template is one of hourly, daily, or weekly."`
+	Interval   time.Duration `description:"How often the schedules are checked for runs which are due."`
+	MaxCatchUp int           `description:"The number of missed runs of a schedule which are queued after downtime, most recent first."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*SchedulerConfig) Name() string {
+	return "scheduler"
+}
+
+// Description returns the help information for the configuration root.
+func (*SchedulerConfig) Description() string {
+	return "Recurring diff configuration."
+}
+
+// BackfillConfig is the container for the limits of backfills.
+type BackfillConfig struct {
+	MaxConcurrency int `description:"The number of diffs each backfill queues at once, at most."`
+	MaxDiffs       int `description:"The number of diffs a single backfill may queue, at most."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*BackfillConfig) Name() string {
+	return "backfill"
+}
+
+// Description returns the help information for the configuration root.
+func (*BackfillConfig) Description() string {
+	return "Backfill configuration."
+}
+
+// TracingConfig is the container for the configuration of the span exporter.
+type TracingConfig struct {
+	Exporter string `description:"The exporter of spans. One of stdout, file, or empty to disable tracing."`
+	File     string `description:"The file to which the file exporter appends spans, as the JSON of the OpenTelemetry stdout exporter rather than OTLP."`
+}
+
+// Name returns the configuration root as it would appear in a config file.
+func (*TracingConfig) Name() string {
+	return "tracing"
+}
+
+// Description returns the help information for the configuration root.
+func (*TracingConfig) Description() string {
+	return "Tracing configuration."
+}
+
+// required returns an error naming the setting if its value is empty.
+func required(setting string, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s is required", setting)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package diffd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/settings"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	conf, err := LoadConfig(context.Background(), settings.NewMapSource(map[string]interface{}{}))
+	require.Nil(t, err)
+	require.Equal(t, NewConfig(), conf)
+	require.Equal(t, 5*time.Minute, conf.Diff.Progress.Timeout)
+	require.Equal(t, time.Hour, conf.Diff.Retention.SweepInterval)
+}
+
+func TestLoadConfig(t *testing.T) {
+	source, err := settings.NewEnvSource([]string{
+		"AWS_USEIAM=true",
+		"VALIDATION_RESPONSES=false",
+		"TENANCY_ENABLED=true",
+		"TENANCY_ACCOUNTSHEADER=X-Accounts",
+		"AWS_CREDENTIALS_PROFILE=diffd",
+		"DIFF_STORAGE_BUCKET=diffs",
+		"DIFF_PROGRESS_TIMEOUT=90s",
+		"DIFF_PROGRESS_REAP_REQUEUE=true",
+		"DIFF_RETENTION_MAXCOUNT=10",
+		"DIFF_RETENTION_LIFECYCLE=true",
+		"SERVICE_JOBS=false",
+		"STREAM_APPLIANCE_ENDPOINT=http://localhost",
+		"GRAPHER_POLLING_INTERVAL=500ms",
+		"GRAPHER_FLOWLOG_PREFIX=logs/",
+		"GRAPHER_FLOWLOG_DELIVERYDELAY=30m",
+		"GRAPHER_CACHE_MAXBYTES=1024",
+		"SCHEDULER_SCHEDULES=day-over-day:daily:0 2 * * *",
+		"SCHEDULER_MAXCATCHUP=7",
+		"BACKFILL_MAXDIFFS=2160",
+		"TRACING_EXPORTER=stdout",
+	})
+	require.Nil(t, err)
+	conf, err := LoadConfig(context.Background(), source)
+	require.Nil(t, err)
+	require.True(t, conf.AWS.UseIAM)
+	require.True(t, conf.Validation.Requests)
+	require.False(t, conf.Validation.Responses)
+	require.True(t, conf.Tenancy.Enabled)
+	require.Equal(t, "X-Tenant-ID", conf.Tenancy.TenantHeader)
+	require.Equal(t, "X-Accounts", conf.Tenancy.AccountsHeader)
+	require.Equal(t, "diffd", conf.AWS.Credentials.Profile)
+	require.Equal(t, "diffs", conf.Diff.Storage.Bucket)
+	require.Equal(t, 90*time.Second, conf.Diff.Progress.Timeout)
+	require.True(t, conf.Diff.Progress.Reap.Requeue)
+	require.Equal(t, 10, conf.Diff.Retention.MaxCount)
+	require.True(t, conf.Diff.Retention.Lifecycle)
+	require.False(t, conf.Service.Jobs)
+	require.Equal(t, "http://localhost", conf.Stream.Appliance.Endpoint)
+	require.Equal(t, 500*time.Millisecond, conf.Grapher.Polling.Interval)
+	require.Equal(t, time.Minute, conf.Grapher.Polling.Timeout)
+	require.Equal(t, "logs/", conf.Grapher.FlowLog.Prefix)
+	require.Equal(t, 30*time.Minute, conf.Grapher.FlowLog.DeliveryDelay)
+	require.Equal(t, int64(1024), conf.Grapher.Cache.MaxBytes)
+	require.Equal(t, "day-over-day:daily:0 2 * * *", conf.Scheduler.Schedules)
+	require.Equal(t, 7, conf.Scheduler.MaxCatchUp)
+	require.Equal(t, time.Minute, conf.Scheduler.Interval)
+	require.Equal(t, 2160, conf.Backfill.MaxDiffs)
+	require.Equal(t, 4, conf.Backfill.MaxConcurrency)
+	require.Equal(t, "stdout", conf.Tracing.Exporter)
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	source, err := settings.NewEnvSource([]string{"DIFF_RETENTION_MAXCOUNT=many"})
+	require.Nil(t, err)
+	_, err = LoadConfig(context.Background(), source)
+	require.NotNil(t, err)
+}
+
+func TestMigrateEnviron(t *testing.T) {
+	environ, deprecated := MigrateEnviron([]string{
+		"USE_IAM=true",
+		"DIFF_STORAGE_BUCKET=diffs",
+		"DIFF_STORAGE_BUCKET_REGION=us-west-2",
+		"DIFF_PROGRESS_REGION=us-east-1",
+		"DIFF_PROGRESS_BUCKET_REGION=eu-west-1",
+		"DIFF_PROGRESS_TIMEOUT=90000",
+		"GRAPHER_POLLING_TIMEOUT=60000",
+		"GRAPHER_POLLING_INTERVAL=500ms",
+	})
+	require.Len(t, deprecated, 5)
+	source, err := settings.NewEnvSource(environ)
+	require.Nil(t, err)
+	conf, err := LoadConfig(context.Background(), source)
+	require.Nil(t, err)
+	require.True(t, conf.AWS.UseIAM)
+	require.Equal(t, "diffs", conf.Diff.Storage.Bucket)
+	require.Equal(t, "us-west-2", conf.Diff.Storage.Region)
+	// a deprecated variable does not override its replacement
+	require.Equal(t, "us-east-1", conf.Diff.Progress.Region)
+	// durations which are whole numbers are read as milliseconds
+	require.Equal(t, 90*time.Second, conf.Diff.Progress.Timeout)
+	require.Equal(t, time.Minute, conf.Grapher.Polling.Timeout)
+	require.Equal(t, 500*time.Millisecond, conf.Grapher.Polling.Interval)
+
+	environ, deprecated = MigrateEnviron([]string{"AWS_USEIAM=true", "DIFF_PROGRESS_TIMEOUT=90s"})
+	require.Empty(t, deprecated)
+	require.Equal(t, []string{"AWS_USEIAM=true", "DIFF_PROGRESS_TIMEOUT=90s"}, environ)
+
+	// only the settings of earlier releases are migrated
+	environ, deprecated = MigrateEnviron([]string{"DIFF_RETENTION_MAX_AGE=24h", "GRAPHER_CACHE_TTL=1000"})
+	require.Empty(t, deprecated)
+	require.Equal(t, []string{"DIFF_RETENTION_MAX_AGE=24h", "GRAPHER_CACHE_TTL=1000"}, environ)
+}
@@ -25,9 +25,23 @@ var keyAttrs = map[string]bool{
 	"color":           true, // red/green represents status reject/accept
 }
 
+const (
+	statEdges    = "differ.edges"
+	statAdded    = "differ.added"
+	statRemoved  = "differ.removed"
+	statTreeSize = "differ.tree.size"
+)
+
 // DOTDiffer is a differ implementation which takes two DOT graphs, and generates a diff between the two
+//
+// Only the edges of the accounts in the scope of the diff are compared, so a diff restricted to
+// some accounts neither shows nor counts the edges of the others.
+//
+// If a StatProvider is set, the differ reports gauges for each diff: the edges read from each
+// window, the edges added and removed, and the size of each radix tree it builds.
 type DOTDiffer struct {
-	Grapher domain.Grapher
+	Grapher      domain.Grapher
+	StatProvider domain.StatFn
 }
 
 // Diff generates the diff of two DOT graphs
@@ -92,6 +106,7 @@ func (d *DOTDiffer) Diff(ctx context.Context, diff domain.Diff) (io.ReadCloser,
 	prevSearch := radix.New()
 	var err error
 	var line string
+	var prevEdges, nextEdges, added, removed int
 	for line, err = prevReader.ReadString('\n'); err == nil; line, err = prevReader.ReadString('\n') {
 		line = strings.TrimSpace(line)
 		if line == "" {
@@ -102,6 +117,10 @@ func (d *DOTDiffer) Diff(ctx context.Context, diff domain.Diff) (io.ReadCloser,
 			nodes.Insert(key, line)
 			continue
 		}
+		if !inScope(diff.Scope, line) {
+			continue
+		}
+		prevEdges++
 		_, _ = prevSearch.Insert(key, nil)
 	}
 	if err != io.EOF {
@@ -120,6 +139,10 @@ func (d *DOTDiffer) Diff(ctx context.Context, diff domain.Diff) (io.ReadCloser,
 			nodes.Insert(key, line)
 			continue
 		}
+		if !inScope(diff.Scope, line) {
+			continue
+		}
+		nextEdges++
 		_, _ = nextSearch.Insert(key, nil)
 	}
 	if err != io.EOF {
@@ -139,12 +162,13 @@ func (d *DOTDiffer) Diff(ctx context.Context, diff domain.Diff) (io.ReadCloser,
 		if keyType == lineTypeNode {
 			continue
 		}
-		if keyType == lineTypeEdge && !prevFound {
+		if keyType == lineTypeEdge && !prevFound && inScope(diff.Scope, line) {
 			for offset := range edgeNodes {
 				nodesToShow.Insert(edgeNodes[offset], nil)
 			}
 			_, _ = output.WriteString(line[:len(line)-2]) // remove ] and newline".
 			_, _ = output.WriteString("\\ndiff=ADDED\" govpc_diff=\"ADDED\"]\n")
+			added++
 		}
 	}
 	if err != io.EOF {
@@ -162,12 +186,13 @@ func (d *DOTDiffer) Diff(ctx context.Context, diff domain.Diff) (io.ReadCloser,
 		if keyType == lineTypeNode {
 			continue
 		}
-		if keyType == lineTypeEdge && !nextFound {
+		if keyType == lineTypeEdge && !nextFound && inScope(diff.Scope, line) {
 			for offset := range edgeNodes {
 				nodesToShow.Insert(edgeNodes[offset], nil)
 			}
 			_, _ = output.WriteString(line[:len(line)-2]) // remove ] and newline".
 			_, _ = output.WriteString("\\ndiff=REMOVED\" govpc_diff=\"REMOVED\"]\n")
+			removed++
 		}
 	}
 	if err != io.EOF {
@@ -182,6 +207,18 @@ func (d *DOTDiffer) Diff(ctx context.Context, diff domain.Diff) (io.ReadCloser,
 	})
 	_, _ = output.WriteString("}")
 
+	if d.StatProvider != nil {
+		stat := d.StatProvider(ctx)
+		stat.Gauge(statEdges, float64(prevEdges), "window:previous")
+		stat.Gauge(statEdges, float64(nextEdges), "window:next")
+		stat.Gauge(statAdded, float64(added))
+		stat.Gauge(statRemoved, float64(removed))
+		stat.Gauge(statTreeSize, float64(nodes.Len()), "tree:nodes")
+		stat.Gauge(statTreeSize, float64(prevSearch.Len()), "tree:previous")
+		stat.Gauge(statTreeSize, float64(nextSearch.Len()), "tree:next")
+		stat.Gauge(statTreeSize, float64(nodesToShow.Len()), "tree:shown")
+	}
+
 	return ioutil.NopCloser(output), nil
 }
 
@@ -229,6 +266,25 @@ func lineEdgeKey(line string) (string, []string) {
 	return key.String(), []string{parts[0], parts[2]}
 }
 
+// inScope returns true if the edge is of an account in the scope. The account of an edge is its
+// govpc_accountID attribute. Edges without one are only in scopes of all accounts.
+func inScope(scope domain.Scope, line string) bool {
+	if len(scope.Accounts) == 0 {
+		return true
+	}
+	const attr = `govpc_accountID="`
+	offset := strings.Index(line, attr)
+	if offset < 0 {
+		return false
+	}
+	account := line[offset+len(attr):]
+	end := strings.IndexByte(account, '"')
+	if end < 0 {
+		return false
+	}
+	return scope.Includes(account[:end])
+}
+
 // lineNodeKey returns the left-hand side of n172311622 [label="172.31.16.22"]
 // which is how the go-vpcflow graph writes a node.
 func lineNodeKey(line string) string {
@@ -32,7 +32,7 @@ func TestPrevGraphError(t *testing.T) {
 	grapherMock.EXPECT().Graph(gomock.Any(), d.PreviousStart, d.PreviousStop).Return(nil, errors.New("")).AnyTimes()
 	grapherMock.EXPECT().Graph(gomock.Any(), d.NextStart, d.NextStop).Return(ioutil.NopCloser(bytes.NewReader([]byte(""))), nil).AnyTimes()
 
-	differ := DOTDiffer{grapherMock}
+	differ := DOTDiffer{Grapher: grapherMock}
 	_, err := differ.Diff(context.Background(), d)
 	assert.NotNil(t, err)
 }
@@ -54,11 +54,122 @@ func TestNextGraphError(t *testing.T) {
 	grapherMock.EXPECT().Graph(gomock.Any(), d.NextStart, d.NextStop).Return(nil, errors.New(""))
 	grapherMock.EXPECT().Graph(gomock.Any(), d.NextStart, d.NextStop).Return(nil, errors.New(""))
 
-	differ := DOTDiffer{grapherMock}
+	differ := DOTDiffer{Grapher: grapherMock}
 	_, err := differ.Diff(context.Background(), d)
 	assert.NotNil(t, err)
 }
 
+type gaugeStat struct {
+	gauges map[string]float64
+}
+
+func (s *gaugeStat) AddTags(tags ...string)                                  {}
+func (s *gaugeStat) GetTags() []string                                       { return nil }
+func (s *gaugeStat) Count(stat string, count float64, tags ...string)        {}
+func (s *gaugeStat) Histogram(stat string, value float64, tags ...string)    {}
+func (s *gaugeStat) Timing(stat string, value time.Duration, tags ...string) {}
+func (s *gaugeStat) Gauge(stat string, value float64, tags ...string) {
+	s.gauges[strings.Join(append([]string{stat}, tags...), ",")] = value
+}
+
+func TestDiffStats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	prev := `digraph {
+n1 -> n2 [govpc_accountID="1" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a"]
+n1 -> n3 [govpc_accountID="1" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a"]
+n1 [label="1"]
+n2 [label="2"]
+n3 [label="3"]
+}`
+	next := `digraph {
+n1 -> n2 [govpc_accountID="1" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a"]
+n1 -> n4 [govpc_accountID="1" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a"]
+n1 -> n5 [govpc_accountID="1" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a"]
+n1 [label="1"]
+n2 [label="2"]
+n4 [label="4"]
+n5 [label="5"]
+}`
+	d := domain.Diff{
+		PreviousStart: time.Now().Add(-1 * time.Hour),
+		PreviousStop:  time.Now().Add(-1 * time.Hour),
+		NextStart:     time.Now(),
+		NextStop:      time.Now(),
+	}
+	grapherMock := NewMockGrapher(ctrl)
+	grapherMock.EXPECT().Graph(gomock.Any(), d.PreviousStart, d.PreviousStop).Return(ioutil.NopCloser(bytes.NewReader([]byte(prev))), nil)
+	grapherMock.EXPECT().Graph(gomock.Any(), d.PreviousStart, d.PreviousStop).Return(ioutil.NopCloser(bytes.NewReader([]byte(prev))), nil)
+	grapherMock.EXPECT().Graph(gomock.Any(), d.NextStart, d.NextStop).Return(ioutil.NopCloser(bytes.NewReader([]byte(next))), nil)
+	grapherMock.EXPECT().Graph(gomock.Any(), d.NextStart, d.NextStop).Return(ioutil.NopCloser(bytes.NewReader([]byte(next))), nil)
+
+	stat := &gaugeStat{gauges: make(map[string]float64)}
+	differ := DOTDiffer{
+		Grapher:      grapherMock,
+		StatProvider: func(context.Context) domain.Stat { return stat },
+	}
+	_, err := differ.Diff(context.Background(), d)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(2), stat.gauges["differ.edges,window:previous"])
+	assert.Equal(t, float64(3), stat.gauges["differ.edges,window:next"])
+	assert.Equal(t, float64(2), stat.gauges["differ.added"])
+	assert.Equal(t, float64(1), stat.gauges["differ.removed"])
+	assert.Equal(t, float64(2), stat.gauges["differ.tree.size,tree:previous"])
+	assert.Equal(t, float64(3), stat.gauges["differ.tree.size,tree:next"])
+	assert.Equal(t, float64(4), stat.gauges["differ.tree.size,tree:shown"])
+}
+
+func TestDiffScope(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	prev := `digraph {
+n1 -> n2 [govpc_accountID="1" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a"]
+n1 -> n3 [govpc_accountID="2" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a"]
+n1 [label="1"]
+n2 [label="2"]
+n3 [label="3"]
+}`
+	next := `digraph {
+n1 -> n4 [govpc_accountID="1" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a"]
+n1 -> n5 [govpc_accountID="2" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a"]
+n1 [label="1"]
+n4 [label="4"]
+n5 [label="5"]
+}`
+	d := domain.Diff{
+		PreviousStart: time.Now().Add(-1 * time.Hour),
+		PreviousStop:  time.Now().Add(-1 * time.Hour),
+		NextStart:     time.Now(),
+		NextStop:      time.Now(),
+		Scope:         domain.NewScope("", []string{"1"}),
+	}
+	grapherMock := NewMockGrapher(ctrl)
+	grapherMock.EXPECT().Graph(gomock.Any(), d.PreviousStart, d.PreviousStop).Return(ioutil.NopCloser(bytes.NewReader([]byte(prev))), nil)
+	grapherMock.EXPECT().Graph(gomock.Any(), d.PreviousStart, d.PreviousStop).Return(ioutil.NopCloser(bytes.NewReader([]byte(prev))), nil)
+	grapherMock.EXPECT().Graph(gomock.Any(), d.NextStart, d.NextStop).Return(ioutil.NopCloser(bytes.NewReader([]byte(next))), nil)
+	grapherMock.EXPECT().Graph(gomock.Any(), d.NextStart, d.NextStop).Return(ioutil.NopCloser(bytes.NewReader([]byte(next))), nil)
+
+	stat := &gaugeStat{gauges: make(map[string]float64)}
+	differ := DOTDiffer{
+		Grapher:      grapherMock,
+		StatProvider: func(context.Context) domain.Stat { return stat },
+	}
+	out, err := differ.Diff(context.Background(), d)
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(out)
+	assert.Contains(t, string(body), "n1 -> n4")
+	assert.Contains(t, string(body), "n1 -> n2")
+	assert.NotContains(t, string(body), "n1 -> n5")
+	assert.NotContains(t, string(body), "n1 -> n3")
+	assert.NotContains(t, string(body), `n5 [label="5"]`)
+	assert.Equal(t, float64(1), stat.gauges["differ.edges,window:previous"])
+	assert.Equal(t, float64(1), stat.gauges["differ.edges,window:next"])
+	assert.Equal(t, float64(1), stat.gauges["differ.added"])
+	assert.Equal(t, float64(1), stat.gauges["differ.removed"])
+}
+
 func TestDiff(t *testing.T) {
 
 	tc := []struct {
@@ -197,7 +308,7 @@ func TestDiff(t *testing.T) {
 			grapherMock.EXPECT().Graph(gomock.Any(), d.NextStart, d.NextStop).Return(ioutil.NopCloser(bytes.NewReader([]byte(tt.Next))), nil)
 			grapherMock.EXPECT().Graph(gomock.Any(), d.NextStart, d.NextStop).Return(ioutil.NopCloser(bytes.NewReader([]byte(tt.Next))), nil)
 
-			differ := DOTDiffer{grapherMock}
+			differ := DOTDiffer{Grapher: grapherMock}
 			out, err := differ.Diff(context.Background(), d)
 			assert.Nil(t, err)
 			reader := bufio.NewReader(out)
@@ -325,7 +436,7 @@ func BenchmarkDiff(b *testing.B) {
 				grapherMock.EXPECT().Graph(gomock.Any(), d.NextStart, d.NextStop).Return(ioutil.NopCloser(bytes.NewReader([]byte(tt.Next))), nil)
 			}
 
-			differ := DOTDiffer{grapherMock}
+			differ := DOTDiffer{Grapher: grapherMock}
 
 			b.ResetTimer()
 			for n := 0; n < b.N; n = n + 1 {
@@ -393,7 +504,7 @@ func BenchmarkLargeDiff(b *testing.B) {
 		grapherMock.EXPECT().Graph(gomock.Any(), d.NextStart, d.NextStop).Return(ioutil.NopCloser(bytes.NewReader(nextBuff.Bytes())), nil)
 	}
 
-	differ := DOTDiffer{grapherMock}
+	differ := DOTDiffer{Grapher: grapherMock}
 
 	b.ResetTimer()
 	for n := 0; n < b.N; n = n + 1 {
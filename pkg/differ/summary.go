@@ -0,0 +1,49 @@
+package differ
+
+import (
+	"bytes"
+	"io"
+)
+
+var (
+	addedSuffix   = []byte(`govpc_diff="ADDED"]`)
+	removedSuffix = []byte(`govpc_diff="REMOVED"]`)
+)
+
+// Summary counts the edges added and removed by a DOT diff as it is read. Diff edges are
+// identified by the govpc_diff attribute which ends each edge line. The counts are complete
+// once the diff has been read to the end.
+type Summary struct {
+	io.ReadCloser
+	Added   int
+	Removed int
+
+	line []byte
+}
+
+// Read reads from the diff, counting each complete line.
+func (s *Summary) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	data := p[:n]
+	for offset := bytes.IndexByte(data, '\n'); offset >= 0; offset = bytes.IndexByte(data, '\n') {
+		s.line = append(s.line, data[:offset]...)
+		s.count()
+		data = data[offset+1:]
+	}
+	s.line = append(s.line, data...)
+	if err == io.EOF {
+		s.count()
+	}
+	return n, err
+}
+
+func (s *Summary) count() {
+	line := bytes.TrimSpace(s.line)
+	switch {
+	case bytes.HasSuffix(line, addedSuffix):
+		s.Added++
+	case bytes.HasSuffix(line, removedSuffix):
+		s.Removed++
+	}
+	s.line = s.line[:0]
+}
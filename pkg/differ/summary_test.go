@@ -0,0 +1,27 @@
+package differ
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummary(t *testing.T) {
+	diff := `digraph {
+n1 [label="10.0.0.1"]
+n1 -> n2 [label="a\ndiff=ADDED" govpc_diff="ADDED"]
+n1 -> n3 [label="a\ndiff=ADDED" govpc_diff="ADDED"]
+n1 -> n4 [label="a\ndiff=REMOVED" govpc_diff="REMOVED"]
+}
+n1 -> n5 [label="a\ndiff=ADDED" govpc_diff="ADDED"]`
+	summary := &Summary{ReadCloser: ioutil.NopCloser(iotest.OneByteReader(strings.NewReader(diff)))}
+	data, err := ioutil.ReadAll(summary)
+	require.Nil(t, err)
+	assert.Equal(t, diff, string(data))
+	assert.Equal(t, 3, summary.Added)
+	assert.Equal(t, 1, summary.Removed)
+}
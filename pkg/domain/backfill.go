@@ -0,0 +1,90 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// BackfillStatusRunning is the status of a backfill which is queuing diffs.
+	BackfillStatusRunning = "running"
+	// BackfillStatusComplete is the status of a backfill which has queued every diff, or found
+	// it to exist already.
+	BackfillStatusComplete = "complete"
+	// BackfillStatusFailed is the status of a backfill which finished, but failed to queue some
+	// of its diffs.
+	BackfillStatusFailed = "failed"
+	// BackfillStatusInterrupted is the status of a backfill which stopped before it finished,
+	// such as when the service restarted, until it is resumed.
+	BackfillStatusInterrupted = "interrupted"
+)
+
+// BackfillRequest describes the diffs of a backfill. The range is split into consecutive
+// pairs of windows, each starting a step after the last, and the diff of each pair is queued.
+type BackfillRequest struct {
+	Start time.Time
+	Stop  time.Time
+
+	// Template names the window and step of the diffs, which are both an hour, a day or a
+	// week for hourly, daily or weekly. It is used instead of Window and Step if it is set.
+	Template string
+	Window   time.Duration
+	Step     time.Duration
+
+	// Concurrency is the number of diffs which are checked and queued at once. It is capped
+	// by the backfiller.
+	Concurrency int
+
+	// Scope is the tenant and accounts the diffs are restricted to.
+	Scope Scope
+}
+
+// Backfill is the progress of the diffs queued for a historical range.
+type Backfill struct {
+	ID          string
+	Start       time.Time
+	Stop        time.Time
+	Window      time.Duration
+	Step        time.Duration
+	Concurrency int
+	Status      string
+
+	// Scope is the tenant and accounts the diffs of the backfill are restricted to.
+	Scope Scope
+
+	// Total is the number of diffs of the backfill. Each is counted as Queued, Existing,
+	// InProgress or Failed once it has been handled.
+	Total      int
+	Queued     int
+	Existing   int
+	InProgress int
+	Failed     int
+
+	// LastError is the reason the most recent diff failed to be queued, if any did.
+	LastError string
+
+	Created   time.Time
+	Updated   time.Time
+	Completed time.Time
+}
+
+// ErrInvalidBackfill is returned when a backfill cannot be started because its request is
+// not valid.
+type ErrInvalidBackfill struct {
+	Reason string
+}
+
+func (e ErrInvalidBackfill) Error() string {
+	return "invalid backfill: " + e.Reason
+}
+
+// Backfiller queues the diffs of historical ranges in the background.
+type Backfiller interface {
+	// Start validates the request and starts queuing its diffs. An error of type
+	// ErrInvalidBackfill is returned if the request is not valid.
+	Start(ctx context.Context, r BackfillRequest) (Backfill, error)
+
+	// Get returns the progress of a backfill. An error of type ErrNotFound is returned if it
+	// does not exist.
+	Get(ctx context.Context, id string) (Backfill, error)
+}
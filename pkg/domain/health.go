@@ -0,0 +1,8 @@
+package domain
+
+import "context"
+
+// Checker verifies that a dependency of the service can be reached
+type Checker interface {
+	Check(ctx context.Context) error
+}
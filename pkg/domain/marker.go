@@ -14,6 +14,15 @@ func (e ErrInProgress) Error() string {
 	return fmt.Sprintf("diff %s is being created", e.Key)
 }
 
+// ErrLeaseLost indicates that the lease on a diff has expired and been acquired by another worker
+type ErrLeaseLost struct {
+	Key string
+}
+
+func (e ErrLeaseLost) Error() string {
+	return fmt.Sprintf("lease on diff %s was lost", e.Key)
+}
+
 // Marker is an interface for indicating that a diff is in progress of being created
 type Marker interface {
 	// Mark flags the diff identified by key as being "in progress"
@@ -22,3 +31,28 @@ type Marker interface {
 	// Unmark flags the diff identified by key as not being "in progress"
 	Unmark(ctx context.Context, key string) error
 }
+
+// LeaseMarker is implemented by Markers which grant exclusive, expiring leases on diffs to the
+// workers which create them. A worker renews its lease for as long as it works on the diff, so
+// that the diff is not created by another worker at the same time, and so that a diff abandoned
+// by a crashed worker can be acquired once the lease expires.
+//
+// Mark returns an error of type ErrInProgress if the diff is already marked or leased and the
+// mark or lease has not expired.
+type LeaseMarker interface {
+	Marker
+
+	// Acquire leases the diff to the caller, and returns an ID which identifies the lease. A
+	// diff which is marked, but not yet leased, can be acquired. If the diff is leased to
+	// another worker and the lease has not expired, an error of type ErrInProgress is returned.
+	// The diff is recorded with the lease so that it can be queued again if the worker is lost.
+	Acquire(ctx context.Context, d Diff) (string, error)
+
+	// Renew extends the lease. If the lease has expired and been acquired by another worker,
+	// an error of type ErrLeaseLost is returned.
+	Renew(ctx context.Context, key string, lease string) error
+
+	// Release ends the lease and flags the diff as not being "in progress", unless the lease
+	// has been acquired by another worker.
+	Release(ctx context.Context, key string, lease string) error
+}
@@ -2,9 +2,14 @@ package domain
 
 import (
 	"context"
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
+var diffNamespace = uuid.NewSHA1(uuid.Nil, []byte("diff"))
+
 // Diff represents two time for which a network graph diff will be computed
 type Diff struct {
 	ID            string
@@ -12,6 +17,43 @@ type Diff struct {
 	PreviousStop  time.Time
 	NextStart     time.Time
 	NextStop      time.Time
+
+	// TTL is how long the diff is kept once it is created. If zero, the diff is only
+	// subject to the retention policy of the service.
+	TTL time.Duration
+
+	// Scope is the tenant and accounts the diff is restricted to.
+	Scope Scope
+}
+
+// NewDiff returns the Diff of the given time ranges, of all accounts and no tenant, with the ID
+// which is unique to them. The time ranges are truncated to the nearest minute since anything
+// with more precision doesn't really fit the vpc flow filter use case.
+func NewDiff(previousStart, previousStop, nextStart, nextStop time.Time) Diff {
+	return NewScopedDiff(previousStart, previousStop, nextStart, nextStop, Scope{})
+}
+
+// NewScopedDiff returns the Diff of the given time ranges restricted to the scope, with the ID
+// which is unique to them. The scope is part of the ID, so diffs of the same time ranges in
+// different scopes are different diffs. Diffs of the zero Scope have the same ID as by NewDiff.
+func NewScopedDiff(previousStart, previousStop, nextStart, nextStop time.Time, scope Scope) Diff {
+	name := previousStart.String() + previousStop.String() + nextStart.String() + nextStop.String()
+	if scope.Tenant != "" || len(scope.Accounts) > 0 {
+		name += "tenant=" + scope.Tenant + "accounts=" + strings.Join(scope.Accounts, ",")
+	}
+	return Diff{
+		ID:            uuid.NewSHA1(diffNamespace, []byte(name)).String(),
+		PreviousStart: previousStart.Truncate(time.Minute),
+		PreviousStop:  previousStop.Truncate(time.Minute),
+		NextStart:     nextStart.Truncate(time.Minute),
+		NextStop:      nextStop.Truncate(time.Minute),
+		Scope:         scope,
+	}
+}
+
+// Key returns the storage key of the diff, which is its ID within its scope.
+func (d Diff) Key() string {
+	return d.Scope.Key(d.ID)
 }
 
 // Queuer provides an interface for queuing diff jobs onto a streaming appliance
@@ -0,0 +1,33 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// ScheduleState is the state of a recurring diff.
+type ScheduleState struct {
+	Name     string
+	Spec     string
+	Template string
+
+	// Scope is the tenant and accounts the diffs of the schedule are restricted to.
+	Scope Scope
+
+	// LastRun is the most recent scheduled time for which the diff was queued, or found to
+	// exist already. It is zero if the schedule has not run yet.
+	LastRun time.Time
+
+	// NextRun is the next scheduled time of the diff.
+	NextRun time.Time
+
+	// LastError is the reason the most recent run failed, if it did. It is cleared by the
+	// next successful run.
+	LastError string
+}
+
+// Scheduler queues recurring diffs.
+type Scheduler interface {
+	// States returns the state of every schedule.
+	States(ctx context.Context) ([]ScheduleState, error)
+}
@@ -0,0 +1,112 @@
+package domain
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// TenantPrefix is the prefix of the keys of the diffs of tenants
+const TenantPrefix = "tenants/"
+
+// ErrForbidden indicates that the caller of a request may not access the diffs of a scope.
+type ErrForbidden struct {
+	Reason string
+}
+
+func (e ErrForbidden) Error() string {
+	return fmt.Sprintf("forbidden: %s", e.Reason)
+}
+
+// Scope restricts a diff to the edges of a set of accounts, within a tenant. The zero Scope is
+// the scope of diffs of all accounts which belong to no tenant, as created when tenancy is not
+// enabled.
+type Scope struct {
+	// Tenant owns the diffs of the scope. The diffs of a tenant are stored under their own
+	// prefix, so that they are kept apart from those of other tenants.
+	Tenant string
+
+	// Accounts are the accounts, by their govpc_accountID, whose edges are included in the
+	// diff, in order and without duplicates. Empty means all accounts.
+	Accounts []string
+}
+
+// NewScope returns the Scope of the accounts within the tenant. Accounts are sorted, and empty
+// or duplicate accounts are removed, so that the same accounts always give the same Scope.
+func NewScope(tenant string, accounts []string) Scope {
+	seen := make(map[string]bool, len(accounts))
+	var unique []string
+	for _, account := range accounts {
+		account = strings.TrimSpace(account)
+		if account == "" || seen[account] {
+			continue
+		}
+		seen[account] = true
+		unique = append(unique, account)
+	}
+	sort.Strings(unique)
+	return Scope{Tenant: tenant, Accounts: unique}
+}
+
+// Key returns the storage key of the diff with the given ID in the scope. The keys of diffs of
+// a tenant have the prefix tenants/<tenant>/. The keys of diffs which belong to no tenant are
+// their IDs.
+func (s Scope) Key(id string) string {
+	if s.Tenant == "" {
+		return id
+	}
+	return TenantPrefix + s.Tenant + "/" + id
+}
+
+// Includes returns true if the edges of the account are included in diffs of the scope.
+func (s Scope) Includes(account string) bool {
+	if len(s.Accounts) == 0 {
+		return true
+	}
+	i := sort.SearchStrings(s.Accounts, account)
+	return i < len(s.Accounts) && s.Accounts[i] == account
+}
+
+// Contains returns true if the other scope is within this one, which is when both belong to
+// the same tenant and every account of the other scope is included in this one.
+func (s Scope) Contains(other Scope) bool {
+	if s.Tenant != other.Tenant {
+		return false
+	}
+	if len(s.Accounts) == 0 {
+		return true
+	}
+	if len(other.Accounts) == 0 {
+		return false
+	}
+	for _, account := range other.Accounts {
+		if !s.Includes(account) {
+			return false
+		}
+	}
+	return true
+}
+
+// Narrow returns the scope of the given accounts within this one. If no accounts are given,
+// the scope itself is returned. If any of the accounts is not included in the scope, an error
+// of type ErrForbidden is returned.
+func (s Scope) Narrow(accounts []string) (Scope, error) {
+	narrowed := NewScope(s.Tenant, accounts)
+	if len(narrowed.Accounts) == 0 {
+		return s, nil
+	}
+	if !s.Contains(narrowed) {
+		return Scope{}, ErrForbidden{Reason: fmt.Sprintf("accounts %s are not permitted", strings.Join(narrowed.Accounts, ","))}
+	}
+	return narrowed, nil
+}
+
+// Authorizer is a hook which decides which diffs the caller of a request may access, by the
+// identity headers of the request.
+type Authorizer interface {
+	// Authorize returns the scope the caller of the request is permitted. Callers may create
+	// and fetch diffs of that scope, or of any of its accounts. If the request does not have
+	// a valid identity, an error of type ErrForbidden is returned.
+	Authorize(r *http.Request) (Scope, error)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 )
 
 // ErrNotFound represents a resource lookup that failed due to a missing record.
@@ -15,6 +16,116 @@ func (e ErrNotFound) Error() string {
 	return fmt.Sprintf("digest %s was not found", e.ID)
 }
 
+// ErrExpired represents a lookup of a diff which has been removed by the retention policy.
+type ErrExpired struct {
+	ID string
+}
+
+func (e ErrExpired) Error() string {
+	return fmt.Sprintf("diff %s has expired", e.ID)
+}
+
+const (
+	// DiffStatusQueued indicates that a diff has been queued to be created.
+	DiffStatusQueued = "queued"
+
+	// DiffStatusComplete indicates that a diff has been created.
+	DiffStatusComplete = "complete"
+
+	// DiffStatusInProgress indicates that a diff is in the process of being created.
+	DiffStatusInProgress = "in_progress"
+
+	// DiffStatusExpired indicates that a diff has been removed by the retention policy.
+	DiffStatusExpired = "expired"
+)
+
+// DiffMetadata describes a diff which has been stored, or queued to be stored. Created is the
+// time the diff was stored, or queued if it has not been stored yet.
+type DiffMetadata struct {
+	Diff
+	Created time.Time
+	Status  string
+	Added   int
+	Removed int
+
+	// Expires is the time after which the diff is removed. Zero means the diff is only
+	// subject to the retention policy.
+	Expires time.Time
+}
+
+// RetentionPolicy limits how long stored diffs are kept. Zero values are unbounded.
+type RetentionPolicy struct {
+	// MaxAge is the maximum time a diff is kept after it is created.
+	MaxAge time.Duration
+
+	// MaxCount is the maximum number of diffs which are kept. The oldest diffs are removed
+	// first.
+	MaxCount int
+}
+
+// Expired returns true if the diff has expired at the given time, either because it has
+// already been removed, or by its own expiry or maximum age. MaxCount is not considered
+// as it depends on the other stored diffs.
+func (p RetentionPolicy) Expired(d DiffMetadata, now time.Time) bool {
+	if d.Status == DiffStatusExpired {
+		return true
+	}
+	if !d.Expires.IsZero() && !now.Before(d.Expires) {
+		return true
+	}
+	return p.MaxAge > 0 && !now.Before(d.Created.Add(p.MaxAge))
+}
+
+// ListFilter selects which diffs are returned by IndexedStorage.List. Zero values are unbounded.
+type ListFilter struct {
+	// Start and Stop select diffs for which either the previous or the next time range
+	// overlaps the range from Start to Stop.
+	Start time.Time
+	Stop  time.Time
+
+	// CreatedAfter and CreatedBefore select diffs by the time they were stored.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	// Limit is the maximum number of diffs to return. Zero means the storage default.
+	Limit int
+
+	// Cursor continues a previous listing from where it left off.
+	Cursor string
+
+	// Scope selects the diffs whose scope it contains. Nil selects the diffs of every scope.
+	Scope *Scope
+}
+
+// Matches returns true if the diff is selected by the filter.
+func (f ListFilter) Matches(d DiffMetadata) bool {
+	if f.Scope != nil && !f.Scope.Contains(d.Scope) {
+		return false
+	}
+	if !f.overlaps(d.PreviousStart, d.PreviousStop) && !f.overlaps(d.NextStart, d.NextStop) {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && !d.Created.After(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !d.Created.Before(f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+func (f ListFilter) overlaps(start, stop time.Time) bool {
+	return (f.Stop.IsZero() || start.Before(f.Stop)) && (f.Start.IsZero() || stop.After(f.Start))
+}
+
+// DiffPage is a single page of a diff listing.
+type DiffPage struct {
+	Diffs []DiffMetadata
+
+	// Next is the cursor for the following page, or empty if this is the last page.
+	Next string
+}
+
 // Storage is an interface for accessing created diffs. It is the caller's responsibility to call Close on the Reader when done.
 type Storage interface {
 	// Get returns the diff for the given key.
@@ -25,4 +136,71 @@ type Storage interface {
 
 	// Store stores the diff
 	Store(ctx context.Context, key string, data io.ReadCloser) error
+
+	// Delete removes the diff, along with any metadata recorded for it. Deleting a diff which
+	// does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// IndexedStorage is implemented by Storage which records the metadata of the diffs it stores.
+// It allows diffs to be listed and subjected to a retention policy.
+type IndexedStorage interface {
+	// Metadata returns the metadata recorded for the diff by Index. If no metadata has been
+	// recorded, an error of type ErrNotFound is returned.
+	Metadata(ctx context.Context, key string) (DiffMetadata, error)
+
+	// Index records the metadata of a diff so that it can be found with List.
+	Index(ctx context.Context, meta DiffMetadata) error
+
+	// List returns a page of the metadata of indexed diffs which match the filter.
+	List(ctx context.Context, filter ListFilter) (DiffPage, error)
+}
+
+// EncodedStorage is implemented by Storage which compresses the diffs it stores. It allows
+// diffs to be fetched without being decompressed, so that they can be passed through as-is to
+// clients which accept the same encoding.
+type EncodedStorage interface {
+	// GetEncoded returns the diff for the given key along with its content encoding. If the
+	// diff is stored with one of the accepted encodings it is returned as stored. Otherwise,
+	// it is decompressed and the returned encoding is empty.
+	GetEncoded(ctx context.Context, key string, accept []string) (io.ReadCloser, string, error)
+}
+
+// ErrUnsupported is returned by a Storage decorator for an optional operation, such as those
+// of ObjectStorage or IndexedStorage, which the Storage it decorates does not implement.
+type ErrUnsupported struct {
+	Operation string
+}
+
+func (e ErrUnsupported) Error() string {
+	return fmt.Sprintf("storage does not support %s", e.Operation)
+}
+
+// ObjectInfo describes the content of a stored diff, as it is stored.
+type ObjectInfo struct {
+	// Size is the length of the stored diff in bytes. It is the compressed length if the diff
+	// is stored with an Encoding.
+	Size int64
+
+	// Checksum identifies the content of the stored diff, and changes whenever the diff is
+	// stored again.
+	Checksum string
+
+	// Created is the time the diff was stored.
+	Created time.Time
+
+	// Encoding is the content encoding of the stored diff, or empty if it is uncompressed.
+	Encoding string
+}
+
+// ObjectStorage is implemented by Storage which can describe a stored diff without fetching it,
+// and fetch part of it. It allows downloads of diffs to be cached and resumed.
+type ObjectStorage interface {
+	// Stat returns the ObjectInfo of the diff for the given key. If the diff does not exist,
+	// an error of type ErrNotFound is returned.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+
+	// GetRange returns the diff for the given key, as it is stored, from the given offset to
+	// its end. It is the caller's responsibility to call Close on the Reader when done.
+	GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error)
 }
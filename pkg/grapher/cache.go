@@ -0,0 +1,536 @@
+package grapher
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+)
+
+const (
+	metaSuffix = ".json"
+
+	statCacheHit      = "grapher.cache.hit"
+	statCacheMiss     = "grapher.cache.miss"
+	statCacheEviction = "grapher.cache.eviction"
+	statCacheCorrupt  = "grapher.cache.corrupt"
+	statCacheSize     = "grapher.cache.size"
+)
+
+// ErrChecksumMismatch is returned while reading a cached graph whose content no longer
+// matches the checksum it was cached with.
+var ErrChecksumMismatch = errors.New("cached graph does not match its checksum")
+
+// CacheStore is the backing store of the graph Cache. Keys are opaque strings which are safe
+// to use as file names.
+type CacheStore interface {
+	// Get returns the content for the given key, or domain.ErrNotFound if the key does not
+	// exist. It is the caller's responsibility to call Close on the Reader when done.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Store stores the content for the given key, replacing any existing content.
+	Store(ctx context.Context, key string, data io.ReadCloser) error
+
+	// Delete removes the content for the given key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// CacheContent describes a single key of a CacheStore.
+type CacheContent struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// ListableCacheStore is a CacheStore which can list its keys. When the Store of a Cache is
+// listable, the size limit of the Cache applies to all of the graphs in the store, including
+// those cached by earlier runs and by other instances which share the store, rather than only
+// to the graphs cached by the process.
+type ListableCacheStore interface {
+	CacheStore
+
+	// List returns every key of the store.
+	List(ctx context.Context) ([]CacheContent, error)
+}
+
+// cacheEntry describes the cached graph for a single time range. Graphs are content
+// addressed, so the entry points at the content by its checksum.
+type cacheEntry struct {
+	Checksum string    `json:"checksum"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+}
+
+// Cache is a Grapher implementation which decorates another Grapher, caching the graphs
+// it returns by time range. Diffs which share a time range, such as day-over-day diffs,
+// then only fetch the graph for that range once.
+//
+// Graph content is stored under its SHA256 checksum, and is verified against the checksum
+// as it is read. Content which fails verification is evicted and reading it results in
+// ErrChecksumMismatch. Concurrent requests for the same uncached time range are coalesced
+// in to a single request to the decorated Grapher.
+type Cache struct {
+	Grapher      domain.Grapher
+	Store        CacheStore
+	StatProvider domain.StatFn
+
+	// MaxBytes limits the total size of the cached graphs. Once the limit is exceeded, the
+	// least recently used graphs are evicted. Zero means no limit. If the Store is a
+	// ListableCacheStore, the store is listed each time a graph is added, so that graphs
+	// cached by earlier runs or by other instances are counted, and evicted oldest first.
+	// Otherwise, only the graphs cached by this process are counted.
+	MaxBytes int64
+
+	// TTL is how long a graph is cached for. Zero means graphs never expire.
+	TTL time.Duration
+
+	// SpoolDirectory is the directory in which graphs are held while being added to the
+	// cache. If unset, the default directory for temporary files is used.
+	SpoolDirectory string
+
+	lock     sync.Mutex
+	once     sync.Once
+	entries  map[string]cacheEntry
+	lru      *list.List
+	blobs    map[string]*list.Element
+	size     int64
+	inflight map[string]chan struct{}
+	now      func() time.Time
+}
+
+// cacheBlob tracks the recency of use of a single piece of cached content
+type cacheBlob struct {
+	checksum string
+	size     int64
+}
+
+func (c *Cache) init() {
+	c.once.Do(func() {
+		c.entries = make(map[string]cacheEntry)
+		c.lru = list.New()
+		c.blobs = make(map[string]*list.Element)
+		c.inflight = make(map[string]chan struct{})
+		if c.now == nil {
+			c.now = time.Now
+		}
+	})
+}
+
+// Graph returns the graph for the given time range from the cache, or from the decorated
+// Grapher if it is not cached. It is the caller's responsibility to call Close on the
+// Reader when done.
+func (c *Cache) Graph(ctx context.Context, start, stop time.Time) (io.ReadCloser, error) {
+	c.init()
+	stat := c.StatProvider(ctx)
+	key := windowKey(start, stop)
+	for {
+		if graph := c.cached(ctx, key); graph != nil {
+			stat.Count(statCacheHit, 1)
+			return graph, nil
+		}
+		c.lock.Lock()
+		wait, ok := c.inflight[key]
+		if !ok {
+			c.inflight[key] = make(chan struct{})
+		}
+		c.lock.Unlock()
+		if !ok {
+			stat.Count(statCacheMiss, 1)
+			return c.fill(ctx, key, start, stop)
+		}
+		// Another request is already fetching this graph. Wait for it, then try the
+		// cache again.
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// cached returns the cached graph for the key, or nil if there is no usable graph cached.
+// Failures of the cache store are treated as a cache miss.
+func (c *Cache) cached(ctx context.Context, key string) io.ReadCloser {
+	c.lock.Lock()
+	entry, ok := c.entries[key]
+	c.lock.Unlock()
+	if !ok {
+		body, err := c.Store.Get(ctx, key+metaSuffix)
+		if err != nil {
+			return nil
+		}
+		err = json.NewDecoder(body).Decode(&entry)
+		_ = body.Close()
+		if err != nil {
+			return nil
+		}
+	}
+	if c.TTL > 0 && !c.now().Before(entry.Created.Add(c.TTL)) {
+		return nil
+	}
+	body, err := c.Store.Get(ctx, entry.Checksum)
+	if err != nil {
+		if _, ok := err.(domain.ErrNotFound); ok {
+			// The content was evicted, so the time range no longer refers to anything.
+			c.forget(key)
+			_ = c.Store.Delete(ctx, key+metaSuffix)
+		}
+		return nil
+	}
+	c.use(ctx, key, entry)
+	return &verifiedGraph{
+		ReadCloser: body,
+		hash:       sha256.New(),
+		checksum:   entry.Checksum,
+		onMismatch: func() {
+			c.StatProvider(ctx).Count(statCacheCorrupt, 1)
+			c.remove(entry.Checksum)
+			_ = c.Store.Delete(context.Background(), entry.Checksum)
+		},
+	}
+}
+
+// fill fetches the graph from the decorated Grapher and adds it to the cache. The graph is
+// spooled to a temporary file while its checksum is computed, and that file is returned.
+func (c *Cache) fill(ctx context.Context, key string, start, stop time.Time) (io.ReadCloser, error) {
+	defer func() {
+		c.lock.Lock()
+		close(c.inflight[key])
+		delete(c.inflight, key)
+		c.lock.Unlock()
+	}()
+	graph, err := c.Grapher.Graph(ctx, start, stop)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	f, err := ioutil.TempFile(c.SpoolDirectory, spoolPrefix)
+	if err != nil {
+		return nil, err
+	}
+	spooled := &spooledGraph{File: f}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), graph)
+	if err != nil {
+		_ = spooled.Close()
+		return nil, err
+	}
+	entry := cacheEntry{
+		Checksum: hex.EncodeToString(h.Sum(nil)),
+		Size:     size,
+		Created:  c.now(),
+	}
+	// A graph which can't be cached can still be returned, so failures to store it are
+	// only reflected in a later cache miss.
+	_ = c.store(ctx, key, entry, f)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		_ = spooled.Close()
+		return nil, err
+	}
+	return spooled, nil
+}
+
+func (c *Cache) store(ctx context.Context, key string, entry cacheEntry, f *os.File) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := c.Store.Store(ctx, entry.Checksum, ioutil.NopCloser(f)); err != nil {
+		return err
+	}
+	meta, _ := json.Marshal(entry)
+	if err := c.Store.Store(ctx, key+metaSuffix, ioutil.NopCloser(bytes.NewReader(meta))); err != nil {
+		return err
+	}
+	c.sync(ctx)
+	c.use(ctx, key, entry)
+	return nil
+}
+
+// sync reconciles the tracked content with the content of a listable store. Content which is
+// not tracked yet, having been cached by an earlier run or by another instance, is tracked as
+// less recently used than any content used by this process, oldest first. Tracked content
+// which is no longer in the store is forgotten. A store which can't be listed is left as-is.
+func (c *Cache) sync(ctx context.Context) {
+	store, ok := c.Store.(ListableCacheStore)
+	if !ok || c.MaxBytes <= 0 {
+		return
+	}
+	contents, err := store.List(ctx)
+	if err != nil {
+		return
+	}
+	sort.Slice(contents, func(i, j int) bool {
+		return contents[i].Modified.After(contents[j].Modified)
+	})
+	listed := make(map[string]bool, len(contents))
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, content := range contents {
+		if !isChecksum(content.Key) {
+			continue
+		}
+		listed[content.Key] = true
+		if _, ok := c.blobs[content.Key]; !ok {
+			c.blobs[content.Key] = c.lru.PushBack(&cacheBlob{checksum: content.Key, size: content.Size})
+			c.size += content.Size
+		}
+	}
+	for checksum := range c.blobs {
+		if !listed[checksum] {
+			c.removeLocked(checksum)
+		}
+	}
+}
+
+// use records the entry as the most recently used, and evicts content if the cache has
+// grown beyond its size limit.
+func (c *Cache) use(ctx context.Context, key string, entry cacheEntry) {
+	stat := c.StatProvider(ctx)
+	c.lock.Lock()
+	c.entries[key] = entry
+	c.touch(entry)
+	evicted := c.evict()
+	size := c.size
+	c.lock.Unlock()
+	for _, checksum := range evicted {
+		_ = c.Store.Delete(ctx, checksum)
+		stat.Count(statCacheEviction, 1)
+	}
+	stat.Gauge(statCacheSize, float64(size))
+}
+
+// touch marks the content of the entry as most recently used. The lock must be held.
+func (c *Cache) touch(entry cacheEntry) {
+	if e, ok := c.blobs[entry.Checksum]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.blobs[entry.Checksum] = c.lru.PushFront(&cacheBlob{checksum: entry.Checksum, size: entry.Size})
+	c.size += entry.Size
+}
+
+// evict removes the least recently used content until the cache is within its size limit,
+// and returns the checksums of the removed content. The most recently used content is
+// never evicted, even if it alone exceeds the limit. The lock must be held.
+func (c *Cache) evict() []string {
+	if c.MaxBytes <= 0 {
+		return nil
+	}
+	var evicted []string
+	for c.size > c.MaxBytes && c.lru.Len() > 1 {
+		blob := c.lru.Back().Value.(*cacheBlob)
+		c.removeLocked(blob.checksum)
+		evicted = append(evicted, blob.checksum)
+	}
+	return evicted
+}
+
+// forget removes the time range from the tracked entries.
+func (c *Cache) forget(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.entries, key)
+}
+
+func (c *Cache) remove(checksum string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.removeLocked(checksum)
+}
+
+// removeLocked forgets the content with the given checksum, and every time range which
+// refers to it. The lock must be held.
+func (c *Cache) removeLocked(checksum string) {
+	if e, ok := c.blobs[checksum]; ok {
+		c.size -= e.Value.(*cacheBlob).size
+		c.lru.Remove(e)
+		delete(c.blobs, checksum)
+	}
+	for key, entry := range c.entries {
+		if entry.Checksum == checksum {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// isChecksum reports whether a key of the store is graph content rather than the metadata of
+// a time range or a partially written file.
+func isChecksum(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
+
+// windowKey computes the cache key of a time range.
+func windowKey(start, stop time.Time) string {
+	sum := sha256.Sum256([]byte(start.UTC().Format(time.RFC3339Nano) + "/" + stop.UTC().Format(time.RFC3339Nano)))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifiedGraph computes the checksum of a cached graph as it is read, and fails the final
+// read if it does not match the checksum the graph was cached with.
+type verifiedGraph struct {
+	io.ReadCloser
+	hash       hash.Hash
+	checksum   string
+	onMismatch func()
+}
+
+func (v *verifiedGraph) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	_, _ = v.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(v.hash.Sum(nil)) != v.checksum {
+		v.onMismatch()
+		return n, ErrChecksumMismatch
+	}
+	return n, err
+}
+
+// DiskCacheStore is a CacheStore which keeps content in files in a local directory.
+type DiskCacheStore struct {
+	Directory string
+}
+
+// Get opens the file for the given key.
+func (s *DiskCacheStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(s.Directory, key))
+	if os.IsNotExist(err) {
+		return nil, domain.ErrNotFound{ID: key}
+	}
+	return f, err
+}
+
+// Store writes the content to the file for the given key. The content is first written to a
+// temporary file which is then renamed, so that partially written content is never visible.
+func (s *DiskCacheStore) Store(ctx context.Context, key string, data io.ReadCloser) error {
+	f, err := ioutil.TempFile(s.Directory, key)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(s.Directory, key))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// Delete removes the file for the given key.
+func (s *DiskCacheStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(filepath.Join(s.Directory, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List returns the files of the directory.
+func (s *DiskCacheStore) List(ctx context.Context) ([]CacheContent, error) {
+	infos, err := ioutil.ReadDir(s.Directory)
+	if err != nil {
+		return nil, err
+	}
+	contents := make([]CacheContent, 0, len(infos))
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			contents = append(contents, CacheContent{Key: info.Name(), Size: info.Size(), Modified: info.ModTime()})
+		}
+	}
+	return contents, nil
+}
+
+// S3CacheStore is a CacheStore which keeps content in objects of an S3 bucket. Objects are
+// stored under their keys as-is, so the bucket should be dedicated to the cache.
+type S3CacheStore struct {
+	Bucket string
+	Client s3iface.S3API
+
+	uploader s3manageriface.UploaderAPI
+	once     sync.Once
+}
+
+// Get returns the content of the object for the given key.
+func (s *S3CacheStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	res, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, domain.ErrNotFound{ID: key}
+		}
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// Store uploads the content to the object for the given key.
+func (s *S3CacheStore) Store(ctx context.Context, key string, data io.ReadCloser) error {
+	// lazily initialize uploader with the s3 client
+	s.once.Do(func() {
+		s.uploader = s3manager.NewUploaderWithClient(s.Client)
+	})
+	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Body:   data,
+	})
+	return err
+}
+
+// Delete removes the object for the given key.
+func (s *S3CacheStore) Delete(ctx context.Context, key string) error {
+	_, err := s.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// List returns the objects of the bucket.
+func (s *S3CacheStore) List(ctx context.Context) ([]CacheContent, error) {
+	var contents []CacheContent
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(s.Bucket)}
+	for {
+		res, err := s.Client.ListObjectsV2WithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range res.Contents {
+			contents = append(contents, CacheContent{
+				Key:      aws.StringValue(obj.Key),
+				Size:     aws.Int64Value(obj.Size),
+				Modified: aws.TimeValue(obj.LastModified),
+			})
+		}
+		if !aws.BoolValue(res.IsTruncated) {
+			return contents, nil
+		}
+		input.ContinuationToken = res.NextContinuationToken
+	}
+}
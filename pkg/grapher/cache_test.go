@@ -0,0 +1,323 @@
+package grapher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingStat struct {
+	lock   sync.Mutex
+	counts map[string]float64
+}
+
+func (s *countingStat) AddTags(tags ...string)                               {}
+func (s *countingStat) GetTags() []string                                    { return nil }
+func (s *countingStat) Gauge(stat string, value float64, tags ...string)     {}
+func (s *countingStat) Histogram(stat string, value float64, tags ...string) {}
+func (s *countingStat) Timing(stat string, value time.Duration, tags ...string) {
+}
+func (s *countingStat) Count(stat string, count float64, tags ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.counts[stat] += count
+}
+
+func (s *countingStat) get(stat string) float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.counts[stat]
+}
+
+func newTestCache(t *testing.T, g domain.Grapher) (*Cache, *countingStat, func()) {
+	dir, err := ioutil.TempDir("", "cache")
+	require.Nil(t, err)
+	stat := &countingStat{counts: make(map[string]float64)}
+	c := &Cache{
+		Grapher:      g,
+		Store:        &DiskCacheStore{Directory: dir},
+		StatProvider: func(context.Context) domain.Stat { return stat },
+	}
+	return c, stat, func() { os.RemoveAll(dir) }
+}
+
+func graphBody(s string) io.ReadCloser {
+	return ioutil.NopCloser(bytes.NewReader([]byte(s)))
+}
+
+func newGraph(s string) func(context.Context, time.Time, time.Time) (io.ReadCloser, error) {
+	return func(context.Context, time.Time, time.Time) (io.ReadCloser, error) {
+		return graphBody(s), nil
+	}
+}
+
+func readGraph(t *testing.T, c *Cache, start, stop time.Time) (string, error) {
+	graph, err := c.Graph(context.Background(), start, stop)
+	if err != nil {
+		return "", err
+	}
+	defer graph.Close()
+	data, err := ioutil.ReadAll(graph)
+	return string(data), err
+}
+
+func TestCacheHit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stop := time.Now()
+	start := stop.Add(-1 * time.Hour)
+	mockGrapher := NewMockGrapher(ctrl)
+	mockGrapher.EXPECT().Graph(gomock.Any(), start, stop).Return(graphBody("graph"), nil).Times(1)
+	c, stat, cleanup := newTestCache(t, mockGrapher)
+	defer cleanup()
+
+	for i := 0; i < 3; i++ {
+		data, err := readGraph(t, c, start, stop)
+		require.Nil(t, err)
+		assert.Equal(t, "graph", data)
+	}
+	assert.Equal(t, float64(1), stat.get(statCacheMiss))
+	assert.Equal(t, float64(2), stat.get(statCacheHit))
+
+	// a new cache over the same store finds the persisted graph
+	c2 := &Cache{Grapher: mockGrapher, Store: c.Store, StatProvider: c.StatProvider}
+	data, err := readGraph(t, c2, start, stop)
+	require.Nil(t, err)
+	assert.Equal(t, "graph", data)
+	assert.Equal(t, float64(3), stat.get(statCacheHit))
+}
+
+func TestCacheTTL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stop := time.Now()
+	start := stop.Add(-1 * time.Hour)
+	mockGrapher := NewMockGrapher(ctrl)
+	mockGrapher.EXPECT().Graph(gomock.Any(), start, stop).Return(graphBody("old graph"), nil)
+	mockGrapher.EXPECT().Graph(gomock.Any(), start, stop).Return(graphBody("new graph"), nil)
+	c, _, cleanup := newTestCache(t, mockGrapher)
+	defer cleanup()
+	now := time.Now()
+	c.TTL = time.Minute
+	c.now = func() time.Time { return now }
+
+	data, err := readGraph(t, c, start, stop)
+	require.Nil(t, err)
+	assert.Equal(t, "old graph", data)
+	now = now.Add(time.Minute)
+	data, err = readGraph(t, c, start, stop)
+	require.Nil(t, err)
+	assert.Equal(t, "new graph", data)
+}
+
+func TestCacheEviction(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stop := time.Now()
+	starts := []time.Time{stop.Add(-3 * time.Hour), stop.Add(-2 * time.Hour), stop.Add(-1 * time.Hour)}
+	mockGrapher := NewMockGrapher(ctrl)
+	mockGrapher.EXPECT().Graph(gomock.Any(), starts[0], stop).DoAndReturn(newGraph("graph 0")).Times(2)
+	mockGrapher.EXPECT().Graph(gomock.Any(), starts[1], stop).Return(graphBody("graph 1"), nil).Times(1)
+	mockGrapher.EXPECT().Graph(gomock.Any(), starts[2], stop).Return(graphBody("graph 2"), nil).Times(1)
+	c, stat, cleanup := newTestCache(t, mockGrapher)
+	defer cleanup()
+	c.MaxBytes = 14
+
+	for _, start := range starts {
+		_, err := readGraph(t, c, start, stop)
+		require.Nil(t, err)
+	}
+	assert.Equal(t, float64(1), stat.get(statCacheEviction))
+	_, err := readGraph(t, c, starts[2], stop)
+	require.Nil(t, err)
+	data, err := readGraph(t, c, starts[0], stop)
+	require.Nil(t, err)
+	assert.Equal(t, "graph 0", data)
+}
+
+func TestCacheEvictionCountsStoredContent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stop := time.Now()
+	starts := []time.Time{stop.Add(-3 * time.Hour), stop.Add(-2 * time.Hour), stop.Add(-1 * time.Hour)}
+	mockGrapher := NewMockGrapher(ctrl)
+	mockGrapher.EXPECT().Graph(gomock.Any(), starts[0], stop).Return(graphBody("graph 0"), nil)
+	mockGrapher.EXPECT().Graph(gomock.Any(), starts[1], stop).Return(graphBody("graph 1"), nil)
+	mockGrapher.EXPECT().Graph(gomock.Any(), starts[2], stop).Return(graphBody("graph 2"), nil)
+	earlier, _, cleanup := newTestCache(t, mockGrapher)
+	defer cleanup()
+	for _, start := range starts[:2] {
+		_, err := readGraph(t, earlier, start, stop)
+		require.Nil(t, err)
+	}
+	// make the content of the first graph the oldest
+	dir := earlier.Store.(*DiskCacheStore).Directory
+	oldest := earlier.entries[windowKey(starts[0], stop)].Checksum
+	require.Nil(t, os.Chtimes(filepath.Join(dir, oldest), stop.Add(-time.Hour), stop.Add(-time.Hour)))
+
+	// a new cache over the same store counts the graphs cached by the earlier one
+	stat := &countingStat{counts: make(map[string]float64)}
+	c := &Cache{
+		Grapher:      mockGrapher,
+		Store:        earlier.Store,
+		StatProvider: func(context.Context) domain.Stat { return stat },
+		MaxBytes:     14,
+	}
+	_, err := readGraph(t, c, starts[2], stop)
+	require.Nil(t, err)
+	assert.Equal(t, float64(1), stat.get(statCacheEviction))
+	_, err = os.Stat(filepath.Join(dir, oldest))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCacheCorrupt(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stop := time.Now()
+	start := stop.Add(-1 * time.Hour)
+	mockGrapher := NewMockGrapher(ctrl)
+	mockGrapher.EXPECT().Graph(gomock.Any(), start, stop).DoAndReturn(newGraph("graph")).Times(2)
+	c, stat, cleanup := newTestCache(t, mockGrapher)
+	defer cleanup()
+
+	_, err := readGraph(t, c, start, stop)
+	require.Nil(t, err)
+	var checksum string
+	for _, entry := range c.entries {
+		checksum = entry.Checksum
+	}
+	dir := c.Store.(*DiskCacheStore).Directory
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, checksum), []byte("grapf"), 0600))
+
+	_, err = readGraph(t, c, start, stop)
+	assert.Equal(t, ErrChecksumMismatch, err)
+	assert.Equal(t, float64(1), stat.get(statCacheCorrupt))
+	data, err := readGraph(t, c, start, stop)
+	require.Nil(t, err)
+	assert.Equal(t, "graph", data)
+}
+
+func TestCacheCoalesce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stop := time.Now()
+	start := stop.Add(-1 * time.Hour)
+	release := make(chan struct{})
+	mockGrapher := NewMockGrapher(ctrl)
+	mockGrapher.EXPECT().Graph(gomock.Any(), start, stop).DoAndReturn(func(context.Context, time.Time, time.Time) (io.ReadCloser, error) {
+		<-release
+		return graphBody("graph"), nil
+	}).Times(1)
+	c, _, cleanup := newTestCache(t, mockGrapher)
+	defer cleanup()
+
+	wg := &sync.WaitGroup{}
+	results := make(chan string, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, _ := readGraph(t, c, start, stop)
+			results <- data
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+	for data := range results {
+		assert.Equal(t, "graph", data)
+	}
+}
+
+func TestCacheGrapherError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stop := time.Now()
+	start := stop.Add(-1 * time.Hour)
+	mockGrapher := NewMockGrapher(ctrl)
+	mockGrapher.EXPECT().Graph(gomock.Any(), start, stop).Return(nil, errors.New(""))
+	mockGrapher.EXPECT().Graph(gomock.Any(), start, stop).Return(graphBody("graph"), nil)
+	c, _, cleanup := newTestCache(t, mockGrapher)
+	defer cleanup()
+
+	_, err := readGraph(t, c, start, stop)
+	assert.NotNil(t, err)
+	data, err := readGraph(t, c, start, stop)
+	require.Nil(t, err)
+	assert.Equal(t, "graph", data)
+}
+
+func TestDiskCacheStoreNotFound(t *testing.T) {
+	s := &DiskCacheStore{Directory: "/does/not/exist"}
+	_, err := s.Get(context.Background(), "key")
+	_, ok := err.(domain.ErrNotFound)
+	assert.True(t, ok)
+	assert.Nil(t, s.Delete(context.Background(), "key"))
+}
+
+func TestS3CacheStore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockUploader := NewMockUploaderAPI(ctrl)
+	s := &S3CacheStore{Bucket: bucket, Client: mockS3, uploader: mockUploader}
+	s.once.Do(func() {}) // trigger the once call
+
+	mockUploader.EXPECT().UploadWithContext(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, input *s3manager.UploadInput, _ ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+		assert.Equal(t, "key", aws.StringValue(input.Key))
+		assert.Nil(t, input.ContentEncoding)
+		return &s3manager.UploadOutput{}, nil
+	})
+	assert.Nil(t, s.Store(context.Background(), "key", graphBody("graph")))
+
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("key"),
+	}).Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "", nil))
+	_, err := s.Get(context.Background(), "key")
+	_, ok := err.(domain.ErrNotFound)
+	assert.True(t, ok)
+
+	modified := time.Now()
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+	}).Return(&s3.ListObjectsV2Output{
+		Contents:              []*s3.Object{{Key: aws.String("a"), Size: aws.Int64(1), LastModified: aws.Time(modified)}},
+		IsTruncated:           aws.Bool(true),
+		NextContinuationToken: aws.String("next"),
+	}, nil)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+		Bucket:            aws.String(bucket),
+		ContinuationToken: aws.String("next"),
+	}).Return(&s3.ListObjectsV2Output{
+		Contents: []*s3.Object{{Key: aws.String("b"), Size: aws.Int64(2), LastModified: aws.Time(modified)}},
+	}, nil)
+	contents, err := s.List(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, []CacheContent{{Key: "a", Size: 1, Modified: modified}, {Key: "b", Size: 2, Modified: modified}}, contents)
+}
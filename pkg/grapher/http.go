@@ -1,19 +1,23 @@
 package grapher
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/tracing"
 )
 
 const (
 	queryStart = "start"
 	queryStop  = "stop"
+
+	spoolPrefix = "graph"
 )
 
 // HTTP is used to create a new graph
@@ -22,65 +26,162 @@ type HTTP struct {
 	Endpoint        *url.URL
 	PollTimeout     time.Duration
 	PollingInterval time.Duration
+
+	// SpoolDirectory, if set, is a directory in which graphs are copied to a temporary file
+	// before being returned. This frees the HTTP connection to the grapher as soon as the
+	// graph is downloaded. The temporary file is removed when the returned graph is closed.
+	// If unset, the live response body is returned.
+	SpoolDirectory string
 }
 
 // Graph starts a new graph job, and waits for its completion. On successful completion, Graph will return the
-// graph content.
+// graph content. The graph is streamed rather than buffered in memory, so it is the caller's responsibility
+// to call Close on the returned ReadCloser when done.
 func (c *HTTP) Graph(ctx context.Context, start, stop time.Time) (io.ReadCloser, error) {
 	req, err := newGraphRequest(c.Endpoint, http.MethodPost, start, stop)
 	if err != nil {
 		return nil, err
 	}
+	tracing.Inject(ctx, req.Header)
 	res, err := c.Client.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
 	// If the status code is 202 or 409 the job is either a) scheduled b) in progress or c) created.
 	// In all of these cases, we want to poll the GET endpoint for a 200. Otherwise, report an error.
 	if res.StatusCode != http.StatusAccepted && res.StatusCode != http.StatusConflict {
 		data, _ := ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
 		return nil, fmt.Errorf("Received unexpected response from grapher %d: %s", res.StatusCode, data)
 	}
-	pollingCtx, cancel := context.WithTimeout(ctx, c.PollTimeout)
-	defer cancel()
-	return c.waitForGraph(pollingCtx, start, stop)
+	discard(res.Body)
+	return c.waitForGraph(ctx, time.Now().Add(c.PollTimeout), start, stop)
 }
 
-func (c *HTTP) waitForGraph(ctx context.Context, start, stop time.Time) (io.ReadCloser, error) {
+// waitForGraph polls the grapher until the graph is ready or the deadline passes. The deadline
+// bounds the polling only: the returned graph is tied to the caller's context, so reading a
+// large graph is not cut short by the polling timeout.
+func (c *HTTP) waitForGraph(ctx context.Context, deadline time.Time, start, stop time.Time) (io.ReadCloser, error) {
 	req, err := newGraphRequest(c.Endpoint, http.MethodGet, start, stop)
 	if err != nil {
 		return nil, err
 	}
+	tracing.Inject(ctx, req.Header)
 	var attempts int
 	for {
 		attempts++
-		res, err := c.Client.Do(req.WithContext(ctx))
+		res, cancel, err := c.poll(ctx, req, deadline)
 		if err != nil {
 			return nil, err
 		}
-		defer res.Body.Close()
 		if res.StatusCode == http.StatusOK { // graph is ready
-			return extractGraph(res.Body)
+			graph, err := c.extractGraph(res.Body)
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			return &cancelOnClose{ReadCloser: graph, cancel: cancel}, nil
 		}
 		if res.StatusCode != http.StatusNoContent {
 			data, _ := ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+			cancel()
 			return nil, fmt.Errorf("Received unexpected response while polling grapher %d: %s", res.StatusCode, data)
 		}
-		select {
-		case <-ctx.Done():
+		// Release each polled response before the next attempt rather than holding
+		// every one of them open until the graph is ready.
+		discard(res.Body)
+		cancel()
+		if ctx.Err() == nil && time.Now().Before(deadline) {
+			wait := time.NewTimer(c.PollingInterval)
+			select {
+			case <-ctx.Done():
+			case <-wait.C:
+			}
+			wait.Stop()
+		}
+		if ctx.Err() != nil {
 			return nil, fmt.Errorf("request time out reached after %d attempt(s): %s", attempts, ctx.Err().Error())
-		case <-time.After(c.PollingInterval):
 		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("request time out reached after %d attempt(s): %s", attempts, context.DeadlineExceeded.Error())
+		}
+	}
+}
+
+// poll makes a single polling request. The request is cancelled if the deadline passes before
+// the response arrives, but not afterwards, so the response body outlives the deadline. The
+// returned CancelFunc releases the request once its body is no longer needed.
+func (c *HTTP) poll(ctx context.Context, req *http.Request, deadline time.Time) (*http.Response, context.CancelFunc, error) {
+	attemptCtx, cancel := context.WithCancel(ctx)
+	timer := time.AfterFunc(time.Until(deadline), cancel)
+	res, err := c.Client.Do(req.WithContext(attemptCtx))
+	if !timer.Stop() {
+		// The deadline passed while waiting for the response.
+		if err == nil {
+			discard(res.Body)
+		}
+		cancel()
+		return nil, nil, fmt.Errorf("request time out reached while polling grapher: %s", context.DeadlineExceeded.Error())
+	}
+	if err != nil {
+		cancel()
+		return nil, nil, err
 	}
+	return res, cancel, nil
 }
 
-func extractGraph(r io.Reader) (io.ReadCloser, error) {
-	data, err := ioutil.ReadAll(r)
+// extractGraph returns the graph body as-is, or, if a spool directory is configured,
+// copies it to a temporary file and returns the file instead. Either way, the body is
+// never read fully into memory.
+func (c *HTTP) extractGraph(body io.ReadCloser) (io.ReadCloser, error) {
+	if c.SpoolDirectory == "" {
+		return body, nil
+	}
+	defer body.Close()
+	f, err := ioutil.TempFile(c.SpoolDirectory, spoolPrefix)
 	if err != nil {
 		return nil, err
 	}
-	return ioutil.NopCloser(bytes.NewReader(data)), nil
+	if _, err = io.Copy(f, body); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	return &spooledGraph{File: f}, nil
+}
+
+// discard drains and closes a response body so that the underlying connection can be reused.
+func discard(body io.ReadCloser) {
+	_, _ = io.Copy(ioutil.Discard, body)
+	_ = body.Close()
+}
+
+// cancelOnClose releases a context once the wrapped ReadCloser is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// spooledGraph is a graph which was copied to a temporary file. Closing it removes the file.
+type spooledGraph struct {
+	*os.File
+}
+
+func (s *spooledGraph) Close() error {
+	err := s.File.Close()
+	if rmErr := os.Remove(s.File.Name()); err == nil {
+		err = rmErr
+	}
+	return err
 }
 
 func newGraphRequest(endpoint *url.URL, method string, start, stop time.Time) (*http.Request, error) {
@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"testing"
 	"time"
 
@@ -102,6 +103,122 @@ func TestGraphRetriesSuceeded(t *testing.T) {
 	assert.Equal(t, body, string(data))
 }
 
+func TestGraphPolledResponsesClosed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRT := NewMockRoundTripper(ctrl)
+	polled := make([]*trackingBody, 3)
+	for i := range polled {
+		polled[i] = &trackingBody{Reader: bytes.NewReader([]byte(""))}
+	}
+	graph := &trackingBody{Reader: bytes.NewReader([]byte("this is a graph"))}
+	setClientExpectations(mockRT, http.MethodPost, nil, response{statusCode: 202})
+	for _, body := range polled {
+		mockRT.EXPECT().RoundTrip(&requestMethodMatcher{method: http.MethodGet}).Return(&http.Response{StatusCode: 204, Body: body}, nil)
+	}
+	mockRT.EXPECT().RoundTrip(&requestMethodMatcher{method: http.MethodGet}).Return(&http.Response{StatusCode: 200, Body: graph}, nil)
+	output, err := execute(context.Background(), mockRT)
+	assert.Nil(t, err)
+	for _, body := range polled {
+		assert.True(t, body.closed)
+	}
+	assert.False(t, graph.closed)
+	assert.Nil(t, output.Close())
+	assert.True(t, graph.closed)
+}
+
+func TestGraphOutlivesPollTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRT := NewMockRoundTripper(ctrl)
+	body := "this is a slow graph"
+	setClientExpectations(mockRT, http.MethodPost, nil, response{statusCode: 202})
+	mockRT.EXPECT().RoundTrip(&requestMethodMatcher{method: http.MethodGet}).DoAndReturn(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: &contextBody{ctx: r.Context(), Reader: bytes.NewReader([]byte(body))}}, nil
+	})
+	u, _ := url.Parse("http://host")
+	c := HTTP{
+		Endpoint:        u,
+		Client:          &http.Client{Transport: mockRT},
+		PollTimeout:     10 * time.Millisecond,
+		PollingInterval: time.Duration(-1),
+	}
+	output, err := c.Graph(context.Background(), time.Now().Add(-1*time.Minute), time.Now())
+	assert.Nil(t, err)
+	defer output.Close()
+	time.Sleep(50 * time.Millisecond)
+	data, err := ioutil.ReadAll(output)
+	assert.Nil(t, err)
+	assert.Equal(t, body, string(data))
+}
+
+func TestGraphPollTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRT := NewMockRoundTripper(ctrl)
+	setClientExpectations(mockRT, http.MethodPost, nil, response{statusCode: 202})
+	mockRT.EXPECT().RoundTrip(&requestMethodMatcher{method: http.MethodGet}).Return(&http.Response{StatusCode: 204, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil).AnyTimes()
+	u, _ := url.Parse("http://host")
+	c := HTTP{
+		Endpoint:        u,
+		Client:          &http.Client{Transport: mockRT},
+		PollTimeout:     10 * time.Millisecond,
+		PollingInterval: time.Millisecond,
+	}
+	_, err := c.Graph(context.Background(), time.Now().Add(-1*time.Minute), time.Now())
+	assert.NotNil(t, err)
+}
+
+func TestGraphSpooled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dir, err := ioutil.TempDir("", "spool")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	mockRT := NewMockRoundTripper(ctrl)
+	body := "this is a spooled graph"
+	graph := &trackingBody{Reader: bytes.NewReader([]byte(body))}
+	setClientExpectations(mockRT, http.MethodPost, nil, response{statusCode: 202})
+	mockRT.EXPECT().RoundTrip(&requestMethodMatcher{method: http.MethodGet}).Return(&http.Response{StatusCode: 200, Body: graph}, nil)
+	u, _ := url.Parse("http://host")
+	c := HTTP{
+		Endpoint:        u,
+		Client:          &http.Client{Transport: mockRT},
+		PollTimeout:     time.Minute,
+		PollingInterval: time.Duration(-1),
+		SpoolDirectory:  dir,
+	}
+	output, err := c.Graph(context.Background(), time.Now().Add(-1*time.Minute), time.Now())
+	assert.Nil(t, err)
+	assert.True(t, graph.closed)
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1)
+	data, _ := ioutil.ReadAll(output)
+	assert.Equal(t, body, string(data))
+	assert.Nil(t, output.Close())
+	files, _ = ioutil.ReadDir(dir)
+	assert.Len(t, files, 0)
+}
+
+func TestGraphSpoolDirectoryError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRT := NewMockRoundTripper(ctrl)
+	setClientExpectations(mockRT, http.MethodPost, nil, response{statusCode: 202})
+	setClientExpectations(mockRT, http.MethodGet, nil, response{statusCode: 200, body: "graph"})
+	u, _ := url.Parse("http://host")
+	c := HTTP{
+		Endpoint:        u,
+		Client:          &http.Client{Transport: mockRT},
+		PollTimeout:     time.Minute,
+		PollingInterval: time.Duration(-1),
+		SpoolDirectory:  "/does/not/exist",
+	}
+	_, err := c.Graph(context.Background(), time.Now().Add(-1*time.Minute), time.Now())
+	assert.NotNil(t, err)
+}
+
 func execute(ctx context.Context, rt http.RoundTripper) (io.ReadCloser, error) {
 	u, _ := url.Parse("http://host")
 	stop := time.Now()
@@ -115,6 +232,34 @@ func execute(ctx context.Context, rt http.RoundTripper) (io.ReadCloser, error) {
 	return c.Graph(ctx, start, stop)
 }
 
+type trackingBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *trackingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+// contextBody fails reads once the context of the request it answers is done, as the body
+// of a real response does.
+type contextBody struct {
+	io.Reader
+	ctx context.Context
+}
+
+func (b *contextBody) Read(p []byte) (int, error) {
+	if err := b.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return b.Reader.Read(p)
+}
+
+func (b *contextBody) Close() error {
+	return nil
+}
+
 type response struct {
 	statusCode int
 	body       string
@@ -0,0 +1,319 @@
+package grapher
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field offsets of a version 2 VPC flow log record, which looks like:
+//
+// 2 123456789010 eni-abc123de 172.31.16.139 172.31.16.21 20641 22 6 20 4249 1418530010 1418530070 ACCEPT OK
+const (
+	fieldVersion = iota
+	fieldAccountID
+	fieldInterfaceID
+	fieldSrcAddr
+	fieldDstAddr
+	fieldSrcPort
+	fieldDstPort
+	fieldProtocol
+	fieldPackets
+	fieldBytes
+	fieldStart
+	fieldEnd
+	fieldAction
+	fieldLogStatus
+	fieldCount
+)
+
+const (
+	actionReject = "REJECT"
+	logStatusOK  = "OK"
+)
+
+// gzipMagic is the header every gzip stream starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// DefaultDeliveryDelay is the default for how long after a flow log record starts the file
+// which contains it may be delivered.
+const DefaultDeliveryDelay = time.Hour
+
+// AWS delivers flow log files under keys like:
+//
+// AWSLogs/123456789010/vpcflowlogs/us-east-1/2014/12/14/123456789010_vpcflowlogs_us-east-1_fl-1234abcd_20141214T0405Z_hash.log.gz
+//
+// where the final timestamp is the time, to the minute, at which the file was created.
+var (
+	deliveryTimePattern = regexp.MustCompile(`_(\d{8}T\d{4}Z)_`)
+	deliveryDatePattern = regexp.MustCompile(`(?:^|/)(\d{4}/\d{2}/\d{2})/`)
+)
+
+const (
+	deliveryTimeLayout = "20060102T1504Z"
+	deliveryDateLayout = "2006/01/02"
+)
+
+// Native is a Grapher implementation which builds graphs in-process from raw VPC flow log
+// files rather than calling out to vpcflow-grapherd. The graphs are written in the same DOT
+// format as the go-vpcflow graph component used by vpcflow-grapherd, so graphs from either
+// source can be diffed against one another.
+//
+// A flow log record is included in the graph if its start time falls within the requested
+// range. Records of the same flow, meaning the same account, interface, addresses, ports,
+// protocol and action, are merged into a single edge.
+//
+// Files named the way AWS delivers them are only read if they were delivered between the
+// start of the range and DeliveryDelay after its end, as no other file can contain records
+// of the range. Files with any other name are always read.
+// If the Source is a DatedFlowLogSource, only the days on which such files may have been
+// delivered are listed.
+type Native struct {
+	Source FlowLogSource
+
+	// DeliveryDelay is how long after a record starts the file which contains it may be
+	// delivered. Defaults to DefaultDeliveryDelay.
+	DeliveryDelay time.Duration
+}
+
+// Graph builds the graph of all flow log records in the given time range. It is the caller's
+// responsibility to call Close on the Reader when done.
+func (g *Native) Graph(ctx context.Context, start, stop time.Time) (io.ReadCloser, error) {
+	var names []string
+	var err error
+	if source, ok := g.Source.(DatedFlowLogSource); ok {
+		names, err = source.ListDays(ctx, deliveryDays(g.deliveryRange(start, stop)))
+	} else {
+		names, err = g.Source.List(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	graph := newFlowGraph(start.Unix(), stop.Unix())
+	for _, name := range names {
+		if !g.mayContain(name, start, stop) {
+			continue
+		}
+		if err := g.readFile(ctx, name, graph); err != nil {
+			return nil, fmt.Errorf("failed to read flow logs from %s: %s", name, err.Error())
+		}
+	}
+	r, w := io.Pipe()
+	go func() {
+		_ = w.CloseWithError(graph.writeDOT(w))
+	}()
+	return r, nil
+}
+
+// mayContain reports whether the named file may contain records of the given time range,
+// based on the delivery time encoded in its name.
+func (g *Native) mayContain(name string, start, stop time.Time) bool {
+	earliest, latest := g.deliveryRange(start, stop)
+	if m := deliveryTimePattern.FindStringSubmatch(path.Base(name)); m != nil {
+		if created, err := time.Parse(deliveryTimeLayout, m[1]); err == nil {
+			return !created.Before(earliest) && !created.After(latest)
+		}
+	}
+	if m := deliveryDatePattern.FindStringSubmatch(filepath.ToSlash(name)); m != nil {
+		if day, err := time.Parse(deliveryDateLayout, m[1]); err == nil {
+			return day.Add(24*time.Hour).After(earliest) && !day.After(latest)
+		}
+	}
+	return true
+}
+
+// deliveryRange returns the earliest and latest creation times of the files which may contain
+// records of the given time range.
+func (g *Native) deliveryRange(start, stop time.Time) (time.Time, time.Time) {
+	delay := g.DeliveryDelay
+	if delay == 0 {
+		delay = DefaultDeliveryDelay
+	}
+	// A record is always delivered after it starts, so the earliest file of the range is
+	// created at its start. The creation time is truncated to the minute.
+	return start.Add(-time.Minute), stop.Add(delay)
+}
+
+// deliveryDays returns the days, in UTC, from the one containing earliest to the one
+// containing latest.
+func deliveryDays(earliest, latest time.Time) []time.Time {
+	const day = 24 * time.Hour
+	var days []time.Time
+	for d := earliest.UTC().Truncate(day); !d.After(latest); d = d.Add(day) {
+		days = append(days, d)
+	}
+	return days
+}
+
+func (g *Native) readFile(ctx context.Context, name string, graph *flowGraph) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f, err := g.Source.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var src io.Reader = r
+	if magic, _ := r.Peek(len(gzipMagic)); string(magic) == string(gzipMagic) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		src = gz
+	}
+	scanner := bufio.NewScanner(src)
+	for scanner.Scan() {
+		graph.add(strings.Fields(scanner.Text()))
+	}
+	return scanner.Err()
+}
+
+type flowEdge struct {
+	accountID string
+	eniID     string
+	src       string
+	dst       string
+	srcPort   string
+	dstPort   string
+	protocol  string
+	action    string
+	packets   int64
+	bytes     int64
+	start     int64
+	end       int64
+}
+
+// flowGraph aggregates flow log records in to the nodes and edges of a network graph.
+type flowGraph struct {
+	start int64
+	stop  int64
+	nodes map[string]string
+	edges map[string]*flowEdge
+}
+
+func newFlowGraph(start, stop int64) *flowGraph {
+	return &flowGraph{
+		start: start,
+		stop:  stop,
+		nodes: make(map[string]string),
+		edges: make(map[string]*flowEdge),
+	}
+}
+
+// add merges a single flow log record in to the graph. Header lines, records without data,
+// and records outside of the graph's time range are skipped.
+func (g *flowGraph) add(fields []string) {
+	if len(fields) < fieldCount || fields[fieldLogStatus] != logStatusOK {
+		return
+	}
+	start, err := strconv.ParseInt(fields[fieldStart], 10, 64)
+	if err != nil || start < g.start || start >= g.stop {
+		return
+	}
+	end, err := strconv.ParseInt(fields[fieldEnd], 10, 64)
+	if err != nil {
+		return
+	}
+	packets, err := strconv.ParseInt(fields[fieldPackets], 10, 64)
+	if err != nil {
+		return
+	}
+	bytes, err := strconv.ParseInt(fields[fieldBytes], 10, 64)
+	if err != nil {
+		return
+	}
+	key := strings.Join([]string{
+		fields[fieldAccountID], fields[fieldInterfaceID],
+		fields[fieldSrcAddr], fields[fieldDstAddr],
+		fields[fieldSrcPort], fields[fieldDstPort],
+		fields[fieldProtocol], fields[fieldAction],
+	}, " ")
+	edge, ok := g.edges[key]
+	if !ok {
+		edge = &flowEdge{
+			accountID: fields[fieldAccountID],
+			eniID:     fields[fieldInterfaceID],
+			src:       fields[fieldSrcAddr],
+			dst:       fields[fieldDstAddr],
+			srcPort:   fields[fieldSrcPort],
+			dstPort:   fields[fieldDstPort],
+			protocol:  fields[fieldProtocol],
+			action:    fields[fieldAction],
+			start:     start,
+			end:       end,
+		}
+		g.edges[key] = edge
+		g.nodes[nodeID(edge.src)] = edge.src
+		g.nodes[nodeID(edge.dst)] = edge.dst
+	}
+	edge.packets += packets
+	edge.bytes += bytes
+	if start < edge.start {
+		edge.start = start
+	}
+	if end > edge.end {
+		edge.end = end
+	}
+}
+
+// writeDOT writes the graph in the format of the go-vpcflow graph component. Edges look like:
+//
+// n1723116139 -> n172311621 [govpc_accountID="123456789010" govpc_eniID="eni-abc123de" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" govpc_packets="20" govpc_bytes="1000" govpc_start="1418530010" govpc_end="1818530070" color=red label="accountID=123456789010\neniID=eni-abc123de\nsrcPort=0\ndstPort=80\nprotocol=6\npackets=20\nbytes=1000\nstart=1418530010\nend=1818530070"]
+//
+// and nodes look like:
+//
+// n1723116139 [label="172.31.16.139"]
+//
+// Edges and nodes are written in a stable order so that the same records always produce
+// the same graph.
+func (g *flowGraph) writeDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	_, _ = bw.WriteString("digraph {\n")
+	keys := make([]string, 0, len(g.edges))
+	for key := range g.edges {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		e := g.edges[key]
+		color := "green"
+		if e.action == actionReject {
+			color = "red"
+		}
+		_, _ = fmt.Fprintf(bw,
+			"%s -> %s [govpc_accountID=%q govpc_eniID=%q govpc_srcPort=%q govpc_dstPort=%q govpc_protocol=%q govpc_packets=\"%d\" govpc_bytes=\"%d\" govpc_start=\"%d\" govpc_end=\"%d\" color=%s label=\"accountID=%s\\neniID=%s\\nsrcPort=%s\\ndstPort=%s\\nprotocol=%s\\npackets=%d\\nbytes=%d\\nstart=%d\\nend=%d\"]\n",
+			nodeID(e.src), nodeID(e.dst),
+			e.accountID, e.eniID, e.srcPort, e.dstPort, e.protocol, e.packets, e.bytes, e.start, e.end,
+			color,
+			e.accountID, e.eniID, e.srcPort, e.dstPort, e.protocol, e.packets, e.bytes, e.start, e.end,
+		)
+	}
+	ids := make([]string, 0, len(g.nodes))
+	for id := range g.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		_, _ = fmt.Fprintf(bw, "%s [label=%q]\n", id, g.nodes[id])
+	}
+	_, _ = bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// nodeID converts an IP address in to a node ID the same way the go-vpcflow graph
+// component does, by stripping the separators and prefixing an "n".
+func nodeID(addr string) string {
+	return "n" + strings.NewReplacer(".", "", ":", "").Replace(addr)
+}
@@ -0,0 +1,246 @@
+package grapher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const flowLogs = `version account-id interface-id srcaddr dstaddr srcport dstport protocol packets bytes start end action log-status
+2 123456789010 eni-abc123de 172.31.16.139 172.31.16.21 0 80 6 20 1000 1418530010 1418530070 REJECT OK
+2 123456789010 eni-abc123de 172.31.16.139 172.31.16.21 0 80 6 10 500 1418530020 1418530080 REJECT OK
+2 123456789010 eni-abc123de 172.31.16.21 172.31.16.139 80 0 6 40 2000 1418530010 1418530070 ACCEPT OK
+2 123456789010 eni-abc123de - - - - - - - 1418530010 1418530070 - NODATA
+2 123456789010 eni-abc123de 172.31.16.139 172.31.16.22 0 443 6 1 100 1418540000 1418540060 ACCEPT OK
+`
+
+var expectedGraph = `digraph {
+n1723116139 -> n172311621 [govpc_accountID="123456789010" govpc_eniID="eni-abc123de" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" govpc_packets="30" govpc_bytes="1500" govpc_start="1418530010" govpc_end="1418530080" color=red label="accountID=123456789010\neniID=eni-abc123de\nsrcPort=0\ndstPort=80\nprotocol=6\npackets=30\nbytes=1500\nstart=1418530010\nend=1418530080"]
+n172311621 -> n1723116139 [govpc_accountID="123456789010" govpc_eniID="eni-abc123de" govpc_srcPort="80" govpc_dstPort="0" govpc_protocol="6" govpc_packets="40" govpc_bytes="2000" govpc_start="1418530010" govpc_end="1418530070" color=green label="accountID=123456789010\neniID=eni-abc123de\nsrcPort=80\ndstPort=0\nprotocol=6\npackets=40\nbytes=2000\nstart=1418530010\nend=1418530070"]
+n1723116139 [label="172.31.16.139"]
+n172311621 [label="172.31.16.21"]
+}
+`
+
+func gzipped(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(data))
+	require.Nil(t, err)
+	require.Nil(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestNativeGraphDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowlogs")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// split the records between a gzipped file in a subdirectory and a plain text file
+	lines := strings.SplitAfter(flowLogs, "\n")
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "sub"), 0700))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "sub", "a.log.gz"), gzipped(t, strings.Join(lines[:3], "")), 0600))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "b.log"), []byte(strings.Join(lines[3:], "")), 0600))
+
+	g := &Native{Source: &DirectorySource{Path: dir}}
+	output, err := g.Graph(context.Background(), time.Unix(1418530000, 0), time.Unix(1418531000, 0))
+	require.Nil(t, err)
+	defer output.Close()
+	data, err := ioutil.ReadAll(output)
+	require.Nil(t, err)
+	assert.Equal(t, expectedGraph, string(data))
+}
+
+func TestNativeGraphDirectoryMissing(t *testing.T) {
+	g := &Native{Source: &DirectorySource{Path: "/does/not/exist"}}
+	_, err := g.Graph(context.Background(), time.Unix(1418530000, 0), time.Unix(1418531000, 0))
+	assert.NotNil(t, err)
+}
+
+func TestNativeGraphS3(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String("AWSLogs/"),
+		Delimiter: aws.String("/"),
+	}).Return(&s3.ListObjectsV2Output{
+		Contents:              []*s3.Object{{Key: aws.String("AWSLogs/a.log.gz")}},
+		IsTruncated:           aws.Bool(true),
+		NextContinuationToken: aws.String("next"),
+	}, nil)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+		Bucket:            aws.String(bucket),
+		Prefix:            aws.String("AWSLogs/"),
+		Delimiter:         aws.String("/"),
+		ContinuationToken: aws.String("next"),
+	}).Return(&s3.ListObjectsV2Output{
+		Contents:    []*s3.Object{{Key: aws.String("AWSLogs/b.log.gz")}},
+		IsTruncated: aws.Bool(false),
+	}, nil)
+	lines := strings.SplitAfter(flowLogs, "\n")
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("AWSLogs/a.log.gz"),
+	}).Return(&s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(gzipped(t, strings.Join(lines[:2], ""))))}, nil)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("AWSLogs/b.log.gz"),
+	}).Return(&s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(gzipped(t, strings.Join(lines[2:], ""))))}, nil)
+
+	g := &Native{Source: &S3Source{Bucket: bucket, Prefix: "AWSLogs/", Client: mockS3}}
+	output, err := g.Graph(context.Background(), time.Unix(1418530000, 0), time.Unix(1418531000, 0))
+	require.Nil(t, err)
+	defer output.Close()
+	data, err := ioutil.ReadAll(output)
+	require.Nil(t, err)
+	assert.Equal(t, expectedGraph, string(data))
+}
+
+func TestNativeGraphPrunesByDeliveryTime(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	prefix := "AWSLogs/123456789010/vpcflowlogs/us-east-1/"
+	inRange := prefix + "2014/12/14/123456789010_vpcflowlogs_us-east-1_fl-1234abcd_20141214T0410Z_a.log.gz"
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(&s3.ListObjectsV2Output{
+		Contents: []*s3.Object{
+			{Key: aws.String(prefix + "2014/12/13/123456789010_vpcflowlogs_us-east-1_fl-1234abcd_20141213T0410Z_b.log.gz")},
+			{Key: aws.String(inRange)},
+			{Key: aws.String(prefix + "2014/12/14/123456789010_vpcflowlogs_us-east-1_fl-1234abcd_20141214T0600Z_c.log.gz")},
+			{Key: aws.String(prefix + "2014/12/15/renamed.log.gz")},
+		},
+	}, nil)
+	// only the file delivered during the range is read
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(inRange),
+	}).Return(&s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(gzipped(t, flowLogs)))}, nil)
+
+	g := &Native{Source: &S3Source{Bucket: bucket, Client: mockS3}}
+	output, err := g.Graph(context.Background(), time.Unix(1418530000, 0), time.Unix(1418531000, 0))
+	require.Nil(t, err)
+	defer output.Close()
+	data, err := ioutil.ReadAll(output)
+	require.Nil(t, err)
+	assert.Equal(t, expectedGraph, string(data))
+}
+
+func TestNativeGraphS3ListsDays(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// the prefixes are walked down to the years of the date path, and only the days of the
+	// range are listed below them
+	region := "AWSLogs/123456789010/vpcflowlogs/us-east-1/"
+	inRange := region + "2014/12/14/123456789010_vpcflowlogs_us-east-1_fl-1234abcd_20141214T0410Z_a.log.gz"
+	walked := map[string][]string{
+		"AWSLogs/":                          {"AWSLogs/123456789010/"},
+		"AWSLogs/123456789010/":             {"AWSLogs/123456789010/vpcflowlogs/"},
+		"AWSLogs/123456789010/vpcflowlogs/": {region},
+		region:                              {region + "2013/", region + "2014/"},
+	}
+	mockS3 := NewMockS3API(ctrl)
+	for prefix, prefixes := range walked {
+		out := &s3.ListObjectsV2Output{}
+		for _, p := range prefixes {
+			out.CommonPrefixes = append(out.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(p)})
+		}
+		mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+			Bucket:    aws.String(bucket),
+			Prefix:    aws.String(prefix),
+			Delimiter: aws.String("/"),
+		}).Return(out, nil)
+	}
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(region + "2014/12/14/"),
+	}).Return(&s3.ListObjectsV2Output{Contents: []*s3.Object{{Key: aws.String(inRange)}}}, nil)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(inRange),
+	}).Return(&s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(gzipped(t, flowLogs)))}, nil)
+
+	g := &Native{Source: &S3Source{Bucket: bucket, Prefix: "AWSLogs/", Client: mockS3}}
+	output, err := g.Graph(context.Background(), time.Unix(1418530000, 0), time.Unix(1418531000, 0))
+	require.Nil(t, err)
+	defer output.Close()
+	data, err := ioutil.ReadAll(output)
+	require.Nil(t, err)
+	assert.Equal(t, expectedGraph, string(data))
+}
+
+func TestDeliveryDays(t *testing.T) {
+	day := time.Date(2014, 12, 14, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []time.Time{day}, deliveryDays(day.Add(time.Hour), day.Add(2*time.Hour)))
+	assert.Equal(t, []time.Time{day.Add(-24 * time.Hour), day}, deliveryDays(day.Add(-time.Minute), day.Add(time.Hour)))
+}
+
+func TestNativeMayContain(t *testing.T) {
+	start := time.Date(2014, 12, 14, 4, 0, 0, 0, time.UTC)
+	stop := start.Add(time.Hour)
+	tests := []struct {
+		Name     string
+		File     string
+		Expected bool
+	}{
+		{"created in range", "x_20141214T0430Z_y.log.gz", true},
+		{"created within the minute of the start", "x_20141214T0359Z_y.log.gz", true},
+		{"created before the range", "x_20141214T0358Z_y.log.gz", false},
+		{"created within the delay", "x_20141214T0555Z_y.log.gz", true},
+		{"created after the delay", "x_20141214T0601Z_y.log.gz", false},
+		{"dated directory of the range", "logs/2014/12/14/file.log", true},
+		{"dated directory before the range", "logs/2014/12/13/file.log", false},
+		{"dated directory after the range", "logs/2014/12/15/file.log", false},
+		{"undated", "logs/file.log", true},
+	}
+	g := &Native{}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			assert.Equal(t, tt.Expected, g.mayContain(tt.File, start, stop))
+		})
+	}
+}
+
+func TestNativeGraphS3ListError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New(""))
+
+	g := &Native{Source: &S3Source{Bucket: bucket, Client: mockS3}}
+	_, err := g.Graph(context.Background(), time.Unix(1418530000, 0), time.Unix(1418531000, 0))
+	assert.NotNil(t, err)
+}
+
+func TestNativeGraphS3OpenError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(&s3.ListObjectsV2Output{
+		Contents: []*s3.Object{{Key: aws.String("a.log.gz")}},
+	}, nil)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New(""))
+
+	g := &Native{Source: &S3Source{Bucket: bucket, Client: mockS3}}
+	_, err := g.Graph(context.Background(), time.Unix(1418530000, 0), time.Unix(1418531000, 0))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,67 @@
+package grapher
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/google/uuid"
+)
+
+const keySuffix = ".dot"
+
+// graphNamespace is the UUID namespace vpcflow-grapherd uses to derive graph IDs.
+var graphNamespace = uuid.NewSHA1(uuid.Nil, []byte("graph"))
+
+// S3 is a Grapher implementation which reads graphs directly from the bucket vpcflow-grapherd
+// stores them in. This avoids the create and poll cycle of the HTTP grapher when both services
+// have access to the same bucket.
+//
+// If the graph for a time range has not been created yet, S3 defers to the Fallback Grapher,
+// which is expected to trigger the creation of the graph. If no Fallback is configured, a
+// domain.ErrNotFound is returned.
+type S3 struct {
+	Bucket   string
+	Client   s3iface.S3API
+	Fallback domain.Grapher
+}
+
+// Graph returns the graph for the given time range. It is the caller's responsibility to call
+// Close on the Reader when done.
+func (g *S3) Graph(ctx context.Context, start, stop time.Time) (io.ReadCloser, error) {
+	id := graphID(start, stop)
+	res, err := g.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(g.Bucket),
+		Key:    aws.String(id + keySuffix),
+	})
+	if err == nil {
+		return res.Body, nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+	if g.Fallback == nil {
+		return nil, domain.ErrNotFound{ID: id}
+	}
+	return g.Fallback.Graph(ctx, start, stop)
+}
+
+// graphID computes the ID vpcflow-grapherd assigns to the graph of the given time range.
+// grapherd derives the ID from the times it parses out of the RFC3339Nano query parameters
+// sent by the HTTP grapher, so the times are round tripped through that format first to
+// produce the same location and precision.
+func graphID(start, stop time.Time) string {
+	start, _ = time.Parse(time.RFC3339Nano, start.Format(time.RFC3339Nano))
+	stop, _ = time.Parse(time.RFC3339Nano, stop.Format(time.RFC3339Nano))
+	return uuid.NewSHA1(graphNamespace, []byte(start.String()+stop.String())).String()
+}
+
+func isNotFound(err error) bool {
+	aErr, ok := err.(awserr.Error)
+	return ok && (aErr.Code() == s3.ErrCodeNoSuchKey || aErr.Code() == "NotFound") // NotFound is an undocumented error code with no provided constant
+}
@@ -0,0 +1,99 @@
+package grapher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const bucket = "foo_bucket"
+
+func TestS3GraphExists(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stop := time.Now()
+	start := stop.Add(-1 * time.Hour)
+	expectedInput := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(graphID(start, stop) + ".dot"),
+	}
+	body := "this is a graph"
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), expectedInput).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewReader([]byte(body))),
+	}, nil)
+	mockGrapher := NewMockGrapher(ctrl)
+
+	g := &S3{Bucket: bucket, Client: mockS3, Fallback: mockGrapher}
+	output, err := g.Graph(context.Background(), start, stop)
+	assert.Nil(t, err)
+	defer output.Close()
+	data, _ := ioutil.ReadAll(output)
+	assert.Equal(t, body, string(data))
+}
+
+func TestS3GraphNotFoundFallback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stop := time.Now()
+	start := stop.Add(-1 * time.Hour)
+	body := "this is a new graph"
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "", errors.New("")))
+	mockGrapher := NewMockGrapher(ctrl)
+	mockGrapher.EXPECT().Graph(gomock.Any(), start, stop).Return(ioutil.NopCloser(bytes.NewReader([]byte(body))), nil)
+
+	g := &S3{Bucket: bucket, Client: mockS3, Fallback: mockGrapher}
+	output, err := g.Graph(context.Background(), start, stop)
+	assert.Nil(t, err)
+	defer output.Close()
+	data, _ := ioutil.ReadAll(output)
+	assert.Equal(t, body, string(data))
+}
+
+func TestS3GraphNotFoundNoFallback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New("NotFound", "", errors.New("")))
+
+	g := &S3{Bucket: bucket, Client: mockS3}
+	_, err := g.Graph(context.Background(), time.Now().Add(-1*time.Hour), time.Now())
+	assert.NotNil(t, err)
+	_, ok := err.(domain.ErrNotFound)
+	assert.True(t, ok)
+}
+
+func TestS3GraphError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New(""))
+	mockGrapher := NewMockGrapher(ctrl)
+
+	g := &S3{Bucket: bucket, Client: mockS3, Fallback: mockGrapher}
+	_, err := g.Graph(context.Background(), time.Now().Add(-1*time.Hour), time.Now())
+	assert.NotNil(t, err)
+}
+
+func TestGraphIDNormalizesLocation(t *testing.T) {
+	start, _ := time.Parse(time.RFC3339, "2019-01-01T00:00:00Z")
+	stop, _ := time.Parse(time.RFC3339, "2019-01-02T00:00:00Z")
+	local := time.FixedZone("", 0)
+	assert.Equal(t, graphID(start, stop), graphID(start.In(local), stop.In(local)))
+	assert.NotEqual(t, graphID(start, stop), graphID(start, stop.Add(time.Minute)))
+}
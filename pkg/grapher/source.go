@@ -0,0 +1,168 @@
+package grapher
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// FlowLogSource provides access to a set of raw VPC flow log files. Files may be either
+// plain text or gzipped.
+type FlowLogSource interface {
+	// List returns the names of all flow log files in the source.
+	List(ctx context.Context) ([]string, error)
+
+	// Open returns the content of the named flow log file. It is the caller's responsibility
+	// to call Close on the Reader when done.
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// DatedFlowLogSource is a FlowLogSource which can list only the files delivered on some
+// days. Native lists such sources by the days of each graph, rather than listing every file
+// of the source.
+type DatedFlowLogSource interface {
+	FlowLogSource
+
+	// ListDays returns the names of the flow log files delivered under the AWS date path
+	// (YYYY/MM/DD/) of any of the given days, in UTC, along with any files which are not
+	// under a date path.
+	ListDays(ctx context.Context, days []time.Time) ([]string, error)
+}
+
+// deliveryYearPattern matches the year directory of the AWS date path.
+var deliveryYearPattern = regexp.MustCompile(`^\d{4}$`)
+
+// DirectorySource is a FlowLogSource which reads flow log files from a local directory.
+// Every regular file in the directory, and in any of its subdirectories, is treated as a
+// flow log file.
+type DirectorySource struct {
+	Path string
+}
+
+// List returns the paths of all files in the directory tree.
+func (s *DirectorySource) List(ctx context.Context) ([]string, error) {
+	var names []string
+	err := filepath.Walk(s.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			names = append(names, path)
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Open opens the file at the given path.
+func (s *DirectorySource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+// S3Source is a FlowLogSource which reads flow log files from all objects under a prefix
+// of an S3 bucket, such as the location AWS delivers VPC flow logs to.
+type S3Source struct {
+	Bucket string
+	Prefix string
+	Client s3iface.S3API
+}
+
+// List returns the keys of all objects under the prefix.
+func (s *S3Source) List(ctx context.Context) ([]string, error) {
+	names, _, err := s.list(ctx, s.Prefix, "")
+	return names, err
+}
+
+// ListDays returns the keys of the objects under the prefix which were delivered on any of
+// the given days. The prefix is walked down to the year directories of the AWS date path,
+// such as AWSLogs/123456789010/vpcflowlogs/us-east-1/2014/, and only the directories of the
+// given days are listed below them. Objects which are not under a date path are always
+// returned.
+func (s *S3Source) ListDays(ctx context.Context, days []time.Time) ([]string, error) {
+	return s.listDays(ctx, s.Prefix, days)
+}
+
+func (s *S3Source) listDays(ctx context.Context, prefix string, days []time.Time) ([]string, error) {
+	names, prefixes, err := s.list(ctx, prefix, "/")
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range prefixes {
+		year := path.Base(p)
+		if !deliveryYearPattern.MatchString(year) {
+			more, err := s.listDays(ctx, p, days)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, more...)
+			continue
+		}
+		for _, day := range days {
+			day = day.UTC()
+			if day.Format("2006") != year {
+				continue
+			}
+			more, _, err := s.list(ctx, p+day.Format("01/02/"), "")
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, more...)
+		}
+	}
+	return names, nil
+}
+
+// list returns the keys of the objects under the prefix and, if a delimiter is given, the
+// common prefixes found under it.
+func (s *S3Source) list(ctx context.Context, prefix string, delimiter string) ([]string, []string, error) {
+	var names []string
+	var prefixes []string
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	}
+	if delimiter != "" {
+		input.Delimiter = aws.String(delimiter)
+	}
+	for {
+		res, err := s.Client.ListObjectsV2WithContext(ctx, input)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, obj := range res.Contents {
+			names = append(names, aws.StringValue(obj.Key))
+		}
+		for _, p := range res.CommonPrefixes {
+			prefixes = append(prefixes, aws.StringValue(p.Prefix))
+		}
+		if !aws.BoolValue(res.IsTruncated) {
+			return names, prefixes, nil
+		}
+		input.ContinuationToken = res.NextContinuationToken
+	}
+}
+
+// Open returns the content of the object with the given key.
+func (s *S3Source) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	res, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+)
+
+const (
+	// PublicCacheControl is the Cache-Control of a stored diff. A diff may be created again with
+	// force, or deleted by retention, so caches may keep it but must revalidate it before each
+	// use. A revalidated diff which has not changed is answered with 304 Not Modified, and one
+	// which was created again with different content has a new ETag and is sent in full.
+	PublicCacheControl = "public, no-cache"
+
+	// PrivateCacheControl is the Cache-Control of a stored diff whose scope is decided by the
+	// identity of the caller. Shared caches do not key diffs by the identity headers, so they
+	// must not store them, or one caller could be sent the cached diff of another.
+	PrivateCacheControl = "private, no-cache"
+)
+
+// CacheControl returns the Cache-Control of the diffs served with the Authorizer. Diffs are
+// private to the caller when an Authorizer decides their scope.
+func CacheControl(authorizer domain.Authorizer) string {
+	if authorizer == nil {
+		return PublicCacheControl
+	}
+	return PrivateCacheControl
+}
+
+// ETag returns the entity tag of a stored diff which is sent with the given encoding. Diffs
+// sent as they are stored are tagged with their checksum. Diffs which are decompressed or
+// compressed as they are sent are tagged with the encoding they are sent with as well, so that
+// caches do not confuse the two.
+func ETag(info domain.ObjectInfo, encoding string) string {
+	if encoding == info.Encoding {
+		return `"` + info.Checksum + `"`
+	}
+	if encoding == "" {
+		encoding = "identity"
+	}
+	return `"` + info.Checksum + "-" + encoding + `"`
+}
+
+// SetCacheHeaders sets the ETag, Last-Modified and Cache-Control headers of a stored diff.
+func SetCacheHeaders(header http.Header, etag string, created time.Time, cacheControl string) {
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", cacheControl)
+	if !created.IsZero() {
+		header.Set("Last-Modified", created.UTC().Format(http.TimeFormat))
+	}
+}
+
+// NotModified returns true if the If-None-Match or If-Modified-Since header of the request
+// shows that the client already has the diff with the given entity tag and creation time.
+// If-Modified-Since is ignored if the request has an If-None-Match header.
+func NotModified(r *http.Request, etag string, created time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || created.IsZero() {
+		return false
+	}
+	return !created.Truncate(time.Second).After(since)
+}
+
+// ServeObject sends a stored diff as it is stored, and responds to conditional and Range
+// requests as http.ServeContent does. The cache headers of the diff, with the given
+// Cache-Control, its Content-Length and Accept-Ranges are set, while the Content-Type and
+// Content-Encoding must be set by the caller. Only the requested ranges of the diff are
+// fetched from the storage. An error is returned if the diff could not be fetched once the
+// response was started.
+func ServeObject(w http.ResponseWriter, r *http.Request, objects domain.ObjectStorage, key string, info domain.ObjectInfo, cacheControl string) error {
+	SetCacheHeaders(w.Header(), ETag(info, info.Encoding), info.Created, cacheControl)
+	content := &objectReader{ctx: r.Context(), objects: objects, key: key, size: info.Size}
+	defer content.Close()
+	http.ServeContent(w, r, "", info.Created, content)
+	return content.error()
+}
+
+// objectReader is an io.ReadSeeker of a stored diff. The diff is fetched from the offset of
+// the first Read after each Seek, so seeking to a range does not fetch what comes before it.
+// It is safe for concurrent use, as http.ServeContent may still read multiple ranges once it
+// has returned.
+type objectReader struct {
+	ctx     context.Context
+	objects domain.ObjectStorage
+	key     string
+	size    int64
+
+	lock   sync.Mutex
+	offset int64
+	body   io.ReadCloser
+	err    error
+	closed bool
+}
+
+func (o *objectReader) Read(p []byte) (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.closed {
+		return 0, errors.New("read of a closed diff")
+	}
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		body, err := o.objects.GetRange(o.ctx, o.key, o.offset)
+		if err != nil {
+			o.err = err
+			return 0, err
+		}
+		o.body = body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	if err != nil && err != io.EOF {
+		o.err = err
+	}
+	return n, err
+}
+
+func (o *objectReader) Seek(offset int64, whence int) (int64, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek to a negative offset")
+	}
+	if offset != o.offset && o.body != nil {
+		_ = o.body.Close()
+		o.body = nil
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *objectReader) Close() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.closed = true
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
+
+func (o *objectReader) error() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.err
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// objectStorage is an in memory domain.ObjectStorage of a single diff, which records the
+// offsets of the ranges which are fetched.
+type objectStorage struct {
+	body    string
+	err     error
+	offsets []int64
+}
+
+func (s *objectStorage) Stat(ctx context.Context, key string) (domain.ObjectInfo, error) {
+	return domain.ObjectInfo{Size: int64(len(s.body))}, nil
+}
+
+func (s *objectStorage) GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	s.offsets = append(s.offsets, offset)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return ioutil.NopCloser(strings.NewReader(s.body[offset:])), nil
+}
+
+var created = time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func TestETag(t *testing.T) {
+	info := domain.ObjectInfo{Checksum: "abc", Encoding: "gzip"}
+	assert.Equal(t, `"abc"`, ETag(info, "gzip"))
+	assert.Equal(t, `"abc-identity"`, ETag(info, ""))
+	assert.Equal(t, `"abc-zstd"`, ETag(info, "zstd"))
+	assert.Equal(t, `"abc"`, ETag(domain.ObjectInfo{Checksum: "abc"}, ""))
+}
+
+func TestCacheControl(t *testing.T) {
+	assert.Equal(t, PublicCacheControl, CacheControl(nil))
+	assert.Equal(t, PrivateCacheControl, CacheControl(authorizer{}))
+}
+
+// authorizer permits every caller the zero Scope
+type authorizer struct{}
+
+func (authorizer) Authorize(r *http.Request) (domain.Scope, error) {
+	return domain.Scope{}, nil
+}
+
+func TestNotModified(t *testing.T) {
+	tc := []struct {
+		Name     string
+		Method   string
+		Header   string
+		Value    string
+		Expected bool
+	}{
+		{"no conditions", http.MethodGet, "", "", false},
+		{"matching etag", http.MethodGet, "If-None-Match", `"other", "abc"`, true},
+		{"weak etag", http.MethodGet, "If-None-Match", `W/"abc"`, true},
+		{"any etag", http.MethodGet, "If-None-Match", `*`, true},
+		{"other etag", http.MethodGet, "If-None-Match", `"other"`, false},
+		{"not modified since", http.MethodGet, "If-Modified-Since", created.Format(http.TimeFormat), true},
+		{"modified since", http.MethodGet, "If-Modified-Since", created.Add(-time.Second).Format(http.TimeFormat), false},
+		{"invalid time", http.MethodGet, "If-Modified-Since", "yesterday", false},
+		{"not a GET", http.MethodPost, "If-None-Match", `"abc"`, false},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.Method, "/", nil)
+			if tt.Header != "" {
+				r.Header.Set(tt.Header, tt.Value)
+			}
+			assert.Equal(t, tt.Expected, NotModified(r, `"abc"`, created.Add(500*time.Millisecond)))
+		})
+	}
+
+	// If-Modified-Since is ignored if the request has an If-None-Match header
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"other"`)
+	r.Header.Set("If-Modified-Since", created.Format(http.TimeFormat))
+	assert.False(t, NotModified(r, `"abc"`, created))
+}
+
+func TestServeObject(t *testing.T) {
+	info := domain.ObjectInfo{Size: 10, Checksum: "abc", Created: created}
+	tc := []struct {
+		Name    string
+		Header  string
+		Value   string
+		Status  int
+		Body    string
+		Offsets []int64
+	}{
+		{"full", "", "", http.StatusOK, "0123456789", []int64{0}},
+		{"range", "Range", "bytes=4-6", http.StatusPartialContent, "456", []int64{4}},
+		{"suffix range", "Range", "bytes=-2", http.StatusPartialContent, "89", []int64{8}},
+		{"unsatisfiable range", "Range", "bytes=20-", http.StatusRequestedRangeNotSatisfiable, "", nil},
+		{"matching etag", "If-None-Match", `"abc"`, http.StatusNotModified, "", nil},
+		{"not modified since", "If-Modified-Since", created.Format(http.TimeFormat), http.StatusNotModified, "", nil},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			objects := &objectStorage{body: "0123456789"}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.Header != "" {
+				r.Header.Set(tt.Header, tt.Value)
+			}
+			w := httptest.NewRecorder()
+			w.Header().Set("Content-Type", "application/octet-stream")
+			require.Nil(t, ServeObject(w, r, objects, "key", info, PublicCacheControl))
+
+			assert.Equal(t, tt.Status, w.Code)
+			assert.Equal(t, tt.Offsets, objects.offsets)
+			assert.Equal(t, `"abc"`, w.Header().Get("ETag"))
+			assert.Equal(t, PublicCacheControl, w.Header().Get("Cache-Control"))
+			if tt.Status == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			assert.Equal(t, tt.Body, w.Body.String())
+			if tt.Status != http.StatusNotModified {
+				assert.Equal(t, "Tue, 01 Jan 2019 00:00:00 GMT", w.Header().Get("Last-Modified"))
+				assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+			}
+		})
+	}
+}
+
+func TestServeObjectError(t *testing.T) {
+	objects := &objectStorage{body: "0123456789", err: errors.New("oops")}
+	w := httptest.NewRecorder()
+	err := ServeObject(w, httptest.NewRequest(http.MethodGet, "/", nil), objects, "key", domain.ObjectInfo{Size: 10}, PublicCacheControl)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+)
+
+// Indexed returns the storage as a domain.IndexedStorage. If the storage does not implement
+// domain.IndexedStorage, every operation of the returned IndexedStorage fails with an error of
+// type domain.ErrUnsupported.
+func Indexed(storage domain.Storage) domain.IndexedStorage {
+	if indexed, ok := storage.(domain.IndexedStorage); ok {
+		return indexed
+	}
+	return unindexed{}
+}
+
+type unindexed struct{}
+
+func (unindexed) Metadata(context.Context, string) (domain.DiffMetadata, error) {
+	return domain.DiffMetadata{}, domain.ErrUnsupported{Operation: "Metadata"}
+}
+
+func (unindexed) Index(context.Context, domain.DiffMetadata) error {
+	return domain.ErrUnsupported{Operation: "Index"}
+}
+
+func (unindexed) List(context.Context, domain.ListFilter) (domain.DiffPage, error) {
+	return domain.DiffPage{}, domain.ErrUnsupported{Operation: "List"}
+}
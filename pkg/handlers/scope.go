@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+)
+
+// Permitted returns the scope the caller of the request is permitted by the Authorizer. If the
+// Authorizer is nil, every caller is permitted the zero Scope, which is the scope of all diffs
+// which belong to no tenant. If the caller is not permitted any scope, an error of type
+// domain.ErrForbidden is returned.
+func Permitted(r *http.Request, authorizer domain.Authorizer) (domain.Scope, error) {
+	if authorizer == nil {
+		return domain.Scope{}, nil
+	}
+	scope, err := authorizer.Authorize(r)
+	switch err.(type) {
+	case nil:
+		return scope, nil
+	case domain.ErrForbidden:
+		return domain.Scope{}, err
+	default:
+		return domain.Scope{}, domain.ErrForbidden{Reason: err.Error()}
+	}
+}
+
+// SplitAccounts splits a comma separated list of accounts, such as the accounts query
+// parameter. Empty accounts are left for domain.NewScope to remove.
+func SplitAccounts(accounts string) []string {
+	if accounts == "" {
+		return nil
+	}
+	return strings.Split(accounts, ",")
+}
+
+// CheckScope returns an error of type domain.ErrForbidden if the diff with the given ID in
+// the permitted scope is of accounts the caller is not permitted. Diffs are only checked for
+// callers who are permitted some accounts of their tenant, as the diffs of other tenants are
+// not found under the keys of the scope. Diffs without metadata have not been queued, and are
+// not checked. If the storage does not record metadata, the diff can't be checked and the
+// caller is refused.
+func CheckScope(ctx context.Context, storage domain.Storage, permitted domain.Scope, id string) error {
+	if len(permitted.Accounts) == 0 {
+		return nil
+	}
+	meta, err := Indexed(storage).Metadata(ctx, permitted.Key(id))
+	switch err.(type) {
+	case nil:
+	case domain.ErrNotFound:
+		return nil
+	case domain.ErrUnsupported:
+		return domain.ErrForbidden{Reason: fmt.Sprintf("the accounts of diff %s can not be verified", id)}
+	default:
+		return err
+	}
+	if !permitted.Contains(meta.Scope) {
+		return domain.ErrForbidden{Reason: fmt.Sprintf("diff %s is not of the permitted accounts", id)}
+	}
+	return nil
+}
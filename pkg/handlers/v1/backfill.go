@@ -0,0 +1,185 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/handlers"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/logs"
+	"github.com/go-chi/chi"
+)
+
+type backfillItem struct {
+	ID          string   `json:"id"`
+	Start       string   `json:"start"`
+	Stop        string   `json:"stop"`
+	Window      string   `json:"window"`
+	Step        string   `json:"step"`
+	Concurrency int      `json:"concurrency"`
+	Status      string   `json:"status"`
+	Tenant      string   `json:"tenant,omitempty"`
+	Accounts    []string `json:"accounts,omitempty"`
+	Total       int      `json:"total"`
+	Queued      int      `json:"queued"`
+	Existing    int      `json:"existing"`
+	InProgress  int      `json:"inProgress"`
+	Failed      int      `json:"failed"`
+	LastError   string   `json:"lastError,omitempty"`
+	Created     string   `json:"created"`
+	Updated     string   `json:"updated"`
+	Completed   string   `json:"completed,omitempty"`
+}
+
+// Backfills handles requests to queue the diffs of historical ranges
+type Backfills struct {
+	LogProvider domain.LogFn
+	Backfiller  domain.Backfiller
+
+	// Authorizer decides the scope of the backfills each caller may start and follow, as it
+	// does for diffs. If nil, every caller may access every backfill.
+	Authorizer domain.Authorizer
+}
+
+// Post starts a backfill of the diffs of the permitted scope, or of the accounts given by the
+// accounts query parameter within it, and responds with its initial progress. The Location
+// header is the path at which its progress can be followed.
+func (h *Backfills) Post(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	permitted, err := handlers.Permitted(r, h.Authorizer)
+	if err != nil {
+		writeInputError(w, logger, err)
+		return
+	}
+	req, err := extractBackfillRequest(r, permitted)
+	if err != nil {
+		writeInputError(w, logger, err)
+		return
+	}
+	backfill, err := h.Backfiller.Start(r.Context(), req)
+	switch err.(type) {
+	case nil:
+	case domain.ErrInvalidBackfill:
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		writeJSONResponse(w, http.StatusBadRequest, err.Error())
+		return
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyBackfills, Reason: err.Error()})
+		writeJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	w.Header().Set("Location", "/backfills/"+backfill.ID)
+	writeBackfill(w, http.StatusAccepted, backfill)
+}
+
+// Get responds with the progress of the backfill named by the id path parameter. Backfills of
+// other tenants are not found, and backfills of accounts the caller is not permitted are
+// forbidden.
+func (h *Backfills) Get(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	permitted, err := handlers.Permitted(r, h.Authorizer)
+	if err != nil {
+		writeInputError(w, logger, err)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	backfill, err := h.Backfiller.Get(r.Context(), id)
+	if err == nil && backfill.Scope.Tenant != permitted.Tenant {
+		err = domain.ErrNotFound{ID: id}
+	}
+	switch err.(type) {
+	case nil:
+	case domain.ErrNotFound:
+		logger.Info(logs.NotFound{Reason: err.Error()})
+		writeJSONResponse(w, http.StatusNotFound, fmt.Sprintf("backfill %s was not found", id))
+		return
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyBackfills, Reason: err.Error()})
+		writeJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if !permitted.Contains(backfill.Scope) {
+		writeInputError(w, logger, domain.ErrForbidden{Reason: fmt.Sprintf("backfill %s is not of the permitted accounts", id)})
+		return
+	}
+	writeBackfill(w, http.StatusOK, backfill)
+}
+
+func writeBackfill(w http.ResponseWriter, status int, b domain.Backfill) {
+	item := backfillItem{
+		ID:          b.ID,
+		Start:       b.Start.Format(time.RFC3339Nano),
+		Stop:        b.Stop.Format(time.RFC3339Nano),
+		Window:      b.Window.String(),
+		Step:        b.Step.String(),
+		Concurrency: b.Concurrency,
+		Status:      b.Status,
+		Tenant:      b.Scope.Tenant,
+		Accounts:    b.Scope.Accounts,
+		Total:       b.Total,
+		Queued:      b.Queued,
+		Existing:    b.Existing,
+		InProgress:  b.InProgress,
+		Failed:      b.Failed,
+		LastError:   b.LastError,
+		Created:     b.Created.Format(time.RFC3339Nano),
+		Updated:     b.Updated.Format(time.RFC3339Nano),
+	}
+	if !b.Completed.IsZero() {
+		item.Completed = b.Completed.Format(time.RFC3339Nano)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(item)
+}
+
+// extractBackfillRequest extracts the range of a backfill, which must be RFC3339Nano, along
+// with either its template or its window and step durations, its optional concurrency, and
+// its scope. An error of type domain.ErrForbidden is returned if the accounts of the backfill
+// are not within the permitted scope.
+func extractBackfillRequest(r *http.Request, permitted domain.Scope) (domain.BackfillRequest, error) {
+	q := r.URL.Query()
+	start, stop, err := validateTimeRange(q.Get("start"), q.Get("stop"))
+	if err != nil {
+		return domain.BackfillRequest{}, err
+	}
+	scope, err := permitted.Narrow(handlers.SplitAccounts(q.Get("accounts")))
+	if err != nil {
+		return domain.BackfillRequest{}, err
+	}
+	req := domain.BackfillRequest{
+		Start:    start,
+		Stop:     stop,
+		Template: q.Get("template"),
+		Scope:    scope,
+	}
+	durations := []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"window", &req.Window},
+		{"step", &req.Step},
+	}
+	for _, d := range durations {
+		if q.Get(d.name) == "" {
+			continue
+		}
+		if req.Template != "" {
+			return domain.BackfillRequest{}, fmt.Errorf("%s cannot be combined with template", d.name)
+		}
+		if *d.value, err = time.ParseDuration(q.Get(d.name)); err != nil {
+			return domain.BackfillRequest{}, fmt.Errorf("invalid %s: %s", d.name, err.Error())
+		}
+	}
+	if concurrency := q.Get("concurrency"); concurrency != "" {
+		req.Concurrency, err = strconv.Atoi(concurrency)
+		if err != nil || req.Concurrency < 1 {
+			return domain.BackfillRequest{}, errors.New("concurrency should be a positive integer")
+		}
+	}
+	return req, nil
+}
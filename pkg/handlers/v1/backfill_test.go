@@ -0,0 +1,203 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/go-chi/chi"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBackfillRequest(method string, target string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	return r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+}
+
+var testBackfill = domain.Backfill{
+	ID:          "id",
+	Start:       time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+	Stop:        time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC),
+	Window:      24 * time.Hour,
+	Step:        24 * time.Hour,
+	Concurrency: 4,
+	Status:      domain.BackfillStatusRunning,
+	Total:       88,
+	Created:     time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC),
+	Updated:     time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC),
+}
+
+func TestBackfillsPost(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	backfiller := NewMockBackfiller(ctrl)
+	backfiller.EXPECT().Start(gomock.Any(), domain.BackfillRequest{
+		Start:       testBackfill.Start,
+		Stop:        testBackfill.Stop,
+		Template:    "daily",
+		Concurrency: 2,
+	}).Return(testBackfill, nil)
+
+	h := &Backfills{LogProvider: logevent.FromContext, Backfiller: backfiller}
+	w := httptest.NewRecorder()
+	h.Post(w, newBackfillRequest(http.MethodPost, "/backfills?start=2019-01-01T00:00:00Z&stop=2019-04-01T00:00:00Z&template=daily&concurrency=2"))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/backfills/id", w.Header().Get("Location"))
+	assert.JSONEq(t, `{"id":"id","start":"2019-01-01T00:00:00Z","stop":"2019-04-01T00:00:00Z","window":"24h0m0s","step":"24h0m0s",
+		"concurrency":4,"status":"running","total":88,"queued":0,"existing":0,"inProgress":0,"failed":0,
+		"created":"2019-04-01T12:00:00Z","updated":"2019-04-01T12:00:00Z"}`, w.Body.String())
+}
+
+func TestBackfillsPostWindow(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	backfiller := NewMockBackfiller(ctrl)
+	backfiller.EXPECT().Start(gomock.Any(), domain.BackfillRequest{
+		Start:  testBackfill.Start,
+		Stop:   testBackfill.Stop,
+		Window: 24 * time.Hour,
+		Step:   time.Hour,
+	}).Return(testBackfill, nil)
+
+	h := &Backfills{LogProvider: logevent.FromContext, Backfiller: backfiller}
+	w := httptest.NewRecorder()
+	h.Post(w, newBackfillRequest(http.MethodPost, "/backfills?start=2019-01-01T00:00:00Z&stop=2019-04-01T00:00:00Z&window=24h&step=1h"))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestBackfillsPostInvalid(t *testing.T) {
+	for _, query := range []string{
+		"stop=2019-04-01T00:00:00Z&template=daily",
+		"start=2019-04-01T00:00:00Z&stop=2019-01-01T00:00:00Z&template=daily",
+		"start=2019-01-01T00:00:00Z&stop=2019-04-01T00:00:00Z&template=daily&window=1h",
+		"start=2019-01-01T00:00:00Z&stop=2019-04-01T00:00:00Z&window=day",
+		"start=2019-01-01T00:00:00Z&stop=2019-04-01T00:00:00Z&template=daily&concurrency=0",
+	} {
+		h := &Backfills{LogProvider: logevent.FromContext}
+		w := httptest.NewRecorder()
+		h.Post(w, newBackfillRequest(http.MethodPost, "/backfills?"+query))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	backfiller := NewMockBackfiller(ctrl)
+	gomock.InOrder(
+		backfiller.EXPECT().Start(gomock.Any(), gomock.Any()).Return(domain.Backfill{}, domain.ErrInvalidBackfill{Reason: "too long"}),
+		backfiller.EXPECT().Start(gomock.Any(), gomock.Any()).Return(domain.Backfill{}, errors.New("denied")),
+	)
+	h := &Backfills{LogProvider: logevent.FromContext, Backfiller: backfiller}
+	w := httptest.NewRecorder()
+	h.Post(w, newBackfillRequest(http.MethodPost, "/backfills?start=2019-01-01T00:00:00Z&stop=2019-04-01T00:00:00Z&template=hourly"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = httptest.NewRecorder()
+	h.Post(w, newBackfillRequest(http.MethodPost, "/backfills?start=2019-01-01T00:00:00Z&stop=2019-04-01T00:00:00Z&template=hourly"))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestBackfillsGet(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	complete := testBackfill
+	complete.Status = domain.BackfillStatusComplete
+	complete.Queued = 88
+	complete.Completed = time.Date(2019, 4, 1, 12, 5, 0, 0, time.UTC)
+	backfiller := NewMockBackfiller(ctrl)
+	gomock.InOrder(
+		backfiller.EXPECT().Get(gomock.Any(), "id").Return(complete, nil),
+		backfiller.EXPECT().Get(gomock.Any(), "missing").Return(domain.Backfill{}, domain.ErrNotFound{ID: "missing"}),
+		backfiller.EXPECT().Get(gomock.Any(), "id").Return(domain.Backfill{}, errors.New("denied")),
+	)
+
+	h := &Backfills{LogProvider: logevent.FromContext, Backfiller: backfiller}
+	router := chi.NewRouter()
+	router.Get("/backfills/{id}", h.Get)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newBackfillRequest(http.MethodGet, "/backfills/id"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"complete"`)
+	assert.Contains(t, w.Body.String(), `"completed":"2019-04-01T12:05:00Z"`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newBackfillRequest(http.MethodGet, "/backfills/missing"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newBackfillRequest(http.MethodGet, "/backfills/id"))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestBackfillsPostScoped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	backfiller := NewMockBackfiller(ctrl)
+	backfiller.EXPECT().Start(gomock.Any(), domain.BackfillRequest{
+		Start:    testBackfill.Start,
+		Stop:     testBackfill.Stop,
+		Template: "daily",
+		Scope:    domain.NewScope("acme", []string{"1"}),
+	}).Return(testBackfill, nil)
+
+	h := &Backfills{
+		LogProvider: logevent.FromContext,
+		Backfiller:  backfiller,
+		Authorizer:  staticAuthorizer{scope: domain.NewScope("acme", []string{"1", "2"})},
+	}
+	w := httptest.NewRecorder()
+	h.Post(w, newBackfillRequest(http.MethodPost, "/backfills?start=2019-01-01T00:00:00Z&stop=2019-04-01T00:00:00Z&template=daily&accounts=1"))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// accounts which are not permitted, and callers without an identity, are forbidden
+	w = httptest.NewRecorder()
+	h.Post(w, newBackfillRequest(http.MethodPost, "/backfills?start=2019-01-01T00:00:00Z&stop=2019-04-01T00:00:00Z&template=daily&accounts=3"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	h.Authorizer = staticAuthorizer{err: errors.New("missing header")}
+	w = httptest.NewRecorder()
+	h.Post(w, newBackfillRequest(http.MethodPost, "/backfills?start=2019-01-01T00:00:00Z&stop=2019-04-01T00:00:00Z&template=daily"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestBackfillsGetScoped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	scoped := testBackfill
+	scoped.Scope = domain.NewScope("acme", []string{"1"})
+	backfiller := NewMockBackfiller(ctrl)
+	backfiller.EXPECT().Get(gomock.Any(), "id").Return(scoped, nil).Times(3)
+
+	h := &Backfills{
+		LogProvider: logevent.FromContext,
+		Backfiller:  backfiller,
+		Authorizer:  staticAuthorizer{scope: domain.NewScope("acme", nil)},
+	}
+	router := chi.NewRouter()
+	router.Get("/backfills/{id}", h.Get)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newBackfillRequest(http.MethodGet, "/backfills/id"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"tenant":"acme","accounts":["1"]`)
+
+	// backfills of other accounts are forbidden, and those of other tenants are not found
+	h.Authorizer = staticAuthorizer{scope: domain.NewScope("acme", []string{"2"})}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newBackfillRequest(http.MethodGet, "/backfills/id"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	h.Authorizer = staticAuthorizer{scope: domain.NewScope("other", nil)}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newBackfillRequest(http.MethodGet, "/backfills/id"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
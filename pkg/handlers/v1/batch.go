@@ -0,0 +1,150 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/handlers"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/logs"
+)
+
+const (
+	maxBatchSize = 1000
+
+	// batchConcurrency is the number of diffs of a batch which are checked and queued at once
+	batchConcurrency = 8
+)
+
+// The outcomes of the diffs of a batch
+const (
+	batchAccepted   = "accepted"
+	batchExists     = "exists"
+	batchInProgress = "in_progress"
+	batchInvalid    = "invalid"
+	batchForbidden  = "forbidden"
+	batchFailed     = "failed"
+)
+
+type batchItem struct {
+	PreviousStart string   `json:"previousStart"`
+	PreviousStop  string   `json:"previousStop"`
+	NextStart     string   `json:"nextStart"`
+	NextStop      string   `json:"nextStop"`
+	TTL           string   `json:"ttl,omitempty"`
+	Accounts      []string `json:"accounts,omitempty"`
+}
+
+type batchResult struct {
+	ID      string `json:"id,omitempty"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type batchResults struct {
+	Results []batchResult `json:"results"`
+}
+
+// Batch creates the diffs of a JSON array of time ranges, each validated as by Post. Diffs
+// are created as by Post without force, and the response holds the outcome of each diff in
+// the order of the request. Ranges which have the same ID are only queued once, with the TTL
+// of the first, and share its outcome. Diffs of accounts the caller is not permitted are
+// reported as forbidden.
+func (h *DiffHandler) Batch(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	permitted, err := handlers.Permitted(r, h.Authorizer)
+	if err != nil {
+		writeInputError(w, logger, err)
+		return
+	}
+	var items []batchItem
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		writeJSONResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(items) < 1 || len(items) > maxBatchSize {
+		msg := fmt.Sprintf("a batch should hold between 1 and %d diffs", maxBatchSize)
+		logger.Info(logs.InvalidInput{Reason: msg})
+		writeJSONResponse(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	results := make([]batchResult, len(items))
+	diffs := make([]domain.Diff, 0, len(items))
+	indexes := make(map[string][]int, len(items))
+	for i, item := range items {
+		scope, err := permitted.Narrow(item.Accounts)
+		if err != nil {
+			logger.Info(logs.Forbidden{Reason: err.Error()})
+			results[i] = batchResult{Status: batchForbidden, Message: err.Error()}
+			continue
+		}
+		diff, err := parseInput(item.PreviousStart, item.PreviousStop, item.NextStart, item.NextStop, scope)
+		if err == nil {
+			diff.TTL, err = parseTTL(item.TTL)
+		}
+		if err != nil {
+			logger.Info(logs.InvalidInput{Reason: err.Error()})
+			results[i] = batchResult{Status: batchInvalid, Message: err.Error()}
+			continue
+		}
+		if _, ok := indexes[diff.ID]; !ok {
+			diffs = append(diffs, diff)
+		}
+		indexes[diff.ID] = append(indexes[diff.ID], i)
+	}
+
+	var wg sync.WaitGroup
+	work := make(chan domain.Diff)
+	for i := 0; i < batchConcurrency && i < len(diffs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for diff := range work {
+				// each diff has its own indexes, so the results are written without locking
+				result := h.queue(r.Context(), logger, diff)
+				for _, index := range indexes[diff.ID] {
+					results[index] = result
+				}
+			}
+		}()
+	}
+	for _, diff := range diffs {
+		work <- diff
+	}
+	close(work)
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(batchResults{Results: results})
+}
+
+// queue creates a diff of a batch, and returns its outcome.
+func (h *DiffHandler) queue(ctx context.Context, logger domain.Logger, diff domain.Diff) batchResult {
+	exists, err := h.Storage.Exists(ctx, diff.Key())
+	switch err.(type) {
+	case nil:
+	case domain.ErrInProgress:
+		return batchResult{ID: diff.ID, Status: batchInProgress}
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		return batchResult{ID: diff.ID, Status: batchFailed, Message: "Internal Server Error"}
+	}
+	if exists {
+		return batchResult{ID: diff.ID, Status: batchExists}
+	}
+	if err = h.Queuer.Queue(ctx, diff); err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyQueuer, Reason: err.Error()})
+		return batchResult{ID: diff.ID, Status: batchFailed, Message: "Internal Server Error"}
+	}
+	// If mark fails, the diff is still accepted since diff creation should be idempotent
+	if err = h.Marker.Mark(ctx, diff.Key()); err != nil {
+		logger.Info(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+	}
+	return batchResult{ID: diff.ID, Status: batchAccepted}
+}
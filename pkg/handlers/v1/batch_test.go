@@ -0,0 +1,156 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBatchRequest(body string) *http.Request {
+	r, _ := http.NewRequest(http.MethodPost, "/batch", bytes.NewBufferString(body))
+	return r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+}
+
+// newBatchItem returns the item of the day-over-day diff of the day which starts at the
+// given time, along with its ID.
+func newBatchItem(start time.Time) (batchItem, string) {
+	day := 24 * time.Hour
+	item := batchItem{
+		PreviousStart: start.Add(-day).Format(time.RFC3339Nano),
+		PreviousStop:  start.Format(time.RFC3339Nano),
+		NextStart:     start.Format(time.RFC3339Nano),
+		NextStop:      start.Add(day).Format(time.RFC3339Nano),
+	}
+	return item, domain.NewDiff(start.Add(-day), start, start, start.Add(day)).ID
+}
+
+func TestBatchBadRequest(t *testing.T) {
+	tc := []struct {
+		Name string
+		Body string
+	}{
+		{"malformed", "{"},
+		{"not an array", "{}"},
+		{"empty", "[]"},
+		{"too many", "[" + strings.Repeat("{},", maxBatchSize) + "{}]"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h := DiffHandler{LogProvider: logevent.FromContext}
+			h.Batch(w, newBatchRequest(tt.Body))
+			assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+		})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, time.January, 2, 0, 0, 0, 0, time.UTC)
+	accepted, acceptedID := newBatchItem(start)
+	accepted.TTL = "72h"
+	exists, existsID := newBatchItem(start.Add(24 * time.Hour))
+	inProgress, inProgressID := newBatchItem(start.Add(48 * time.Hour))
+	storageFailure, storageFailureID := newBatchItem(start.Add(72 * time.Hour))
+	queueFailure, queueFailureID := newBatchItem(start.Add(96 * time.Hour))
+	reversed, _ := newBatchItem(start)
+	reversed.NextStart, reversed.NextStop = reversed.NextStop, reversed.NextStart
+	badTTL, _ := newBatchItem(start)
+	badTTL.TTL = "-1h"
+	items := []batchItem{accepted, exists, inProgress, reversed, storageFailure, queueFailure, badTTL, accepted}
+	body, err := json.Marshal(items)
+	require.Nil(t, err)
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), acceptedID).Return(false, nil)
+	storageMock.EXPECT().Exists(gomock.Any(), existsID).Return(true, nil)
+	storageMock.EXPECT().Exists(gomock.Any(), inProgressID).Return(false, domain.ErrInProgress{Key: inProgressID})
+	storageMock.EXPECT().Exists(gomock.Any(), storageFailureID).Return(false, errors.New("oops"))
+	storageMock.EXPECT().Exists(gomock.Any(), queueFailureID).Return(false, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, diff domain.Diff) error {
+		if diff.ID == queueFailureID {
+			return errors.New("oops")
+		}
+		assert.Equal(t, acceptedID, diff.ID)
+		assert.Equal(t, 72*time.Hour, diff.TTL)
+		return nil
+	}).Times(2)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), acceptedID).Return(errors.New("oops"))
+
+	w := httptest.NewRecorder()
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Queuer:      queuerMock,
+		Marker:      markerMock,
+	}
+	h.Batch(w, newBatchRequest(string(body)))
+
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	var results batchResults
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&results))
+	require.Len(t, results.Results, len(items))
+	statuses := make([]string, 0, len(items))
+	for _, result := range results.Results {
+		statuses = append(statuses, result.Status)
+	}
+	assert.Equal(t, []string{
+		batchAccepted, batchExists, batchInProgress, batchInvalid, batchFailed, batchFailed, batchInvalid, batchAccepted,
+	}, statuses)
+	assert.Equal(t, acceptedID, results.Results[0].ID)
+	assert.Equal(t, acceptedID, results.Results[7].ID)
+	assert.Equal(t, existsID, results.Results[1].ID)
+	assert.Empty(t, results.Results[3].ID)
+	assert.NotEmpty(t, results.Results[3].Message)
+	assert.Equal(t, "Internal Server Error", results.Results[4].Message)
+}
+
+func TestBatchForbidden(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start := time.Date(2019, time.January, 2, 0, 0, 0, 0, time.UTC)
+	permitted, _ := newBatchItem(start)
+	permitted.Accounts = []string{"1"}
+	forbidden, _ := newBatchItem(start)
+	forbidden.Accounts = []string{"2"}
+	body, _ := json.Marshal([]batchItem{permitted, forbidden})
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, key string) (bool, error) {
+		assert.True(t, strings.HasPrefix(key, "tenants/acme/"))
+		return true, nil
+	})
+
+	w := httptest.NewRecorder()
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Authorizer:  staticAuthorizer{scope: domain.NewScope("acme", []string{"1"})},
+	}
+	h.Batch(w, newBatchRequest(string(body)))
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	var results batchResults
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&results))
+	require.Len(t, results.Results, 2)
+	assert.Equal(t, batchExists, results.Results[0].Status)
+	assert.Equal(t, batchForbidden, results.Results[1].Status)
+}
@@ -6,36 +6,72 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/handlers"
 	"github.com/asecurityteam/vpcflow-diffd/pkg/logs"
 	"github.com/google/uuid"
 )
 
-var diffNamespace = uuid.NewSHA1(uuid.Nil, []byte("diff"))
-
 // DiffHandler handles incoming HTTP requests for creating and retrieving new network graph diffs
 type DiffHandler struct {
 	LogProvider domain.LogFn
 	Storage     domain.Storage
 	Queuer      domain.Queuer
 	Marker      domain.Marker
+
+	// Authorizer decides the scope of the diffs each caller may access. If nil, every caller
+	// may access all diffs which belong to no tenant.
+	Authorizer domain.Authorizer
+
+	// forced holds the keys of diffs for which a forced regeneration is being queued by
+	// this handler. It only coalesces the requests made to this instance.
+	forced sync.Map
 }
 
-// Post creates a new diff
+// Post creates a new diff. If the force query parameter is true, an existing diff is
+// created again and replaced. If the ttl query parameter is set, the diff expires once
+// that long has passed since it was created. If the accounts query parameter is set, the
+// diff only has the edges of those accounts.
 func (h *DiffHandler) Post(w http.ResponseWriter, r *http.Request) {
 	logger := h.LogProvider(r.Context())
-	diff, err := extractInput(r)
+	permitted, err := handlers.Permitted(r, h.Authorizer)
+	if err != nil {
+		writeInputError(w, logger, err)
+		return
+	}
+	diff, err := extractInput(r, permitted)
+	if err != nil {
+		writeInputError(w, logger, err)
+		return
+	}
+	diff.TTL, err = extractTTL(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		writeJSONResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	force, err := extractForce(r)
 	if err != nil {
 		logger.Info(logs.InvalidInput{Reason: err.Error()})
 		writeJSONResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	exists, err := h.Storage.Exists(r.Context(), diff.ID)
+	exists, err := h.Storage.Exists(r.Context(), diff.Key())
 	switch err.(type) {
 	case nil:
 	case domain.ErrInProgress:
+		// A forced request for a diff which is already being created is folded in to
+		// that job rather than starting another.
+		if force {
+			logger.Info(logs.Coalesced{Reason: err.Error()})
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
 		logger.Info(logs.Conflict{Reason: err.Error()})
 		writeJSONResponse(w, http.StatusConflict, err.Error())
 		return
@@ -44,6 +80,10 @@ func (h *DiffHandler) Post(w http.ResponseWriter, r *http.Request) {
 		writeJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
+	if force {
+		h.regenerate(w, r, diff)
+		return
+	}
 	// if data is returned, a diff already exists. return 409 and exit
 	if exists {
 		pStart := diff.PreviousStart.Format(time.RFC3339)
@@ -63,7 +103,7 @@ func (h *DiffHandler) Post(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// If mark fails, we don't fail the request since diff creation should be idempotent
-	err = h.Marker.Mark(r.Context(), diff.ID)
+	err = h.Marker.Mark(r.Context(), diff.Key())
 	if err != nil {
 		logger.Info(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
 	}
@@ -71,66 +111,428 @@ func (h *DiffHandler) Post(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusAccepted)
 }
 
-// Get retrieves a diff
+// regenerate queues a diff job regardless of whether the diff already exists. The previous
+// diff is served until it is replaced once the job completes, and its metadata is kept by
+// the Storage.
+//
+// Unlike a regular POST, the diff is marked as in progress before it is queued, and marking
+// must succeed. Forced requests which arrive once the mark is in place see the diff as in
+// progress and are coalesced in to this job. Concurrent forced requests which arrive before
+// the mark is in place are coalesced by this handler if they reach this instance, or by the
+// Marker if it marks diffs conditionally, which is the only way to coalesce them across
+// instances.
+func (h *DiffHandler) regenerate(w http.ResponseWriter, r *http.Request, diff domain.Diff) {
+	logger := h.LogProvider(r.Context())
+	if _, loaded := h.forced.LoadOrStore(diff.Key(), struct{}{}); loaded {
+		logger.Info(logs.Coalesced{Reason: fmt.Sprintf("diff %s is already being regenerated", diff.ID)})
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	defer h.forced.Delete(diff.Key())
+
+	err := h.Marker.Mark(r.Context(), diff.Key())
+	switch err.(type) {
+	case nil:
+	case domain.ErrInProgress:
+		// a Marker which marks conditionally has found the diff marked by another request
+		logger.Info(logs.Coalesced{Reason: err.Error()})
+		w.WriteHeader(http.StatusAccepted)
+		return
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+		writeJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if err := h.Queuer.Queue(r.Context(), diff); err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyQueuer, Reason: err.Error()})
+		if err := h.Marker.Unmark(r.Context(), diff.Key()); err != nil {
+			logger.Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+		}
+		writeJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Get retrieves a diff. If the Storage implements domain.ObjectStorage, the diff is sent with
+// cache headers, conditional requests for a diff the client already has are answered with 304
+// Not Modified, and diffs sent as they are stored can be fetched in ranges.
 func (h *DiffHandler) Get(w http.ResponseWriter, r *http.Request) {
 	logger := h.LogProvider(r.Context())
-	diff, err := extractInput(r)
+	permitted, err := handlers.Permitted(r, h.Authorizer)
 	if err != nil {
-		logger.Info(logs.InvalidInput{Reason: err.Error()})
-		writeJSONResponse(w, http.StatusBadRequest, err.Error())
+		writeInputError(w, logger, err)
 		return
 	}
+	diff, err := extractInput(r, permitted)
+	if err != nil {
+		writeInputError(w, logger, err)
+		return
+	}
+
+	accept := acceptedEncodings(r)
+	if objects, ok := h.Storage.(domain.ObjectStorage); ok {
+		info, err := objects.Stat(r.Context(), diff.Key())
+		switch err.(type) {
+		case nil:
+			// diffs which the client accepts as they are stored are sent as they are stored
+			if info.Encoding == "" || contains(accept, info.Encoding) {
+				setDiffHeaders(w, info.Encoding)
+				if err := handlers.ServeObject(w, r, objects, diff.Key(), info, handlers.CacheControl(h.Authorizer)); err != nil {
+					logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+				}
+				return
+			}
+			etag := handlers.ETag(info, "")
+			handlers.SetCacheHeaders(w.Header(), etag, info.Created, handlers.CacheControl(h.Authorizer))
+			if handlers.NotModified(r, etag, info.Created) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case domain.ErrUnsupported:
+		default:
+			h.writeGetError(w, r, err)
+			return
+		}
+	}
+
+	var body io.ReadCloser
+	var encoding string
+	if encoded, ok := h.Storage.(domain.EncodedStorage); ok {
+		body, encoding, err = encoded.GetEncoded(r.Context(), diff.Key(), accept)
+	} else {
+		body, err = h.Storage.Get(r.Context(), diff.Key())
+	}
+	if err != nil {
+		h.writeGetError(w, r, err)
+		return
+	}
+	defer body.Close()
 
-	body, err := h.Storage.Get(r.Context(), diff.ID)
+	setDiffHeaders(w, encoding)
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, body)
+}
+
+// writeGetError writes the response to a GET of a diff which could not be fetched
+func (h *DiffHandler) writeGetError(w http.ResponseWriter, r *http.Request, err error) {
+	logger := h.LogProvider(r.Context())
 	switch err.(type) {
-	case nil:
-		defer body.Close()
 	case domain.ErrInProgress:
 		w.WriteHeader(http.StatusNoContent)
-		return
 	case domain.ErrNotFound:
 		logger.Info(logs.NotFound{Reason: err.Error()})
 		w.WriteHeader(http.StatusNotFound)
-		return
+	case domain.ErrExpired:
+		logger.Info(logs.Expired{Reason: err.Error()})
+		w.WriteHeader(http.StatusGone)
 	default:
 		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
 		writeJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
-		return
 	}
+}
 
+// setDiffHeaders sets the headers of a diff which is sent with the given encoding
+func setDiffHeaders(w http.ResponseWriter, encoding string) {
 	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Vary", "Accept-Encoding")
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptedEncodings returns the content encodings listed in the Accept-Encoding header of the
+// request, excluding any which the client has explicitly refused with a zero quality value.
+func acceptedEncodings(r *http.Request) []string {
+	var encodings []string
+	for _, header := range r.Header["Accept-Encoding"] {
+		for _, value := range strings.Split(header, ",") {
+			parts := strings.Split(value, ";")
+			encoding := strings.ToLower(strings.TrimSpace(parts[0]))
+			if encoding == "" {
+				continue
+			}
+			refused := false
+			for _, param := range parts[1:] {
+				param = strings.Replace(param, " ", "", -1)
+				if strings.HasPrefix(param, "q=") {
+					q, err := strconv.ParseFloat(param[2:], 64)
+					refused = err == nil && q == 0
+				}
+			}
+			if !refused {
+				encodings = append(encodings, encoding)
+			}
+		}
+	}
+	return encodings
+}
+
+// Delete removes a diff, along with its metadata and any in progress marker, so that it can
+// be created again
+func (h *DiffHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	permitted, err := handlers.Permitted(r, h.Authorizer)
+	if err != nil {
+		writeInputError(w, logger, err)
+		return
+	}
+	key, err := extractKey(r, permitted)
+	if err != nil {
+		writeInputError(w, logger, err)
+		return
+	}
+	// a diff identified by its ID must be of accounts the caller is permitted
+	if id := r.URL.Query().Get("id"); id != "" {
+		err = handlers.CheckScope(r.Context(), h.Storage, permitted, id)
+		switch err.(type) {
+		case nil:
+		case domain.ErrForbidden:
+			writeInputError(w, logger, err)
+			return
+		default:
+			logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+			writeJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+	}
+
+	if err = h.Storage.Delete(r.Context(), key); err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		writeJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	// The marker is removed last so that the diff is never reported as complete while its
+	// content is being deleted. If this fails, the diff appears in progress until the marker
+	// expires, and the request should be retried.
+	if err = h.Marker.Unmark(r.Context(), key); err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+		writeJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+type diffListItem struct {
+	ID            string   `json:"id"`
+	PreviousStart string   `json:"previousStart"`
+	PreviousStop  string   `json:"previousStop"`
+	NextStart     string   `json:"nextStart"`
+	NextStop      string   `json:"nextStop"`
+	Created       string   `json:"created"`
+	Status        string   `json:"status"`
+	Added         int      `json:"added"`
+	Removed       int      `json:"removed"`
+	Expires       string   `json:"expires,omitempty"`
+	Accounts      []string `json:"accounts,omitempty"`
+}
+
+type diffList struct {
+	Diffs []diffListItem `json:"diffs"`
+	Next  string         `json:"next,omitempty"`
+}
+
+// List returns a page of the diffs which have been created, of the accounts the caller is
+// permitted
+func (h *DiffHandler) List(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	filter, err := extractListFilter(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		writeJSONResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	permitted, err := handlers.Permitted(r, h.Authorizer)
+	if err != nil {
+		writeInputError(w, logger, err)
+		return
+	}
+	scope, err := extractScope(r, permitted)
+	if err != nil {
+		writeInputError(w, logger, err)
+		return
+	}
+	filter.Scope = &scope
+
+	page, err := handlers.Indexed(h.Storage).List(r.Context(), filter)
+	if err != nil {
+		writeIndexError(w, logger, err)
+		return
+	}
+
+	list := diffList{Diffs: make([]diffListItem, 0, len(page.Diffs)), Next: page.Next}
+	for _, d := range page.Diffs {
+		item := diffListItem{
+			ID:            d.ID,
+			PreviousStart: d.PreviousStart.Format(time.RFC3339Nano),
+			PreviousStop:  d.PreviousStop.Format(time.RFC3339Nano),
+			NextStart:     d.NextStart.Format(time.RFC3339Nano),
+			NextStop:      d.NextStop.Format(time.RFC3339Nano),
+			Created:       d.Created.Format(time.RFC3339Nano),
+			Status:        d.Status,
+			Added:         d.Added,
+			Removed:       d.Removed,
+			Accounts:      d.Scope.Accounts,
+		}
+		if !d.Expires.IsZero() {
+			item.Expires = d.Expires.Format(time.RFC3339Nano)
+		}
+		list.Diffs = append(list.Diffs, item)
+	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	_, _ = io.Copy(w, body)
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+// extractListFilter extracts the optional filter and pagination query parameters of a
+// listing. Times must be RFC3339Nano.
+func extractListFilter(r *http.Request) (domain.ListFilter, error) {
+	q := r.URL.Query()
+	filter := domain.ListFilter{
+		Limit:  defaultListLimit,
+		Cursor: q.Get("cursor"),
+	}
+	times := []struct {
+		name  string
+		value *time.Time
+	}{
+		{"start", &filter.Start},
+		{"stop", &filter.Stop},
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+	}
+	for _, t := range times {
+		if q.Get(t.name) == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, q.Get(t.name))
+		if err != nil {
+			return domain.ListFilter{}, err
+		}
+		*t.value = parsed
+	}
+	if !filter.Start.IsZero() && !filter.Stop.IsZero() && filter.Start.After(filter.Stop) {
+		return domain.ListFilter{}, errors.New("start should be before stop")
+	}
+	if limit := q.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > maxListLimit {
+			return domain.ListFilter{}, fmt.Errorf("limit should be between 1 and %d", maxListLimit)
+		}
+		filter.Limit = l
+	}
+	return filter, nil
+}
+
+// extractForce returns the value of the optional force query parameter.
+func extractForce(r *http.Request) (bool, error) {
+	force := r.URL.Query().Get("force")
+	if force == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(force)
+}
+
+// extractTTL returns the value of the optional ttl query parameter, which is a positive
+// duration such as 72h.
+func extractTTL(r *http.Request) (time.Duration, error) {
+	return parseTTL(r.URL.Query().Get("ttl"))
+}
+
+// parseTTL parses an optional ttl, which is a positive duration such as 72h.
+func parseTTL(ttl string) (time.Duration, error) {
+	if ttl == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, errors.New("ttl must be positive")
+	}
+	return d, nil
+}
+
+// extractKey returns the storage key of the diff identified either directly by the id query
+// parameter within the permitted scope, or by the query parameters accepted by extractInput.
+func extractKey(r *http.Request, permitted domain.Scope) (string, error) {
+	if id := r.URL.Query().Get("id"); id != "" {
+		if _, err := uuid.Parse(id); err != nil {
+			return "", fmt.Errorf("invalid id %s", id)
+		}
+		return permitted.Key(id), nil
+	}
+	diff, err := extractInput(r, permitted)
+	return diff.Key(), err
+}
+
+// extractScope returns the scope of the optional accounts query parameter, which is a comma
+// separated list of accounts, within the permitted scope. If the parameter is not set, the
+// permitted scope is returned. If any of the accounts is not permitted, an error of type
+// domain.ErrForbidden is returned.
+func extractScope(r *http.Request, permitted domain.Scope) (domain.Scope, error) {
+	return permitted.Narrow(handlers.SplitAccounts(r.URL.Query().Get("accounts")))
 }
 
 // extractInput attempts to extract the time range query parameters required by GET and POST.
 // If any of the values are not valid RFC3339Nano or the input is invalid, an error is returned.
-// Otherwise, the Diff domain type is returned with the "previous" and "next" time ranges set. A
-// unique ID for the diff is also computed using these time values.
-//
-// Additionally, it truncates the time values to the nearest minute since anything with more
-// precision doesn't really fit the vpc flow filter use case
-func extractInput(r *http.Request) (domain.Diff, error) {
-	pStart, pStop, err := validateTimeRange(r.URL.Query().Get("previous_start"), r.URL.Query().Get("previous_stop"))
+// Otherwise, the Diff domain type is returned with the "previous" and "next" time ranges set,
+// in the scope given by extractScope. A unique ID for the diff is also computed using these
+// values, as by domain.NewScopedDiff.
+func extractInput(r *http.Request, permitted domain.Scope) (domain.Diff, error) {
+	scope, err := extractScope(r, permitted)
+	if err != nil {
+		return domain.Diff{}, err
+	}
+	q := r.URL.Query()
+	return parseInput(q.Get("previous_start"), q.Get("previous_stop"), q.Get("next_start"), q.Get("next_stop"), scope)
+}
+
+// parseInput validates the time ranges of a diff as extractInput does, and returns the Diff
+// of the scope with its ID.
+func parseInput(previousStart, previousStop, nextStart, nextStop string, scope domain.Scope) (domain.Diff, error) {
+	pStart, pStop, err := validateTimeRange(previousStart, previousStop)
 	if err != nil {
 		return domain.Diff{}, err
 	}
-	nStart, nStop, err := validateTimeRange(r.URL.Query().Get("next_start"), r.URL.Query().Get("next_stop"))
+	nStart, nStop, err := validateTimeRange(nextStart, nextStop)
 	if err != nil {
 		return domain.Diff{}, err
 	}
 	if pStart.After(nStart) || pStop.After(nStop) {
 		return domain.Diff{}, errors.New("the previous range should be before the next range")
 	}
-	name := pStart.String() + pStop.String() + nStart.String() + nStop.String()
-	id := uuid.NewSHA1(diffNamespace, []byte(name)).String()
-	return domain.Diff{
-		ID:            id,
-		PreviousStart: pStart.Truncate(time.Minute),
-		PreviousStop:  pStop.Truncate(time.Minute),
-		NextStart:     nStart.Truncate(time.Minute),
-		NextStop:      nStop.Truncate(time.Minute),
-	}, nil
+	return domain.NewScopedDiff(pStart, pStop, nStart, nStop, scope), nil
+}
+
+// writeInputError writes the response to a request which is invalid, or which is for diffs
+// the caller is not permitted.
+func writeInputError(w http.ResponseWriter, logger domain.Logger, err error) {
+	switch err.(type) {
+	case domain.ErrForbidden:
+		logger.Info(logs.Forbidden{Reason: err.Error()})
+		writeJSONResponse(w, http.StatusForbidden, err.Error())
+	default:
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		writeJSONResponse(w, http.StatusBadRequest, err.Error())
+	}
 }
 
 // write the http response with the given status code and message
@@ -144,3 +546,14 @@ func writeJSONResponse(w http.ResponseWriter, statusCode int, message string) {
 	w.WriteHeader(statusCode)
 	_ = json.NewEncoder(w).Encode(msg)
 }
+
+// writeIndexError writes the response of a request which failed to list or remove diffs. Storage
+// which does not record the metadata of diffs can not do either.
+func writeIndexError(w http.ResponseWriter, logger domain.Logger, err error) {
+	if _, ok := err.(domain.ErrUnsupported); ok {
+		writeJSONResponse(w, http.StatusNotImplemented, err.Error())
+		return
+	}
+	logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+	writeJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
+}
@@ -3,7 +3,9 @@ package v1
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -12,8 +14,10 @@ import (
 
 	"github.com/asecurityteam/logevent"
 	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/handlers"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func newHandlerFunc(storage domain.Storage, queuer domain.Queuer, method string) http.HandlerFunc {
@@ -211,6 +215,11 @@ func TestGetStorageErrors(t *testing.T) {
 			Error:              domain.ErrNotFound{},
 			ExpectedStatusCode: http.StatusNotFound,
 		},
+		{
+			Name:               "GET_expired",
+			Error:              domain.ErrExpired{},
+			ExpectedStatusCode: http.StatusGone,
+		},
 		{
 			Name:               "GET_unknown",
 			Error:              errors.New("oops"),
@@ -268,6 +277,151 @@ func TestGetHappyPath(t *testing.T) {
 	assert.Equal(t, data, string(result))
 }
 
+type encodedStorage struct {
+	domain.Storage
+	accept   []string
+	body     string
+	encoding string
+}
+
+func (s *encodedStorage) GetEncoded(ctx context.Context, key string, accept []string) (io.ReadCloser, string, error) {
+	s.accept = accept
+	return ioutil.NopCloser(bytes.NewReader([]byte(s.body))), s.encoding, nil
+}
+
+func TestGetEncoded(t *testing.T) {
+	r := newValidRequest(http.MethodGet)
+	r.Header.Set("Accept-Encoding", "gzip, zstd;q=0.5, br;q=0")
+	w := httptest.NewRecorder()
+
+	storage := &encodedStorage{body: "compressed diff", encoding: "zstd"}
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storage,
+	}
+	h.Get(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, []string{"gzip", "zstd"}, storage.accept)
+	assert.Equal(t, "zstd", w.Result().Header.Get("Content-Encoding"))
+	result, _ := ioutil.ReadAll(w.Result().Body)
+	assert.Equal(t, "compressed diff", string(result))
+}
+
+func TestGetEncodedIdentity(t *testing.T) {
+	r := newValidRequest(http.MethodGet)
+	w := httptest.NewRecorder()
+
+	storage := &encodedStorage{body: "diff"}
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storage,
+	}
+	h.Get(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Empty(t, storage.accept)
+	assert.Equal(t, "", w.Result().Header.Get("Content-Encoding"))
+}
+
+// objectStorage adds domain.ObjectStorage to an encodedStorage.
+type objectStorage struct {
+	encodedStorage
+	info    domain.ObjectInfo
+	statErr error
+}
+
+func (s *objectStorage) Stat(ctx context.Context, key string) (domain.ObjectInfo, error) {
+	return s.info, s.statErr
+}
+
+func (s *objectStorage) GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader([]byte(s.body[offset:]))), nil
+}
+
+func TestGetObjectRange(t *testing.T) {
+	r := newValidRequest(http.MethodGet)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Range", "bytes=5-")
+	w := httptest.NewRecorder()
+
+	storage := &objectStorage{
+		encodedStorage: encodedStorage{body: "compressed diff"},
+		info:           domain.ObjectInfo{Size: 15, Checksum: "abc", Created: time.Now(), Encoding: "gzip"},
+	}
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storage,
+	}
+	h.Get(w, r)
+
+	assert.Equal(t, http.StatusPartialContent, w.Result().StatusCode)
+	assert.Equal(t, "gzip", w.Result().Header.Get("Content-Encoding"))
+	assert.Equal(t, `"abc"`, w.Result().Header.Get("ETag"))
+	assert.Equal(t, "10", w.Result().Header.Get("Content-Length"))
+	assert.Equal(t, "bytes 5-14/15", w.Result().Header.Get("Content-Range"))
+	assert.Equal(t, handlers.PublicCacheControl, w.Result().Header.Get("Cache-Control"))
+	result, _ := ioutil.ReadAll(w.Result().Body)
+	assert.Equal(t, "essed diff", string(result))
+}
+
+func TestGetObjectDecoded(t *testing.T) {
+	storage := &objectStorage{
+		encodedStorage: encodedStorage{body: "diff"},
+		info:           domain.ObjectInfo{Size: 15, Checksum: "abc", Created: time.Now(), Encoding: "gzip"},
+	}
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storage,
+	}
+
+	// diffs which are decompressed as they are sent have their own entity tag, and are sent in full
+	r := newValidRequest(http.MethodGet)
+	r.Header.Set("Range", "bytes=2-")
+	w := httptest.NewRecorder()
+	h.Get(w, r)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, `"abc-identity"`, w.Result().Header.Get("ETag"))
+	assert.Equal(t, "", w.Result().Header.Get("Content-Encoding"))
+	result, _ := ioutil.ReadAll(w.Result().Body)
+	assert.Equal(t, "diff", string(result))
+
+	r = newValidRequest(http.MethodGet)
+	r.Header.Set("If-None-Match", `"abc-identity"`)
+	w = httptest.NewRecorder()
+	h.Get(w, r)
+	assert.Equal(t, http.StatusNotModified, w.Result().StatusCode)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestGetObjectStatErrors(t *testing.T) {
+	tc := []struct {
+		Name               string
+		Error              error
+		ExpectedStatusCode int
+	}{
+		{"in_progress", domain.ErrInProgress{}, http.StatusNoContent},
+		{"not_found", domain.ErrNotFound{}, http.StatusNotFound},
+		{"expired", domain.ErrExpired{}, http.StatusGone},
+		{"unknown", errors.New("oops"), http.StatusInternalServerError},
+		{"unsupported", domain.ErrUnsupported{}, http.StatusOK},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := newValidRequest(http.MethodGet)
+			w := httptest.NewRecorder()
+
+			h := DiffHandler{
+				LogProvider: logevent.FromContext,
+				Storage:     &objectStorage{encodedStorage: encodedStorage{body: "diff"}, statErr: tt.Error},
+			}
+			h.Get(w, r)
+
+			assert.Equal(t, tt.ExpectedStatusCode, w.Result().StatusCode)
+		})
+	}
+}
+
 func TestPostConflictInProgress(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -397,3 +551,513 @@ func TestPostUnsuccessfulMark(t *testing.T) {
 	// Shouldn't blow up
 	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
 }
+
+func newForcedRequest(force string) *http.Request {
+	r := newValidRequest(http.MethodPost)
+	q := r.URL.Query()
+	q.Set("force", force)
+	r.URL.RawQuery = q.Encode()
+	return r
+}
+
+func TestPostForceBadRequest(t *testing.T) {
+	w := httptest.NewRecorder()
+	h := DiffHandler{LogProvider: logevent.FromContext}
+	h.Post(w, newForcedRequest("maybe"))
+
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
+
+func TestPostForceHappyPath(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	w := httptest.NewRecorder()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(true, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	markerMock := NewMockMarker(ctrl)
+	gomock.InOrder(
+		markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil),
+		queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any()).Return(nil),
+	)
+
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Queuer:      queuerMock,
+		Marker:      markerMock,
+	}
+	h.Post(w, newForcedRequest("true"))
+
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+}
+
+func TestPostForceInProgress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	w := httptest.NewRecorder()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, domain.ErrInProgress{})
+
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Queuer:      NewMockQueuer(ctrl),
+		Marker:      NewMockMarker(ctrl),
+	}
+	h.Post(w, newForcedRequest("true"))
+
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+}
+
+func TestPostForceCoalesced(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	r := newForcedRequest("true")
+	w := httptest.NewRecorder()
+	diff, _ := extractInput(r, domain.Scope{})
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(true, nil)
+
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Queuer:      NewMockQueuer(ctrl),
+		Marker:      NewMockMarker(ctrl),
+	}
+	h.forced.Store(diff.ID, struct{}{})
+	h.Post(w, r)
+
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+}
+
+func TestPostForceMarkInProgress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	w := httptest.NewRecorder()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(true, nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(domain.ErrInProgress{})
+
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Queuer:      NewMockQueuer(ctrl),
+		Marker:      markerMock,
+	}
+	h.Post(w, newForcedRequest("true"))
+
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+}
+
+func TestPostForceMarkError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	w := httptest.NewRecorder()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(true, nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(errors.New("oops"))
+
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Queuer:      NewMockQueuer(ctrl),
+		Marker:      markerMock,
+	}
+	h.Post(w, newForcedRequest("true"))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+}
+
+func TestPostForceQueueError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	w := httptest.NewRecorder()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(true, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any()).Return(errors.New("oops"))
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil)
+	markerMock.EXPECT().Unmark(gomock.Any(), gomock.Any()).Return(nil)
+
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Queuer:      queuerMock,
+		Marker:      markerMock,
+	}
+	h.Post(w, newForcedRequest("true"))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+}
+
+func TestPostTTL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	r := newValidRequest(http.MethodPost)
+	q := r.URL.Query()
+	q.Set("ttl", "72h")
+	r.URL.RawQuery = q.Encode()
+	w := httptest.NewRecorder()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, diff domain.Diff) error {
+		assert.Equal(t, 72*time.Hour, diff.TTL)
+		return nil
+	})
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil)
+
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Queuer:      queuerMock,
+		Marker:      markerMock,
+	}
+	h.Post(w, r)
+
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+}
+
+func TestPostTTLBadRequest(t *testing.T) {
+	for _, ttl := range []string{"forever", "-1h", "0s"} {
+		t.Run(ttl, func(t *testing.T) {
+			r := newValidRequest(http.MethodPost)
+			q := r.URL.Query()
+			q.Set("ttl", ttl)
+			r.URL.RawQuery = q.Encode()
+			w := httptest.NewRecorder()
+
+			h := DiffHandler{LogProvider: logevent.FromContext}
+			h.Post(w, r)
+
+			assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+		})
+	}
+}
+
+func newListRequest(query string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "/diffs?"+query, nil)
+	return r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+}
+
+func TestListBadRequest(t *testing.T) {
+	tc := []struct {
+		Name  string
+		Query string
+	}{
+		{Name: "invalid_start", Query: "start=yesterday"},
+		{Name: "invalid_created_after", Query: "created_after=yesterday"},
+		{Name: "start_after_stop", Query: "start=2019-01-02T00:00:00Z&stop=2019-01-01T00:00:00Z"},
+		{Name: "invalid_limit", Query: "limit=ten"},
+		{Name: "limit_too_large", Query: "limit=1001"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h := DiffHandler{LogProvider: logevent.FromContext}
+			h.List(w, newListRequest(tt.Query))
+			assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+		})
+	}
+}
+
+func TestListStorageError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().List(gomock.Any(), gomock.Any()).Return(domain.DiffPage{}, errors.New("oops"))
+
+	w := httptest.NewRecorder()
+	h := DiffHandler{LogProvider: logevent.FromContext, Storage: storageMock}
+	h.List(w, newListRequest(""))
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+}
+
+func TestListUnindexed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// only the methods of domain.Storage are visible, so the storage is not indexed
+	storage := struct{ domain.Storage }{NewMockStorage(ctrl)}
+
+	w := httptest.NewRecorder()
+	h := DiffHandler{LogProvider: logevent.FromContext, Storage: storage}
+	h.List(w, newListRequest(""))
+	assert.Equal(t, http.StatusNotImplemented, w.Result().StatusCode)
+}
+
+func TestListHappyPath(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	start, _ := time.Parse(time.RFC3339, "2019-01-01T00:00:00Z")
+	stop, _ := time.Parse(time.RFC3339, "2019-01-02T00:00:00Z")
+	expectedFilter := domain.ListFilter{
+		Start:  start,
+		Stop:   stop,
+		Limit:  10,
+		Cursor: "abc",
+		Scope:  &domain.Scope{},
+	}
+	page := domain.DiffPage{
+		Diffs: []domain.DiffMetadata{
+			{
+				Diff: domain.Diff{
+					ID:            "def",
+					PreviousStart: start,
+					PreviousStop:  start.Add(time.Hour),
+					NextStart:     stop,
+					NextStop:      stop.Add(time.Hour),
+				},
+				Created: stop.Add(2 * time.Hour),
+				Status:  domain.DiffStatusComplete,
+				Added:   3,
+				Removed: 4,
+			},
+		},
+		Next: "def",
+	}
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().List(gomock.Any(), expectedFilter).Return(page, nil)
+
+	w := httptest.NewRecorder()
+	h := DiffHandler{LogProvider: logevent.FromContext, Storage: storageMock}
+	h.List(w, newListRequest("start=2019-01-01T00:00:00Z&stop=2019-01-02T00:00:00Z&limit=10&cursor=abc"))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	var list diffList
+	assert.Nil(t, json.NewDecoder(w.Result().Body).Decode(&list))
+	assert.Equal(t, diffList{
+		Diffs: []diffListItem{
+			{
+				ID:            "def",
+				PreviousStart: "2019-01-01T00:00:00Z",
+				PreviousStop:  "2019-01-01T01:00:00Z",
+				NextStart:     "2019-01-02T00:00:00Z",
+				NextStop:      "2019-01-02T01:00:00Z",
+				Created:       "2019-01-02T02:00:00Z",
+				Status:        domain.DiffStatusComplete,
+				Added:         3,
+				Removed:       4,
+			},
+		},
+		Next: "def",
+	}, list)
+}
+
+func TestDeleteBadRequest(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodDelete, "/?id=notauuid", nil)
+	r = r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+	w := httptest.NewRecorder()
+	h := DiffHandler{LogProvider: logevent.FromContext}
+	h.Delete(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
+
+func TestDeleteStorageError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Delete(gomock.Any(), gomock.Any()).Return(errors.New("oops"))
+
+	w := httptest.NewRecorder()
+	h := DiffHandler{LogProvider: logevent.FromContext, Storage: storageMock}
+	h.Delete(w, newValidRequest(http.MethodDelete))
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+}
+
+func TestDeleteUnindexed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// diffs are deleted from storage which records no metadata
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Delete(gomock.Any(), gomock.Any()).Return(nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Unmark(gomock.Any(), gomock.Any()).Return(nil)
+
+	w := httptest.NewRecorder()
+	h := DiffHandler{LogProvider: logevent.FromContext, Storage: struct{ domain.Storage }{storageMock}, Marker: markerMock}
+	h.Delete(w, newValidRequest(http.MethodDelete))
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+}
+
+func TestDeleteUnmarkError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Delete(gomock.Any(), gomock.Any()).Return(nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Unmark(gomock.Any(), gomock.Any()).Return(errors.New("oops"))
+
+	w := httptest.NewRecorder()
+	h := DiffHandler{LogProvider: logevent.FromContext, Storage: storageMock, Marker: markerMock}
+	h.Delete(w, newValidRequest(http.MethodDelete))
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+}
+
+func TestDeleteHappyPath(t *testing.T) {
+	tc := []struct {
+		Name    string
+		Request *http.Request
+	}{
+		{
+			Name:    "time_range",
+			Request: newValidRequest(http.MethodDelete),
+		},
+		{
+			Name: "id",
+			Request: httptest.NewRequest(http.MethodDelete, "/?id=6ba7b810-9dad-11d1-80b4-00c04fd430c8", nil).WithContext(
+				logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})),
+			),
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			expectedID, _ := extractKey(tt.Request, domain.Scope{})
+			storageMock := NewMockStorage(ctrl)
+			storageMock.EXPECT().Delete(gomock.Any(), expectedID).Return(nil)
+			markerMock := NewMockMarker(ctrl)
+			markerMock.EXPECT().Unmark(gomock.Any(), expectedID).Return(nil)
+
+			w := httptest.NewRecorder()
+			h := DiffHandler{LogProvider: logevent.FromContext, Storage: storageMock, Marker: markerMock}
+			h.Delete(w, tt.Request)
+			assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+		})
+	}
+}
+
+// staticAuthorizer permits every caller the same scope, or none if it has an error
+type staticAuthorizer struct {
+	scope domain.Scope
+	err   error
+}
+
+func (a staticAuthorizer) Authorize(r *http.Request) (domain.Scope, error) {
+	return a.scope, a.err
+}
+
+func TestPostScoped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	r := newValidRequest(http.MethodPost)
+	q := r.URL.Query()
+	q.Set("accounts", "2,1")
+	r.URL.RawQuery = q.Encode()
+	expected, err := extractInput(r, domain.NewScope("acme", nil))
+	require.Nil(t, err)
+	require.Equal(t, domain.NewScope("acme", []string{"1", "2"}), expected.Scope)
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), "tenants/acme/"+expected.ID).Return(false, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), expected).Return(nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), "tenants/acme/"+expected.ID).Return(nil)
+
+	w := httptest.NewRecorder()
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Queuer:      queuerMock,
+		Marker:      markerMock,
+		Authorizer:  staticAuthorizer{scope: domain.NewScope("acme", nil)},
+	}
+	h.Post(w, r)
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+}
+
+func TestGetScopedPrivate(t *testing.T) {
+	storage := &objectStorage{
+		encodedStorage: encodedStorage{body: "diff"},
+		info:           domain.ObjectInfo{Size: 4, Checksum: "abc", Created: time.Now()},
+	}
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storage,
+		Authorizer:  staticAuthorizer{scope: domain.NewScope("acme", nil)},
+	}
+
+	// the scope of the diff is decided by the identity headers, which shared caches ignore
+	w := httptest.NewRecorder()
+	h.Get(w, newValidRequest(http.MethodGet))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, handlers.PrivateCacheControl, w.Result().Header.Get("Cache-Control"))
+}
+
+func TestScopeForbidden(t *testing.T) {
+	tc := []struct {
+		Name       string
+		Authorizer staticAuthorizer
+		Query      string
+	}{
+		{"no identity", staticAuthorizer{err: errors.New("missing header")}, ""},
+		{"other accounts", staticAuthorizer{scope: domain.NewScope("acme", []string{"1"})}, "2"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := DiffHandler{LogProvider: logevent.FromContext, Authorizer: tt.Authorizer}
+			for _, handler := range []http.HandlerFunc{h.Post, h.Get, h.Delete, h.List} {
+				r := newValidRequest(http.MethodGet)
+				q := r.URL.Query()
+				q.Set("accounts", tt.Query)
+				r.URL.RawQuery = q.Encode()
+				w := httptest.NewRecorder()
+				handler(w, r)
+				assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+			}
+		})
+	}
+}
+
+func TestDeleteByIDForbidden(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	id := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Metadata(gomock.Any(), "tenants/acme/"+id).Return(domain.DiffMetadata{
+		Diff: domain.Diff{ID: id, Scope: domain.NewScope("acme", []string{"2"})},
+	}, nil)
+
+	r := httptest.NewRequest(http.MethodDelete, "/?id="+id, nil).WithContext(
+		logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})),
+	)
+	w := httptest.NewRecorder()
+	h := DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Authorizer:  staticAuthorizer{scope: domain.NewScope("acme", []string{"1"})},
+	}
+	h.Delete(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+}
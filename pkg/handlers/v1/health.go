@@ -0,0 +1,113 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/logs"
+)
+
+const (
+	defaultCheckTimeout  = 2 * time.Second
+	defaultCheckCacheTTL = 10 * time.Second
+)
+
+type dependencyStatus struct {
+	Ready     bool   `json:"ready"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+type readiness struct {
+	Ready        bool                        `json:"ready"`
+	CheckedAt    string                      `json:"checkedAt"`
+	Dependencies map[string]dependencyStatus `json:"dependencies"`
+}
+
+// Health handles the liveness and readiness probes of the service
+type Health struct {
+	LogProvider domain.LogFn
+
+	// Checks are the dependencies verified by the readiness probe, by name.
+	Checks map[string]domain.Checker
+
+	// Timeout bounds each check. It defaults to two seconds.
+	Timeout time.Duration
+
+	// CacheTTL is how long the results of the checks are reused for, so that frequent
+	// probes do not load the dependencies. It defaults to ten seconds.
+	CacheTTL time.Duration
+
+	lock      sync.Mutex
+	checkedAt time.Time
+	report    readiness
+}
+
+// Live reports that the service is running and able to handle requests.
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, http.StatusOK, "ok")
+}
+
+// Ready checks every dependency of the service and reports the outcome of each. The response
+// is 503 if any dependency cannot be reached.
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	report := h.check(r.Context())
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Add("Content-type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// check returns the cached readiness report, or runs the checks again if it is stale.
+// Concurrent probes wait for the same run rather than each running the checks.
+func (h *Health) check(ctx context.Context) readiness {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	ttl := h.CacheTTL
+	if ttl == 0 {
+		ttl = defaultCheckCacheTTL
+	}
+	if !h.checkedAt.IsZero() && time.Since(h.checkedAt) < ttl {
+		return h.report
+	}
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = defaultCheckTimeout
+	}
+
+	logger := h.LogProvider(ctx)
+	report := readiness{Ready: true, Dependencies: make(map[string]dependencyStatus, len(h.Checks))}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for name, checker := range h.Checks {
+		wg.Add(1)
+		go func(name string, checker domain.Checker) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			err := checker.Check(checkCtx)
+			status := dependencyStatus{Ready: err == nil, LatencyMs: int64(time.Since(start) / time.Millisecond)}
+			if err != nil {
+				logger.Warn(logs.DependencyFailure{Dependency: name, Reason: err.Error()})
+				status.Error = err.Error()
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			report.Dependencies[name] = status
+			report.Ready = report.Ready && status.Ready
+		}(name, checker)
+	}
+	wg.Wait()
+	h.checkedAt = time.Now()
+	report.CheckedAt = h.checkedAt.Format(time.RFC3339Nano)
+	h.report = report
+	return report
+}
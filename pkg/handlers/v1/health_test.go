@@ -0,0 +1,86 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newHealthRequest(path string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, path, nil)
+	return r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+}
+
+func TestLive(t *testing.T) {
+	h := &Health{LogProvider: logevent.FromContext}
+	w := httptest.NewRecorder()
+	h.Live(w, newHealthRequest("/healthcheck"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestReady(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storage := NewMockChecker(ctrl)
+	grapher := NewMockChecker(ctrl)
+	storage.EXPECT().Check(gomock.Any()).Return(nil)
+	grapher.EXPECT().Check(gomock.Any()).Return(errors.New("connection refused"))
+
+	h := &Health{
+		LogProvider: logevent.FromContext,
+		Checks:      map[string]domain.Checker{"storage": storage, "grapher": grapher},
+	}
+	w := httptest.NewRecorder()
+	h.Ready(w, newHealthRequest("/ready"))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var report readiness
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.False(t, report.Ready)
+	assert.True(t, report.Dependencies["storage"].Ready)
+	assert.False(t, report.Dependencies["grapher"].Ready)
+	assert.Equal(t, "connection refused", report.Dependencies["grapher"].Error)
+
+	// the report is cached, so the dependencies are not checked again
+	w = httptest.NewRecorder()
+	h.Ready(w, newHealthRequest("/ready"))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestReadyTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storage := NewMockChecker(ctrl)
+	storage.EXPECT().Check(gomock.Any()).DoAndReturn(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	storage.EXPECT().Check(gomock.Any()).Return(nil)
+
+	h := &Health{
+		LogProvider: logevent.FromContext,
+		Checks:      map[string]domain.Checker{"storage": storage},
+		Timeout:     time.Millisecond,
+		CacheTTL:    time.Nanosecond,
+	}
+	w := httptest.NewRecorder()
+	h.Ready(w, newHealthRequest("/ready"))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// the stale report is replaced once the cache expires
+	time.Sleep(time.Millisecond)
+	w = httptest.NewRecorder()
+	h.Ready(w, newHealthRequest("/ready"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
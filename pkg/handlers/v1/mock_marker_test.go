@@ -5,6 +5,7 @@ package v1
 
 import (
 	context "context"
+	domain "github.com/asecurityteam/vpcflow-diffd/pkg/domain"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -48,3 +49,75 @@ func (_m *MockMarker) Unmark(ctx context.Context, key string) error {
 func (_mr *_MockMarkerRecorder) Unmark(arg0, arg1 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Unmark", arg0, arg1)
 }
+
+// Mock of LeaseMarker interface
+type MockLeaseMarker struct {
+	ctrl     *gomock.Controller
+	recorder *_MockLeaseMarkerRecorder
+}
+
+// Recorder for MockLeaseMarker (not exported)
+type _MockLeaseMarkerRecorder struct {
+	mock *MockLeaseMarker
+}
+
+func NewMockLeaseMarker(ctrl *gomock.Controller) *MockLeaseMarker {
+	mock := &MockLeaseMarker{ctrl: ctrl}
+	mock.recorder = &_MockLeaseMarkerRecorder{mock}
+	return mock
+}
+
+func (_m *MockLeaseMarker) EXPECT() *_MockLeaseMarkerRecorder {
+	return _m.recorder
+}
+
+func (_m *MockLeaseMarker) Mark(ctx context.Context, key string) error {
+	ret := _m.ctrl.Call(_m, "Mark", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockLeaseMarkerRecorder) Mark(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Mark", arg0, arg1)
+}
+
+func (_m *MockLeaseMarker) Unmark(ctx context.Context, key string) error {
+	ret := _m.ctrl.Call(_m, "Unmark", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockLeaseMarkerRecorder) Unmark(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Unmark", arg0, arg1)
+}
+
+func (_m *MockLeaseMarker) Acquire(ctx context.Context, d domain.Diff) (string, error) {
+	ret := _m.ctrl.Call(_m, "Acquire", ctx, d)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockLeaseMarkerRecorder) Acquire(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Acquire", arg0, arg1)
+}
+
+func (_m *MockLeaseMarker) Renew(ctx context.Context, key string, lease string) error {
+	ret := _m.ctrl.Call(_m, "Renew", ctx, key, lease)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockLeaseMarkerRecorder) Renew(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Renew", arg0, arg1, arg2)
+}
+
+func (_m *MockLeaseMarker) Release(ctx context.Context, key string, lease string) error {
+	ret := _m.ctrl.Call(_m, "Release", ctx, key, lease)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockLeaseMarkerRecorder) Release(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Release", arg0, arg1, arg2)
+}
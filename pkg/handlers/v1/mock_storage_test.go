@@ -5,6 +5,7 @@ package v1
 
 import (
 	context "context"
+	domain "github.com/asecurityteam/vpcflow-diffd/pkg/domain"
 	gomock "github.com/golang/mock/gomock"
 	io "io"
 )
@@ -61,3 +62,45 @@ func (_m *MockStorage) Store(ctx context.Context, key string, data io.ReadCloser
 func (_mr *_MockStorageRecorder) Store(arg0, arg1, arg2 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Store", arg0, arg1, arg2)
 }
+
+func (_m *MockStorage) Metadata(ctx context.Context, key string) (domain.DiffMetadata, error) {
+	ret := _m.ctrl.Call(_m, "Metadata", ctx, key)
+	ret0, _ := ret[0].(domain.DiffMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockStorageRecorder) Metadata(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Metadata", arg0, arg1)
+}
+
+func (_m *MockStorage) Index(ctx context.Context, meta domain.DiffMetadata) error {
+	ret := _m.ctrl.Call(_m, "Index", ctx, meta)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockStorageRecorder) Index(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Index", arg0, arg1)
+}
+
+func (_m *MockStorage) List(ctx context.Context, filter domain.ListFilter) (domain.DiffPage, error) {
+	ret := _m.ctrl.Call(_m, "List", ctx, filter)
+	ret0, _ := ret[0].(domain.DiffPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockStorageRecorder) List(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "List", arg0, arg1)
+}
+
+func (_m *MockStorage) Delete(ctx context.Context, key string) error {
+	ret := _m.ctrl.Call(_m, "Delete", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockStorageRecorder) Delete(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Delete", arg0, arg1)
+}
@@ -1,12 +1,17 @@
 package v1
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
+	"time"
 
+	"github.com/asecurityteam/vpcflow-diffd/pkg/differ"
 	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/handlers"
 	"github.com/asecurityteam/vpcflow-diffd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/tracing"
 )
 
 type payload struct {
@@ -15,14 +20,32 @@ type payload struct {
 	PreviousStop  string `json:"previousStop"`
 	NextStart     string `json:"nextStart"`
 	NextStop      string `json:"nextStop"`
+	TTL           int64  `json:"ttl,omitempty"` // milliseconds
+
+	// Tenant and Accounts are the scope of the diff
+	Tenant   string   `json:"tenant,omitempty"`
+	Accounts []string `json:"accounts,omitempty"`
+
+	// Trace is the trace context of the request which queued the job
+	Trace map[string]string `json:"trace,omitempty"`
 }
 
-// Produce is a handler which performs the diff job, and stores the diff
+const defaultHeartbeatInterval = 10 * time.Second
+
+// Produce is a handler which performs the diff job, and stores the diff.
+//
+// If the Marker implements domain.LeaseMarker, the diff is leased for as long as the job runs,
+// and jobs for diffs which are leased to another worker are rejected. If the lease is lost
+// part way, the job is cancelled.
 type Produce struct {
 	LogProvider domain.LogFn
 	Marker      domain.Marker
 	Differ      domain.Differ
 	Storage     domain.Storage
+
+	// HeartbeatInterval is the time between renewals of the lease on the diff. It should be
+	// well within the TTL of the lease. If unset, leases are renewed every 10 seconds.
+	HeartbeatInterval time.Duration
 }
 
 // ServeHTTP handles incoming HTTP requests, and creates a diff of the VPC network graphs given two time windows
@@ -42,7 +65,33 @@ func (h *Produce) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dOut, err := h.Differ.Diff(r.Context(), diff)
+	// the job continues the trace of the request which queued it, even if the streaming
+	// appliance did not forward the trace headers
+	ctx := tracing.ExtractMap(r.Context(), body.Trace)
+	release := func(ctx context.Context) error { return h.Marker.Unmark(ctx, diff.Key()) }
+	if leaser, ok := h.Marker.(domain.LeaseMarker); ok {
+		lease, err := leaser.Acquire(ctx, diff)
+		switch err.(type) {
+		case nil:
+		case domain.ErrInProgress:
+			logger.Info(logs.Conflict{Reason: err.Error()})
+			writeTextResponse(w, http.StatusConflict, err.Error())
+			return
+		default:
+			logger.Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+			writeTextResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		var stop func()
+		ctx, stop = h.heartbeat(ctx, leaser, diff.Key(), lease)
+		defer stop()
+		release = func(ctx context.Context) error {
+			stop()
+			return leaser.Release(ctx, diff.Key(), lease)
+		}
+	}
+
+	dOut, err := h.Differ.Diff(ctx, diff)
 	if err != nil {
 		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyDiffer, Reason: err.Error()})
 		writeTextResponse(w, http.StatusInternalServerError, err.Error())
@@ -50,7 +99,26 @@ func (h *Produce) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	defer dOut.Close()
 
-	if err := h.Storage.Store(r.Context(), diff.ID, dOut); err != nil {
+	summary := &differ.Summary{ReadCloser: dOut}
+	if err := h.Storage.Store(ctx, diff.Key(), summary); err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		writeTextResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	meta := domain.DiffMetadata{
+		Diff:    diff,
+		Created: time.Now(),
+		Status:  domain.DiffStatusComplete,
+		Added:   summary.Added,
+		Removed: summary.Removed,
+	}
+	if diff.TTL > 0 {
+		meta.Expires = meta.Created.Add(diff.TTL)
+	}
+	// Storage which does not record metadata only stores the diff.
+	err = handlers.Indexed(h.Storage).Index(ctx, meta)
+	if _, ok := err.(domain.ErrUnsupported); err != nil && !ok {
 		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
 		writeTextResponse(w, http.StatusInternalServerError, err.Error())
 		return
@@ -60,7 +128,7 @@ func (h *Produce) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// fetching the diff will result in a perpetual "in progress" state. To mitigate this, we
 	// report a failure to the caller signifying that the operation should be retried. This will
 	// hopefully mitigate the amount of invalid state occurrence we may incur
-	if err := h.Marker.Unmark(r.Context(), body.ID); err != nil {
+	if err := release(r.Context()); err != nil {
 		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
 		writeTextResponse(w, http.StatusInternalServerError, err.Error())
 		return
@@ -68,6 +136,48 @@ func (h *Produce) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// heartbeat renews the lease on the diff every interval until stopped. The returned context is
+// cancelled if the lease is lost, and once the heartbeat is stopped. Stopping the heartbeat waits
+// for any renewal in flight, and may be called more than once.
+func (h *Produce) heartbeat(ctx context.Context, leaser domain.LeaseMarker, key string, lease string) (context.Context, func()) {
+	logger := h.LogProvider(ctx)
+	interval := h.HeartbeatInterval
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := leaser.Renew(ctx, key, lease)
+			switch err.(type) {
+			case nil:
+			case domain.ErrLeaseLost:
+				logger.Info(logs.Conflict{Reason: err.Error()})
+				cancel()
+				return
+			default:
+				// the lease may still be renewed before it expires
+				if ctx.Err() == nil {
+					logger.Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+				}
+			}
+		}
+	}()
+	return ctx, func() {
+		cancel()
+		<-done
+	}
+}
+
 func diffFromPayload(p payload) (domain.Diff, error) {
 	if p.ID == "" {
 		return domain.Diff{}, errors.New("missing ID field")
@@ -87,12 +197,18 @@ func diffFromPayload(p payload) (domain.Diff, error) {
 		return domain.Diff{}, errors.New("the previous range should be before the next range")
 	}
 
+	if p.TTL < 0 {
+		return domain.Diff{}, errors.New("ttl must not be negative")
+	}
+
 	return domain.Diff{
 		ID:            p.ID,
 		PreviousStart: pStart,
 		PreviousStop:  pStop,
 		NextStart:     nStart,
 		NextStop:      nStop,
+		TTL:           time.Duration(p.TTL) * time.Millisecond,
+		Scope:         domain.NewScope(p.Tenant, p.Accounts),
 	}, nil
 }
 
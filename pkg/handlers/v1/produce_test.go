@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -12,6 +13,8 @@ import (
 	"time"
 
 	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/tracing"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 )
@@ -162,6 +165,163 @@ func TestProduceStoreFailure(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
 }
 
+func TestProduceIndexFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDiffer := NewMockDiffer(ctrl)
+	mockDiffer.EXPECT().Diff(gomock.Any(), gomock.Any()).Return(ioutil.NopCloser(bytes.NewReader([]byte(""))), nil)
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Store(gomock.Any(), diffID, gomock.Any()).Return(nil)
+	mockStorage.EXPECT().Index(gomock.Any(), gomock.Any()).Return(errors.New(""))
+
+	r := newProduceRequest()
+	w := httptest.NewRecorder()
+	handler := &Produce{
+		LogProvider: logevent.FromContext,
+		Differ:      mockDiffer,
+		Storage:     mockStorage,
+	}
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+}
+
+func TestProduceUnindexed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDiffer := NewMockDiffer(ctrl)
+	mockDiffer.EXPECT().Diff(gomock.Any(), gomock.Any()).Return(ioutil.NopCloser(bytes.NewReader([]byte(""))), nil)
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Store(gomock.Any(), diffID, gomock.Any()).Return(nil)
+	mockMarker := NewMockMarker(ctrl)
+	mockMarker.EXPECT().Unmark(gomock.Any(), diffID).Return(nil)
+
+	r := newProduceRequest()
+	w := httptest.NewRecorder()
+	handler := &Produce{
+		LogProvider: logevent.FromContext,
+		Differ:      mockDiffer,
+		// only the methods of domain.Storage are visible, so the storage is not indexed
+		Storage: struct{ domain.Storage }{mockStorage},
+		Marker:  mockMarker,
+	}
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+}
+
+func TestProduceIndexSummary(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	diff := `digraph {
+n1 -> n2 [govpc_accountID="1" color=red label="accountID=1\ndiff=ADDED" govpc_diff="ADDED"]
+n2 -> n1 [govpc_accountID="1" color=red label="accountID=1\ndiff=ADDED" govpc_diff="ADDED"]
+n1 -> n3 [govpc_accountID="1" color=red label="accountID=1\ndiff=REMOVED" govpc_diff="REMOVED"]
+n1 [label="1"]
+}`
+	mockDiffer := NewMockDiffer(ctrl)
+	mockDiffer.EXPECT().Diff(gomock.Any(), gomock.Any()).Return(ioutil.NopCloser(bytes.NewReader([]byte(diff))), nil)
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Store(gomock.Any(), diffID, gomock.Any()).DoAndReturn(func(_ context.Context, _ string, data io.ReadCloser) error {
+		// read in small chunks so that lines span multiple reads
+		buf := make([]byte, 7)
+		for {
+			if _, err := data.Read(buf); err != nil {
+				return nil
+			}
+		}
+	})
+	mockStorage.EXPECT().Index(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, meta domain.DiffMetadata) error {
+		assert.Equal(t, diffID, meta.ID)
+		assert.Equal(t, domain.DiffStatusComplete, meta.Status)
+		assert.Equal(t, 2, meta.Added)
+		assert.Equal(t, 1, meta.Removed)
+		return nil
+	})
+	mockMarker := NewMockMarker(ctrl)
+	mockMarker.EXPECT().Unmark(gomock.Any(), diffID).Return(nil)
+
+	r := newProduceRequest()
+	w := httptest.NewRecorder()
+	handler := &Produce{
+		LogProvider: logevent.FromContext,
+		Differ:      mockDiffer,
+		Storage:     mockStorage,
+		Marker:      mockMarker,
+	}
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+}
+
+func TestProduceIndexExpiry(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDiffer := NewMockDiffer(ctrl)
+	mockDiffer.EXPECT().Diff(gomock.Any(), gomock.Any()).Return(ioutil.NopCloser(bytes.NewReader([]byte("digraph {}"))), nil)
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Store(gomock.Any(), diffID, gomock.Any()).Return(nil)
+	mockStorage.EXPECT().Index(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, meta domain.DiffMetadata) error {
+		assert.Equal(t, time.Hour, meta.TTL)
+		assert.Equal(t, meta.Created.Add(time.Hour), meta.Expires)
+		return nil
+	})
+	mockMarker := NewMockMarker(ctrl)
+	mockMarker.EXPECT().Unmark(gomock.Any(), diffID).Return(nil)
+
+	pStart := time.Now().Add(-1 * time.Hour).Format(time.RFC3339Nano)
+	pStop := time.Now().Format(time.RFC3339Nano)
+	nStart := time.Now().Add(time.Hour).Format(time.RFC3339Nano)
+	nStop := time.Now().Add(2 * time.Hour).Format(time.RFC3339Nano)
+	payload := fmt.Sprintf(`{"id":"%s","previousStart":"%s","previousStop":"%s","nextStart":"%s","nextStop":"%s","ttl":3600000}`, diffID, pStart, pStop, nStart, nStop)
+	r, _ := http.NewRequest(http.MethodPost, "/", ioutil.NopCloser(bytes.NewReader([]byte(payload))))
+	r = r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+	w := httptest.NewRecorder()
+	handler := &Produce{
+		LogProvider: logevent.FromContext,
+		Differ:      mockDiffer,
+		Storage:     mockStorage,
+		Marker:      mockMarker,
+	}
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+}
+
+func TestProduceTrace(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	traceparent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	mockDiffer := NewMockDiffer(ctrl)
+	mockDiffer.EXPECT().Diff(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, _ domain.Diff) (io.ReadCloser, error) {
+		assert.Equal(t, traceparent, tracing.InjectMap(ctx)["traceparent"])
+		return ioutil.NopCloser(bytes.NewReader([]byte(""))), nil
+	})
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Store(gomock.Any(), diffID, gomock.Any()).Return(nil)
+	mockStorage.EXPECT().Index(gomock.Any(), gomock.Any()).Return(nil)
+	mockMarker := NewMockMarker(ctrl)
+	mockMarker.EXPECT().Unmark(gomock.Any(), diffID).Return(nil)
+
+	pStart := time.Now().Add(-1 * time.Hour).Format(time.RFC3339Nano)
+	pStop := time.Now().Format(time.RFC3339Nano)
+	nStart := time.Now().Add(time.Hour).Format(time.RFC3339Nano)
+	nStop := time.Now().Add(2 * time.Hour).Format(time.RFC3339Nano)
+	payload := fmt.Sprintf(`{"id":"%s","previousStart":"%s","previousStop":"%s","nextStart":"%s","nextStop":"%s","trace":{"traceparent":"%s"}}`, diffID, pStart, pStop, nStart, nStop, traceparent)
+	r, _ := http.NewRequest(http.MethodPost, "/", ioutil.NopCloser(bytes.NewReader([]byte(payload))))
+	r = r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+	w := httptest.NewRecorder()
+	handler := &Produce{
+		LogProvider: logevent.FromContext,
+		Differ:      mockDiffer,
+		Storage:     mockStorage,
+		Marker:      mockMarker,
+	}
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+}
+
 func TestProduceUnmarkFailure(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -170,6 +330,7 @@ func TestProduceUnmarkFailure(t *testing.T) {
 	mockDiffer.EXPECT().Diff(gomock.Any(), gomock.Any()).Return(ioutil.NopCloser(bytes.NewReader([]byte(""))), nil)
 	mockStorage := NewMockStorage(ctrl)
 	mockStorage.EXPECT().Store(gomock.Any(), diffID, gomock.Any()).Return(nil)
+	mockStorage.EXPECT().Index(gomock.Any(), gomock.Any()).Return(nil)
 	mockMarker := NewMockMarker(ctrl)
 	mockMarker.EXPECT().Unmark(gomock.Any(), diffID).Return(errors.New(""))
 
@@ -193,6 +354,7 @@ func TestProduce(t *testing.T) {
 	mockDiffer.EXPECT().Diff(gomock.Any(), gomock.Any()).Return(ioutil.NopCloser(bytes.NewReader([]byte(""))), nil)
 	mockStorage := NewMockStorage(ctrl)
 	mockStorage.EXPECT().Store(gomock.Any(), diffID, gomock.Any()).Return(nil)
+	mockStorage.EXPECT().Index(gomock.Any(), gomock.Any()).Return(nil)
 	mockMarker := NewMockMarker(ctrl)
 	mockMarker.EXPECT().Unmark(gomock.Any(), diffID).Return(nil)
 
@@ -207,3 +369,138 @@ func TestProduce(t *testing.T) {
 	handler.ServeHTTP(w, r)
 	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
 }
+
+func TestProduceLease(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	renewed := make(chan struct{})
+	mockMarker := NewMockLeaseMarker(ctrl)
+	mockMarker.EXPECT().Acquire(gomock.Any(), gomock.Any()).Return("lease", nil)
+	mockMarker.EXPECT().Renew(gomock.Any(), diffID, "lease").DoAndReturn(func(context.Context, string, string) error {
+		select {
+		case renewed <- struct{}{}:
+		default:
+		}
+		return nil
+	}).MinTimes(1)
+	mockDiffer := NewMockDiffer(ctrl)
+	mockDiffer.EXPECT().Diff(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, domain.Diff) (io.ReadCloser, error) {
+		<-renewed // the lease is renewed while the diff is created
+		return ioutil.NopCloser(bytes.NewReader([]byte(""))), nil
+	})
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Store(gomock.Any(), diffID, gomock.Any()).Return(nil)
+	mockStorage.EXPECT().Index(gomock.Any(), gomock.Any()).Return(nil)
+	mockMarker.EXPECT().Release(gomock.Any(), diffID, "lease").Return(nil)
+
+	r := newProduceRequest()
+	w := httptest.NewRecorder()
+	handler := &Produce{
+		LogProvider:       logevent.FromContext,
+		Differ:            mockDiffer,
+		Storage:           mockStorage,
+		Marker:            mockMarker,
+		HeartbeatInterval: time.Millisecond,
+	}
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+}
+
+func TestProduceLeaseHeld(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockMarker := NewMockLeaseMarker(ctrl)
+	mockMarker.EXPECT().Acquire(gomock.Any(), gomock.Any()).Return("", domain.ErrInProgress{Key: diffID})
+
+	r := newProduceRequest()
+	w := httptest.NewRecorder()
+	handler := &Produce{
+		LogProvider: logevent.FromContext,
+		Differ:      NewMockDiffer(ctrl),
+		Storage:     NewMockStorage(ctrl),
+		Marker:      mockMarker,
+	}
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusConflict, w.Result().StatusCode)
+}
+
+func TestProduceLeaseError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockMarker := NewMockLeaseMarker(ctrl)
+	mockMarker.EXPECT().Acquire(gomock.Any(), gomock.Any()).Return("", errors.New("oops"))
+
+	r := newProduceRequest()
+	w := httptest.NewRecorder()
+	handler := &Produce{
+		LogProvider: logevent.FromContext,
+		Differ:      NewMockDiffer(ctrl),
+		Storage:     NewMockStorage(ctrl),
+		Marker:      mockMarker,
+	}
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+}
+
+func TestProduceLeaseLost(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockMarker := NewMockLeaseMarker(ctrl)
+	mockMarker.EXPECT().Acquire(gomock.Any(), gomock.Any()).Return("lease", nil)
+	mockMarker.EXPECT().Renew(gomock.Any(), diffID, "lease").Return(domain.ErrLeaseLost{Key: diffID})
+	mockDiffer := NewMockDiffer(ctrl)
+	mockDiffer.EXPECT().Diff(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, _ domain.Diff) (io.ReadCloser, error) {
+		<-ctx.Done() // the job is cancelled once the lease is lost
+		return nil, ctx.Err()
+	})
+
+	r := newProduceRequest()
+	w := httptest.NewRecorder()
+	handler := &Produce{
+		LogProvider:       logevent.FromContext,
+		Differ:            mockDiffer,
+		Storage:           NewMockStorage(ctrl),
+		Marker:            mockMarker,
+		HeartbeatInterval: time.Millisecond,
+	}
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+}
+
+func TestProduceScope(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	key := "tenants/acme/" + diffID
+	mockDiffer := NewMockDiffer(ctrl)
+	mockDiffer.EXPECT().Diff(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, diff domain.Diff) (io.ReadCloser, error) {
+		assert.Equal(t, domain.NewScope("acme", []string{"1", "2"}), diff.Scope)
+		return ioutil.NopCloser(bytes.NewReader([]byte(""))), nil
+	})
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Store(gomock.Any(), key, gomock.Any()).Return(nil)
+	mockStorage.EXPECT().Index(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, meta domain.DiffMetadata) error {
+		assert.Equal(t, key, meta.Key())
+		return nil
+	})
+	mockMarker := NewMockMarker(ctrl)
+	mockMarker.EXPECT().Unmark(gomock.Any(), key).Return(nil)
+
+	payload := `{"id":"` + diffID + `","previousStart":"2019-01-01T00:00:00Z","previousStop":"2019-01-01T01:00:00Z",
+		"nextStart":"2019-01-01T01:00:00Z","nextStop":"2019-01-01T02:00:00Z","tenant":"acme","accounts":["2","1"]}`
+	r, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(payload))
+	r = r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+	w := httptest.NewRecorder()
+	handler := &Produce{
+		LogProvider: logevent.FromContext,
+		Differ:      mockDiffer,
+		Storage:     mockStorage,
+		Marker:      mockMarker,
+	}
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+}
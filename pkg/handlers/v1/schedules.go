@@ -0,0 +1,74 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/handlers"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/logs"
+)
+
+type scheduleItem struct {
+	Name      string   `json:"name"`
+	Spec      string   `json:"spec"`
+	Template  string   `json:"template"`
+	Tenant    string   `json:"tenant,omitempty"`
+	Accounts  []string `json:"accounts,omitempty"`
+	LastRun   string   `json:"lastRun,omitempty"`
+	NextRun   string   `json:"nextRun"`
+	LastError string   `json:"lastError,omitempty"`
+}
+
+type scheduleList struct {
+	Schedules []scheduleItem `json:"schedules"`
+}
+
+// Schedules handles requests for the state of the recurring diffs
+type Schedules struct {
+	LogProvider domain.LogFn
+	Scheduler   domain.Scheduler
+
+	// Authorizer decides the scope of the schedules each caller may list, as it does for
+	// diffs. If nil, every caller may list every schedule.
+	Authorizer domain.Authorizer
+}
+
+// List returns the state of every schedule whose scope the caller is permitted
+func (h *Schedules) List(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	permitted, err := handlers.Permitted(r, h.Authorizer)
+	if err != nil {
+		writeInputError(w, logger, err)
+		return
+	}
+	states, err := h.Scheduler.States(r.Context())
+	if err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencySchedules, Reason: err.Error()})
+		writeJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	list := scheduleList{Schedules: make([]scheduleItem, 0, len(states))}
+	for _, state := range states {
+		if h.Authorizer != nil && !permitted.Contains(state.Scope) {
+			continue
+		}
+		item := scheduleItem{
+			Name:      state.Name,
+			Spec:      state.Spec,
+			Template:  state.Template,
+			Tenant:    state.Scope.Tenant,
+			Accounts:  state.Scope.Accounts,
+			NextRun:   state.NextRun.Format(time.RFC3339Nano),
+			LastError: state.LastError,
+		}
+		if !state.LastRun.IsZero() {
+			item.LastRun = state.LastRun.Format(time.RFC3339Nano)
+		}
+		list.Schedules = append(list.Schedules, item)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(list)
+}
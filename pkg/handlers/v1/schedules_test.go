@@ -0,0 +1,89 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulesList(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	scheduler := NewMockScheduler(ctrl)
+	scheduler.EXPECT().States(gomock.Any()).Return([]domain.ScheduleState{
+		{
+			Name:     "daily",
+			Spec:     "0 2 * * *",
+			Template: "daily",
+			LastRun:  time.Date(2019, 1, 9, 2, 0, 0, 0, time.UTC),
+			NextRun:  time.Date(2019, 1, 10, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			Name:      "hourly",
+			Spec:      "@hourly",
+			Template:  "hourly",
+			NextRun:   time.Date(2019, 1, 9, 3, 0, 0, 0, time.UTC),
+			LastError: "unavailable",
+		},
+	}, nil)
+
+	h := &Schedules{LogProvider: logevent.FromContext, Scheduler: scheduler}
+	w := httptest.NewRecorder()
+	h.List(w, newHealthRequest("/schedules"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"schedules":[
+		{"name":"daily","spec":"0 2 * * *","template":"daily","lastRun":"2019-01-09T02:00:00Z","nextRun":"2019-01-10T02:00:00Z"},
+		{"name":"hourly","spec":"@hourly","template":"hourly","nextRun":"2019-01-09T03:00:00Z","lastError":"unavailable"}
+	]}`, w.Body.String())
+}
+
+func TestSchedulesListError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	scheduler := NewMockScheduler(ctrl)
+	scheduler.EXPECT().States(gomock.Any()).Return(nil, errors.New("denied"))
+
+	h := &Schedules{LogProvider: logevent.FromContext, Scheduler: scheduler}
+	w := httptest.NewRecorder()
+	h.List(w, newHealthRequest("/schedules"))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestSchedulesListScoped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	scheduler := NewMockScheduler(ctrl)
+	scheduler.EXPECT().States(gomock.Any()).Return([]domain.ScheduleState{
+		{Name: "all", Spec: "@daily", Template: "daily", NextRun: time.Date(2019, 1, 10, 0, 0, 0, 0, time.UTC)},
+		{Name: "acme", Spec: "@daily", Template: "daily", NextRun: time.Date(2019, 1, 10, 0, 0, 0, 0, time.UTC), Scope: domain.NewScope("acme", []string{"1"})},
+		{Name: "other", Spec: "@daily", Template: "daily", NextRun: time.Date(2019, 1, 10, 0, 0, 0, 0, time.UTC), Scope: domain.NewScope("other", nil)},
+	}, nil)
+
+	// only the schedules of the caller's tenant and accounts are listed
+	h := &Schedules{
+		LogProvider: logevent.FromContext,
+		Scheduler:   scheduler,
+		Authorizer:  staticAuthorizer{scope: domain.NewScope("acme", nil)},
+	}
+	w := httptest.NewRecorder()
+	h.List(w, newHealthRequest("/schedules"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"schedules":[
+		{"name":"acme","spec":"@daily","template":"daily","tenant":"acme","accounts":["1"],"nextRun":"2019-01-10T00:00:00Z"}
+	]}`, w.Body.String())
+
+	h.Authorizer = staticAuthorizer{err: errors.New("missing header")}
+	w = httptest.NewRecorder()
+	h.List(w, newHealthRequest("/schedules"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
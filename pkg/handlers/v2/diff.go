@@ -0,0 +1,454 @@
+package v2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/handlers"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/storage"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// formatDOT is the format of a diff which is not compressed
+const formatDOT = "dot"
+
+// contentTypes are the content types of the formats of a diff
+var contentTypes = map[string]string{
+	formatDOT:            "text/vnd.graphviz",
+	storage.EncodingGzip: "application/gzip",
+	storage.EncodingZstd: "application/zstd",
+}
+
+type window struct {
+	Start string `json:"start"`
+	Stop  string `json:"stop"`
+}
+
+type diffOptions struct {
+	Force bool   `json:"force"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
+// diffSpec is the body of a request to create a diff
+type diffSpec struct {
+	Previous window      `json:"previous"`
+	Next     window      `json:"next"`
+	Options  diffOptions `json:"options"`
+	Format   string      `json:"format,omitempty"`
+	Accounts []string    `json:"accounts,omitempty"`
+}
+
+// diffResource is the body of a response about a diff which is being created
+type diffResource struct {
+	ID       string   `json:"id"`
+	Previous window   `json:"previous"`
+	Next     window   `json:"next"`
+	Status   string   `json:"status"`
+	Accounts []string `json:"accounts,omitempty"`
+}
+
+// DiffHandler handles incoming HTTP requests for creating, retrieving and deleting network
+// graph diffs, which are identified by their ID
+type DiffHandler struct {
+	LogProvider domain.LogFn
+	Storage     domain.Storage
+	Queuer      domain.Queuer
+	Marker      domain.Marker
+
+	// Authorizer decides the scope of the diffs each caller may access. If nil, every caller
+	// may access all diffs which belong to no tenant.
+	Authorizer domain.Authorizer
+
+	// forced holds the keys of diffs for which a forced regeneration is being queued by
+	// this handler. It only coalesces the requests made to this instance.
+	forced sync.Map
+}
+
+// Post creates the diff described by the JSON body of the request. Diffs are created as by
+// the v1 API, and the Location header of the response is the path at which the diff is
+// fetched in the format of the request once it is complete. If the spec has accounts, the diff
+// only has the edges of those accounts.
+func (h *DiffHandler) Post(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	permitted, err := handlers.Permitted(r, h.Authorizer)
+	if err != nil {
+		writeForbidden(w, logger, err)
+		return
+	}
+	var spec diffSpec
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	scope, err := permitted.Narrow(spec.Accounts)
+	if err != nil {
+		writeForbidden(w, logger, err)
+		return
+	}
+	diff, err := newDiff(spec, scope)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		writeError(w, http.StatusBadRequest, CodeInvalidRange, err.Error())
+		return
+	}
+	if diff.TTL, err = parseTTL(spec.Options.TTL); err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if err = validateFormat(spec.Format); err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	w.Header().Set("Location", location(diff.ID, spec.Format))
+
+	exists, err := h.Storage.Exists(r.Context(), diff.Key())
+	switch err.(type) {
+	case nil:
+	case domain.ErrInProgress:
+		// A forced request for a diff which is already being created is folded in to
+		// that job rather than starting another.
+		if spec.Options.Force {
+			logger.Info(logs.Coalesced{Reason: err.Error()})
+			writeAccepted(w, diff)
+			return
+		}
+		logger.Info(logs.Conflict{Reason: err.Error()})
+		writeError(w, http.StatusConflict, CodeInProgress, fmt.Sprintf("diff %s is in progress", diff.ID))
+		return
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		writeDependencyFailure(w)
+		return
+	}
+	if spec.Options.Force {
+		h.regenerate(w, r, diff)
+		return
+	}
+	if exists {
+		msg := fmt.Sprintf("diff %s already exists", diff.ID)
+		logger.Info(logs.Conflict{Reason: msg})
+		writeError(w, http.StatusConflict, CodeAlreadyExists, msg)
+		return
+	}
+
+	if err = h.Queuer.Queue(r.Context(), diff); err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyQueuer, Reason: err.Error()})
+		writeDependencyFailure(w)
+		return
+	}
+	// If mark fails, we don't fail the request since diff creation should be idempotent
+	if err = h.Marker.Mark(r.Context(), diff.Key()); err != nil {
+		logger.Info(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+	}
+	writeAccepted(w, diff)
+}
+
+// regenerate queues a diff job regardless of whether the diff already exists, as the v1
+// handler does for forced requests.
+func (h *DiffHandler) regenerate(w http.ResponseWriter, r *http.Request, diff domain.Diff) {
+	logger := h.LogProvider(r.Context())
+	if _, loaded := h.forced.LoadOrStore(diff.Key(), struct{}{}); loaded {
+		logger.Info(logs.Coalesced{Reason: fmt.Sprintf("diff %s is already being regenerated", diff.ID)})
+		writeAccepted(w, diff)
+		return
+	}
+	defer h.forced.Delete(diff.Key())
+
+	err := h.Marker.Mark(r.Context(), diff.Key())
+	switch err.(type) {
+	case nil:
+	case domain.ErrInProgress:
+		// a Marker which marks conditionally has found the diff marked by another request
+		logger.Info(logs.Coalesced{Reason: err.Error()})
+		writeAccepted(w, diff)
+		return
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+		writeDependencyFailure(w)
+		return
+	}
+	if err := h.Queuer.Queue(r.Context(), diff); err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyQueuer, Reason: err.Error()})
+		if err := h.Marker.Unmark(r.Context(), diff.Key()); err != nil {
+			logger.Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+		}
+		writeDependencyFailure(w)
+		return
+	}
+	writeAccepted(w, diff)
+}
+
+// Get retrieves the diff named by the id path parameter, in the format of the optional format
+// query parameter. Diffs are DOT graphs by default. If the Storage implements
+// domain.ObjectStorage, the diff is sent with cache headers, conditional requests for a diff
+// the client already has are answered with 304 Not Modified, and diffs stored in the requested
+// format can be fetched in ranges.
+func (h *DiffHandler) Get(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	id, err := extractID(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	key, ok := h.permittedKey(w, r, id)
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = formatDOT
+	}
+	if err = validateFormat(format); err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	if objects, ok := h.Storage.(domain.ObjectStorage); ok {
+		info, err := objects.Stat(r.Context(), key)
+		switch err.(type) {
+		case nil:
+			if info.Encoding == formatEncoding(format) {
+				w.Header().Set("Content-Type", contentTypes[format])
+				if err := handlers.ServeObject(w, r, objects, key, info, handlers.CacheControl(h.Authorizer)); err != nil {
+					logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+				}
+				return
+			}
+			etag := handlers.ETag(info, formatEncoding(format))
+			handlers.SetCacheHeaders(w.Header(), etag, info.Created, handlers.CacheControl(h.Authorizer))
+			if handlers.NotModified(r, etag, info.Created) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case domain.ErrUnsupported:
+		default:
+			h.writeGetError(w, r, id, err)
+			return
+		}
+	}
+
+	var body io.ReadCloser
+	var encoding string
+	if encoded, ok := h.Storage.(domain.EncodedStorage); ok && format != formatDOT {
+		body, encoding, err = encoded.GetEncoded(r.Context(), key, []string{format})
+	} else {
+		body, err = h.Storage.Get(r.Context(), key)
+	}
+	if err != nil {
+		h.writeGetError(w, r, id, err)
+		return
+	}
+	defer body.Close()
+
+	// diffs which are not stored in the requested format are compressed as they are sent
+	var content io.Reader = body
+	if format != formatDOT && encoding != format {
+		decoded, err := storage.Decode(body, encoding)
+		if err != nil {
+			logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+			writeDependencyFailure(w)
+			return
+		}
+		encoded, err := storage.Encode(decoded, format)
+		if err != nil {
+			logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+			writeDependencyFailure(w)
+			return
+		}
+		defer encoded.Close()
+		content = encoded
+	}
+	w.Header().Set("Content-Type", contentTypes[format])
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, content)
+}
+
+// writeGetError writes the response to a GET of a diff which could not be fetched
+func (h *DiffHandler) writeGetError(w http.ResponseWriter, r *http.Request, id string, err error) {
+	logger := h.LogProvider(r.Context())
+	switch err.(type) {
+	case domain.ErrInProgress:
+		writeError(w, http.StatusConflict, CodeInProgress, fmt.Sprintf("diff %s is in progress", id))
+	case domain.ErrNotFound:
+		logger.Info(logs.NotFound{Reason: err.Error()})
+		writeError(w, http.StatusNotFound, CodeNotFound, fmt.Sprintf("diff %s was not found", id))
+	case domain.ErrExpired:
+		logger.Info(logs.Expired{Reason: err.Error()})
+		writeError(w, http.StatusGone, CodeExpired, fmt.Sprintf("diff %s has expired", id))
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		writeDependencyFailure(w)
+	}
+}
+
+// formatEncoding returns the content encoding of a diff stored in the given format
+func formatEncoding(format string) string {
+	if format == formatDOT {
+		return ""
+	}
+	return format
+}
+
+// Delete removes the diff named by the id path parameter, along with its metadata and any in
+// progress marker, so that it can be created again
+func (h *DiffHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	logger := h.LogProvider(r.Context())
+	id, err := extractID(r)
+	if err != nil {
+		logger.Info(logs.InvalidInput{Reason: err.Error()})
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	key, ok := h.permittedKey(w, r, id)
+	if !ok {
+		return
+	}
+	if err = h.Storage.Delete(r.Context(), key); err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		writeDependencyFailure(w)
+		return
+	}
+	// The marker is removed last so that the diff is never reported as complete while its
+	// content is being deleted.
+	if err = h.Marker.Unmark(r.Context(), key); err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+		writeDependencyFailure(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAccepted writes the response of a diff which is being created
+func writeAccepted(w http.ResponseWriter, diff domain.Diff) {
+	resource := diffResource{
+		ID: diff.ID,
+		Previous: window{
+			Start: diff.PreviousStart.Format(time.RFC3339Nano),
+			Stop:  diff.PreviousStop.Format(time.RFC3339Nano),
+		},
+		Next: window{
+			Start: diff.NextStart.Format(time.RFC3339Nano),
+			Stop:  diff.NextStop.Format(time.RFC3339Nano),
+		},
+		Status:   domain.DiffStatusInProgress,
+		Accounts: diff.Scope.Accounts,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(resource)
+}
+
+// permittedKey returns the storage key of the diff with the given ID in the scope the caller
+// of the request is permitted. If the caller may not access the diff, or it could not be
+// checked, the response is written and false is returned.
+func (h *DiffHandler) permittedKey(w http.ResponseWriter, r *http.Request, id string) (string, bool) {
+	logger := h.LogProvider(r.Context())
+	permitted, err := handlers.Permitted(r, h.Authorizer)
+	if err == nil {
+		err = handlers.CheckScope(r.Context(), h.Storage, permitted, id)
+	}
+	switch err.(type) {
+	case nil:
+		return permitted.Key(id), true
+	case domain.ErrForbidden:
+		writeForbidden(w, logger, err)
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		writeDependencyFailure(w)
+	}
+	return "", false
+}
+
+// writeForbidden writes the response of a request for diffs the caller may not access
+func writeForbidden(w http.ResponseWriter, logger domain.Logger, err error) {
+	logger.Info(logs.Forbidden{Reason: err.Error()})
+	writeError(w, http.StatusForbidden, CodeForbidden, err.Error())
+}
+
+// location returns the path at which a diff is fetched in the given format
+func location(id string, format string) string {
+	if format == "" || format == formatDOT {
+		return "/v2/diffs/" + id
+	}
+	return "/v2/diffs/" + id + "?format=" + format
+}
+
+// extractID returns the id path parameter, which must be the ID of a diff
+func extractID(r *http.Request) (string, error) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		return "", fmt.Errorf("invalid id %s", id)
+	}
+	return id, nil
+}
+
+// newDiff returns the diff of a spec in the scope, whose windows must be RFC3339Nano with each
+// start before its stop, and the previous window before the next. The ID of the diff is
+// computed as by the v1 API, so both APIs name the same diff alike.
+func newDiff(spec diffSpec, scope domain.Scope) (domain.Diff, error) {
+	pStart, pStop, err := parseWindow("previous", spec.Previous)
+	if err != nil {
+		return domain.Diff{}, err
+	}
+	nStart, nStop, err := parseWindow("next", spec.Next)
+	if err != nil {
+		return domain.Diff{}, err
+	}
+	if pStart.After(nStart) || pStop.After(nStop) {
+		return domain.Diff{}, errors.New("the previous window should be before the next window")
+	}
+	return domain.NewScopedDiff(pStart, pStop, nStart, nStop, scope), nil
+}
+
+func parseWindow(name string, w window) (time.Time, time.Time, error) {
+	start, err := time.Parse(time.RFC3339Nano, w.Start)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid %s.start: %s", name, err.Error())
+	}
+	stop, err := time.Parse(time.RFC3339Nano, w.Stop)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid %s.stop: %s", name, err.Error())
+	}
+	if start.After(stop) {
+		return time.Time{}, time.Time{}, fmt.Errorf("%s.start should be before %s.stop", name, name)
+	}
+	return start, stop, nil
+}
+
+// parseTTL parses an optional ttl, which is a positive duration such as 72h.
+func parseTTL(ttl string) (time.Duration, error) {
+	if ttl == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, errors.New("ttl must be positive")
+	}
+	return d, nil
+}
+
+// validateFormat returns an error if the format is neither empty nor a format of diffs
+func validateFormat(format string) error {
+	if _, ok := contentTypes[format]; format != "" && !ok {
+		return fmt.Errorf("unsupported format %s", format)
+	}
+	return nil
+}
@@ -0,0 +1,462 @@
+package v2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/go-chi/chi"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testStart = time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	testDiff  = domain.NewDiff(testStart, testStart.Add(time.Hour), testStart.Add(time.Hour), testStart.Add(2*time.Hour))
+	testSpec  = `{"previous": {"start": "2019-01-01T00:00:00Z", "stop": "2019-01-01T01:00:00Z"},
+		"next": {"start": "2019-01-01T01:00:00Z", "stop": "2019-01-01T02:00:00Z"}`
+)
+
+func newRouter(h *DiffHandler) http.Handler {
+	router := chi.NewRouter()
+	router.Post("/v2/diffs", h.Post)
+	router.Get("/v2/diffs/{id}", h.Get)
+	router.Delete("/v2/diffs/{id}", h.Delete)
+	return router
+}
+
+func newRequest(method string, path string, body string) *http.Request {
+	r, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	return r.WithContext(logevent.NewContext(context.Background(), logevent.New(logevent.Config{Output: ioutil.Discard})))
+}
+
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) errorResponse {
+	var res errorResponse
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	return res
+}
+
+func TestPostInvalid(t *testing.T) {
+	tc := []struct {
+		Name string
+		Body string
+		Code string
+	}{
+		{"malformed", "{", CodeInvalidRequest},
+		{"unknown field", testSpec + `, "force": true}`, CodeInvalidRequest},
+		{"invalid ttl", testSpec + `, "options": {"ttl": "-1h"}}`, CodeInvalidRequest},
+		{"invalid format", testSpec + `, "format": "bzip2"}`, CodeInvalidRequest},
+		{"missing window", `{"previous": {"start": "2019-01-01T00:00:00Z", "stop": "2019-01-01T01:00:00Z"}}`, CodeInvalidRange},
+		{"reversed window", `{"previous": {"start": "2019-01-01T01:00:00Z", "stop": "2019-01-01T00:00:00Z"},
+			"next": {"start": "2019-01-01T01:00:00Z", "stop": "2019-01-01T02:00:00Z"}}`, CodeInvalidRange},
+		{"next before previous", `{"previous": {"start": "2019-01-01T01:00:00Z", "stop": "2019-01-01T02:00:00Z"},
+			"next": {"start": "2019-01-01T00:00:00Z", "stop": "2019-01-01T01:00:00Z"}}`, CodeInvalidRange},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			newRouter(&DiffHandler{LogProvider: logevent.FromContext}).ServeHTTP(w, newRequest(http.MethodPost, "/v2/diffs", tt.Body))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, tt.Code, decodeError(t, w).Code)
+		})
+	}
+}
+
+func TestPostAccepted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), testDiff.ID).Return(false, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, diff domain.Diff) error {
+		assert.Equal(t, testDiff.ID, diff.ID)
+		assert.Equal(t, 72*time.Hour, diff.TTL)
+		return nil
+	})
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), testDiff.ID).Return(errors.New("oops"))
+
+	h := &DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Queuer:      queuerMock,
+		Marker:      markerMock,
+	}
+	w := httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, newRequest(http.MethodPost, "/v2/diffs", testSpec+`, "options": {"ttl": "72h"}, "format": "gzip"}`))
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/v2/diffs/"+testDiff.ID+"?format=gzip", w.Header().Get("Location"))
+	var res diffResource
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, testDiff.ID, res.ID)
+	assert.Equal(t, domain.DiffStatusInProgress, res.Status)
+	assert.Equal(t, window{Start: "2019-01-01T01:00:00Z", Stop: "2019-01-01T02:00:00Z"}, res.Next)
+}
+
+func TestPostConflicts(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Exists bool
+		Error  error
+		Code   string
+	}{
+		{"exists", true, nil, CodeAlreadyExists},
+		{"in progress", false, domain.ErrInProgress{Key: testDiff.ID}, CodeInProgress},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			storageMock := NewMockStorage(ctrl)
+			storageMock.EXPECT().Exists(gomock.Any(), testDiff.ID).Return(tt.Exists, tt.Error)
+			h := &DiffHandler{LogProvider: logevent.FromContext, Storage: storageMock}
+			w := httptest.NewRecorder()
+			newRouter(h).ServeHTTP(w, newRequest(http.MethodPost, "/v2/diffs", testSpec+"}"))
+
+			assert.Equal(t, http.StatusConflict, w.Code)
+			assert.Equal(t, "/v2/diffs/"+testDiff.ID, w.Header().Get("Location"))
+			assert.Equal(t, tt.Code, decodeError(t, w).Code)
+		})
+	}
+}
+
+func TestPostForce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), testDiff.ID).Return(true, nil)
+	markerMock := NewMockMarker(ctrl)
+	queuerMock := NewMockQueuer(ctrl)
+	gomock.InOrder(
+		markerMock.EXPECT().Mark(gomock.Any(), testDiff.ID).Return(nil),
+		queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any()).Return(nil),
+	)
+
+	h := &DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Queuer:      queuerMock,
+		Marker:      markerMock,
+	}
+	w := httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, newRequest(http.MethodPost, "/v2/diffs", testSpec+`, "options": {"force": true}}`))
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestPostDependencyFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), testDiff.ID).Return(false, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), gomock.Any()).Return(errors.New("oops"))
+
+	h := &DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Queuer:      queuerMock,
+	}
+	w := httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, newRequest(http.MethodPost, "/v2/diffs", testSpec+"}"))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, CodeDependencyFailure, decodeError(t, w).Code)
+}
+
+func TestGetInvalid(t *testing.T) {
+	tc := []struct {
+		Name string
+		Path string
+	}{
+		{"invalid id", "/v2/diffs/digest"},
+		{"invalid format", "/v2/diffs/" + testDiff.ID + "?format=bzip2"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			newRouter(&DiffHandler{LogProvider: logevent.FromContext}).ServeHTTP(w, newRequest(http.MethodGet, tt.Path, ""))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, CodeInvalidRequest, decodeError(t, w).Code)
+		})
+	}
+}
+
+func TestGetStorageErrors(t *testing.T) {
+	tc := []struct {
+		Name       string
+		Error      error
+		StatusCode int
+		Code       string
+	}{
+		{"in progress", domain.ErrInProgress{}, http.StatusConflict, CodeInProgress},
+		{"not found", domain.ErrNotFound{}, http.StatusNotFound, CodeNotFound},
+		{"expired", domain.ErrExpired{}, http.StatusGone, CodeExpired},
+		{"unknown", errors.New("oops"), http.StatusInternalServerError, CodeDependencyFailure},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			storageMock := NewMockStorage(ctrl)
+			storageMock.EXPECT().Get(gomock.Any(), testDiff.ID).Return(nil, tt.Error)
+			h := &DiffHandler{LogProvider: logevent.FromContext, Storage: storageMock}
+			w := httptest.NewRecorder()
+			newRouter(h).ServeHTTP(w, newRequest(http.MethodGet, "/v2/diffs/"+testDiff.ID, ""))
+
+			assert.Equal(t, tt.StatusCode, w.Code)
+			assert.Equal(t, tt.Code, decodeError(t, w).Code)
+		})
+	}
+}
+
+func TestGetCompressed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	data := "digraph {}"
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Get(gomock.Any(), testDiff.ID).DoAndReturn(func(context.Context, string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewBufferString(data)), nil
+	}).Times(2)
+	h := &DiffHandler{LogProvider: logevent.FromContext, Storage: storageMock}
+
+	w := httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, newRequest(http.MethodGet, "/v2/diffs/"+testDiff.ID, ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/vnd.graphviz", w.Header().Get("Content-Type"))
+	assert.Equal(t, data, w.Body.String())
+
+	w = httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, newRequest(http.MethodGet, "/v2/diffs/"+testDiff.ID+"?format=gzip", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/gzip", w.Header().Get("Content-Type"))
+	gz, err := gzip.NewReader(w.Body)
+	require.Nil(t, err)
+	result, err := ioutil.ReadAll(gz)
+	require.Nil(t, err)
+	assert.Equal(t, data, string(result))
+}
+
+type encodedStorage struct {
+	domain.Storage
+	accept   []string
+	body     string
+	encoding string
+}
+
+func (s *encodedStorage) GetEncoded(ctx context.Context, key string, accept []string) (io.ReadCloser, string, error) {
+	s.accept = accept
+	return ioutil.NopCloser(bytes.NewReader([]byte(s.body))), s.encoding, nil
+}
+
+func TestGetEncoded(t *testing.T) {
+	storage := &encodedStorage{body: "compressed diff", encoding: "zstd"}
+	h := &DiffHandler{LogProvider: logevent.FromContext, Storage: storage}
+	w := httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, newRequest(http.MethodGet, "/v2/diffs/"+testDiff.ID+"?format=zstd", ""))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"zstd"}, storage.accept)
+	assert.Equal(t, "application/zstd", w.Header().Get("Content-Type"))
+	assert.Equal(t, "compressed diff", w.Body.String())
+}
+
+// objectStorage adds domain.ObjectStorage to an encodedStorage.
+type objectStorage struct {
+	encodedStorage
+	info    domain.ObjectInfo
+	statErr error
+}
+
+func (s *objectStorage) Stat(ctx context.Context, key string) (domain.ObjectInfo, error) {
+	return s.info, s.statErr
+}
+
+func (s *objectStorage) GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader([]byte(s.body[offset:]))), nil
+}
+
+func TestGetObject(t *testing.T) {
+	created := time.Now()
+	storage := &objectStorage{
+		encodedStorage: encodedStorage{body: "compressed diff", encoding: "zstd"},
+		info:           domain.ObjectInfo{Size: 15, Checksum: "abc", Created: created, Encoding: "zstd"},
+	}
+	h := &DiffHandler{LogProvider: logevent.FromContext, Storage: storage}
+
+	// diffs requested in the format they are stored in can be fetched in ranges
+	r := newRequest(http.MethodGet, "/v2/diffs/"+testDiff.ID+"?format=zstd", "")
+	r.Header.Set("Range", "bytes=0-9")
+	w := httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "application/zstd", w.Header().Get("Content-Type"))
+	assert.Equal(t, `"abc"`, w.Header().Get("ETag"))
+	assert.Equal(t, "compressed", w.Body.String())
+
+	// diffs in other formats are sent in full, and tagged with their format
+	r = newRequest(http.MethodGet, "/v2/diffs/"+testDiff.ID+"?format=gzip", "")
+	r.Header.Set("If-None-Match", `"abc"`)
+	w = httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"abc-gzip"`, w.Header().Get("ETag"))
+	assert.Equal(t, created.UTC().Format(http.TimeFormat), w.Header().Get("Last-Modified"))
+
+	r = newRequest(http.MethodGet, "/v2/diffs/"+testDiff.ID+"?format=gzip", "")
+	r.Header.Set("If-Modified-Since", created.Add(time.Second).UTC().Format(http.TimeFormat))
+	w = httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+}
+
+func TestGetObjectStatErrors(t *testing.T) {
+	storage := &objectStorage{statErr: domain.ErrExpired{}}
+	h := &DiffHandler{LogProvider: logevent.FromContext, Storage: storage}
+	w := httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, newRequest(http.MethodGet, "/v2/diffs/"+testDiff.ID, ""))
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Equal(t, CodeExpired, decodeError(t, w).Code)
+}
+
+func TestDelete(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storageMock := NewMockStorage(ctrl)
+	markerMock := NewMockMarker(ctrl)
+	gomock.InOrder(
+		storageMock.EXPECT().Delete(gomock.Any(), testDiff.ID).Return(nil),
+		markerMock.EXPECT().Unmark(gomock.Any(), testDiff.ID).Return(nil),
+		storageMock.EXPECT().Delete(gomock.Any(), testDiff.ID).Return(errors.New("oops")),
+	)
+	h := &DiffHandler{LogProvider: logevent.FromContext, Storage: storageMock, Marker: markerMock}
+
+	w := httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, newRequest(http.MethodDelete, "/v2/diffs/"+testDiff.ID, ""))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, newRequest(http.MethodDelete, "/v2/diffs/"+testDiff.ID, ""))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, CodeDependencyFailure, decodeError(t, w).Code)
+
+	w = httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, newRequest(http.MethodDelete, "/v2/diffs/digest", ""))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// staticAuthorizer permits every caller the same scope, or none if it has an error
+type staticAuthorizer struct {
+	scope domain.Scope
+	err   error
+}
+
+func (a staticAuthorizer) Authorize(r *http.Request) (domain.Scope, error) {
+	return a.scope, a.err
+}
+
+func TestPostScoped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	scope := domain.NewScope("acme", []string{"2"})
+	diff := domain.NewScopedDiff(testDiff.PreviousStart, testDiff.PreviousStop, testDiff.NextStart, testDiff.NextStop, scope)
+	require.NotEqual(t, testDiff.ID, diff.ID)
+
+	storageMock := NewMockStorage(ctrl)
+	storageMock.EXPECT().Exists(gomock.Any(), "tenants/acme/"+diff.ID).Return(false, nil)
+	queuerMock := NewMockQueuer(ctrl)
+	queuerMock.EXPECT().Queue(gomock.Any(), diff).Return(nil)
+	markerMock := NewMockMarker(ctrl)
+	markerMock.EXPECT().Mark(gomock.Any(), "tenants/acme/"+diff.ID).Return(nil)
+
+	h := &DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Queuer:      queuerMock,
+		Marker:      markerMock,
+		Authorizer:  staticAuthorizer{scope: domain.NewScope("acme", []string{"1", "2"})},
+	}
+	w := httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, newRequest(http.MethodPost, "/v2/diffs", testSpec+`, "accounts": ["2"]}`))
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/v2/diffs/"+diff.ID, w.Header().Get("Location"))
+	var res diffResource
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, diff.ID, res.ID)
+	assert.Equal(t, []string{"2"}, res.Accounts)
+}
+
+func TestPostForbidden(t *testing.T) {
+	tc := []struct {
+		Name       string
+		Authorizer staticAuthorizer
+	}{
+		{"no identity", staticAuthorizer{err: domain.ErrForbidden{Reason: "missing header"}}},
+		{"other accounts", staticAuthorizer{scope: domain.NewScope("acme", []string{"1"})}},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := &DiffHandler{LogProvider: logevent.FromContext, Authorizer: tt.Authorizer}
+			w := httptest.NewRecorder()
+			newRouter(h).ServeHTTP(w, newRequest(http.MethodPost, "/v2/diffs", testSpec+`, "accounts": ["2"]}`))
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			assert.Equal(t, CodeForbidden, decodeError(t, w).Code)
+		})
+	}
+}
+
+func TestGetScoped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	key := "tenants/acme/" + testDiff.ID
+	storageMock := NewMockStorage(ctrl)
+	gomock.InOrder(
+		storageMock.EXPECT().Metadata(gomock.Any(), key).Return(domain.DiffMetadata{Diff: domain.Diff{Scope: domain.NewScope("acme", []string{"1"})}}, nil),
+		storageMock.EXPECT().Get(gomock.Any(), key).Return(ioutil.NopCloser(bytes.NewReader([]byte("digraph {}"))), nil),
+		storageMock.EXPECT().Metadata(gomock.Any(), key).Return(domain.DiffMetadata{Diff: domain.Diff{Scope: domain.NewScope("acme", []string{"3"})}}, nil),
+		storageMock.EXPECT().Metadata(gomock.Any(), key).Return(domain.DiffMetadata{}, errors.New("oops")),
+	)
+	h := &DiffHandler{
+		LogProvider: logevent.FromContext,
+		Storage:     storageMock,
+		Authorizer:  staticAuthorizer{scope: domain.NewScope("acme", []string{"1", "2"})},
+	}
+
+	w := httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, newRequest(http.MethodGet, "/v2/diffs/"+testDiff.ID, ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "digraph {}", w.Body.String())
+
+	w = httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, newRequest(http.MethodGet, "/v2/diffs/"+testDiff.ID, ""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, CodeForbidden, decodeError(t, w).Code)
+
+	w = httptest.NewRecorder()
+	newRouter(h).ServeHTTP(w, newRequest(http.MethodDelete, "/v2/diffs/"+testDiff.ID, ""))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, CodeDependencyFailure, decodeError(t, w).Code)
+}
@@ -0,0 +1,4 @@
+// Package v2 contains all handlers used to service the version 2.X.X API, which describes
+// diffs with JSON bodies and reports errors with machine-readable codes.
+//
+package v2
@@ -0,0 +1,52 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// The codes of the errors of the API. Clients should act on the code of an error rather than
+// its message, which is meant for people.
+const (
+	// CodeInvalidRequest is returned when the body or parameters of a request are malformed.
+	CodeInvalidRequest = "INVALID_REQUEST"
+
+	// CodeInvalidRange is returned when the windows of a diff are missing or out of order.
+	CodeInvalidRange = "INVALID_RANGE"
+
+	// CodeAlreadyExists is returned when a diff is created which already exists.
+	CodeAlreadyExists = "ALREADY_EXISTS"
+
+	// CodeInProgress is returned when a diff is still being created.
+	CodeInProgress = "IN_PROGRESS"
+
+	// CodeNotFound is returned when a diff does not exist.
+	CodeNotFound = "NOT_FOUND"
+
+	// CodeExpired is returned when a diff has been removed by the retention policy.
+	CodeExpired = "EXPIRED"
+
+	// CodeForbidden is returned when the caller may not access the diffs of a request.
+	CodeForbidden = "FORBIDDEN"
+
+	// CodeDependencyFailure is returned when a dependency of the service failed.
+	CodeDependencyFailure = "DEPENDENCY_FAILURE"
+)
+
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError writes an error response with the given status, code and message
+func writeError(w http.ResponseWriter, statusCode int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(errorResponse{Code: code, Message: message})
+}
+
+// writeDependencyFailure writes the response of a request which failed because of a
+// dependency. The reason is logged rather than returned to the client.
+func writeDependencyFailure(w http.ResponseWriter) {
+	writeError(w, http.StatusInternalServerError, CodeDependencyFailure, "Internal Server Error")
+}
@@ -0,0 +1,4 @@
+// Package health contains the Checker implementations which verify that the dependencies
+// of the service can be reached.
+//
+package health
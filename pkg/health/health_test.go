@@ -0,0 +1,51 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3Bucket(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	gomock.InOrder(
+		mockS3.EXPECT().HeadBucketWithContext(gomock.Any(), &s3.HeadBucketInput{
+			Bucket: aws.String("bucket"),
+		}).Return(&s3.HeadBucketOutput{}, nil),
+		mockS3.EXPECT().HeadBucketWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("expired")),
+	)
+
+	c := &S3Bucket{Bucket: "bucket", Client: mockS3}
+	assert.Nil(t, c.Check(context.Background()))
+	assert.NotNil(t, c.Check(context.Background()))
+}
+
+func TestHTTPEndpoint(t *testing.T) {
+	status := http.StatusMethodNotAllowed
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+	endpoint, _ := url.Parse(ts.URL)
+
+	c := &HTTPEndpoint{Endpoint: endpoint, Client: ts.Client()}
+	assert.Nil(t, c.Check(context.Background()))
+
+	status = http.StatusServiceUnavailable
+	assert.NotNil(t, c.Check(context.Background()))
+
+	ts.Close()
+	assert.NotNil(t, c.Check(context.Background()))
+}
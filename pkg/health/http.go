@@ -0,0 +1,33 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// HTTPEndpoint is a Checker which verifies that a service responds at an endpoint. Any
+// response other than a server error is accepted, as the endpoint may not support the
+// request.
+type HTTPEndpoint struct {
+	Endpoint *url.URL
+	Client   *http.Client
+}
+
+// Check sends a HEAD request to the endpoint.
+func (c *HTTPEndpoint) Check(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodHead, c.Endpoint.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected response from %s: %d", c.Endpoint.Host, res.StatusCode)
+	}
+	return nil
+}
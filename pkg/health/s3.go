@@ -0,0 +1,24 @@
+package health
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// S3Bucket is a Checker which verifies that a bucket exists and that the credentials of the
+// client grant access to it.
+type S3Bucket struct {
+	Bucket string
+	Client s3iface.S3API
+}
+
+// Check sends a HEAD request for the bucket.
+func (c *S3Bucket) Check(ctx context.Context) error {
+	_, err := c.Client.HeadBucketWithContext(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(c.Bucket),
+	})
+	return err
+}
@@ -0,0 +1,12 @@
+package logs
+
+// Backfilled is logged when a backfill has handled every one of its diffs
+type Backfilled struct {
+	ID         string `logevent:"id"`
+	Total      int    `logevent:"total"`
+	Queued     int    `logevent:"queued"`
+	Existing   int    `logevent:"existing"`
+	InProgress int    `logevent:"in_progress"`
+	Failed     int    `logevent:"failed"`
+	Message    string `logevent:"message,default=backfilled"`
+}
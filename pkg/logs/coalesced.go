@@ -0,0 +1,8 @@
+package logs
+
+// Coalesced is logged when a request is folded in to an equivalent request which is already
+// being processed
+type Coalesced struct {
+	Reason  string `logevent:"reason"`
+	Message string `logevent:"message,default=coalesced"`
+}
@@ -12,6 +12,15 @@ const (
 
 	// DependencyDiffer identifies a differ failure
 	DependencyDiffer = "differ"
+
+	// DependencyGrapher identifies a grapher failure
+	DependencyGrapher = "grapher"
+
+	// DependencySchedules identifies a failure of the store of schedule states
+	DependencySchedules = "schedules"
+
+	// DependencyBackfills identifies a failure of the store of backfill progress
+	DependencyBackfills = "backfills"
 )
 
 // DependencyFailure is logged when a downstream dependency fails
@@ -0,0 +1,8 @@
+package logs
+
+// Deprecated is logged when the service is configured with a deprecated setting
+type Deprecated struct {
+	Setting string `logevent:"setting"`
+	Reason  string `logevent:"reason"`
+	Message string `logevent:"message,default=deprecated"`
+}
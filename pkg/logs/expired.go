@@ -0,0 +1,7 @@
+package logs
+
+// Expired is logged when the requested resource has been removed by the retention policy
+type Expired struct {
+	Reason  string `logevent:"reason"`
+	Message string `logevent:"message,default=expired"`
+}
@@ -0,0 +1,7 @@
+package logs
+
+// Forbidden is logged when the caller of the request may not access the requested diffs
+type Forbidden struct {
+	Reason  string `logevent:"reason"`
+	Message string `logevent:"message,default=forbidden"`
+}
@@ -0,0 +1,9 @@
+package logs
+
+// InvalidResponse is logged when a response does not match the API document
+type InvalidResponse struct {
+	Route   string `logevent:"route"`
+	Status  int    `logevent:"status"`
+	Reason  string `logevent:"reason"`
+	Message string `logevent:"message,default=invalid-response"`
+}
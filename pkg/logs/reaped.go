@@ -0,0 +1,10 @@
+package logs
+
+// Reaped is logged when the expired progress marker of an abandoned diff is removed
+type Reaped struct {
+	Key      string `logevent:"key"`
+	Expired  string `logevent:"expired"`
+	Requeued bool   `logevent:"requeued"`
+	Requeues int    `logevent:"requeues"`
+	Message  string `logevent:"message,default=reaped"`
+}
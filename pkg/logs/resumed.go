@@ -0,0 +1,7 @@
+package logs
+
+// Resumed is logged when a backfill which was interrupted is started again
+type Resumed struct {
+	ID      string `logevent:"id"`
+	Message string `logevent:"message,default=resumed"`
+}
@@ -0,0 +1,9 @@
+package logs
+
+// Scheduled is logged when the diff of a scheduled run is queued
+type Scheduled struct {
+	Schedule string `logevent:"schedule"`
+	ID       string `logevent:"id"`
+	Run      string `logevent:"run"`
+	Message  string `logevent:"message,default=scheduled"`
+}
@@ -0,0 +1,221 @@
+package marker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/google/uuid"
+)
+
+// S3 reports these error codes when the condition of a conditional write does not hold. The
+// SDK provides no constants for them.
+const (
+	errCodePreconditionFailed         = "PreconditionFailed"
+	errCodeConditionalRequestConflict = "ConditionalRequestConflict"
+)
+
+// Lease is the content of a progress marker written by LeaseMarker.
+type Lease struct {
+	// Owner is the ID of the lease, or empty if the diff is marked but not yet leased.
+	Owner string `json:"owner,omitempty"`
+
+	// Expires is the time after which the mark or lease is no longer valid.
+	Expires time.Time `json:"expires"`
+
+	// Diff is the diff being created, if it is known. It is recorded when the lease is
+	// acquired, and kept when the lease is renewed or reaped.
+	Diff *domain.Diff `json:"diff,omitempty"`
+
+	// Requeued is the number of times the diff was queued again by the Reaper after its lease
+	// expired. It is kept when the diff is leased again.
+	Requeued int `json:"requeued,omitempty"`
+}
+
+// LeaseMarker is an implementation of domain.LeaseMarker which stores leases as progress
+// markers in S3. Markers are only written with conditional requests, so that a marker is
+// replaced only by the caller which read it.
+type LeaseMarker struct {
+	Bucket string
+	Client s3iface.S3API
+
+	// TTL is how long a mark or lease is valid for without being renewed.
+	TTL time.Duration
+
+	now func() time.Time
+}
+
+// Mark flags the diff identified by key as being "in progress". If the diff is already marked
+// or leased and the mark or lease has not expired, an error of type domain.ErrInProgress is
+// returned.
+func (m *LeaseMarker) Mark(ctx context.Context, key string) error {
+	return m.acquire(ctx, key, Lease{})
+}
+
+// Unmark flags the diff identified by key as not being "in progress", regardless of any lease.
+func (m *LeaseMarker) Unmark(ctx context.Context, key string) error {
+	return m.remove(ctx, key)
+}
+
+// Acquire leases the diff to the caller. A diff which is marked, but not yet leased, can be
+// acquired. If the diff is leased to another worker and the lease has not expired, an error of
+// type domain.ErrInProgress is returned.
+func (m *LeaseMarker) Acquire(ctx context.Context, d domain.Diff) (string, error) {
+	owner := uuid.New().String()
+	return owner, m.acquire(ctx, d.Key(), Lease{Owner: owner, Diff: &d})
+}
+
+// Renew extends the lease by the TTL. If the lease has been acquired by another worker, an
+// error of type domain.ErrLeaseLost is returned.
+func (m *LeaseMarker) Renew(ctx context.Context, key string, lease string) error {
+	current, etag, err := m.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Owner != lease {
+		return domain.ErrLeaseLost{Key: key}
+	}
+	_, err = m.put(ctx, key, *current, ifMatch(etag))
+	if isConditionFailed(err) {
+		return domain.ErrLeaseLost{Key: key}
+	}
+	return err
+}
+
+// Release ends the lease and flags the diff as not being "in progress". If the lease has been
+// acquired by another worker, the diff is left as-is.
+func (m *LeaseMarker) Release(ctx context.Context, key string, lease string) error {
+	current, etag, err := m.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Owner != lease {
+		return nil
+	}
+	// the condition fails if the lease expired and was acquired by another worker since it
+	// was read
+	err = m.remove(ctx, key, ifMatch(etag))
+	if isConditionFailed(err) {
+		return nil
+	}
+	return err
+}
+
+// acquire writes the lease if there is no valid marker for the diff. A marker without an
+// owner can be taken over by a lease with an owner.
+func (m *LeaseMarker) acquire(ctx context.Context, key string, lease Lease) error {
+	current, etag, err := m.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	condition := ifNoneMatch
+	if current != nil {
+		pending := current.Owner == "" && lease.Owner != ""
+		if m.timeNow().Before(current.Expires) && !pending {
+			return domain.ErrInProgress{Key: key}
+		}
+		if lease.Owner != "" {
+			lease.Requeued = current.Requeued
+		}
+		condition = ifMatch(etag)
+	}
+	_, err = m.put(ctx, key, lease, condition)
+	if isConditionFailed(err) {
+		return domain.ErrInProgress{Key: key}
+	}
+	return err
+}
+
+// get returns the current marker for the diff and its ETag, or nil if the diff is not marked.
+func (m *LeaseMarker) get(ctx context.Context, key string) (*Lease, string, error) {
+	res, err := m.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(m.Bucket),
+		Key:    aws.String(key + inProgressSuffix),
+	})
+	if isNotFound(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	var lease Lease
+	if err := json.Unmarshal(b, &lease); err != nil {
+		// markers written by ProgressMarker hold only the time they were written
+		ts, _ := time.Parse(time.RFC3339Nano, string(b))
+		lease = Lease{Expires: ts.Add(m.TTL)}
+	}
+	return &lease, aws.StringValue(res.ETag), nil
+}
+
+// put writes the lease with an expiry of one TTL from now, and returns the ETag of the
+// written marker.
+func (m *LeaseMarker) put(ctx context.Context, key string, lease Lease, condition request.Option) (string, error) {
+	lease.Expires = m.timeNow().Add(m.TTL)
+	body, _ := json.Marshal(lease)
+	res, err := m.Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(m.Bucket),
+		Key:         aws.String(key + inProgressSuffix),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}, condition)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(res.ETag), nil
+}
+
+// remove deletes the marker of the diff, subject to the given conditions.
+func (m *LeaseMarker) remove(ctx context.Context, key string, conditions ...request.Option) error {
+	_, err := m.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(m.Bucket),
+		Key:    aws.String(key + inProgressSuffix),
+	}, conditions...)
+	return err
+}
+
+func (m *LeaseMarker) timeNow() time.Time {
+	if m.now == nil {
+		return time.Now()
+	}
+	return m.now()
+}
+
+// ifNoneMatch makes a write conditional on the object not existing.
+func ifNoneMatch(r *request.Request) {
+	r.HTTPRequest.Header.Set("If-None-Match", "*")
+}
+
+// ifMatch makes a write or delete conditional on the object not having changed since it was
+// read.
+func ifMatch(etag string) request.Option {
+	return func(r *request.Request) {
+		r.HTTPRequest.Header.Set("If-Match", etag)
+	}
+}
+
+func isConditionFailed(err error) bool {
+	if rErr, ok := err.(awserr.RequestFailure); ok && rErr.StatusCode() == http.StatusPreconditionFailed {
+		return true
+	}
+	aErr, ok := err.(awserr.Error)
+	return ok && (aErr.Code() == errCodePreconditionFailed || aErr.Code() == errCodeConditionalRequestConflict)
+}
+
+func isNotFound(err error) bool {
+	aErr, ok := err.(awserr.Error)
+	return ok && (aErr.Code() == s3.ErrCodeNoSuchKey || aErr.Code() == "NotFound") // NotFound is an undocumented error code with no provided constant
+}
@@ -0,0 +1,298 @@
+package marker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	etag    = `"etag"`
+	putEtag = `"put"`
+)
+
+var leaseNow = time.Date(1999, time.January, 1, 1, 0, 0, 0, time.UTC)
+
+func leaseObject(l Lease) *s3.GetObjectOutput {
+	body, _ := json.Marshal(l)
+	return &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewReader(body)),
+		ETag: aws.String(etag),
+	}
+}
+
+func notFound() error {
+	return awserr.New(s3.ErrCodeNoSuchKey, "", errors.New(""))
+}
+
+func preconditionFailed() error {
+	return awserr.NewRequestFailure(awserr.New(errCodePreconditionFailed, "", errors.New("")), http.StatusPreconditionFailed, "")
+}
+
+// expectPut expects a conditional write of a lease, and returns the written lease and the
+// headers set by the condition through the given pointers.
+func expectPut(mockS3 *MockS3API, written *Lease, header *http.Header, err error) {
+	mockS3.EXPECT().PutObjectWithContext(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+		_ = json.NewDecoder(input.Body).Decode(written)
+		r := &request.Request{HTTPRequest: &http.Request{Header: http.Header{}}}
+		for _, opt := range opts {
+			opt(r)
+		}
+		*header = r.HTTPRequest.Header
+		return &s3.PutObjectOutput{ETag: aws.String(putEtag)}, err
+	})
+}
+
+// expectDelete expects a conditional delete of the marker with the given key, and returns the
+// headers set by the condition through the given pointer.
+func expectDelete(mockS3 *MockS3API, key string, header *http.Header, err error) {
+	mockS3.EXPECT().DeleteObjectWithContext(gomock.Any(), &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}, gomock.Any()).DoAndReturn(func(_ context.Context, _ *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+		r := &request.Request{HTTPRequest: &http.Request{Header: http.Header{}}}
+		for _, opt := range opts {
+			opt(r)
+		}
+		*header = r.HTTPRequest.Header
+		return &s3.DeleteObjectOutput{}, err
+	})
+}
+
+func newLeaseMarker(mockS3 *MockS3API) *LeaseMarker {
+	return &LeaseMarker{
+		Bucket: bucket,
+		Client: mockS3,
+		TTL:    time.Minute,
+		now:    func() time.Time { return leaseNow },
+	}
+}
+
+func TestLeaseMark(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key + "_in_progress"),
+	}).Return(nil, notFound())
+	var written Lease
+	var header http.Header
+	expectPut(mockS3, &written, &header, nil)
+
+	assert.Nil(t, newLeaseMarker(mockS3).Mark(context.Background(), key))
+	assert.Equal(t, "*", header.Get("If-None-Match"))
+	assert.Equal(t, "", written.Owner)
+	assert.True(t, leaseNow.Add(time.Minute).Equal(written.Expires))
+}
+
+func TestLeaseMarkInProgress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(leaseObject(Lease{Expires: leaseNow.Add(time.Second)}), nil)
+
+	err := newLeaseMarker(mockS3).Mark(context.Background(), key)
+	assert.Equal(t, domain.ErrInProgress{Key: key}, err)
+}
+
+func TestLeaseMarkExpired(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Object *s3.GetObjectOutput
+	}{
+		{
+			Name:   "lease",
+			Object: leaseObject(Lease{Owner: "other", Expires: leaseNow}),
+		},
+		{
+			Name: "timestamp",
+			Object: &s3.GetObjectOutput{
+				Body: ioutil.NopCloser(bytes.NewReader([]byte(leaseNow.Add(-time.Hour).Format(time.RFC3339Nano)))),
+				ETag: aws.String(etag),
+			},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockS3 := NewMockS3API(ctrl)
+			mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(tt.Object, nil)
+			var written Lease
+			var header http.Header
+			expectPut(mockS3, &written, &header, nil)
+
+			assert.Nil(t, newLeaseMarker(mockS3).Mark(context.Background(), key))
+			assert.Equal(t, etag, header.Get("If-Match"))
+		})
+	}
+}
+
+func TestLeaseMarkRace(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, notFound())
+	var written Lease
+	var header http.Header
+	expectPut(mockS3, &written, &header, preconditionFailed())
+
+	err := newLeaseMarker(mockS3).Mark(context.Background(), key)
+	assert.Equal(t, domain.ErrInProgress{Key: key}, err)
+}
+
+func TestLeaseMarkError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("oops"))
+
+	err := newLeaseMarker(mockS3).Mark(context.Background(), key)
+	assert.NotNil(t, err)
+	assert.IsType(t, errors.New(""), err)
+}
+
+func TestLeaseAcquireMarked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(leaseObject(Lease{Expires: leaseNow.Add(time.Second), Requeued: 1}), nil)
+	var written Lease
+	var header http.Header
+	expectPut(mockS3, &written, &header, nil)
+
+	lease, err := newLeaseMarker(mockS3).Acquire(context.Background(), domain.Diff{ID: key})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, lease)
+	assert.Equal(t, lease, written.Owner)
+	assert.Equal(t, key, written.Diff.ID)
+	// the requeues of a diff which was reaped are counted across leases
+	assert.Equal(t, 1, written.Requeued)
+	assert.Equal(t, etag, header.Get("If-Match"))
+}
+
+func TestLeaseAcquireLeased(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(leaseObject(Lease{Owner: "other", Expires: leaseNow.Add(time.Second)}), nil)
+
+	_, err := newLeaseMarker(mockS3).Acquire(context.Background(), domain.Diff{ID: key})
+	assert.Equal(t, domain.ErrInProgress{Key: key}, err)
+}
+
+func TestLeaseRenew(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(leaseObject(Lease{Owner: "lease", Expires: leaseNow.Add(time.Second), Diff: &domain.Diff{ID: key}}), nil)
+	var written Lease
+	var header http.Header
+	expectPut(mockS3, &written, &header, nil)
+
+	assert.Nil(t, newLeaseMarker(mockS3).Renew(context.Background(), key, "lease"))
+	assert.Equal(t, "lease", written.Owner)
+	assert.Equal(t, key, written.Diff.ID)
+	assert.True(t, leaseNow.Add(time.Minute).Equal(written.Expires))
+	assert.Equal(t, etag, header.Get("If-Match"))
+}
+
+func TestLeaseRenewLost(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Object *s3.GetObjectOutput
+		Err    error
+		Put    bool
+		PutErr error
+	}{
+		{
+			Name: "released",
+			Err:  notFound(),
+		},
+		{
+			Name:   "acquired",
+			Object: leaseObject(Lease{Owner: "other", Expires: leaseNow.Add(time.Second)}),
+		},
+		{
+			Name:   "race",
+			Object: leaseObject(Lease{Owner: "lease", Expires: leaseNow.Add(time.Second)}),
+			Put:    true,
+			PutErr: preconditionFailed(),
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockS3 := NewMockS3API(ctrl)
+			mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(tt.Object, tt.Err)
+			if tt.Put {
+				var written Lease
+				var header http.Header
+				expectPut(mockS3, &written, &header, tt.PutErr)
+			}
+
+			err := newLeaseMarker(mockS3).Renew(context.Background(), key, "lease")
+			assert.Equal(t, domain.ErrLeaseLost{Key: key}, err)
+		})
+	}
+}
+
+func TestLeaseRelease(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(leaseObject(Lease{Owner: "lease", Expires: leaseNow}), nil)
+	var header http.Header
+	expectDelete(mockS3, key+"_in_progress", &header, nil)
+
+	assert.Nil(t, newLeaseMarker(mockS3).Release(context.Background(), key, "lease"))
+	assert.Equal(t, etag, header.Get("If-Match"))
+}
+
+func TestLeaseReleaseRace(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// the lease expired and was acquired by another worker after it was read
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(leaseObject(Lease{Owner: "lease", Expires: leaseNow}), nil)
+	var header http.Header
+	expectDelete(mockS3, key+"_in_progress", &header, preconditionFailed())
+
+	assert.Nil(t, newLeaseMarker(mockS3).Release(context.Background(), key, "lease"))
+}
+
+func TestLeaseReleaseAcquired(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(leaseObject(Lease{Owner: "other", Expires: leaseNow}), nil)
+
+	assert.Nil(t, newLeaseMarker(mockS3).Release(context.Background(), key, "lease"))
+}
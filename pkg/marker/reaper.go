@@ -0,0 +1,184 @@
+package marker
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/logs"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	defaultReapInterval = time.Minute
+	defaultMaxRequeues  = 3
+
+	statReaped = "marker.reaped"
+)
+
+// Reaper periodically removes the markers of diffs whose mark or lease has expired, which are
+// left behind when a worker dies part way through a diff, or when a queued job is lost.
+//
+// If a Queuer is set, diffs which were leased by a worker are queued again, and their marker
+// is replaced by a new mark so that the diff is still reported as in progress. Markers which
+// do not record the diff, or whose diff was already queued again MaxRequeues times, are removed
+// without queuing the diff again.
+//
+// Only the markers of diffs are scanned, which are at the root of the bucket or under the
+// prefix of a tenant, so the bucket may be shared with the state kept under other prefixes.
+type Reaper struct {
+	Marker       *LeaseMarker
+	Queuer       domain.Queuer
+	LogProvider  domain.LogFn
+	StatProvider domain.StatFn
+
+	// Interval is the time between scans of the progress bucket. If unset, the bucket is
+	// scanned every minute.
+	Interval time.Duration
+
+	// MaxRequeues is the number of times a diff is queued again before it is dropped, so that
+	// a diff which always kills its worker is not retried forever. It defaults to 3.
+	MaxRequeues int
+}
+
+// Run reaps expired markers once every interval until the context is cancelled. Failed scans
+// are logged and retried at the next interval.
+func (r *Reaper) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = defaultReapInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := r.Reap(ctx); err != nil && ctx.Err() == nil {
+			r.LogProvider(ctx).Error(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reap scans the progress bucket once, and removes or requeues each diff whose marker has
+// expired.
+func (r *Reaper) Reap(ctx context.Context) error {
+	prefixes, err := r.scan(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, prefix := range prefixes {
+		// other prefixes, such as those of schedules and backfills, hold no markers
+		if prefix != domain.TenantPrefix {
+			continue
+		}
+		tenants, err := r.scan(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, tenant := range tenants {
+			if _, err := r.scan(ctx, tenant); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scan reaps the markers directly under the prefix, without listing the objects of any
+// deeper prefix, and returns the prefixes found under it.
+func (r *Reaper) scan(ctx context.Context, prefix string) ([]string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(r.Marker.Bucket),
+		Delimiter: aws.String("/"),
+	}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+	var prefixes []string
+	for {
+		res, err := r.Marker.Client.ListObjectsV2WithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range res.Contents {
+			name := aws.StringValue(obj.Key)
+			if !strings.HasSuffix(name, inProgressSuffix) {
+				continue
+			}
+			if err := r.reap(ctx, strings.TrimSuffix(name, inProgressSuffix)); err != nil {
+				return nil, err
+			}
+		}
+		for _, p := range res.CommonPrefixes {
+			prefixes = append(prefixes, aws.StringValue(p.Prefix))
+		}
+		if !aws.BoolValue(res.IsTruncated) {
+			return prefixes, nil
+		}
+		input.ContinuationToken = res.NextContinuationToken
+	}
+}
+
+func (r *Reaper) reap(ctx context.Context, key string) error {
+	current, etag, err := r.Marker.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if current == nil || r.Marker.timeNow().Before(current.Expires) {
+		return nil // removed or renewed since the listing was made
+	}
+	requeue := r.Queuer != nil && current.Diff != nil && current.Requeued < r.maxRequeues()
+	if requeue {
+		// the condition fails if the marker was replaced since it was read, in which case
+		// the diff is no longer abandoned
+		etag, err = r.Marker.put(ctx, key, Lease{Diff: current.Diff, Requeued: current.Requeued + 1}, ifMatch(etag))
+		if isConditionFailed(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.Queuer.Queue(ctx, *current.Diff); err != nil {
+			r.LogProvider(ctx).Error(logs.DependencyFailure{Dependency: logs.DependencyQueuer, Reason: err.Error()})
+			requeue = false
+		}
+	}
+	if !requeue {
+		// as above, the condition fails if the diff was leased again since the marker was
+		// read or written, in which case the lease is left to its new owner
+		err = r.Marker.remove(ctx, key, ifMatch(etag))
+		if isConditionFailed(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	r.LogProvider(ctx).Info(logs.Reaped{
+		Key:      key,
+		Expired:  current.Expires.Format(time.RFC3339Nano),
+		Requeued: requeue,
+		Requeues: current.Requeued,
+	})
+	r.StatProvider(ctx).Count(statReaped, 1, "requeued:"+boolTag(requeue))
+	return nil
+}
+
+func (r *Reaper) maxRequeues() int {
+	if r.MaxRequeues <= 0 {
+		return defaultMaxRequeues
+	}
+	return r.MaxRequeues
+}
+
+func boolTag(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
@@ -0,0 +1,256 @@
+package marker
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+type reapedStat struct {
+	tags []string
+}
+
+func (s *reapedStat) AddTags(tags ...string)                                  {}
+func (s *reapedStat) GetTags() []string                                       { return nil }
+func (s *reapedStat) Gauge(stat string, value float64, tags ...string)        {}
+func (s *reapedStat) Histogram(stat string, value float64, tags ...string)    {}
+func (s *reapedStat) Timing(stat string, value time.Duration, tags ...string) {}
+func (s *reapedStat) Count(stat string, count float64, tags ...string) {
+	if stat == statReaped {
+		s.tags = append(s.tags, tags...)
+	}
+}
+
+func newReaper(mockS3 *MockS3API, queuer domain.Queuer) (*Reaper, *reapedStat) {
+	stat := &reapedStat{}
+	return &Reaper{
+		Marker:       newLeaseMarker(mockS3),
+		Queuer:       queuer,
+		LogProvider:  func(context.Context) domain.Logger { return logevent.New(logevent.Config{Output: ioutil.Discard}) },
+		StatProvider: func(context.Context) domain.Stat { return stat },
+	}, stat
+}
+
+func listed(keys ...string) *s3.ListObjectsV2Output {
+	out := &s3.ListObjectsV2Output{}
+	for _, k := range keys {
+		out.Contents = append(out.Contents, &s3.Object{Key: aws.String(k)})
+	}
+	return out
+}
+
+func TestReap(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	gomock.InOrder(
+		mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+			Bucket:    aws.String(bucket),
+			Delimiter: aws.String("/"),
+		}).Return(&s3.ListObjectsV2Output{
+			Contents:              listed("live_in_progress", "other").Contents,
+			IsTruncated:           aws.Bool(true),
+			NextContinuationToken: aws.String("next"),
+		}, nil),
+		mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+			Bucket:            aws.String(bucket),
+			Delimiter:         aws.String("/"),
+			ContinuationToken: aws.String("next"),
+		}).Return(listed("expired_in_progress"), nil),
+	)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("live_in_progress"),
+	}).Return(leaseObject(Lease{Owner: "lease", Expires: leaseNow.Add(time.Second)}), nil)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("expired_in_progress"),
+	}).Return(leaseObject(Lease{Owner: "lease", Expires: leaseNow, Diff: &domain.Diff{ID: "expired"}}), nil)
+	var header http.Header
+	expectDelete(mockS3, "expired_in_progress", &header, nil)
+
+	r, stat := newReaper(mockS3, nil)
+	assert.Nil(t, r.Reap(context.Background()))
+	assert.Equal(t, etag, header.Get("If-Match"))
+	assert.Equal(t, []string{"requeued:false"}, stat.tags)
+}
+
+func TestReapRequeue(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	diff := domain.Diff{ID: key, NextStart: leaseNow.Add(-time.Hour), NextStop: leaseNow}
+	mockS3 := NewMockS3API(ctrl)
+	mockQueuer := NewMockQueuer(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(listed(key+"_in_progress"), nil)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(leaseObject(Lease{Owner: "lease", Expires: leaseNow, Diff: &diff}), nil)
+	var written Lease
+	var header http.Header
+	expectPut(mockS3, &written, &header, nil)
+	mockQueuer.EXPECT().Queue(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, d domain.Diff) error {
+		assert.Equal(t, key, d.ID)
+		assert.True(t, diff.NextStart.Equal(d.NextStart))
+		return nil
+	})
+
+	r, stat := newReaper(mockS3, mockQueuer)
+	assert.Nil(t, r.Reap(context.Background()))
+	assert.Equal(t, etag, header.Get("If-Match"))
+	assert.Equal(t, "", written.Owner)
+	assert.Equal(t, key, written.Diff.ID)
+	assert.Equal(t, 1, written.Requeued)
+	assert.Equal(t, []string{"requeued:true"}, stat.tags)
+}
+
+func TestReapTenants(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// the prefixes of schedules and backfills are not listed, and each tenant is listed
+	// without the objects of any deeper prefix
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Delimiter: aws.String("/"),
+	}).Return(&s3.ListObjectsV2Output{
+		CommonPrefixes: []*s3.CommonPrefix{
+			{Prefix: aws.String("backfills/")},
+			{Prefix: aws.String("schedules/")},
+			{Prefix: aws.String("tenants/")},
+		},
+	}, nil)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Delimiter: aws.String("/"),
+		Prefix:    aws.String("tenants/"),
+	}).Return(&s3.ListObjectsV2Output{
+		CommonPrefixes: []*s3.CommonPrefix{{Prefix: aws.String("tenants/acme/")}},
+	}, nil)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Delimiter: aws.String("/"),
+		Prefix:    aws.String("tenants/acme/"),
+	}).Return(listed("tenants/acme/expired_in_progress"), nil)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("tenants/acme/expired_in_progress"),
+	}).Return(leaseObject(Lease{Owner: "lease", Expires: leaseNow}), nil)
+	var header http.Header
+	expectDelete(mockS3, "tenants/acme/expired_in_progress", &header, nil)
+
+	r, stat := newReaper(mockS3, nil)
+	assert.Nil(t, r.Reap(context.Background()))
+	assert.Equal(t, []string{"requeued:false"}, stat.tags)
+}
+
+func TestReapRequeueLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	diff := domain.Diff{ID: key}
+	mockS3 := NewMockS3API(ctrl)
+	mockQueuer := NewMockQueuer(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(listed(key+"_in_progress"), nil)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(leaseObject(Lease{Owner: "lease", Expires: leaseNow, Diff: &diff, Requeued: 2}), nil)
+	var header http.Header
+	expectDelete(mockS3, key+"_in_progress", &header, nil)
+
+	// a diff which was already queued again as many times as allowed is dropped
+	r, stat := newReaper(mockS3, mockQueuer)
+	r.MaxRequeues = 2
+	assert.Nil(t, r.Reap(context.Background()))
+	assert.Equal(t, []string{"requeued:false"}, stat.tags)
+}
+
+func TestReapRequeueUnknownDiff(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockQueuer := NewMockQueuer(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(listed(key+"_in_progress"), nil)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(leaseObject(Lease{Expires: leaseNow}), nil)
+	var header http.Header
+	expectDelete(mockS3, key+"_in_progress", &header, nil)
+
+	r, stat := newReaper(mockS3, mockQueuer)
+	assert.Nil(t, r.Reap(context.Background()))
+	assert.Equal(t, []string{"requeued:false"}, stat.tags)
+}
+
+func TestReapRequeueRace(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockQueuer := NewMockQueuer(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(listed(key+"_in_progress"), nil)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(leaseObject(Lease{Owner: "lease", Expires: leaseNow, Diff: &domain.Diff{ID: key}}), nil)
+	var written Lease
+	var header http.Header
+	expectPut(mockS3, &written, &header, preconditionFailed())
+
+	r, stat := newReaper(mockS3, mockQueuer)
+	assert.Nil(t, r.Reap(context.Background()))
+	assert.Empty(t, stat.tags)
+}
+
+func TestReapRequeueError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockQueuer := NewMockQueuer(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(listed(key+"_in_progress"), nil)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(leaseObject(Lease{Owner: "lease", Expires: leaseNow, Diff: &domain.Diff{ID: key}}), nil)
+	var written Lease
+	var header http.Header
+	expectPut(mockS3, &written, &header, nil)
+	mockQueuer.EXPECT().Queue(gomock.Any(), gomock.Any()).Return(errors.New("oops"))
+	var deleteHeader http.Header
+	expectDelete(mockS3, key+"_in_progress", &deleteHeader, nil)
+
+	// the marker is removed only if it is still the one written before queuing
+	r, stat := newReaper(mockS3, mockQueuer)
+	assert.Nil(t, r.Reap(context.Background()))
+	assert.Equal(t, putEtag, deleteHeader.Get("If-Match"))
+	assert.Equal(t, []string{"requeued:false"}, stat.tags)
+}
+
+func TestReapUnmarkRace(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// the diff was leased again after the expired lease was read
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(listed(key+"_in_progress"), nil)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(leaseObject(Lease{Owner: "lease", Expires: leaseNow}), nil)
+	var header http.Header
+	expectDelete(mockS3, key+"_in_progress", &header, preconditionFailed())
+
+	r, stat := newReaper(mockS3, nil)
+	assert.Nil(t, r.Reap(context.Background()))
+	assert.Empty(t, stat.tags)
+}
+
+func TestReapListError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("oops"))
+
+	r, _ := newReaper(mockS3, nil)
+	assert.NotNil(t, r.Reap(context.Background()))
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+)
+
+const statDifferDiff = "differ.diff"
+
+// Differ is an implementation of domain.Differ which records metrics for the Differ it
+// decorates. The bytes of a diff are recorded once its body is closed.
+type Differ struct {
+	Differ       domain.Differ
+	StatProvider domain.StatFn
+}
+
+// Diff generates the diff of the graphs of the diff's time ranges.
+func (d *Differ) Diff(ctx context.Context, diff domain.Diff) (io.ReadCloser, error) {
+	stat := d.StatProvider(ctx)
+	start := time.Now()
+	body, err := d.Differ.Diff(ctx, diff)
+	observe(stat, statDifferDiff, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return newCountingReadCloser(body, stat, statDifferDiff), nil
+}
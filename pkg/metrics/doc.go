@@ -0,0 +1,4 @@
+// Package metrics is a container of decorators which record metrics for the
+// domain interfaces. Each decorator reports the latency and errors of every
+// call, and the bytes passed through it, to the stats client in the context.
+package metrics
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+)
+
+const statGrapherGraph = "grapher.graph"
+
+// Grapher is an implementation of domain.Grapher which records metrics for the Grapher it
+// decorates. The bytes of a graph are recorded once its body is closed.
+type Grapher struct {
+	Grapher      domain.Grapher
+	StatProvider domain.StatFn
+}
+
+// Graph returns the graph of the given time range.
+func (g *Grapher) Graph(ctx context.Context, start, stop time.Time) (io.ReadCloser, error) {
+	stat := g.StatProvider(ctx)
+	began := time.Now()
+	body, err := g.Grapher.Graph(ctx, start, stop)
+	observe(stat, statGrapherGraph, began, err)
+	if err != nil {
+		return nil, err
+	}
+	return newCountingReadCloser(body, stat, statGrapherGraph), nil
+}
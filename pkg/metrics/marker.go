@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+)
+
+const (
+	statMarkerMark    = "marker.mark"
+	statMarkerUnmark  = "marker.unmark"
+	statMarkerAcquire = "marker.acquire"
+	statMarkerRenew   = "marker.renew"
+	statMarkerRelease = "marker.release"
+)
+
+// Marker is an implementation of domain.Marker which records metrics for the Marker it
+// decorates.
+type Marker struct {
+	Marker       domain.Marker
+	StatProvider domain.StatFn
+}
+
+// Mark flags the diff identified by key as being "in progress".
+func (m *Marker) Mark(ctx context.Context, key string) error {
+	start := time.Now()
+	err := m.Marker.Mark(ctx, key)
+	observe(m.StatProvider(ctx), statMarkerMark, start, err)
+	return err
+}
+
+// Unmark flags the diff identified by key as not being "in progress".
+func (m *Marker) Unmark(ctx context.Context, key string) error {
+	start := time.Now()
+	err := m.Marker.Unmark(ctx, key)
+	observe(m.StatProvider(ctx), statMarkerUnmark, start, err)
+	return err
+}
+
+// LeaseMarker is an implementation of domain.LeaseMarker which records metrics for the
+// LeaseMarker it decorates.
+type LeaseMarker struct {
+	LeaseMarker  domain.LeaseMarker
+	StatProvider domain.StatFn
+}
+
+// Mark flags the diff identified by key as being "in progress".
+func (m *LeaseMarker) Mark(ctx context.Context, key string) error {
+	start := time.Now()
+	err := m.LeaseMarker.Mark(ctx, key)
+	observe(m.StatProvider(ctx), statMarkerMark, start, err)
+	return err
+}
+
+// Unmark flags the diff identified by key as not being "in progress".
+func (m *LeaseMarker) Unmark(ctx context.Context, key string) error {
+	start := time.Now()
+	err := m.LeaseMarker.Unmark(ctx, key)
+	observe(m.StatProvider(ctx), statMarkerUnmark, start, err)
+	return err
+}
+
+// Acquire leases the diff to the caller.
+func (m *LeaseMarker) Acquire(ctx context.Context, d domain.Diff) (string, error) {
+	start := time.Now()
+	lease, err := m.LeaseMarker.Acquire(ctx, d)
+	observe(m.StatProvider(ctx), statMarkerAcquire, start, err)
+	return lease, err
+}
+
+// Renew extends the lease.
+func (m *LeaseMarker) Renew(ctx context.Context, key string, lease string) error {
+	start := time.Now()
+	err := m.LeaseMarker.Renew(ctx, key, lease)
+	observe(m.StatProvider(ctx), statMarkerRenew, start, err)
+	return err
+}
+
+// Release ends the lease.
+func (m *LeaseMarker) Release(ctx context.Context, key string, lease string) error {
+	start := time.Now()
+	err := m.LeaseMarker.Release(ctx, key, lease)
+	observe(m.StatProvider(ctx), statMarkerRelease, start, err)
+	return err
+}
+
+// NewMarker decorates the Marker with metrics. A domain.LeaseMarker is decorated with
+// LeaseMarker so that it can still be used to lease diffs.
+func NewMarker(m domain.Marker, stat domain.StatFn) domain.Marker {
+	if leaser, ok := m.(domain.LeaseMarker); ok {
+		return &LeaseMarker{LeaseMarker: leaser, StatProvider: stat}
+	}
+	return &Marker{Marker: m, StatProvider: stat}
+}
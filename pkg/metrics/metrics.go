@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+const (
+	suffixLatency = ".latency"
+	suffixError   = ".error"
+	suffixBytes   = ".bytes"
+
+	outcomeSuccess = "success"
+	outcomeError   = "error"
+)
+
+// observe records the latency of a call which started at the given time, tagged by whether
+// the call failed, and counts the error by its type if it did.
+func observe(stat domain.Stat, name string, start time.Time, err error) {
+	outcome := outcomeSuccess
+	if err != nil {
+		outcome = outcomeError
+		stat.Count(name+suffixError, 1, "error:"+errorType(err))
+	}
+	stat.Timing(name+suffixLatency, time.Since(start), "outcome:"+outcome)
+}
+
+// errorType returns a low cardinality name for the error. AWS errors are named by their
+// error code, as the types of the errors returned by the SDK are all the same.
+func errorType(err error) string {
+	if aErr, ok := err.(awserr.Error); ok {
+		return aErr.Code()
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", err), "*")
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// countingReadCloser counts the bytes read through it, and records the count when it is
+// closed.
+type countingReadCloser struct {
+	countingReader
+	closer io.Closer
+	stat   domain.Stat
+	name   string
+	once   sync.Once
+}
+
+func newCountingReadCloser(rc io.ReadCloser, stat domain.Stat, name string) *countingReadCloser {
+	return &countingReadCloser{
+		countingReader: countingReader{Reader: rc},
+		closer:         rc,
+		stat:           stat,
+		name:           name,
+	}
+}
+
+func (r *countingReadCloser) Close() error {
+	r.once.Do(func() {
+		r.stat.Count(r.name+suffixBytes, float64(r.n))
+	})
+	return r.closer.Close()
+}
@@ -0,0 +1,221 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const key = "key"
+
+// recordingStat records each count, and each latency by name and tags.
+type recordingStat struct {
+	counts  map[string]float64
+	timings map[string]int
+}
+
+func newRecordingStat() *recordingStat {
+	return &recordingStat{counts: make(map[string]float64), timings: make(map[string]int)}
+}
+
+func statKey(stat string, tags []string) string {
+	return strings.Join(append([]string{stat}, tags...), ",")
+}
+
+func (s *recordingStat) AddTags(tags ...string)                               {}
+func (s *recordingStat) GetTags() []string                                    { return nil }
+func (s *recordingStat) Gauge(stat string, value float64, tags ...string)     {}
+func (s *recordingStat) Histogram(stat string, value float64, tags ...string) {}
+func (s *recordingStat) Timing(stat string, value time.Duration, tags ...string) {
+	s.timings[statKey(stat, tags)]++
+}
+func (s *recordingStat) Count(stat string, count float64, tags ...string) {
+	s.counts[statKey(stat, tags)] += count
+}
+
+func (s *recordingStat) provider(context.Context) domain.Stat {
+	return s
+}
+
+func TestStorageGet(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Get(gomock.Any(), key).Return(ioutil.NopCloser(bytes.NewReader([]byte("diff"))), nil)
+
+	stat := newRecordingStat()
+	s := &Storage{Storage: mockStorage, StatProvider: stat.provider}
+	body, err := s.Get(context.Background(), key)
+	assert.Nil(t, err)
+	_, _ = ioutil.ReadAll(body)
+	assert.Nil(t, body.Close())
+	assert.Equal(t, 1, stat.timings["storage.get.latency,outcome:success"])
+	assert.Equal(t, float64(4), stat.counts["storage.get.bytes"])
+}
+
+func TestStorageGetEncodedFallback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Get(gomock.Any(), key).Return(ioutil.NopCloser(bytes.NewReader([]byte("diff"))), nil)
+
+	stat := newRecordingStat()
+	s := &Storage{Storage: mockStorage, StatProvider: stat.provider}
+	body, encoding, err := s.GetEncoded(context.Background(), key, []string{"gzip"})
+	assert.Nil(t, err)
+	assert.Equal(t, "", encoding)
+	assert.Nil(t, body.Close())
+	assert.Equal(t, 1, stat.timings["storage.get.latency,outcome:success"])
+}
+
+// objectStorage adds ObjectStorage to a mock Storage.
+type objectStorage struct {
+	*MockStorage
+	body string
+}
+
+func (s *objectStorage) Stat(ctx context.Context, key string) (domain.ObjectInfo, error) {
+	return domain.ObjectInfo{Size: int64(len(s.body))}, nil
+}
+
+func (s *objectStorage) GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(s.body[offset:])), nil
+}
+
+func TestStorageObject(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stat := newRecordingStat()
+	s := &Storage{Storage: &objectStorage{MockStorage: NewMockStorage(ctrl), body: "diff"}, StatProvider: stat.provider}
+	info, err := s.Stat(context.Background(), key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), info.Size)
+	body, err := s.GetRange(context.Background(), key, 1)
+	assert.Nil(t, err)
+	_, _ = ioutil.ReadAll(body)
+	assert.Nil(t, body.Close())
+	assert.Equal(t, 1, stat.timings["storage.stat.latency,outcome:success"])
+	assert.Equal(t, 1, stat.timings["storage.get.latency,outcome:success"])
+	assert.Equal(t, float64(3), stat.counts["storage.get.bytes"])
+
+	s = &Storage{Storage: NewMockStorage(ctrl), StatProvider: stat.provider}
+	_, err = s.Stat(context.Background(), key)
+	assert.IsType(t, domain.ErrUnsupported{}, err)
+	_, err = s.GetRange(context.Background(), key, 0)
+	assert.IsType(t, domain.ErrUnsupported{}, err)
+}
+
+func TestStorageErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Get(gomock.Any(), key).Return(nil, domain.ErrNotFound{ID: key})
+	mockStorage.EXPECT().Exists(gomock.Any(), key).Return(false, awserr.New("AccessDenied", "", nil))
+	mockStorage.EXPECT().Delete(gomock.Any(), key).Return(errors.New("oops"))
+
+	stat := newRecordingStat()
+	s := &Storage{Storage: mockStorage, StatProvider: stat.provider}
+	_, err := s.Get(context.Background(), key)
+	assert.IsType(t, domain.ErrNotFound{}, err)
+	_, err = s.Exists(context.Background(), key)
+	assert.NotNil(t, err)
+	assert.NotNil(t, s.Delete(context.Background(), key))
+	assert.Equal(t, float64(1), stat.counts["storage.get.error,error:domain.ErrNotFound"])
+	assert.Equal(t, float64(1), stat.counts["storage.exists.error,error:AccessDenied"])
+	assert.Equal(t, float64(1), stat.counts["storage.delete.error,error:errors.errorString"])
+	assert.Equal(t, 1, stat.timings["storage.get.latency,outcome:error"])
+}
+
+func TestStorageStore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Store(gomock.Any(), key, gomock.Any()).DoAndReturn(func(_ context.Context, _ string, data io.ReadCloser) error {
+		_, _ = ioutil.ReadAll(data)
+		return data.Close()
+	})
+
+	stat := newRecordingStat()
+	s := &Storage{Storage: mockStorage, StatProvider: stat.provider}
+	assert.Nil(t, s.Store(context.Background(), key, ioutil.NopCloser(bytes.NewReader([]byte("diff")))))
+	assert.Equal(t, float64(4), stat.counts["storage.store.bytes"])
+	assert.Equal(t, 1, stat.timings["storage.store.latency,outcome:success"])
+}
+
+type fakeMarker struct{}
+
+func (fakeMarker) Mark(ctx context.Context, key string) error   { return nil }
+func (fakeMarker) Unmark(ctx context.Context, key string) error { return nil }
+
+type fakeLeaseMarker struct {
+	fakeMarker
+}
+
+func (fakeLeaseMarker) Acquire(ctx context.Context, d domain.Diff) (string, error) {
+	return "", domain.ErrInProgress{Key: d.ID}
+}
+func (fakeLeaseMarker) Renew(ctx context.Context, key string, lease string) error   { return nil }
+func (fakeLeaseMarker) Release(ctx context.Context, key string, lease string) error { return nil }
+
+func TestNewMarker(t *testing.T) {
+	stat := newRecordingStat()
+	m := NewMarker(fakeMarker{}, stat.provider)
+	_, ok := m.(domain.LeaseMarker)
+	assert.False(t, ok)
+	assert.Nil(t, m.Mark(context.Background(), key))
+	assert.Equal(t, 1, stat.timings["marker.mark.latency,outcome:success"])
+
+	m = NewMarker(fakeLeaseMarker{}, stat.provider)
+	leaser, ok := m.(domain.LeaseMarker)
+	assert.True(t, ok)
+	_, err := leaser.Acquire(context.Background(), domain.Diff{ID: key})
+	assert.IsType(t, domain.ErrInProgress{}, err)
+	assert.Equal(t, float64(1), stat.counts["marker.acquire.error,error:domain.ErrInProgress"])
+}
+
+func TestQueuer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockQueuer := NewMockQueuer(ctrl)
+	mockQueuer.EXPECT().Queue(gomock.Any(), gomock.Any()).Return(errors.New("oops"))
+
+	stat := newRecordingStat()
+	q := &Queuer{Queuer: mockQueuer, StatProvider: stat.provider}
+	assert.NotNil(t, q.Queue(context.Background(), domain.Diff{ID: key}))
+	assert.Equal(t, 1, stat.timings["queuer.queue.latency,outcome:error"])
+	assert.Equal(t, float64(1), stat.counts["queuer.queue.error,error:errors.errorString"])
+}
+
+func TestGrapher(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockGrapher := NewMockGrapher(ctrl)
+	mockGrapher.EXPECT().Graph(gomock.Any(), gomock.Any(), gomock.Any()).Return(ioutil.NopCloser(bytes.NewReader([]byte("graph"))), nil)
+
+	stat := newRecordingStat()
+	g := &Grapher{Grapher: mockGrapher, StatProvider: stat.provider}
+	body, err := g.Graph(context.Background(), time.Now(), time.Now())
+	assert.Nil(t, err)
+	_, _ = ioutil.ReadAll(body)
+	assert.Nil(t, body.Close())
+	assert.Nil(t, body.Close())
+	assert.Equal(t, 1, stat.timings["grapher.graph.latency,outcome:success"])
+	assert.Equal(t, float64(5), stat.counts["grapher.graph.bytes"])
+}
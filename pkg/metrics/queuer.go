@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+)
+
+const statQueuerQueue = "queuer.queue"
+
+// Queuer is an implementation of domain.Queuer which records metrics for the Queuer it
+// decorates.
+type Queuer struct {
+	Queuer       domain.Queuer
+	StatProvider domain.StatFn
+}
+
+// Queue queues the diff job.
+func (q *Queuer) Queue(ctx context.Context, d domain.Diff) error {
+	start := time.Now()
+	err := q.Queuer.Queue(ctx, d)
+	observe(q.StatProvider(ctx), statQueuerQueue, start, err)
+	return err
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+)
+
+const (
+	statStorageGet      = "storage.get"
+	statStorageStat     = "storage.stat"
+	statStorageExists   = "storage.exists"
+	statStorageStore    = "storage.store"
+	statStorageMetadata = "storage.metadata"
+	statStorageIndex    = "storage.index"
+	statStorageList     = "storage.list"
+	statStorageDelete   = "storage.delete"
+)
+
+// Storage is an implementation of domain.Storage which records metrics for the Storage it
+// decorates. The bytes of a fetched diff are recorded once its body is closed.
+type Storage struct {
+	Storage      domain.Storage
+	StatProvider domain.StatFn
+}
+
+// Get returns the diff for the given key.
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	stat := s.StatProvider(ctx)
+	start := time.Now()
+	body, err := s.Storage.Get(ctx, key)
+	observe(stat, statStorageGet, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return newCountingReadCloser(body, stat, statStorageGet), nil
+}
+
+// GetEncoded returns the diff for the given key along with its encoding. If the decorated
+// Storage does not implement domain.EncodedStorage, the diff is returned by Get with an
+// empty encoding.
+func (s *Storage) GetEncoded(ctx context.Context, key string, accept []string) (io.ReadCloser, string, error) {
+	encoded, ok := s.Storage.(domain.EncodedStorage)
+	if !ok {
+		body, err := s.Get(ctx, key)
+		return body, "", err
+	}
+	stat := s.StatProvider(ctx)
+	start := time.Now()
+	body, encoding, err := encoded.GetEncoded(ctx, key, accept)
+	observe(stat, statStorageGet, start, err)
+	if err != nil {
+		return nil, "", err
+	}
+	return newCountingReadCloser(body, stat, statStorageGet), encoding, nil
+}
+
+// Stat returns the ObjectInfo of the diff for the given key. If the decorated Storage does not
+// implement domain.ObjectStorage, an error of type domain.ErrUnsupported is returned.
+func (s *Storage) Stat(ctx context.Context, key string) (domain.ObjectInfo, error) {
+	objects, ok := s.Storage.(domain.ObjectStorage)
+	if !ok {
+		return domain.ObjectInfo{}, domain.ErrUnsupported{Operation: "Stat"}
+	}
+	start := time.Now()
+	info, err := objects.Stat(ctx, key)
+	observe(s.StatProvider(ctx), statStorageStat, start, err)
+	return info, err
+}
+
+// GetRange returns the diff for the given key, as it is stored, from the given offset. If the
+// decorated Storage does not implement domain.ObjectStorage, an error of type
+// domain.ErrUnsupported is returned.
+func (s *Storage) GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	objects, ok := s.Storage.(domain.ObjectStorage)
+	if !ok {
+		return nil, domain.ErrUnsupported{Operation: "GetRange"}
+	}
+	stat := s.StatProvider(ctx)
+	start := time.Now()
+	body, err := objects.GetRange(ctx, key, offset)
+	observe(stat, statStorageGet, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return newCountingReadCloser(body, stat, statStorageGet), nil
+}
+
+// Exists returns true if the diff exists.
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	start := time.Now()
+	exists, err := s.Storage.Exists(ctx, key)
+	observe(s.StatProvider(ctx), statStorageExists, start, err)
+	return exists, err
+}
+
+// Store stores the diff, and records the bytes which were read from it.
+func (s *Storage) Store(ctx context.Context, key string, data io.ReadCloser) error {
+	stat := s.StatProvider(ctx)
+	counter := &countingReader{Reader: data}
+	start := time.Now()
+	err := s.Storage.Store(ctx, key, struct {
+		io.Reader
+		io.Closer
+	}{counter, data})
+	observe(stat, statStorageStore, start, err)
+	stat.Count(statStorageStore+suffixBytes, float64(counter.n))
+	return err
+}
+
+// Metadata returns the metadata recorded for the diff. If the decorated Storage does not
+// implement domain.IndexedStorage, an error of type domain.ErrUnsupported is returned.
+func (s *Storage) Metadata(ctx context.Context, key string) (domain.DiffMetadata, error) {
+	indexed, ok := s.Storage.(domain.IndexedStorage)
+	if !ok {
+		return domain.DiffMetadata{}, domain.ErrUnsupported{Operation: "Metadata"}
+	}
+	start := time.Now()
+	meta, err := indexed.Metadata(ctx, key)
+	observe(s.StatProvider(ctx), statStorageMetadata, start, err)
+	return meta, err
+}
+
+// Index records the metadata of a diff. If the decorated Storage does not implement
+// domain.IndexedStorage, an error of type domain.ErrUnsupported is returned.
+func (s *Storage) Index(ctx context.Context, meta domain.DiffMetadata) error {
+	indexed, ok := s.Storage.(domain.IndexedStorage)
+	if !ok {
+		return domain.ErrUnsupported{Operation: "Index"}
+	}
+	start := time.Now()
+	err := indexed.Index(ctx, meta)
+	observe(s.StatProvider(ctx), statStorageIndex, start, err)
+	return err
+}
+
+// List returns a page of the metadata of indexed diffs which match the filter. If the
+// decorated Storage does not implement domain.IndexedStorage, an error of type
+// domain.ErrUnsupported is returned.
+func (s *Storage) List(ctx context.Context, filter domain.ListFilter) (domain.DiffPage, error) {
+	indexed, ok := s.Storage.(domain.IndexedStorage)
+	if !ok {
+		return domain.DiffPage{}, domain.ErrUnsupported{Operation: "List"}
+	}
+	start := time.Now()
+	page, err := indexed.List(ctx, filter)
+	observe(s.StatProvider(ctx), statStorageList, start, err)
+	return page, err
+}
+
+// Delete removes the diff and its metadata.
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.Storage.Delete(ctx, key)
+	observe(s.StatProvider(ctx), statStorageDelete, start, err)
+	return err
+}
@@ -0,0 +1,5 @@
+// Package openapi validates the requests and responses of the service against
+// its API document, api.yaml, which is compiled in to the package with
+// go generate.
+//
+package openapi
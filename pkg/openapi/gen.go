@@ -0,0 +1,32 @@
+// +build ignore
+
+// gen.go compiles the API document of the service in to the openapi package. It is run by
+// go generate from the package directory.
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+func main() {
+	spec, err := ioutil.ReadFile("../../api.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if bytes.ContainsRune(spec, '`') {
+		log.Fatal("api.yaml cannot contain backquotes")
+	}
+	var out strings.Builder
+	out.WriteString("// Code generated by gen.go from api.yaml. DO NOT EDIT.\n\n")
+	out.WriteString("package openapi\n\n")
+	out.WriteString("// specYAML is the API document of the service.\n")
+	out.WriteString("const specYAML = `")
+	out.Write(spec)
+	out.WriteString("`\n")
+	if err := ioutil.WriteFile("spec.go", []byte(out.String()), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/logs"
+)
+
+// invalidRequest is the body of the response to a request which does not match the API
+// document. It is both a v1 message and a v2 error.
+type invalidRequest struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidateRequests rejects requests which do not match their operation in the API document
+// with a 400 Bad Request. Requests which match no operation are passed on, so that the router
+// responds to them.
+func ValidateRequests(v *Validator, logProvider domain.LogFn) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := v.ValidateRequest(r)
+			switch err.(type) {
+			case nil, ErrUnknownRoute:
+				next.ServeHTTP(w, r)
+				return
+			}
+			logProvider(r.Context()).Info(logs.InvalidInput{Reason: err.Error()})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(invalidRequest{Code: "INVALID_REQUEST", Message: err.Error()})
+		})
+	}
+}
+
+// responseRecorder records the status of a response, and its body if it is JSON. Other
+// bodies, such as diffs, are not kept.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   *bytes.Buffer
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		if mediaType, _, err := mime.ParseMediaType(w.Header().Get("Content-Type")); err == nil && mediaType == "application/json" {
+			w.body = &bytes.Buffer{}
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.body != nil {
+		_, _ = w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// ValidateResponses logs the responses which do not match their operation in the API
+// document. Responses are sent as the handlers write them, whether they are valid or not.
+func ValidateResponses(v *Validator, logProvider domain.LogFn) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			recorder := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+			route, err := v.Route(r)
+			if err != nil {
+				return
+			}
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+			var body []byte
+			if recorder.body != nil {
+				body = recorder.body.Bytes()
+			}
+			if err := v.ValidateResponse(r, recorder.status, w.Header(), body); err != nil {
+				logProvider(r.Context()).Error(logs.InvalidResponse{Route: route, Status: recorder.status, Reason: err.Error()})
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func bufferLogger(buf *bytes.Buffer) domain.LogFn {
+	logger := logevent.New(logevent.Config{Output: buf})
+	return func(context.Context) domain.Logger { return logger }
+}
+
+func TestValidateRequests(t *testing.T) {
+	v, err := NewValidator()
+	require.Nil(t, err)
+	var logs bytes.Buffer
+	called := false
+	handler := ValidateRequests(v, bufferLogger(&logs))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/?"+validRange, nil))
+	assert.True(t, called)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// routes which are not in the document are left to the router
+	called = false
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/diffs", nil))
+	assert.True(t, called)
+
+	called = false
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/?previous_start=yesterday", nil))
+	assert.False(t, called)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var res invalidRequest
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, "INVALID_REQUEST", res.Code)
+	assert.NotEmpty(t, res.Message)
+	assert.Contains(t, logs.String(), "invalid-input")
+}
+
+func TestValidateResponses(t *testing.T) {
+	v, err := NewValidator()
+	require.Nil(t, err)
+	tc := []struct {
+		Name    string
+		Target  string
+		Status  int
+		Type    string
+		Body    string
+		Invalid bool
+	}{
+		{"valid", "/backfills/id", http.StatusNotFound, "application/json", `{"message": "backfill id was not found"}`, false},
+		{"implicit status", "/backfills/id", 0, "application/json", `{"id": "id", "total": 1}`, false},
+		{"undocumented status", "/backfills/id", http.StatusTeapot, "application/json", `{}`, true},
+		{"invalid body", "/backfills/id", http.StatusOK, "application/json; charset=utf-8", `{"total": "many"}`, true},
+		{"diff", "/v2/diffs/3f1c2d4e-8d6a-4a0e-9a67-2e9c7f3b1a2b", http.StatusOK, "text/vnd.graphviz", `digraph {}`, false},
+		{"unknown route", "/missing/route/", http.StatusNotFound, "text/plain", `404 page not found`, false},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			var logs bytes.Buffer
+			handler := ValidateResponses(v, bufferLogger(&logs))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.Type)
+				if tt.Status != 0 {
+					w.WriteHeader(tt.Status)
+				}
+				_, _ = w.Write([]byte(tt.Body))
+			}))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.Target, nil))
+
+			// responses are sent as written, whether they are valid or not
+			body, _ := ioutil.ReadAll(w.Body)
+			assert.Equal(t, tt.Body, string(body))
+			if tt.Invalid {
+				assert.Contains(t, logs.String(), "invalid-response")
+				return
+			}
+			assert.Empty(t, logs.String())
+		})
+	}
+}
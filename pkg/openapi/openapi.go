@@ -0,0 +1,133 @@
+package openapi
+
+//go:generate go run gen.go
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi2"
+	"github.com/getkin/kin-openapi/openapi2conv"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/invopop/yaml"
+)
+
+// ErrUnknownRoute is returned when a request matches no operation of the API document.
+type ErrUnknownRoute struct {
+	Method string
+	Path   string
+}
+
+func (e ErrUnknownRoute) Error() string {
+	return fmt.Sprintf("%s %s is not in the API document", e.Method, e.Path)
+}
+
+// Validator validates requests and responses against the API document of the service.
+type Validator struct {
+	doc    *openapi3.T
+	router routers.Router
+}
+
+// NewValidator returns a Validator of the API document of the service.
+func NewValidator() (*Validator, error) {
+	return newValidator([]byte(specYAML))
+}
+
+// newValidator returns a Validator of the given Swagger 2.0 document, which is converted to
+// OpenAPI 3 to be validated.
+func newValidator(spec []byte) (*Validator, error) {
+	var swagger openapi2.T
+	if err := yaml.Unmarshal(spec, &swagger); err != nil {
+		return nil, err
+	}
+	doc, err := openapi2conv.ToV3(&swagger)
+	if err != nil {
+		return nil, err
+	}
+	if err = doc.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &Validator{doc: doc, router: router}, nil
+}
+
+// Operations returns the method and path of every operation of the API document, such as
+// "GET /diffs", in the syntax of the patterns of the router of the service.
+func (v *Validator) Operations() []string {
+	var operations []string
+	for path, item := range v.doc.Paths {
+		for method := range item.Operations() {
+			operations = append(operations, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+// Route returns the method and path of the operation of a request, or an error of type
+// ErrUnknownRoute if no operation matches it.
+func (v *Validator) Route(r *http.Request) (string, error) {
+	route, _, err := v.findRoute(r)
+	if err != nil {
+		return "", err
+	}
+	return route.Method + " " + route.Path, nil
+}
+
+func (v *Validator) findRoute(r *http.Request) (*routers.Route, map[string]string, error) {
+	route, params, err := v.router.FindRoute(r)
+	if err != nil {
+		return nil, nil, ErrUnknownRoute{Method: r.Method, Path: r.URL.Path}
+	}
+	return route, params, nil
+}
+
+// ValidateRequest returns an error if the parameters or body of a request do not match its
+// operation, or an error of type ErrUnknownRoute if no operation matches it. The body of the
+// request can still be read afterwards.
+func (v *Validator) ValidateRequest(r *http.Request) error {
+	route, params, err := v.findRoute(r)
+	if err != nil {
+		return err
+	}
+	return openapi3filter.ValidateRequest(r.Context(), &openapi3filter.RequestValidationInput{
+		Request:    r,
+		PathParams: params,
+		Route:      route,
+		Options:    &openapi3filter.Options{SkipSettingDefaults: true},
+	})
+}
+
+// ValidateResponse returns an error if a response to a request is not one of the responses
+// of its operation, or if its headers or body do not match. A nil body is not validated, so
+// that large responses need not be kept.
+func (v *Validator) ValidateResponse(r *http.Request, status int, header http.Header, body []byte) error {
+	route, params, err := v.findRoute(r)
+	if err != nil {
+		return err
+	}
+	input := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: params,
+			Route:      route,
+		},
+		Status: status,
+		Header: header,
+		Options: &openapi3filter.Options{
+			IncludeResponseStatus: true,
+			ExcludeResponseBody:   body == nil,
+		},
+	}
+	input.SetBodyBytes(body)
+	return openapi3filter.ValidateResponse(r.Context(), input)
+}
@@ -0,0 +1,98 @@
+package openapi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpecIsGenerated(t *testing.T) {
+	spec, err := ioutil.ReadFile("../../api.yaml")
+	require.Nil(t, err)
+	require.Equal(t, string(spec), specYAML, "api.yaml has changed, run go generate ./pkg/openapi")
+}
+
+func TestNewValidator(t *testing.T) {
+	v, err := NewValidator()
+	require.Nil(t, err)
+	assert.Contains(t, v.Operations(), "POST /{topic}/{event}")
+	assert.Contains(t, v.Operations(), "GET /v2/diffs/{id}")
+
+	_, err = newValidator([]byte("swagger: ["))
+	assert.NotNil(t, err)
+	_, err = newValidator([]byte(`{"swagger": "2.0", "paths": {"/": {"get": {"responses": {"200": {"$ref": "#/responses/missing"}}}}}}`))
+	assert.NotNil(t, err)
+}
+
+const validRange = "previous_start=2019-01-01T00:00:00Z&previous_stop=2019-01-01T01:00:00Z&next_start=2019-01-01T01:00:00Z&next_stop=2019-01-01T02:00:00Z"
+
+func TestValidateRequest(t *testing.T) {
+	v, err := NewValidator()
+	require.Nil(t, err)
+
+	tc := []struct {
+		Name   string
+		Method string
+		Target string
+		Body   string
+		Valid  bool
+	}{
+		{"v1 range", http.MethodPost, "/?" + validRange, "", true},
+		{"v1 missing parameter", http.MethodPost, "/?previous_start=2019-01-01T00:00:00Z", "", false},
+		{"v1 invalid time", http.MethodGet, "/?previous_start=yesterday&previous_stop=2019-01-01T01:00:00Z&next_start=2019-01-01T01:00:00Z&next_stop=2019-01-01T02:00:00Z", "", false},
+		{"v1 invalid limit", http.MethodGet, "/diffs?limit=0", "", false},
+		{"v2 body", http.MethodPost, "/v2/diffs", `{"previous": {"start": "2019-01-01T00:00:00Z", "stop": "2019-01-01T01:00:00Z"},
+			"next": {"start": "2019-01-01T01:00:00Z", "stop": "2019-01-01T02:00:00Z"}}`, true},
+		{"v2 missing window", http.MethodPost, "/v2/diffs", `{"previous": {"start": "2019-01-01T00:00:00Z", "stop": "2019-01-01T01:00:00Z"}}`, false},
+		{"v2 invalid format", http.MethodGet, "/v2/diffs/3f1c2d4e-8d6a-4a0e-9a67-2e9c7f3b1a2b?format=bzip2", "", false},
+		{"job", http.MethodPost, "/diffs/create", `{"id": "id", "previousStart": "2019-01-01T00:00:00Z", "previousStop": "2019-01-01T01:00:00Z",
+			"nextStart": "2019-01-01T01:00:00Z", "nextStop": "2019-01-01T02:00:00Z"}`, true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.Method, tt.Target, bytes.NewBufferString(tt.Body))
+			if tt.Body != "" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+			err := v.ValidateRequest(r)
+			if !tt.Valid {
+				assert.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			// the body is still readable by the handler
+			body, err := ioutil.ReadAll(r.Body)
+			require.Nil(t, err)
+			assert.Equal(t, tt.Body, string(body))
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodPut, "/diffs", nil)
+	assert.IsType(t, ErrUnknownRoute{}, v.ValidateRequest(r))
+	_, err = v.Route(r)
+	assert.IsType(t, ErrUnknownRoute{}, err)
+}
+
+func TestValidateResponse(t *testing.T) {
+	v, err := NewValidator()
+	require.Nil(t, err)
+	json := http.Header{"Content-Type": []string{"application/json"}}
+
+	r := httptest.NewRequest(http.MethodGet, "/backfills/id", nil)
+	route, err := v.Route(r)
+	require.Nil(t, err)
+	assert.Equal(t, "GET /backfills/{id}", route)
+	assert.Nil(t, v.ValidateResponse(r, http.StatusNotFound, json, []byte(`{"message": "backfill id was not found"}`)))
+	assert.Nil(t, v.ValidateResponse(r, http.StatusOK, json, nil))
+	assert.NotNil(t, v.ValidateResponse(r, http.StatusOK, json, []byte(`{"total": "many"}`)))
+	assert.NotNil(t, v.ValidateResponse(r, http.StatusTeapot, json, nil))
+
+	r = httptest.NewRequest(http.MethodPost, "/v2/diffs", nil)
+	assert.NotNil(t, v.ValidateResponse(r, http.StatusBadRequest, json, []byte(`{"code": "SOMETHING_ELSE"}`)))
+	assert.IsType(t, ErrUnknownRoute{}, v.ValidateResponse(httptest.NewRequest(http.MethodPut, "/diffs", nil), http.StatusOK, json, nil))
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/tracing"
 )
 
 type payload struct {
@@ -18,6 +19,16 @@ type payload struct {
 	PreviousStop  string `json:"previousStop"`
 	NextStart     string `json:"nextStart"`
 	NextStop      string `json:"nextStop"`
+	TTL           int64  `json:"ttl,omitempty"` // milliseconds
+
+	// Tenant and Accounts are the scope of the diff. They are omitted for diffs of the zero
+	// scope, so that workers which predate tenancy can still produce them.
+	Tenant   string   `json:"tenant,omitempty"`
+	Accounts []string `json:"accounts,omitempty"`
+
+	// Trace is the trace context of the request which queued the job. It is sent in the
+	// payload as well as the headers, as the streaming appliance may not forward headers.
+	Trace map[string]string `json:"trace,omitempty"`
 }
 
 // DiffQueuer is a Queuer implementation which queues graph jobs onto a streaming appliance
@@ -34,6 +45,10 @@ func (q *DiffQueuer) Queue(ctx context.Context, diff domain.Diff) error {
 		PreviousStop:  diff.PreviousStop.Format(time.RFC3339Nano),
 		NextStart:     diff.NextStart.Format(time.RFC3339Nano),
 		NextStop:      diff.NextStop.Format(time.RFC3339Nano),
+		TTL:           int64(diff.TTL / time.Millisecond),
+		Tenant:        diff.Scope.Tenant,
+		Accounts:      diff.Scope.Accounts,
+		Trace:         tracing.InjectMap(ctx),
 	}
 	rawBody, _ := json.Marshal(body)
 	req, err := http.NewRequest(http.MethodPost, q.Endpoint.String(), bytes.NewReader(rawBody))
@@ -41,6 +56,7 @@ func (q *DiffQueuer) Queue(ctx context.Context, diff domain.Diff) error {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/octet-stream")
+	tracing.Inject(ctx, req.Header)
 	res, err := q.Client.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
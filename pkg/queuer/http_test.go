@@ -2,6 +2,7 @@ package queuer
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
@@ -10,8 +11,10 @@ import (
 	"time"
 
 	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/tracing"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 const (
@@ -41,6 +44,90 @@ func TestDiffQueuer(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestDiffQueuerTTL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRT := NewMockRoundTripper(ctrl)
+	mockRT.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		var body payload
+		assert.Nil(t, json.NewDecoder(req.Body).Decode(&body))
+		assert.Equal(t, int64(60000), body.TTL)
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(nil)}, nil
+	})
+
+	endpoint, _ := url.Parse(endpoint)
+	client := &http.Client{Transport: mockRT}
+	dq := DiffQueuer{
+		Client:   client,
+		Endpoint: endpoint,
+	}
+	err := dq.Queue(context.Background(), domain.Diff{
+		ID:            diffID,
+		PreviousStart: time.Now(),
+		PreviousStop:  time.Now(),
+		NextStart:     time.Now(),
+		NextStop:      time.Now(),
+		TTL:           time.Minute,
+	})
+	assert.Nil(t, err)
+}
+
+func TestDiffQueuerScope(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRT := NewMockRoundTripper(ctrl)
+	mockRT.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		var body payload
+		assert.Nil(t, json.NewDecoder(req.Body).Decode(&body))
+		assert.Equal(t, "acme", body.Tenant)
+		assert.Equal(t, []string{"1", "2"}, body.Accounts)
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(nil)}, nil
+	})
+
+	endpoint, _ := url.Parse(endpoint)
+	client := &http.Client{Transport: mockRT}
+	dq := DiffQueuer{
+		Client:   client,
+		Endpoint: endpoint,
+	}
+	err := dq.Queue(context.Background(), domain.Diff{
+		ID:            diffID,
+		PreviousStart: time.Now(),
+		PreviousStop:  time.Now(),
+		NextStart:     time.Now(),
+		NextStop:      time.Now(),
+		Scope:         domain.NewScope("acme", []string{"2", "1"}),
+	})
+	assert.Nil(t, err)
+}
+
+func TestDiffQueuerTrace(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	provider := sdktrace.NewTracerProvider()
+	ctx, span := provider.Tracer("test").Start(context.Background(), "post")
+	defer span.End()
+	traceparent := tracing.InjectMap(ctx)["traceparent"]
+	assert.NotEmpty(t, traceparent)
+
+	mockRT := NewMockRoundTripper(ctrl)
+	mockRT.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		var body payload
+		assert.Nil(t, json.NewDecoder(req.Body).Decode(&body))
+		assert.Equal(t, traceparent, body.Trace["traceparent"])
+		assert.Equal(t, traceparent, req.Header.Get("traceparent"))
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(nil)}, nil
+	})
+
+	endpoint, _ := url.Parse(endpoint)
+	dq := DiffQueuer{
+		Client:   &http.Client{Transport: mockRT},
+		Endpoint: endpoint,
+	}
+	assert.Nil(t, dq.Queue(ctx, domain.Diff{ID: diffID}))
+}
+
 func TestUnexpectedResponse(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
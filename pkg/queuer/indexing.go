@@ -0,0 +1,44 @@
+package queuer
+
+import (
+	"context"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+)
+
+// Indexing is an implementation of domain.Queuer which decorates another Queuer so that queued
+// diffs are listed before they are created. Each diff is indexed with the
+// domain.DiffStatusQueued status before it is queued, unless it is already complete, as a diff
+// which is being created again is still served until it is replaced. Diffs are queued without
+// being indexed if the Storage does not record metadata.
+type Indexing struct {
+	Queuer  domain.Queuer
+	Storage domain.IndexedStorage
+}
+
+// Queue indexes the diff, then queues it.
+func (q *Indexing) Queue(ctx context.Context, d domain.Diff) error {
+	meta, err := q.Storage.Metadata(ctx, d.Key())
+	switch err.(type) {
+	case nil, domain.ErrNotFound:
+	case domain.ErrUnsupported:
+		return q.Queuer.Queue(ctx, d)
+	default:
+		return err
+	}
+	if meta.Status != domain.DiffStatusComplete {
+		queued := domain.DiffMetadata{
+			Diff:    d,
+			Created: time.Now(),
+			Status:  domain.DiffStatusQueued,
+		}
+		if d.TTL > 0 {
+			queued.Expires = queued.Created.Add(d.TTL)
+		}
+		if err := q.Storage.Index(ctx, queued); err != nil {
+			return err
+		}
+	}
+	return q.Queuer.Queue(ctx, d)
+}
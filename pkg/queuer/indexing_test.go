@@ -0,0 +1,79 @@
+package queuer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexingQueue(t *testing.T) {
+	stop := time.Now()
+	diff := domain.NewDiff(stop.Add(-2*time.Hour), stop.Add(-time.Hour), stop.Add(-time.Hour), stop)
+	tests := []struct {
+		Name        string
+		Metadata    domain.DiffMetadata
+		MetadataErr error
+		Indexed     bool
+	}{
+		{"new", domain.DiffMetadata{}, domain.ErrNotFound{ID: diff.ID}, true},
+		{"expired", domain.DiffMetadata{Diff: diff, Status: domain.DiffStatusExpired}, nil, true},
+		{"queued again", domain.DiffMetadata{Diff: diff, Status: domain.DiffStatusQueued}, nil, true},
+		{"complete", domain.DiffMetadata{Diff: diff, Status: domain.DiffStatusComplete}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockQueuer := NewMockQueuer(ctrl)
+			mockStorage := NewMockStorage(ctrl)
+			mockStorage.EXPECT().Metadata(gomock.Any(), diff.Key()).Return(tt.Metadata, tt.MetadataErr)
+			if tt.Indexed {
+				mockStorage.EXPECT().Index(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, meta domain.DiffMetadata) error {
+					assert.Equal(t, diff, meta.Diff)
+					assert.Equal(t, domain.DiffStatusQueued, meta.Status)
+					return nil
+				})
+			}
+			mockQueuer.EXPECT().Queue(gomock.Any(), diff).Return(nil)
+
+			q := &Indexing{Queuer: mockQueuer, Storage: mockStorage}
+			assert.Nil(t, q.Queue(context.Background(), diff))
+		})
+	}
+}
+
+func TestIndexingQueueUnsupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stop := time.Now()
+	diff := domain.NewDiff(stop.Add(-2*time.Hour), stop.Add(-time.Hour), stop.Add(-time.Hour), stop)
+	mockQueuer := NewMockQueuer(ctrl)
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Metadata(gomock.Any(), diff.Key()).Return(domain.DiffMetadata{}, domain.ErrUnsupported{Operation: "Metadata"})
+	mockQueuer.EXPECT().Queue(gomock.Any(), diff).Return(nil)
+
+	q := &Indexing{Queuer: mockQueuer, Storage: mockStorage}
+	assert.Nil(t, q.Queue(context.Background(), diff))
+}
+
+func TestIndexingQueueIndexError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stop := time.Now()
+	diff := domain.NewDiff(stop.Add(-2*time.Hour), stop.Add(-time.Hour), stop.Add(-time.Hour), stop)
+	mockQueuer := NewMockQueuer(ctrl)
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Metadata(gomock.Any(), diff.Key()).Return(domain.DiffMetadata{}, domain.ErrNotFound{ID: diff.ID})
+	mockStorage.EXPECT().Index(gomock.Any(), gomock.Any()).Return(errors.New(""))
+
+	q := &Indexing{Queuer: mockQueuer, Storage: mockStorage}
+	assert.NotNil(t, q.Queue(context.Background(), diff))
+}
@@ -1,26 +1,43 @@
 package diffd
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
-	"strconv"
+	"sync"
 	"time"
 
+	"github.com/asecurityteam/settings"
 	"github.com/asecurityteam/transport"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/auth"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/backfill"
 	"github.com/asecurityteam/vpcflow-diffd/pkg/differ"
 	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
 	"github.com/asecurityteam/vpcflow-diffd/pkg/grapher"
 	v1 "github.com/asecurityteam/vpcflow-diffd/pkg/handlers/v1"
+	v2 "github.com/asecurityteam/vpcflow-diffd/pkg/handlers/v2"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/health"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/logs"
 	"github.com/asecurityteam/vpcflow-diffd/pkg/marker"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/metrics"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/openapi"
 	"github.com/asecurityteam/vpcflow-diffd/pkg/queuer"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/scheduler"
 	"github.com/asecurityteam/vpcflow-diffd/pkg/storage"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/tracing"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/go-chi/chi"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Service is a container for all of the pluggable modules used by the service
@@ -52,22 +69,100 @@ type Service struct {
 	// Grapher is responsible for creating a graph of VPC logs for a given time range.
 	// The built in grapher calls out to a grapher service.
 	Grapher domain.Grapher
+
+	// Authorizer decides the scope of the diffs the caller of each request to the public API
+	// may access. If no Authorizer is given and tenancy is enabled, the built in Authorizer
+	// permits the tenant and accounts named by the identity headers of the request. If tenancy
+	// is not enabled either, every caller may access all diffs.
+	Authorizer domain.Authorizer
+
+	// TracerProvider provides the tracer with which every module is traced. If no provider
+	// is given, the configured exporter is used, and tracing is disabled if it is unset.
+	TracerProvider trace.TracerProvider
+
+	// Config holds the settings of the built in modules. If no Config is given, it is loaded
+	// from the environment.
+	Config *Config
+
+	// differ creates the diffs consumed by the Produce handler
+	differ domain.Differ
+
+	// tracer starts the server span of each request
+	tracer trace.Tracer
+
+	// api and worker are whether the public API and the worker routes are mounted
+	api    bool
+	worker bool
+
+	// checks are the dependencies probed by the readiness check, by name
+	checks map[string]domain.Checker
+
+	// scheduler queues the recurring diffs, and reports their state
+	scheduler *scheduler.Scheduler
+
+	// backfiller queues the diffs of historical ranges
+	backfiller *backfill.Backfiller
+
+	// validator validates requests and responses against the API document
+	validator *openapi.Validator
+
+	// heartbeatInterval is how often the Produce handler renews its lease on a diff
+	heartbeatInterval time.Duration
+
+	// jobs are run in the background from Start until Stop
+	jobs    []func(ctx context.Context)
+	stop    context.CancelFunc
+	running sync.WaitGroup
+
+	// shutdownTracing flushes and closes the exporter of the built in TracerProvider
+	shutdownTracing func(ctx context.Context) error
+
+	// deprecated are the deprecated environment variables the Config was loaded from, which
+	// are logged by Start
+	deprecated []logs.Deprecated
 }
 
 func (s *Service) init() error {
 	var err error
-	storageClient, err := createS3Client(mustEnv("DIFF_STORAGE_BUCKET_REGION"))
-	if err != nil {
-		return err
+	if s.Config == nil {
+		var environ []string
+		environ, s.deprecated = MigrateEnviron(os.Environ())
+		source, err := settings.NewEnvSource(environ)
+		if err != nil {
+			return err
+		}
+		s.Config, err = LoadConfig(context.Background(), source)
+		if err != nil {
+			return err
+		}
 	}
-	progressClient, err := createS3Client(mustEnv("DIFF_PROGRESS_BUCKET_REGION"))
+	conf := s.Config
+	api, worker, err := modes(conf.Service.Mode)
 	if err != nil {
 		return err
 	}
+	// the background jobs act on the shared buckets, so they only need to run on one of the
+	// instances serving the API
+	jobs := api && conf.Service.Jobs
+	// custom modules are probed by the readiness check if they can be, and the built in
+	// modules are probed through the buckets and endpoints they depend on
+	s.checks = make(map[string]domain.Checker)
+	for name, module := range map[string]interface{}{
+		logs.DependencyQueuer:  s.Queuer,
+		logs.DependencyStorage: s.Storage,
+		logs.DependencyMarker:  s.Marker,
+		logs.DependencyGrapher: s.Grapher,
+	} {
+		if checker, ok := module.(domain.Checker); ok {
+			s.checks[name] = checker
+		}
+	}
 
-	if s.Queuer == nil {
-		streamApplianceEndpoint := mustEnv("STREAM_APPLIANCE_ENDPOINT")
-		streamApplianceURL, err := url.Parse(streamApplianceEndpoint)
+	if api && s.Queuer == nil {
+		if err := required("STREAM_APPLIANCE_ENDPOINT", conf.Stream.Appliance.Endpoint); err != nil {
+			return err
+		}
+		streamApplianceURL, err := url.Parse(conf.Stream.Appliance.Endpoint)
 		if err != nil {
 			return err
 		}
@@ -78,105 +173,577 @@ func (s *Service) init() error {
 			Client:   s.QueuerHTTPClient,
 			Endpoint: streamApplianceURL,
 		}
+		s.checks[logs.DependencyQueuer] = &health.HTTPEndpoint{
+			Client:   s.QueuerHTTPClient,
+			Endpoint: streamApplianceURL,
+		}
+	}
+	var progressClient *s3.S3
+	if s.Storage == nil || s.Marker == nil {
+		if err := required("DIFF_PROGRESS_BUCKET", conf.Diff.Progress.Bucket); err != nil {
+			return err
+		}
+		progressClient, err = createS3Client(conf.AWS, "DIFF_PROGRESS_REGION", conf.Diff.Progress.Region)
+		if err != nil {
+			return err
+		}
 	}
+	retention, sweeper := retentionPolicy(conf.Diff.Retention)
 	if s.Storage == nil {
-		progressTimeoutStr := mustEnv("DIFF_PROGRESS_TIMEOUT")
-		progressTimeoutInt, err := strconv.Atoi(progressTimeoutStr)
+		if err := required("DIFF_STORAGE_BUCKET", conf.Diff.Storage.Bucket); err != nil {
+			return err
+		}
+		if err := storage.ValidateEncoding(conf.Diff.Storage.Encoding); err != nil {
+			return err
+		}
+		storageClient, err := createS3Client(conf.AWS, "DIFF_STORAGE_REGION", conf.Diff.Storage.Region)
 		if err != nil {
 			return err
 		}
+		s.checks[logs.DependencyStorage] = &health.S3Bucket{
+			Bucket: conf.Diff.Storage.Bucket,
+			Client: storageClient,
+		}
+		s3Storage := &storage.S3{
+			Bucket:    conf.Diff.Storage.Bucket,
+			Client:    storageClient,
+			Encoding:  conf.Diff.Storage.Encoding,
+			Retention: retention,
+		}
+		// If requested, the maximum age of diffs stored in S3 is enforced by a lifecycle rule
+		// of the bucket, so the sweeper only needs to enforce the rest of the policy.
+		if conf.Diff.Retention.Lifecycle && retention.MaxAge > 0 {
+			if jobs {
+				s.jobs = append(s.jobs, applyRetention(s3Storage))
+			}
+			sweeper.Policy.MaxAge = 0
+		}
+		sweeper.Storage = &storage.InProgress{
+			Bucket:  conf.Diff.Progress.Bucket,
+			Client:  progressClient,
+			Storage: s3Storage,
+			Timeout: conf.Diff.Progress.Timeout,
+		}
 		s.Storage = &storage.InProgress{
-			Bucket: mustEnv("DIFF_PROGRESS_BUCKET"),
+			Bucket: conf.Diff.Progress.Bucket,
 			Client: progressClient,
-			Storage: &storage.S3{
-				Bucket: mustEnv("DIFF_STORAGE_BUCKET"),
-				Client: storageClient,
+			Storage: &storage.Retention{
+				Storage: s3Storage,
+				Policy:  retention,
 			},
-			Timeout: time.Millisecond * time.Duration(progressTimeoutInt),
+			Timeout: conf.Diff.Progress.Timeout,
+		}
+	} else {
+		// custom Storage which does not record metadata can't be swept
+		if _, ok := s.Storage.(domain.IndexedStorage); ok {
+			sweeper.Storage = s.Storage
 		}
+		s.Storage = &storage.Retention{
+			Storage: s.Storage,
+			Policy:  retention,
+		}
+	}
+	// the sweeper also removes the diffs stored with their own ttl, so it runs even without
+	// a policy
+	if jobs && sweeper.Storage != nil && sweeper.Interval > 0 {
+		s.jobs = append(s.jobs, sweeper.Run)
 	}
 	if s.Marker == nil {
-		s.Marker = &marker.ProgressMarker{
-			Bucket: mustEnv("DIFF_PROGRESS_BUCKET"),
+		s.Marker = &marker.LeaseMarker{
+			Bucket: conf.Diff.Progress.Bucket,
+			Client: progressClient,
+			TTL:    conf.Diff.Progress.Timeout,
+		}
+		s.checks[logs.DependencyMarker] = &health.S3Bucket{
+			Bucket: conf.Diff.Progress.Bucket,
 			Client: progressClient,
 		}
 	}
-	if s.Grapher == nil {
-		grapherEndpoint := mustEnv("GRAPHER_ENDPOINT")
-		grapherURL, err := url.Parse(grapherEndpoint)
+	if worker && s.Grapher == nil {
+		s.Grapher, err = s.defaultGrapher(conf.AWS, conf.Grapher)
 		if err != nil {
 			return err
 		}
-		durationStr := mustEnv("GRAPHER_POLLING_TIMEOUT")
-		durationMs, err := strconv.Atoi(durationStr)
+		s.Grapher, err = cacheGrapher(s.Grapher, conf.AWS, conf.Grapher)
 		if err != nil {
 			return err
 		}
-		intervalStr := mustEnv("GRAPHER_POLLING_INTERVAL")
-		intervalMs, err := strconv.Atoi(intervalStr)
+	}
+	if s.TracerProvider == nil {
+		s.TracerProvider, s.shutdownTracing, err = tracerProvider(conf.Tracing)
 		if err != nil {
 			return err
 		}
-		if s.GrapherHTTPClient == nil {
-			s.GrapherHTTPClient = defaultHTTPClient()
+	}
+	tracer := s.TracerProvider.Tracer(tracing.InstrumentationName)
+
+	// every component is traced and records metrics, including custom components. The
+	// built-in Marker is kept before it is decorated, as its TTL and bucket configure the
+	// worker and reaper.
+	lm, isLeaseMarker := s.Marker.(*marker.LeaseMarker)
+	s.Storage = &metrics.Storage{
+		Storage:      &tracing.Storage{Storage: s.Storage, Tracer: tracer},
+		StatProvider: domain.StatFromContext,
+	}
+	s.Marker = metrics.NewMarker(tracing.NewMarker(s.Marker, tracer), domain.StatFromContext)
+	if s.Queuer != nil {
+		s.Queuer = &metrics.Queuer{
+			Queuer:       &tracing.Queuer{Queuer: s.Queuer, Tracer: tracer},
+			StatProvider: domain.StatFromContext,
 		}
-		s.Grapher = &grapher.HTTP{
-			Client:          s.GrapherHTTPClient,
-			Endpoint:        grapherURL,
-			PollTimeout:     time.Duration(durationMs) * time.Millisecond,
-			PollingInterval: time.Duration(intervalMs) * time.Millisecond,
+		// queued diffs are listed before they are created
+		s.Queuer = &queuer.Indexing{
+			Queuer:  s.Queuer,
+			Storage: s.Storage.(domain.IndexedStorage),
+		}
+	}
+	if s.Grapher != nil {
+		s.Grapher = &metrics.Grapher{
+			Grapher:      &tracing.Grapher{Grapher: s.Grapher, Tracer: tracer},
+			StatProvider: domain.StatFromContext,
+		}
+	}
+	if api {
+		s.scheduler, err = newScheduler(conf.Scheduler, progressClient, conf.Diff.Progress.Bucket)
+		if err != nil {
+			return err
+		}
+		s.scheduler.Storage = s.Storage
+		s.scheduler.Queuer = s.Queuer
+		s.scheduler.Marker = s.Marker
+		if jobs && len(s.scheduler.Schedules) > 0 {
+			s.jobs = append(s.jobs, s.scheduler.Run)
+		}
+		s.backfiller = newBackfiller(conf.Backfill, progressClient, conf.Diff.Progress.Bucket)
+		s.backfiller.Storage = s.Storage
+		s.backfiller.Queuer = s.Queuer
+		s.backfiller.Marker = s.Marker
+		// backfills kept in memory end with the instance, so there are none to resume
+		if jobs && progressClient != nil {
+			s.jobs = append(s.jobs, s.backfiller.Run)
+		}
+	}
+	if worker {
+		s.differ = &metrics.Differ{
+			Differ: &tracing.Differ{
+				Differ: &differ.DOTDiffer{
+					Grapher:      s.Grapher,
+					StatProvider: domain.StatFromContext,
+				},
+				Tracer: tracer,
+			},
+			StatProvider: domain.StatFromContext,
+		}
+	}
+	if s.Authorizer == nil && conf.Tenancy.Enabled {
+		s.Authorizer = &auth.Headers{
+			TenantHeader:   conf.Tenancy.TenantHeader,
+			AccountsHeader: conf.Tenancy.AccountsHeader,
+		}
+	}
+	if conf.Validation.Requests || conf.Validation.Responses {
+		s.validator, err = openapi.NewValidator()
+		if err != nil {
+			return err
+		}
+	}
+	s.tracer = tracer
+	s.api = api
+	s.worker = worker
+	if isLeaseMarker {
+		// renew leases several times within their TTL, so that a single failed renewal
+		// does not lose the lease
+		s.heartbeatInterval = lm.TTL / 3
+		if reaper := s.reaper(lm, conf.Diff.Progress.Reap); jobs && reaper != nil {
+			s.jobs = append(s.jobs, reaper.Run)
 		}
 	}
 	return nil
 }
 
+// modes returns whether the public API and the worker are enabled in the given mode.
+func modes(mode string) (api bool, worker bool, err error) {
+	switch mode {
+	case ModeAll:
+		return true, true, nil
+	case ModeAPI:
+		return true, false, nil
+	case ModeWorker:
+		return false, true, nil
+	}
+	return false, false, fmt.Errorf("unsupported mode %q", mode)
+}
+
+// reaper returns the Reaper which removes the expired markers of the LeaseMarker, or nil if no
+// reap interval is configured. Abandoned diffs are only queued again if requested.
+func (s *Service) reaper(lm *marker.LeaseMarker, conf *ReapConfig) *marker.Reaper {
+	if conf.Interval <= 0 {
+		return nil
+	}
+	reaper := &marker.Reaper{
+		Marker:       lm,
+		LogProvider:  domain.LoggerFromContext,
+		StatProvider: domain.StatFromContext,
+		Interval:     conf.Interval,
+		MaxRequeues:  conf.MaxRequeues,
+	}
+	if conf.Requeue {
+		reaper.Queuer = s.Queuer
+	}
+	return reaper
+}
+
+// newScheduler returns the Scheduler of the configured schedules. The last run of each
+// schedule is kept in the progress bucket if there is one, so that runs missed during downtime
+// are caught up, and in memory otherwise.
+func newScheduler(conf *SchedulerConfig, progressClient *s3.S3, progressBucket string) (*scheduler.Scheduler, error) {
+	schedules, err := scheduler.ParseSchedules(conf.Schedules)
+	if err != nil {
+		return nil, err
+	}
+	var store scheduler.Store = &scheduler.MemoryStore{}
+	if progressClient != nil {
+		store = &scheduler.S3Store{
+			Bucket: progressBucket,
+			Client: progressClient,
+		}
+	}
+	return &scheduler.Scheduler{
+		Schedules:    schedules,
+		Store:        store,
+		LogProvider:  domain.LoggerFromContext,
+		StatProvider: domain.StatFromContext,
+		Interval:     conf.Interval,
+		MaxCatchUp:   conf.MaxCatchUp,
+	}, nil
+}
+
+// newBackfiller returns the Backfiller of the service. The progress of backfills is kept in the
+// progress bucket if there is one, so that it can be followed through any instance, and in
+// memory otherwise.
+func newBackfiller(conf *BackfillConfig, progressClient *s3.S3, progressBucket string) *backfill.Backfiller {
+	var store backfill.Store = &backfill.MemoryStore{}
+	if progressClient != nil {
+		store = &backfill.S3Store{
+			Bucket: progressBucket,
+			Client: progressClient,
+		}
+	}
+	return &backfill.Backfiller{
+		Store:          store,
+		LogProvider:    domain.LoggerFromContext,
+		StatProvider:   domain.StatFromContext,
+		MaxConcurrency: conf.MaxConcurrency,
+		MaxDiffs:       conf.MaxDiffs,
+	}
+}
+
+// tracerProvider returns the TracerProvider which exports spans to the configured exporter,
+// and a function which flushes the exporter and closes its output. The "stdout" exporter writes
+// spans to stdout, and the "file" exporter appends them to the configured file. Both write each
+// span as soon as it ends, in the JSON format of the OpenTelemetry stdout exporter rather than
+// in OTLP. If no exporter is set, a provider which discards all spans is returned.
+func tracerProvider(conf *TracingConfig) (trace.TracerProvider, func(ctx context.Context) error, error) {
+	var out io.Writer
+	var closer io.Closer
+	switch conf.Exporter {
+	case "":
+		return trace.NewNoopTracerProvider(), func(context.Context) error { return nil }, nil
+	case "stdout":
+		out = os.Stdout
+	case "file":
+		if err := required("TRACING_FILE", conf.File); err != nil {
+			return nil, nil, err
+		}
+		f, err := os.OpenFile(conf.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = f
+		closer = f
+	default:
+		return nil, nil, fmt.Errorf("unsupported tracing exporter %q", conf.Exporter)
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(out))
+	if err != nil {
+		return nil, nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "vpcflow-diffd"))),
+	)
+	shutdown := func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			if closeErr := closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}
+	return provider, shutdown, nil
+}
+
+// applyRetention returns a job which installs the lifecycle rule of the retention policy on the
+// bucket of the S3 storage. The rule is installed once, when the jobs start.
+func applyRetention(s3Storage *storage.S3) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		if err := s3Storage.ApplyRetention(ctx); err != nil {
+			domain.LoggerFromContext(ctx).Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		}
+	}
+}
+
+// retentionPolicy returns the retention policy of stored diffs, and the Sweeper which enforces
+// it. The Sweeper is only run if any part of the policy is configured.
+func retentionPolicy(conf *RetentionConfig) (domain.RetentionPolicy, *storage.Sweeper) {
+	policy := domain.RetentionPolicy{
+		MaxAge:   conf.MaxAge,
+		MaxCount: conf.MaxCount,
+	}
+	return policy, &storage.Sweeper{
+		LogProvider: domain.LoggerFromContext,
+		Policy:      policy,
+		Interval:    conf.SweepInterval,
+	}
+}
+
+// cacheGrapher decorates the Grapher with a cache if either a cache directory or a cache
+// bucket is configured. Otherwise, the Grapher is returned as-is.
+func cacheGrapher(g domain.Grapher, awsConf *AWSConfig, conf *GrapherConfig) (domain.Grapher, error) {
+	var store grapher.CacheStore
+	switch {
+	case conf.Cache.Directory != "":
+		store = &grapher.DiskCacheStore{Directory: conf.Cache.Directory}
+	case conf.Cache.Bucket != "":
+		cacheClient, err := createS3Client(awsConf, "GRAPHER_CACHE_REGION", conf.Cache.Region)
+		if err != nil {
+			return nil, err
+		}
+		store = &grapher.S3CacheStore{
+			Bucket: conf.Cache.Bucket,
+			Client: cacheClient,
+		}
+	default:
+		return g, nil
+	}
+	return &grapher.Cache{
+		Grapher:        g,
+		Store:          store,
+		StatProvider:   domain.StatFromContext,
+		SpoolDirectory: conf.Spool.Directory,
+		MaxBytes:       conf.Cache.MaxBytes,
+		TTL:            conf.Cache.TTL,
+	}, nil
+}
+
+// defaultGrapher creates the built in Grapher. If raw flow logs are configured, graphs are
+// built in-process from them. Otherwise, graphs are created by the grapher service.
+func (s *Service) defaultGrapher(awsConf *AWSConfig, conf *GrapherConfig) (domain.Grapher, error) {
+	if conf.FlowLog.Directory != "" {
+		return &grapher.Native{
+			Source:        &grapher.DirectorySource{Path: conf.FlowLog.Directory},
+			DeliveryDelay: conf.FlowLog.DeliveryDelay,
+		}, nil
+	}
+	if conf.FlowLog.Bucket != "" {
+		flowLogClient, err := createS3Client(awsConf, "GRAPHER_FLOWLOG_REGION", conf.FlowLog.Region)
+		if err != nil {
+			return nil, err
+		}
+		s.checks[logs.DependencyGrapher] = &health.S3Bucket{
+			Bucket: conf.FlowLog.Bucket,
+			Client: flowLogClient,
+		}
+		return &grapher.Native{
+			Source: &grapher.S3Source{
+				Bucket: conf.FlowLog.Bucket,
+				Prefix: conf.FlowLog.Prefix,
+				Client: flowLogClient,
+			},
+			DeliveryDelay: conf.FlowLog.DeliveryDelay,
+		}, nil
+	}
+	if err := required("GRAPHER_ENDPOINT", conf.Endpoint); err != nil {
+		return nil, err
+	}
+	grapherURL, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if s.GrapherHTTPClient == nil {
+		s.GrapherHTTPClient = defaultHTTPClient()
+	}
+	s.checks[logs.DependencyGrapher] = &health.HTTPEndpoint{
+		Client:   s.GrapherHTTPClient,
+		Endpoint: grapherURL,
+	}
+	var g domain.Grapher = &grapher.HTTP{
+		Client:          s.GrapherHTTPClient,
+		Endpoint:        grapherURL,
+		PollTimeout:     conf.Polling.Timeout,
+		PollingInterval: conf.Polling.Interval,
+		SpoolDirectory:  conf.Spool.Directory,
+	}
+	// If the grapher's bucket is configured, read graphs from it directly and only
+	// fall back to the grapher service for graphs which have not been created yet.
+	if conf.Storage.Bucket != "" {
+		grapherClient, err := createS3Client(awsConf, "GRAPHER_STORAGE_REGION", conf.Storage.Region)
+		if err != nil {
+			return nil, err
+		}
+		g = &grapher.S3{
+			Bucket:   conf.Storage.Bucket,
+			Client:   grapherClient,
+			Fallback: g,
+		}
+	}
+	return g, nil
+}
+
 // BindRoutes binds the service handlers to the provided router
 func (s *Service) BindRoutes(router chi.Router) error {
 	if err := s.init(); err != nil {
 		return err
 	}
-	diffHandler := &v1.DiffHandler{
-		LogProvider: domain.LoggerFromContext,
-		Queuer:      s.Queuer,
-		Storage:     s.Storage,
-		Marker:      s.Marker,
+	router.Use(s.Middleware...)
+	router.Use(tracing.Middleware(s.tracer))
+	// responses are validated outside of requests, so that rejected requests are too
+	if s.Config.Validation.Responses {
+		router.Use(openapi.ValidateResponses(s.validator, domain.LoggerFromContext))
+	}
+	if s.Config.Validation.Requests {
+		router.Use(openapi.ValidateRequests(s.validator, domain.LoggerFromContext))
 	}
-	produceHandler := &v1.Produce{
+	healthHandler := &v1.Health{
 		LogProvider: domain.LoggerFromContext,
-		Differ: &differ.DOTDiffer{
-			Grapher: s.Grapher,
-		},
-		Marker: s.Marker,
+		Checks:      s.checks,
+		Timeout:     s.Config.Health.Timeout,
+		CacheTTL:    s.Config.Health.CacheTTL,
+	}
+	router.Get("/healthcheck", healthHandler.Live)
+	router.Get("/ready", healthHandler.Ready)
+	if s.api {
+		diffHandler := &v1.DiffHandler{
+			LogProvider: domain.LoggerFromContext,
+			Queuer:      s.Queuer,
+			Storage:     s.Storage,
+			Marker:      s.Marker,
+			Authorizer:  s.Authorizer,
+		}
+		router.Post("/", diffHandler.Post)
+		router.Get("/", diffHandler.Get)
+		router.Delete("/", diffHandler.Delete)
+		router.Get("/diffs", diffHandler.List)
+		router.Post("/batch", diffHandler.Batch)
+		schedulesHandler := &v1.Schedules{
+			LogProvider: domain.LoggerFromContext,
+			Scheduler:   s.scheduler,
+			Authorizer:  s.Authorizer,
+		}
+		router.Get("/schedules", schedulesHandler.List)
+		backfillsHandler := &v1.Backfills{
+			LogProvider: domain.LoggerFromContext,
+			Backfiller:  s.backfiller,
+			Authorizer:  s.Authorizer,
+		}
+		router.Post("/backfills", backfillsHandler.Post)
+		router.Get("/backfills/{id}", backfillsHandler.Get)
+		v2DiffHandler := &v2.DiffHandler{
+			LogProvider: domain.LoggerFromContext,
+			Queuer:      s.Queuer,
+			Storage:     s.Storage,
+			Marker:      s.Marker,
+			Authorizer:  s.Authorizer,
+		}
+		router.Post("/v2/diffs", v2DiffHandler.Post)
+		router.Get("/v2/diffs/{id}", v2DiffHandler.Get)
+		router.Delete("/v2/diffs/{id}", v2DiffHandler.Delete)
+	}
+	if s.worker {
+		produceHandler := &v1.Produce{
+			LogProvider:       domain.LoggerFromContext,
+			Differ:            s.differ,
+			Marker:            s.Marker,
+			Storage:           s.Storage,
+			HeartbeatInterval: s.heartbeatInterval,
+		}
+		router.Post("/{topic}/{event}", produceHandler.ServeHTTP)
 	}
-	router.Use(s.Middleware...)
-	router.Post("/", diffHandler.Post)
-	router.Get("/", diffHandler.Get)
-	router.Post("/{topic}/{event}", produceHandler.ServeHTTP)
 	return nil
 }
 
-func mustEnv(key string) string {
-	val := os.Getenv(key)
-	if val == "" {
-		panic(fmt.Sprintf("%s is required", key))
+// Start starts the background jobs of the service, such as the retention sweeper, the marker
+// reaper and the scheduler. The jobs only run on instances which serve the API and have
+// SERVICE_JOBS enabled. They report through the logger and stats client of the given context,
+// and run until it is cancelled or Stop is called. BindRoutes must be called first.
+func (s *Service) Start(ctx context.Context) {
+	for _, deprecated := range s.deprecated {
+		domain.LoggerFromContext(ctx).Warn(deprecated)
+	}
+	ctx, s.stop = context.WithCancel(ctx)
+	for _, job := range s.jobs {
+		s.running.Add(1)
+		go func(job func(ctx context.Context)) {
+			defer s.running.Done()
+			job(ctx)
+		}(job)
 	}
-	return val
 }
 
-func createS3Client(region string) (*s3.S3, error) {
-	useIAM := mustEnv("USE_IAM")
-	useIAMFlag, err := strconv.ParseBool(useIAM)
-	if err != nil {
+// Stop cancels the background jobs and waits for them to return, and for any backfill started
+// by this instance to finish queuing its diffs. Backfills which have not finished by the
+// deadline of the context are cancelled, and are resumed later as interrupted backfills. The
+// built in TracerProvider is then shut down, which flushes its exporter within the deadline of
+// the context.
+func (s *Service) Stop(ctx context.Context) error {
+	if s.stop != nil {
+		s.stop()
+	}
+	s.running.Wait()
+	var err error
+	if s.backfiller != nil {
+		err = s.waitBackfills(ctx)
+	}
+	if s.shutdownTracing != nil {
+		if tracingErr := s.shutdownTracing(ctx); tracingErr != nil {
+			return tracingErr
+		}
+	}
+	return err
+}
+
+// waitBackfills waits for the running backfills to finish until the context is cancelled, and
+// then cancels them. Cancelled backfills are resumed by the next instance to run the jobs.
+func (s *Service) waitBackfills(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.backfiller.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.backfiller.Cancel()
+		return ctx.Err()
+	}
+}
+
+// createS3Client returns a client for the given region, which is named by the given setting
+// if it is missing.
+func createS3Client(conf *AWSConfig, setting string, region string) (*s3.S3, error) {
+	if err := required(setting, region); err != nil {
 		return nil, err
 	}
 	cfg := aws.NewConfig()
 	cfg.Region = aws.String(region)
-	if !useIAMFlag {
+	if !conf.UseIAM {
 		cfg.Credentials = credentials.NewChainCredentials([]credentials.Provider{
 			&credentials.EnvProvider{},
 			&credentials.SharedCredentialsProvider{
-				Filename: os.Getenv("AWS_CREDENTIALS_FILE"),
-				Profile:  os.Getenv("AWS_CREDENTIALS_PROFILE"),
+				Filename: conf.Credentials.File,
+				Profile:  conf.Credentials.Profile,
 			},
 		})
 	}
@@ -1,57 +1,44 @@
 package diffd
 
 import (
+	"context"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/asecurityteam/vpcflow-diffd/pkg/auth"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/backfill"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/grapher"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/health"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/logs"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/marker"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/metrics"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/queuer"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/scheduler"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/storage"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/tracing"
 	"github.com/go-chi/chi"
 	"github.com/stretchr/testify/require"
 )
 
-func TestNewDefaultHTTPClientSuccess(t *testing.T) {
-	require.NotNil(t, defaultHTTPClient())
+// builtInStorage returns the Storage of the service without the decorators added to every
+// Storage.
+func builtInStorage(s *Service) domain.Storage {
+	return s.Storage.(*metrics.Storage).Storage.(*tracing.Storage).Storage
 }
 
-func TestMustEnv(t *testing.T) {
-	tc := []struct {
-		Name  string
-		Key   string
-		Value string
-		Set   bool
-	}{
-		{
-			Name:  "var unset",
-			Key:   "key",
-			Value: "",
-			Set:   false,
-		},
-		{
-			Name:  "var set",
-			Key:   "key",
-			Value: "value",
-			Set:   true,
-		},
-	}
+// builtInGrapher returns the Grapher of the service without the decorators added to every
+// Grapher.
+func builtInGrapher(s *Service) domain.Grapher {
+	return s.Grapher.(*metrics.Grapher).Grapher.(*tracing.Grapher).Grapher
+}
 
-	for _, tt := range tc {
-		t.Run(tt.Name, func(t *testing.T) {
-			originalValue, existing := os.LookupEnv(tt.Key)
-			if existing {
-				defer os.Setenv(tt.Key, originalValue)
-			}
-			os.Unsetenv(tt.Key)
-			if tt.Set {
-				os.Setenv(tt.Key, tt.Value)
-				require.Equal(t, tt.Value, mustEnv(tt.Key))
-				return
-			}
-			require.Panics(t, func() {
-				mustEnv(tt.Key)
-			})
-
-		})
-	}
+func TestNewDefaultHTTPClientSuccess(t *testing.T) {
+	require.NotNil(t, defaultHTTPClient())
 }
 
 func TestServiceInitSuccess(t *testing.T) {
@@ -67,18 +54,424 @@ func TestServiceInitSuccess(t *testing.T) {
 	}()
 
 	// set required test environment variables
-	os.Setenv("USE_IAM", "true")
-	os.Setenv("DIFF_STORAGE_BUCKET_REGION", "n/a")
-	os.Setenv("DIFF_PROGRESS_BUCKET_REGION", "n/a")
+	os.Setenv("AWS_USEIAM", "true")
+	os.Setenv("DIFF_STORAGE_REGION", "n/a")
+	os.Setenv("DIFF_PROGRESS_REGION", "n/a")
 	os.Setenv("STREAM_APPLIANCE_ENDPOINT", "n/a")
-	os.Setenv("DIFF_PROGRESS_TIMEOUT", "1")
+	os.Setenv("DIFF_PROGRESS_TIMEOUT", "1ms")
 	os.Setenv("DIFF_PROGRESS_BUCKET", "n/a")
 	os.Setenv("DIFF_STORAGE_BUCKET", "n/a")
 	os.Setenv("GRAPHER_ENDPOINT", "n/a")
-	os.Setenv("GRAPHER_POLLING_TIMEOUT", "1")
-	os.Setenv("GRAPHER_POLLING_INTERVAL", "1")
+	os.Setenv("GRAPHER_POLLING_TIMEOUT", "1ms")
+	os.Setenv("GRAPHER_POLLING_INTERVAL", "1ms")
+	s := &Service{}
+	require.Nil(t, s.init())
+	mm, ok := s.Marker.(*metrics.LeaseMarker)
+	require.True(t, ok)
+	tm, ok := mm.LeaseMarker.(*tracing.LeaseMarker)
+	require.True(t, ok)
+	m, ok := tm.LeaseMarker.(*marker.LeaseMarker)
+	require.True(t, ok)
+	require.Equal(t, time.Millisecond, m.TTL)
+	require.IsType(t, &metrics.Storage{}, s.Storage)
+	iq, ok := s.Queuer.(*queuer.Indexing)
+	require.True(t, ok)
+	require.IsType(t, &metrics.Queuer{}, iq.Queuer)
+	require.IsType(t, &metrics.Grapher{}, s.Grapher)
+	require.IsType(t, &metrics.Differ{}, s.differ)
+	require.Equal(t, time.Millisecond/3, s.heartbeatInterval)
+}
+
+func TestServiceInitGrapherStorage(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	// set required test environment variables
+	os.Setenv("AWS_USEIAM", "true")
+	os.Setenv("DIFF_STORAGE_REGION", "n/a")
+	os.Setenv("DIFF_PROGRESS_REGION", "n/a")
+	os.Setenv("STREAM_APPLIANCE_ENDPOINT", "n/a")
+	os.Setenv("DIFF_PROGRESS_TIMEOUT", "1ms")
+	os.Setenv("DIFF_PROGRESS_BUCKET", "n/a")
+	os.Setenv("DIFF_STORAGE_BUCKET", "n/a")
+	os.Setenv("GRAPHER_ENDPOINT", "n/a")
+	os.Setenv("GRAPHER_POLLING_TIMEOUT", "1ms")
+	os.Setenv("GRAPHER_POLLING_INTERVAL", "1ms")
+	os.Setenv("GRAPHER_STORAGE_BUCKET", "n/a")
+	os.Setenv("GRAPHER_STORAGE_REGION", "n/a")
+	s := &Service{}
+	require.Nil(t, s.init())
+	g, ok := builtInGrapher(s).(*grapher.S3)
+	require.True(t, ok)
+	require.IsType(t, &grapher.HTTP{}, g.Fallback)
+}
+
+func TestServiceInitNativeGrapher(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	// set required test environment variables. No grapher service is needed when
+	// building graphs from raw flow logs.
+	os.Setenv("AWS_USEIAM", "true")
+	os.Setenv("DIFF_STORAGE_REGION", "n/a")
+	os.Setenv("DIFF_PROGRESS_REGION", "n/a")
+	os.Setenv("STREAM_APPLIANCE_ENDPOINT", "n/a")
+	os.Setenv("DIFF_PROGRESS_TIMEOUT", "1ms")
+	os.Setenv("DIFF_PROGRESS_BUCKET", "n/a")
+	os.Setenv("DIFF_STORAGE_BUCKET", "n/a")
+	os.Setenv("GRAPHER_FLOWLOG_DIRECTORY", "n/a")
+	s := &Service{}
+	require.Nil(t, s.init())
+	require.IsType(t, &grapher.Native{}, builtInGrapher(s))
+}
+
+func TestServiceInitGrapherCache(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	// set required test environment variables
+	os.Setenv("AWS_USEIAM", "true")
+	os.Setenv("DIFF_STORAGE_REGION", "n/a")
+	os.Setenv("DIFF_PROGRESS_REGION", "n/a")
+	os.Setenv("STREAM_APPLIANCE_ENDPOINT", "n/a")
+	os.Setenv("DIFF_PROGRESS_TIMEOUT", "1ms")
+	os.Setenv("DIFF_PROGRESS_BUCKET", "n/a")
+	os.Setenv("DIFF_STORAGE_BUCKET", "n/a")
+	os.Setenv("GRAPHER_ENDPOINT", "n/a")
+	os.Setenv("GRAPHER_POLLING_TIMEOUT", "1ms")
+	os.Setenv("GRAPHER_POLLING_INTERVAL", "1ms")
+	os.Setenv("GRAPHER_CACHE_DIRECTORY", "n/a")
+	os.Setenv("GRAPHER_CACHE_MAXBYTES", "1024")
+	os.Setenv("GRAPHER_CACHE_TTL", "1s")
+	s := &Service{}
+	require.Nil(t, s.init())
+	c, ok := builtInGrapher(s).(*grapher.Cache)
+	require.True(t, ok)
+	require.Equal(t, int64(1024), c.MaxBytes)
+	require.Equal(t, time.Second, c.TTL)
+	require.IsType(t, &grapher.DiskCacheStore{}, c.Store)
+}
+
+func TestServiceInitRetention(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	// set required test environment variables
+	os.Setenv("AWS_USEIAM", "true")
+	os.Setenv("DIFF_STORAGE_REGION", "n/a")
+	os.Setenv("DIFF_PROGRESS_REGION", "n/a")
+	os.Setenv("STREAM_APPLIANCE_ENDPOINT", "n/a")
+	os.Setenv("DIFF_PROGRESS_TIMEOUT", "1ms")
+	os.Setenv("DIFF_PROGRESS_BUCKET", "n/a")
+	os.Setenv("DIFF_STORAGE_BUCKET", "n/a")
+	os.Setenv("GRAPHER_FLOWLOG_DIRECTORY", "n/a")
+	os.Setenv("DIFF_RETENTION_MAXCOUNT", "10")
+	s := &Service{}
+	require.Nil(t, s.init())
+	// the backfills kept in the progress bucket are resumed alongside
+	require.Len(t, s.jobs, 2)
+	ip, ok := builtInStorage(s).(*storage.InProgress)
+	require.True(t, ok)
+	r, ok := ip.Storage.(*storage.Retention)
+	require.True(t, ok)
+	require.Equal(t, 10, r.Policy.MaxCount)
+}
+
+func TestServiceInitRetentionLifecycle(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	// set required test environment variables
+	os.Setenv("AWS_USEIAM", "true")
+	os.Setenv("DIFF_STORAGE_REGION", "n/a")
+	os.Setenv("DIFF_PROGRESS_REGION", "n/a")
+	os.Setenv("STREAM_APPLIANCE_ENDPOINT", "n/a")
+	os.Setenv("DIFF_PROGRESS_BUCKET", "n/a")
+	os.Setenv("DIFF_STORAGE_BUCKET", "n/a")
+	os.Setenv("GRAPHER_FLOWLOG_DIRECTORY", "n/a")
+	os.Setenv("DIFF_RETENTION_MAXAGE", "24h")
+
+	// the maximum age is swept unless the lifecycle rule is requested
+	s := &Service{}
+	require.Nil(t, s.init())
+	// the backfills kept in the progress bucket are resumed alongside
+	require.Len(t, s.jobs, 2)
+	ip := builtInStorage(s).(*storage.InProgress)
+	require.Equal(t, 24*time.Hour, ip.Storage.(*storage.Retention).Policy.MaxAge)
+
+	// the lifecycle rule enforces the maximum age, and is only installed by the instance which
+	// runs the jobs, while the sweeper still removes the diffs stored with their own ttl
+	os.Setenv("DIFF_RETENTION_LIFECYCLE", "true")
+	s = &Service{}
+	require.Nil(t, s.init())
+	require.Len(t, s.jobs, 3)
+
+	os.Setenv("SERVICE_JOBS", "false")
+	s = &Service{}
+	require.Nil(t, s.init())
+	require.Empty(t, s.jobs)
+}
+
+func TestServiceStartStop(t *testing.T) {
+	conf := NewConfig()
+	conf.Service.Mode = ModeAPI
+	s := &Service{
+		Config:  conf,
+		Queuer:  &queuer.DiffQueuer{},
+		Storage: &storage.S3{},
+		Marker:  &marker.LeaseMarker{},
+	}
+	require.Nil(t, s.init())
+	started := make(chan struct{})
+	stopped := false
+	s.jobs = []func(ctx context.Context){func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		stopped = true
+	}}
+	s.Start(context.Background())
+	<-started
+	// Stop returns once every job has returned
+	require.Nil(t, s.Stop(context.Background()))
+	require.True(t, stopped)
+}
+
+func TestServiceInitRetentionCustomStorage(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	// set required test environment variables
+	os.Setenv("AWS_USEIAM", "true")
+	os.Setenv("DIFF_STORAGE_REGION", "n/a")
+	os.Setenv("DIFF_PROGRESS_REGION", "n/a")
+	os.Setenv("STREAM_APPLIANCE_ENDPOINT", "n/a")
+	os.Setenv("DIFF_PROGRESS_TIMEOUT", "1ms")
+	os.Setenv("DIFF_PROGRESS_BUCKET", "n/a")
+	os.Setenv("GRAPHER_FLOWLOG_DIRECTORY", "n/a")
+	os.Setenv("DIFF_RETENTION_MAXAGE", "1s")
+	custom := &storage.S3{}
+	s := &Service{Storage: custom}
+	require.Nil(t, s.init())
+	// the backfills kept in the progress bucket are resumed alongside
+	require.Len(t, s.jobs, 2)
+	r, ok := builtInStorage(s).(*storage.Retention)
+	require.True(t, ok)
+	require.Equal(t, custom, r.Storage)
+	require.Equal(t, time.Second, r.Policy.MaxAge)
+}
+
+func TestServiceInitReaper(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	// set required test environment variables
+	os.Setenv("AWS_USEIAM", "true")
+	os.Setenv("DIFF_STORAGE_REGION", "n/a")
+	os.Setenv("DIFF_PROGRESS_REGION", "n/a")
+	os.Setenv("STREAM_APPLIANCE_ENDPOINT", "n/a")
+	os.Setenv("DIFF_PROGRESS_TIMEOUT", "1ms")
+	os.Setenv("DIFF_PROGRESS_BUCKET", "n/a")
+	os.Setenv("DIFF_STORAGE_BUCKET", "n/a")
+	os.Setenv("GRAPHER_FLOWLOG_DIRECTORY", "n/a")
+	os.Setenv("DIFF_PROGRESS_REAP_INTERVAL", "1s")
+	os.Setenv("DIFF_PROGRESS_REAP_REQUEUE", "true")
 	s := &Service{}
 	require.Nil(t, s.init())
+	// the sweeper and the backfills kept in the progress bucket run alongside
+	require.Len(t, s.jobs, 3)
+
+	os.Setenv("DIFF_PROGRESS_REAP_REQUEUE", "maybe")
+	require.NotNil(t, (&Service{}).init())
+}
+
+func TestServiceInitTracing(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+	f, err := ioutil.TempFile("", "traces")
+	require.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.Close()
+
+	// set required test environment variables
+	os.Setenv("AWS_USEIAM", "true")
+	os.Setenv("DIFF_STORAGE_REGION", "n/a")
+	os.Setenv("DIFF_PROGRESS_REGION", "n/a")
+	os.Setenv("STREAM_APPLIANCE_ENDPOINT", "n/a")
+	os.Setenv("DIFF_PROGRESS_TIMEOUT", "1ms")
+	os.Setenv("DIFF_PROGRESS_BUCKET", "n/a")
+	os.Setenv("DIFF_STORAGE_BUCKET", "n/a")
+	os.Setenv("GRAPHER_FLOWLOG_DIRECTORY", "n/a")
+	os.Setenv("TRACING_EXPORTER", "file")
+	os.Setenv("TRACING_FILE", f.Name())
+	s := &Service{}
+	require.Nil(t, s.init())
+	_, span := s.tracer.Start(context.Background(), "test")
+	span.End()
+	exported, err := ioutil.ReadFile(f.Name())
+	require.Nil(t, err)
+	require.Contains(t, string(exported), `"Name":"test"`)
+
+	// spans which end after the service stops are not exported
+	require.Nil(t, s.Stop(context.Background()))
+	_, span = s.tracer.Start(context.Background(), "stopped")
+	span.End()
+	exported, err = ioutil.ReadFile(f.Name())
+	require.Nil(t, err)
+	require.NotContains(t, string(exported), `"Name":"stopped"`)
+
+	os.Setenv("TRACING_EXPORTER", "zipkin")
+	require.NotNil(t, (&Service{}).init())
+}
+
+func TestServiceInitUnsupportedEncoding(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	// set required test environment variables
+	os.Setenv("AWS_USEIAM", "true")
+	os.Setenv("DIFF_STORAGE_REGION", "n/a")
+	os.Setenv("DIFF_PROGRESS_REGION", "n/a")
+	os.Setenv("STREAM_APPLIANCE_ENDPOINT", "n/a")
+	os.Setenv("DIFF_PROGRESS_TIMEOUT", "1ms")
+	os.Setenv("DIFF_PROGRESS_BUCKET", "n/a")
+	os.Setenv("DIFF_STORAGE_BUCKET", "n/a")
+	os.Setenv("DIFF_STORAGE_ENCODING", "br")
+	s := &Service{}
+	require.NotNil(t, s.init())
+}
+
+func TestServiceInitMissingSetting(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	// set required test environment variables, except for the storage bucket
+	os.Setenv("AWS_USEIAM", "true")
+	os.Setenv("DIFF_STORAGE_REGION", "n/a")
+	os.Setenv("DIFF_PROGRESS_REGION", "n/a")
+	os.Setenv("STREAM_APPLIANCE_ENDPOINT", "n/a")
+	os.Setenv("DIFF_PROGRESS_BUCKET", "n/a")
+	os.Setenv("GRAPHER_FLOWLOG_DIRECTORY", "n/a")
+	err := (&Service{}).init()
+	require.NotNil(t, err)
+	require.Equal(t, "DIFF_STORAGE_BUCKET is required", err.Error())
+
+	os.Setenv("DIFF_STORAGE_BUCKET", "n/a")
+	os.Setenv("DIFF_PROGRESS_TIMEOUT", "soon")
+	require.NotNil(t, (&Service{}).init())
+}
+
+func TestServiceInitConfig(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	// the environment is not read if a Config is given
+	conf := NewConfig()
+	conf.AWS.UseIAM = true
+	conf.Diff.Storage.Bucket = "n/a"
+	conf.Diff.Storage.Region = "n/a"
+	conf.Diff.Progress.Bucket = "n/a"
+	conf.Diff.Progress.Region = "n/a"
+	conf.Stream.Appliance.Endpoint = "n/a"
+	conf.Grapher.Endpoint = "n/a"
+	s := &Service{Config: conf}
+	require.Nil(t, s.init())
+	g, ok := builtInGrapher(s).(*grapher.HTTP)
+	require.True(t, ok)
+	require.Equal(t, time.Minute, g.PollTimeout)
+	require.Equal(t, 5*time.Minute/3, s.heartbeatInterval)
+}
+
+func TestServiceInitTenancy(t *testing.T) {
+	conf := NewConfig()
+	conf.AWS.UseIAM = true
+	conf.Diff.Storage.Bucket = "n/a"
+	conf.Diff.Storage.Region = "n/a"
+	conf.Diff.Progress.Bucket = "n/a"
+	conf.Diff.Progress.Region = "n/a"
+	conf.Stream.Appliance.Endpoint = "n/a"
+	conf.Grapher.Endpoint = "n/a"
+
+	s := &Service{Config: conf}
+	require.Nil(t, s.init())
+	require.Nil(t, s.Authorizer)
+
+	conf.Tenancy.Enabled = true
+	conf.Tenancy.TenantHeader = "X-Org"
+	s = &Service{Config: conf}
+	require.Nil(t, s.init())
+	require.Equal(t, &auth.Headers{TenantHeader: "X-Org", AccountsHeader: auth.DefaultAccountsHeader}, s.Authorizer)
+
+	// a custom Authorizer is kept
+	custom := &auth.Headers{}
+	s = &Service{Config: conf, Authorizer: custom}
+	require.Nil(t, s.init())
+	require.True(t, s.Authorizer == custom)
 }
 
 func TestServiceBindRoutesSuccess(t *testing.T) {
@@ -92,18 +485,149 @@ func TestServiceBindRoutesSuccess(t *testing.T) {
 	}()
 
 	// set required test environment variables
-	os.Setenv("USE_IAM", "true")
-	os.Setenv("DIFF_STORAGE_BUCKET_REGION", "n/a")
-	os.Setenv("DIFF_PROGRESS_BUCKET_REGION", "n/a")
+	os.Setenv("AWS_USEIAM", "true")
+	os.Setenv("DIFF_STORAGE_REGION", "n/a")
+	os.Setenv("DIFF_PROGRESS_REGION", "n/a")
 	os.Setenv("STREAM_APPLIANCE_ENDPOINT", "n/a")
-	os.Setenv("DIFF_PROGRESS_TIMEOUT", "1")
+	os.Setenv("DIFF_PROGRESS_TIMEOUT", "1ms")
 	os.Setenv("DIFF_PROGRESS_BUCKET", "n/a")
 	os.Setenv("DIFF_STORAGE_BUCKET", "n/a")
 	os.Setenv("GRAPHER_ENDPOINT", "n/a")
-	os.Setenv("GRAPHER_POLLING_TIMEOUT", "1")
-	os.Setenv("GRAPHER_POLLING_INTERVAL", "1")
+	os.Setenv("GRAPHER_POLLING_TIMEOUT", "1ms")
+	os.Setenv("GRAPHER_POLLING_INTERVAL", "1ms")
 
 	router := chi.NewMux()
 	s := &Service{}
 	require.Nil(t, s.BindRoutes(router))
 }
+
+// routes returns the method and pattern of every route mounted on the router.
+func routes(t *testing.T, router chi.Routes) []string {
+	var mounted []string
+	require.Nil(t, chi.Walk(router, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		mounted = append(mounted, method+" "+route)
+		return nil
+	}))
+	return mounted
+}
+
+func TestServiceBindRoutesModes(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	// set the test environment variables shared by both modes
+	os.Setenv("AWS_USEIAM", "true")
+	os.Setenv("DIFF_STORAGE_REGION", "n/a")
+	os.Setenv("DIFF_STORAGE_BUCKET", "n/a")
+	os.Setenv("DIFF_PROGRESS_REGION", "n/a")
+	os.Setenv("DIFF_PROGRESS_BUCKET", "n/a")
+	os.Setenv("DIFF_PROGRESS_REAP_INTERVAL", "1s")
+
+	// the API needs no grapher
+	os.Setenv("SERVICE_MODE", "api")
+	os.Setenv("STREAM_APPLIANCE_ENDPOINT", "n/a")
+	router := chi.NewMux()
+	s := &Service{}
+	require.Nil(t, s.BindRoutes(router))
+	require.Nil(t, s.Grapher)
+	require.Nil(t, s.differ)
+	// the sweeper and the backfills kept in the progress bucket run alongside
+	require.Len(t, s.jobs, 3)
+	require.ElementsMatch(t, []string{"GET /healthcheck", "GET /ready", "POST /", "GET /", "DELETE /", "GET /diffs", "POST /batch", "GET /schedules", "POST /backfills", "GET /backfills/{id}", "POST /v2/diffs", "GET /v2/diffs/{id}", "DELETE /v2/diffs/{id}"}, routes(t, router))
+
+	// the worker needs no queuer, and leaves the reaper to the API
+	os.Setenv("SERVICE_MODE", "worker")
+	os.Unsetenv("STREAM_APPLIANCE_ENDPOINT")
+	os.Setenv("GRAPHER_FLOWLOG_DIRECTORY", "n/a")
+	router = chi.NewMux()
+	s = &Service{}
+	require.Nil(t, s.BindRoutes(router))
+	require.Nil(t, s.Queuer)
+	require.Empty(t, s.jobs)
+	require.ElementsMatch(t, []string{"GET /healthcheck", "GET /ready", "POST /{topic}/{event}"}, routes(t, router))
+
+	os.Setenv("SERVICE_MODE", "both")
+	require.NotNil(t, (&Service{}).init())
+}
+
+func TestServiceInitSchedulerAndBackfiller(t *testing.T) {
+	conf := NewConfig()
+	conf.Service.Mode = ModeAPI
+	conf.Scheduler.Schedules = "day-over-day:daily:0 2 * * *"
+	s := &Service{
+		Config:  conf,
+		Queuer:  &queuer.DiffQueuer{},
+		Storage: &storage.S3{},
+		Marker:  &marker.LeaseMarker{},
+	}
+	require.Nil(t, s.init())
+	require.Len(t, s.scheduler.Schedules, 1)
+	require.IsType(t, &scheduler.MemoryStore{}, s.scheduler.Store)
+	require.IsType(t, &backfill.MemoryStore{}, s.backfiller.Store)
+	require.Equal(t, 4, s.backfiller.MaxConcurrency)
+	// the scheduler and the sweeper
+	require.Len(t, s.jobs, 2)
+
+	// schedules without a progress bucket are only caught up in memory, and with one
+	// their state is kept in it
+	conf.Diff.Progress.Bucket = "progress"
+	conf.Diff.Progress.Region = "us-west-2"
+	s = &Service{
+		Config:  conf,
+		Queuer:  &queuer.DiffQueuer{},
+		Storage: &storage.S3{},
+	}
+	require.Nil(t, s.init())
+	require.IsType(t, &scheduler.S3Store{}, s.scheduler.Store)
+	require.IsType(t, &backfill.S3Store{}, s.backfiller.Store)
+	require.Len(t, s.jobs, 3)
+
+	conf.Scheduler.Schedules = "day-over-day:monthly:0 2 * * *"
+	require.NotNil(t, (&Service{Config: conf, Queuer: &queuer.DiffQueuer{}, Storage: &storage.S3{}}).init())
+}
+
+func TestServiceInitChecks(t *testing.T) {
+	environ := os.Environ()
+	os.Clearenv()
+	defer func() {
+		for _, e := range environ {
+			envPair := strings.Split(e, "=")
+			os.Setenv(envPair[0], envPair[1])
+		}
+	}()
+
+	// set required test environment variables
+	os.Setenv("AWS_USEIAM", "true")
+	os.Setenv("DIFF_STORAGE_REGION", "n/a")
+	os.Setenv("DIFF_PROGRESS_REGION", "n/a")
+	os.Setenv("STREAM_APPLIANCE_ENDPOINT", "n/a")
+	os.Setenv("DIFF_PROGRESS_BUCKET", "n/a")
+	os.Setenv("DIFF_STORAGE_BUCKET", "n/a")
+	os.Setenv("GRAPHER_ENDPOINT", "n/a")
+	s := &Service{}
+	require.Nil(t, s.init())
+	require.IsType(t, &health.HTTPEndpoint{}, s.checks[logs.DependencyQueuer])
+	require.IsType(t, &health.S3Bucket{}, s.checks[logs.DependencyStorage])
+	require.IsType(t, &health.S3Bucket{}, s.checks[logs.DependencyMarker])
+	require.IsType(t, &health.HTTPEndpoint{}, s.checks[logs.DependencyGrapher])
+
+	// custom modules are only checked if they implement a check
+	s = &Service{Storage: &storage.S3{}, Grapher: &checkedGrapher{}}
+	require.Nil(t, s.init())
+	require.NotContains(t, s.checks, logs.DependencyStorage)
+	require.IsType(t, &checkedGrapher{}, s.checks[logs.DependencyGrapher])
+}
+
+type checkedGrapher struct {
+	domain.Grapher
+}
+
+func (*checkedGrapher) Check(ctx context.Context) error {
+	return nil
+}
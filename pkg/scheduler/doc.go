@@ -0,0 +1,3 @@
+// Package scheduler queues recurring diffs on cron-like schedules.
+//
+package scheduler
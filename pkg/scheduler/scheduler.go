@@ -0,0 +1,304 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/logs"
+	"github.com/google/uuid"
+	"github.com/robfig/cron"
+)
+
+const (
+	defaultInterval   = time.Minute
+	defaultMaxCatchUp = 24
+
+	statQueued = "scheduler.queued"
+)
+
+// Schedule queues the diff of a Template at the times of a cron spec.
+type Schedule struct {
+	Name     string
+	Spec     string
+	Template Template
+
+	// Scope is the tenant and accounts the diffs of the schedule are restricted to. The zero
+	// Scope queues diffs of all accounts which belong to no tenant.
+	Scope domain.Scope
+
+	cron cron.Schedule
+}
+
+// NewSchedule returns a Schedule of the given template at the times of a standard five field
+// cron spec, such as "0 2 * * *", or a descriptor such as "@daily".
+func NewSchedule(name string, template string, spec string) (*Schedule, error) {
+	if name == "" {
+		return nil, fmt.Errorf("schedule %q has no name", spec)
+	}
+	t, err := LookupTemplate(template)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %s: %s", name, err.Error())
+	}
+	c, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %s: %s", name, err.Error())
+	}
+	return &Schedule{Name: name, Spec: spec, Template: t, cron: c}, nil
+}
+
+// Diff returns the diff of a run scheduled at the given time, in the scope of the schedule.
+func (s *Schedule) Diff(run time.Time) domain.Diff {
+	d := s.Template.Diff(run)
+	return domain.NewScopedDiff(d.PreviousStart, d.PreviousStop, d.NextStart, d.NextStop, s.Scope)
+}
+
+// ParseSchedules parses schedules written as name:template:spec, separated by semicolons, such
+// as "day-over-day:daily:0 2 * * *;hour-over-hour:hourly:@hourly". A schedule may be restricted
+// to a tenant, and to a comma separated list of its accounts, as name:template:spec:tenant or
+// name:template:spec:tenant:accounts, such as "acme-daily:daily:0 2 * * *:acme:1,2".
+func ParseSchedules(definitions string) ([]*Schedule, error) {
+	var schedules []*Schedule
+	names := make(map[string]bool)
+	for _, definition := range strings.Split(definitions, ";") {
+		definition = strings.TrimSpace(definition)
+		if definition == "" {
+			continue
+		}
+		// cron specs have no colons, so they are followed by the optional scope
+		parts := strings.SplitN(definition, ":", 5)
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("schedule %q is not of the form name:template:spec", definition)
+		}
+		s, err := NewSchedule(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2]))
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) > 3 {
+			var accounts []string
+			if len(parts) > 4 {
+				accounts = strings.Split(parts[4], ",")
+			}
+			s.Scope = domain.NewScope(strings.TrimSpace(parts[3]), accounts)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("schedule %s is defined more than once", s.Name)
+		}
+		names[s.Name] = true
+		schedules = append(schedules, s)
+	}
+	return schedules, nil
+}
+
+// Scheduler queues the diffs of its schedules once they are due, as a POST of the diff would.
+// Diffs which already exist or are in progress are not queued again.
+//
+// Each run is claimed from the Store before it is queued, so that when several instances check
+// the same schedules, a run is queued by only one of them. A run claimed by another instance is
+// left to it, and is retried once the claim expires if that instance has not saved the run.
+//
+// The last successful run of each schedule is saved to the Store. Runs which were missed while
+// the service was down, or which failed, are caught up by the next check, up to MaxCatchUp runs
+// per schedule. A schedule without a saved run starts from the time the Scheduler starts.
+type Scheduler struct {
+	Schedules    []*Schedule
+	Store        Store
+	Storage      domain.Storage
+	Queuer       domain.Queuer
+	Marker       domain.Marker
+	LogProvider  domain.LogFn
+	StatProvider domain.StatFn
+
+	// Interval is the time between checks for due runs. If unset, schedules are checked
+	// every minute, which is the resolution of a cron spec.
+	Interval time.Duration
+
+	// MaxCatchUp is the number of missed runs of a schedule which are queued, most recent
+	// first. If unset, up to 24 runs are caught up.
+	MaxCatchUp int
+
+	// now returns the current time, and defaults to time.Now
+	now func() time.Time
+
+	lock    sync.Mutex
+	started time.Time
+	errors  map[string]string
+	owner   string
+}
+
+// Run checks the schedules once every interval until the context is cancelled. Failed runs
+// are logged and retried at the next interval.
+func (s *Scheduler) Run(ctx context.Context) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check queues the diffs of every run which is due.
+func (s *Scheduler) Check(ctx context.Context) {
+	now := s.timeNow()
+	s.lock.Lock()
+	if s.started.IsZero() {
+		s.started = now
+	}
+	if s.owner == "" {
+		s.owner = uuid.New().String()
+	}
+	s.lock.Unlock()
+	for _, schedule := range s.Schedules {
+		err := s.check(ctx, schedule, now)
+		s.lock.Lock()
+		if s.errors == nil {
+			s.errors = make(map[string]string)
+		}
+		if err != nil {
+			s.errors[schedule.Name] = err.Error()
+		} else {
+			delete(s.errors, schedule.Name)
+		}
+		s.lock.Unlock()
+	}
+}
+
+func (s *Scheduler) check(ctx context.Context, schedule *Schedule, now time.Time) error {
+	lastRun, err := s.lastRun(ctx, schedule)
+	if err != nil {
+		s.LogProvider(ctx).Error(logs.DependencyFailure{Dependency: logs.DependencySchedules, Reason: err.Error()})
+		return err
+	}
+	var due []time.Time
+	for run := schedule.cron.Next(lastRun); !run.After(now); run = schedule.cron.Next(run) {
+		due = append(due, run)
+	}
+	maxCatchUp := s.MaxCatchUp
+	if maxCatchUp <= 0 {
+		maxCatchUp = defaultMaxCatchUp
+	}
+	if len(due) > maxCatchUp {
+		due = due[len(due)-maxCatchUp:]
+	}
+	queued := make(map[string]bool)
+	for _, run := range due {
+		claimed, err := s.Store.Claim(ctx, schedule.Name, run, s.owner)
+		if err != nil {
+			s.LogProvider(ctx).Error(logs.DependencyFailure{Dependency: logs.DependencySchedules, Reason: err.Error()})
+			return err
+		}
+		if !claimed {
+			// another instance is queuing this run, and the runs after it
+			return nil
+		}
+		diff := schedule.Diff(run)
+		// runs within the same window select the same diff
+		if !queued[diff.ID] {
+			if err := s.queue(ctx, schedule, run, diff); err != nil {
+				return err
+			}
+			queued[diff.ID] = true
+		}
+		if err := s.Store.Save(ctx, schedule.Name, run); err != nil {
+			s.LogProvider(ctx).Error(logs.DependencyFailure{Dependency: logs.DependencySchedules, Reason: err.Error()})
+			return err
+		}
+	}
+	return nil
+}
+
+// queue queues the diff unless it already exists or is in progress.
+func (s *Scheduler) queue(ctx context.Context, schedule *Schedule, run time.Time, diff domain.Diff) error {
+	logger := s.LogProvider(ctx)
+	exists, err := s.Storage.Exists(ctx, diff.Key())
+	switch err.(type) {
+	case nil:
+	case domain.ErrInProgress:
+		return nil
+	default:
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if err := s.Queuer.Queue(ctx, diff); err != nil {
+		logger.Error(logs.DependencyFailure{Dependency: logs.DependencyQueuer, Reason: err.Error()})
+		return err
+	}
+	// as for a POST, the diff is queued even if it cannot be marked
+	if err := s.Marker.Mark(ctx, diff.Key()); err != nil {
+		logger.Info(logs.DependencyFailure{Dependency: logs.DependencyMarker, Reason: err.Error()})
+	}
+	logger.Info(logs.Scheduled{Schedule: schedule.Name, ID: diff.ID, Run: run.UTC().Format(time.RFC3339)})
+	s.StatProvider(ctx).Count(statQueued, 1, "schedule:"+schedule.Name)
+	return nil
+}
+
+// lastRun returns the saved last run of the schedule, or the time the Scheduler started if it
+// has none.
+func (s *Scheduler) lastRun(ctx context.Context, schedule *Schedule) (time.Time, error) {
+	lastRun, err := s.Store.Load(ctx, schedule.Name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if lastRun.IsZero() {
+		s.lock.Lock()
+		lastRun = s.started
+		s.lock.Unlock()
+	}
+	return lastRun, nil
+}
+
+// States returns the state of every schedule, sorted by name.
+func (s *Scheduler) States(ctx context.Context) ([]domain.ScheduleState, error) {
+	now := s.timeNow()
+	states := make([]domain.ScheduleState, 0, len(s.Schedules))
+	for _, schedule := range s.Schedules {
+		lastRun, err := s.Store.Load(ctx, schedule.Name)
+		if err != nil {
+			return nil, err
+		}
+		// a run which is overdue is reported as the next run until it is caught up
+		s.lock.Lock()
+		from := lastRun
+		if from.IsZero() {
+			from = s.started
+		}
+		lastError := s.errors[schedule.Name]
+		s.lock.Unlock()
+		if from.IsZero() {
+			from = now
+		}
+		states = append(states, domain.ScheduleState{
+			Name:      schedule.Name,
+			Spec:      schedule.Spec,
+			Template:  schedule.Template.Name,
+			Scope:     schedule.Scope,
+			LastRun:   lastRun,
+			NextRun:   schedule.cron.Next(from),
+			LastError: lastError,
+		})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states, nil
+}
+
+func (s *Scheduler) timeNow() time.Time {
+	if s.now == nil {
+		return time.Now().UTC()
+	}
+	return s.now().UTC()
+}
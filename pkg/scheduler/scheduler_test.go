@@ -0,0 +1,205 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newScheduler(t *testing.T, ctrl *gomock.Controller, now *time.Time, definitions string) (*Scheduler, *MockStorage, *MockQueuer, *MockMarker) {
+	schedules, err := ParseSchedules(definitions)
+	require.Nil(t, err)
+	storage := NewMockStorage(ctrl)
+	queuer := NewMockQueuer(ctrl)
+	marker := NewMockMarker(ctrl)
+	return &Scheduler{
+		Schedules:    schedules,
+		Store:        &MemoryStore{},
+		Storage:      storage,
+		Queuer:       queuer,
+		Marker:       marker,
+		LogProvider:  func(context.Context) domain.Logger { return logevent.New(logevent.Config{Output: ioutil.Discard}) },
+		StatProvider: domain.StatFromContext,
+		now:          func() time.Time { return *now },
+	}, storage, queuer, marker
+}
+
+func TestParseSchedules(t *testing.T) {
+	schedules, err := ParseSchedules("day-over-day:daily:0 2 * * *; hour-over-hour : hourly : @hourly ;")
+	require.Nil(t, err)
+	require.Len(t, schedules, 2)
+	assert.Equal(t, "day-over-day", schedules[0].Name)
+	assert.Equal(t, TemplateDaily, schedules[0].Template.Name)
+	assert.Equal(t, "0 2 * * *", schedules[0].Spec)
+	assert.Equal(t, "hour-over-hour", schedules[1].Name)
+	assert.Equal(t, "@hourly", schedules[1].Spec)
+
+	schedules, err = ParseSchedules("")
+	assert.Nil(t, err)
+	assert.Empty(t, schedules)
+}
+
+func TestParseSchedulesScoped(t *testing.T) {
+	schedules, err := ParseSchedules("a:daily:0 2 * * *:acme;b:hourly:@hourly: acme : 2, 1")
+	require.Nil(t, err)
+	require.Len(t, schedules, 2)
+	assert.Equal(t, "0 2 * * *", schedules[0].Spec)
+	assert.Equal(t, domain.NewScope("acme", nil), schedules[0].Scope)
+	assert.Equal(t, domain.NewScope("acme", []string{"1", "2"}), schedules[1].Scope)
+
+	run := time.Date(2019, 1, 9, 2, 0, 0, 0, time.UTC)
+	d := schedules[1].Template.Diff(run)
+	assert.Equal(t, domain.NewScopedDiff(d.PreviousStart, d.PreviousStop, d.NextStart, d.NextStop, schedules[1].Scope), schedules[1].Diff(run))
+	assert.Equal(t, "tenants/acme/"+schedules[1].Diff(run).ID, schedules[1].Diff(run).Key())
+}
+
+func TestParseSchedulesInvalid(t *testing.T) {
+	for _, definitions := range []string{
+		"daily",
+		":daily:@daily",
+		"a:monthly:@daily",
+		"a:daily:not a spec",
+		"a:daily:@daily;a:hourly:@hourly",
+	} {
+		_, err := ParseSchedules(definitions)
+		assert.NotNil(t, err, definitions)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Date(2019, 1, 9, 1, 59, 0, 0, time.UTC)
+	s, storage, queuer, marker := newScheduler(t, ctrl, &now, "daily:daily:0 2 * * *")
+
+	// nothing is due when the scheduler starts
+	s.Check(context.Background())
+
+	now = time.Date(2019, 1, 9, 2, 0, 0, 0, time.UTC)
+	diff := s.Schedules[0].Diff(now)
+	gomock.InOrder(
+		storage.EXPECT().Exists(gomock.Any(), diff.ID).Return(false, nil),
+		queuer.EXPECT().Queue(gomock.Any(), diff).Return(nil),
+		marker.EXPECT().Mark(gomock.Any(), diff.ID).Return(errors.New("marking is best effort")),
+	)
+	s.Check(context.Background())
+
+	// the run is not repeated
+	now = now.Add(time.Hour)
+	s.Check(context.Background())
+
+	lastRun, _ := s.Store.Load(context.Background(), "daily")
+	assert.Equal(t, time.Date(2019, 1, 9, 2, 0, 0, 0, time.UTC), lastRun)
+}
+
+func TestCheckSkipsExisting(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Date(2019, 1, 9, 1, 0, 0, 0, time.UTC)
+	s, storage, _, _ := newScheduler(t, ctrl, &now, "a:daily:0 2 * * *;b:hourly:@hourly")
+	s.Check(context.Background())
+
+	now = time.Date(2019, 1, 9, 2, 0, 0, 0, time.UTC)
+	gomock.InOrder(
+		storage.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(true, nil),
+		storage.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, domain.ErrInProgress{}),
+	)
+	s.Check(context.Background())
+
+	states, err := s.States(context.Background())
+	require.Nil(t, err)
+	require.Len(t, states, 2)
+	for _, state := range states {
+		assert.Equal(t, now, state.LastRun)
+		assert.Empty(t, state.LastError)
+	}
+}
+
+func TestCheckCatchUp(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Date(2019, 1, 9, 12, 0, 0, 0, time.UTC)
+	s, storage, queuer, marker := newScheduler(t, ctrl, &now, "hourly:hourly:@hourly")
+	s.MaxCatchUp = 3
+	require.Nil(t, s.Store.Save(context.Background(), "hourly", time.Date(2019, 1, 9, 2, 0, 0, 0, time.UTC)))
+
+	// only the three most recent of the ten missed runs are queued
+	var queued []time.Time
+	storage.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil).Times(3)
+	queuer.EXPECT().Queue(gomock.Any(), gomock.Any()).Do(func(ctx context.Context, d domain.Diff) {
+		queued = append(queued, d.NextStop)
+	}).Return(nil).Times(3)
+	marker.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+	s.Check(context.Background())
+
+	assert.Equal(t, []time.Time{
+		time.Date(2019, 1, 9, 10, 0, 0, 0, time.UTC),
+		time.Date(2019, 1, 9, 11, 0, 0, 0, time.UTC),
+		time.Date(2019, 1, 9, 12, 0, 0, 0, time.UTC),
+	}, queued)
+}
+
+func TestCheckFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Date(2019, 1, 9, 1, 0, 0, 0, time.UTC)
+	s, storage, queuer, marker := newScheduler(t, ctrl, &now, "daily:daily:0 2 * * *")
+	s.Check(context.Background())
+
+	now = time.Date(2019, 1, 9, 2, 0, 0, 0, time.UTC)
+	storage.EXPECT().Exists(gomock.Any(), gomock.Any()).Return(false, nil).Times(2)
+	gomock.InOrder(
+		queuer.EXPECT().Queue(gomock.Any(), gomock.Any()).Return(errors.New("unavailable")),
+		queuer.EXPECT().Queue(gomock.Any(), gomock.Any()).Return(nil),
+	)
+	marker.EXPECT().Mark(gomock.Any(), gomock.Any()).Return(nil)
+
+	s.Check(context.Background())
+	states, err := s.States(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, "unavailable", states[0].LastError)
+	assert.True(t, states[0].LastRun.IsZero())
+	assert.Equal(t, now, states[0].NextRun)
+
+	// the failed run is retried by the next check
+	now = now.Add(time.Minute)
+	s.Check(context.Background())
+	states, err = s.States(context.Background())
+	require.Nil(t, err)
+	assert.Empty(t, states[0].LastError)
+	assert.Equal(t, time.Date(2019, 1, 9, 2, 0, 0, 0, time.UTC), states[0].LastRun)
+	assert.Equal(t, time.Date(2019, 1, 10, 2, 0, 0, 0, time.UTC), states[0].NextRun)
+}
+
+func TestCheckClaimed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Date(2019, 1, 9, 1, 59, 0, 0, time.UTC)
+	s, _, _, _ := newScheduler(t, ctrl, &now, "daily:daily:0 2 * * *")
+	s.Check(context.Background())
+
+	// the run is claimed by another instance, which queues it
+	now = time.Date(2019, 1, 9, 2, 0, 0, 0, time.UTC)
+	claimed, err := s.Store.Claim(context.Background(), "daily", now, "other")
+	require.Nil(t, err)
+	require.True(t, claimed)
+	s.Check(context.Background())
+
+	states, err := s.States(context.Background())
+	require.Nil(t, err)
+	assert.Empty(t, states[0].LastError)
+	assert.True(t, states[0].LastRun.IsZero())
+}
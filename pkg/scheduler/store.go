@@ -0,0 +1,230 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	schedulePrefix = "schedules/"
+	claimSuffix    = ".claim"
+
+	defaultClaimTTL = 5 * time.Minute
+
+	// S3 reports these error codes when the condition of a conditional write does not hold.
+	// The SDK provides no constants for them.
+	errCodePreconditionFailed         = "PreconditionFailed"
+	errCodeConditionalRequestConflict = "ConditionalRequestConflict"
+)
+
+// Store persists the last successful run of each schedule, so that runs missed while the
+// service was down are caught up once it restarts.
+type Store interface {
+	// Load returns the last successful run of the schedule, or the zero time if it has none.
+	Load(ctx context.Context, name string) (time.Time, error)
+
+	// Save records the last successful run of the schedule.
+	Save(ctx context.Context, name string, lastRun time.Time) error
+
+	// Claim leases a run of the schedule to the owner, so that the run is queued by only one
+	// of the instances which check the schedule. It returns false if the run, or a later run,
+	// is leased to another owner and the lease has not expired. The owner may claim a run it
+	// already holds again, such as to retry it.
+	Claim(ctx context.Context, name string, run time.Time, owner string) (bool, error)
+}
+
+type storedState struct {
+	LastRun time.Time `json:"lastRun"`
+}
+
+// claim is the lease of a run of a schedule.
+type claim struct {
+	Run     time.Time `json:"run"`
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// held returns true if the claim prevents the owner from claiming the run at the given time.
+func (c claim) held(run time.Time, owner string, now time.Time) bool {
+	if c.Owner == owner || !now.Before(c.Expires) {
+		return false
+	}
+	return !c.Run.Before(run)
+}
+
+// S3Store stores the last run of each schedule as a JSON object in an S3 bucket. The objects
+// are written under the schedules/ prefix, so the bucket may be shared with progress markers.
+// Runs are claimed with conditional writes of a schedules/<name>.claim object, so that a claim
+// is only replaced by the caller which read it.
+type S3Store struct {
+	Bucket string
+	Client s3iface.S3API
+
+	// TTL is how long a claim of a run is held. If unset, claims are held for five minutes.
+	TTL time.Duration
+
+	now func() time.Time
+}
+
+// Load returns the last successful run of the schedule, or the zero time if it has none.
+func (s *S3Store) Load(ctx context.Context, name string) (time.Time, error) {
+	res, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(schedulePrefix + name),
+	})
+	if isNotFound(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer res.Body.Close()
+	var state storedState
+	if err := json.NewDecoder(res.Body).Decode(&state); err != nil {
+		return time.Time{}, err
+	}
+	return state.LastRun, nil
+}
+
+// Save records the last successful run of the schedule.
+func (s *S3Store) Save(ctx context.Context, name string, lastRun time.Time) error {
+	data, err := json.Marshal(storedState{LastRun: lastRun.UTC()})
+	if err != nil {
+		return err
+	}
+	_, err = s.Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(schedulePrefix + name),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// Claim leases the run of the schedule to the owner, unless it is leased to another owner.
+func (s *S3Store) Claim(ctx context.Context, name string, run time.Time, owner string) (bool, error) {
+	key := schedulePrefix + name + claimSuffix
+	condition := ifNoneMatch
+	res, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	switch {
+	case isNotFound(err):
+	case err != nil:
+		return false, err
+	default:
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return false, err
+		}
+		var current claim
+		// a claim which cannot be read is replaced
+		if json.Unmarshal(b, &current) == nil && current.held(run, owner, s.timeNow()) {
+			return false, nil
+		}
+		condition = ifMatch(aws.StringValue(res.ETag))
+	}
+
+	ttl := s.TTL
+	if ttl <= 0 {
+		ttl = defaultClaimTTL
+	}
+	body, _ := json.Marshal(claim{Run: run.UTC(), Owner: owner, Expires: s.timeNow().Add(ttl)})
+	_, err = s.Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}, condition)
+	if isConditionFailed(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *S3Store) timeNow() time.Time {
+	if s.now == nil {
+		return time.Now().UTC()
+	}
+	return s.now().UTC()
+}
+
+// MemoryStore keeps the last run of each schedule in memory, so runs are only caught up while
+// the process is running.
+type MemoryStore struct {
+	lock     sync.Mutex
+	lastRuns map[string]time.Time
+	claims   map[string]claim
+}
+
+// Load returns the last successful run of the schedule, or the zero time if it has none.
+func (s *MemoryStore) Load(ctx context.Context, name string) (time.Time, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.lastRuns[name], nil
+}
+
+// Save records the last successful run of the schedule.
+func (s *MemoryStore) Save(ctx context.Context, name string, lastRun time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.lastRuns == nil {
+		s.lastRuns = make(map[string]time.Time)
+	}
+	s.lastRuns[name] = lastRun
+	return nil
+}
+
+// Claim leases the run of the schedule to the owner, unless it is leased to another owner.
+// Claims are held for five minutes.
+func (s *MemoryStore) Claim(ctx context.Context, name string, run time.Time, owner string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now().UTC()
+	if current, ok := s.claims[name]; ok && current.held(run, owner, now) {
+		return false, nil
+	}
+	if s.claims == nil {
+		s.claims = make(map[string]claim)
+	}
+	s.claims[name] = claim{Run: run.UTC(), Owner: owner, Expires: now.Add(defaultClaimTTL)}
+	return true, nil
+}
+
+// ifNoneMatch makes a write conditional on the object not existing.
+func ifNoneMatch(r *request.Request) {
+	r.HTTPRequest.Header.Set("If-None-Match", "*")
+}
+
+// ifMatch makes a write conditional on the object not having changed since it was read.
+func ifMatch(etag string) request.Option {
+	return func(r *request.Request) {
+		r.HTTPRequest.Header.Set("If-Match", etag)
+	}
+}
+
+func isConditionFailed(err error) bool {
+	if rErr, ok := err.(awserr.RequestFailure); ok && rErr.StatusCode() == http.StatusPreconditionFailed {
+		return true
+	}
+	aErr, ok := err.(awserr.Error)
+	return ok && (aErr.Code() == errCodePreconditionFailed || aErr.Code() == errCodeConditionalRequestConflict)
+}
+
+func isNotFound(err error) bool {
+	aErr, ok := err.(awserr.Error)
+	return ok && aErr.Code() == s3.ErrCodeNoSuchKey
+}
@@ -0,0 +1,160 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3StoreLoad(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	lastRun := time.Date(2019, 1, 1, 2, 0, 0, 0, time.UTC)
+	gomock.InOrder(
+		mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("schedules/daily"),
+		}).Return(&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewReader([]byte(`{"lastRun":"2019-01-01T02:00:00Z"}`))),
+		}, nil),
+		mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "", nil)),
+		mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("denied")),
+	)
+
+	store := &S3Store{Bucket: "bucket", Client: mockS3}
+	actual, err := store.Load(context.Background(), "daily")
+	assert.Nil(t, err)
+	assert.True(t, lastRun.Equal(actual))
+
+	actual, err = store.Load(context.Background(), "daily")
+	assert.Nil(t, err)
+	assert.True(t, actual.IsZero())
+
+	_, err = store.Load(context.Background(), "daily")
+	assert.NotNil(t, err)
+}
+
+func TestS3StoreSave(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().PutObjectWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx aws.Context, input *s3.PutObjectInput, opts ...interface{}) (*s3.PutObjectOutput, error) {
+			assert.Equal(t, "bucket", aws.StringValue(input.Bucket))
+			assert.Equal(t, "schedules/daily", aws.StringValue(input.Key))
+			body, _ := ioutil.ReadAll(input.Body)
+			assert.JSONEq(t, `{"lastRun":"2019-01-01T02:00:00Z"}`, string(body))
+			return &s3.PutObjectOutput{}, nil
+		})
+
+	store := &S3Store{Bucket: "bucket", Client: mockS3}
+	assert.Nil(t, store.Save(context.Background(), "daily", time.Date(2019, 1, 1, 2, 0, 0, 0, time.UTC)))
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := &MemoryStore{}
+	actual, err := store.Load(context.Background(), "daily")
+	assert.Nil(t, err)
+	assert.True(t, actual.IsZero())
+
+	lastRun := time.Date(2019, 1, 1, 2, 0, 0, 0, time.UTC)
+	assert.Nil(t, store.Save(context.Background(), "daily", lastRun))
+	actual, err = store.Load(context.Background(), "daily")
+	assert.Nil(t, err)
+	assert.Equal(t, lastRun, actual)
+}
+
+func TestS3StoreClaim(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	now := time.Date(2019, 1, 9, 2, 0, 0, 0, time.UTC)
+	run := time.Date(2019, 1, 9, 2, 0, 0, 0, time.UTC)
+	held := `{"run":"2019-01-09T02:00:00Z","owner":"other","expires":"2019-01-09T02:05:00Z"}`
+	expired := `{"run":"2019-01-09T02:00:00Z","owner":"other","expires":"2019-01-09T01:00:00Z"}`
+	gomock.InOrder(
+		// unclaimed runs are claimed
+		mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("schedules/daily.claim"),
+		}).Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "", nil)),
+		mockS3.EXPECT().PutObjectWithContext(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx aws.Context, input *s3.PutObjectInput, opts ...interface{}) (*s3.PutObjectOutput, error) {
+				assert.Equal(t, "schedules/daily.claim", aws.StringValue(input.Key))
+				body, _ := ioutil.ReadAll(input.Body)
+				assert.JSONEq(t, `{"run":"2019-01-09T02:00:00Z","owner":"owner","expires":"2019-01-09T02:05:00Z"}`, string(body))
+				return &s3.PutObjectOutput{}, nil
+			}),
+		// runs claimed by another owner are not
+		mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewReader([]byte(held))),
+			ETag: aws.String(`"etag"`),
+		}, nil),
+		// expired claims are replaced
+		mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewReader([]byte(expired))),
+			ETag: aws.String(`"etag"`),
+		}, nil),
+		mockS3.EXPECT().PutObjectWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(&s3.PutObjectOutput{}, nil),
+		// unless another owner replaces them first
+		mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "", nil)),
+		mockS3.EXPECT().PutObjectWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(
+			nil, awserr.NewRequestFailure(awserr.New(errCodePreconditionFailed, "", nil), http.StatusPreconditionFailed, ""),
+		),
+		mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("denied")),
+	)
+
+	store := &S3Store{Bucket: "bucket", Client: mockS3, now: func() time.Time { return now }}
+	claimed, err := store.Claim(context.Background(), "daily", run, "owner")
+	assert.Nil(t, err)
+	assert.True(t, claimed)
+
+	claimed, err = store.Claim(context.Background(), "daily", run, "owner")
+	assert.Nil(t, err)
+	assert.False(t, claimed)
+
+	claimed, err = store.Claim(context.Background(), "daily", run, "owner")
+	assert.Nil(t, err)
+	assert.True(t, claimed)
+
+	claimed, err = store.Claim(context.Background(), "daily", run, "owner")
+	assert.Nil(t, err)
+	assert.False(t, claimed)
+
+	_, err = store.Claim(context.Background(), "daily", run, "owner")
+	assert.NotNil(t, err)
+}
+
+func TestMemoryStoreClaim(t *testing.T) {
+	store := &MemoryStore{}
+	run := time.Date(2019, 1, 9, 2, 0, 0, 0, time.UTC)
+	claimed, err := store.Claim(context.Background(), "daily", run, "a")
+	assert.Nil(t, err)
+	assert.True(t, claimed)
+
+	// the owner may claim the run again, but no other owner may claim it or an earlier run
+	claimed, _ = store.Claim(context.Background(), "daily", run, "a")
+	assert.True(t, claimed)
+	claimed, _ = store.Claim(context.Background(), "daily", run, "b")
+	assert.False(t, claimed)
+	claimed, _ = store.Claim(context.Background(), "daily", run.Add(-time.Hour), "b")
+	assert.False(t, claimed)
+
+	// a later run may be claimed
+	claimed, _ = store.Claim(context.Background(), "daily", run.Add(24*time.Hour), "b")
+	assert.True(t, claimed)
+}
@@ -0,0 +1,66 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+)
+
+const (
+	// TemplateHourly compares the last full hour with the hour before it.
+	TemplateHourly = "hourly"
+	// TemplateDaily compares the last full day with the day before it.
+	TemplateDaily = "daily"
+	// TemplateWeekly compares the last full week, starting on Monday, with the week before it.
+	TemplateWeekly = "weekly"
+)
+
+const week = 7 * 24 * time.Hour
+
+// Template selects the windows of a scheduled diff. The next window is the last full window
+// which ends at or before the scheduled time, and the previous window is the one before it.
+// Windows are aligned in UTC.
+type Template struct {
+	Name   string
+	Window time.Duration
+}
+
+var templates = map[string]Template{
+	TemplateHourly: {Name: TemplateHourly, Window: time.Hour},
+	TemplateDaily:  {Name: TemplateDaily, Window: 24 * time.Hour},
+	TemplateWeekly: {Name: TemplateWeekly, Window: week},
+}
+
+// ErrUnsupportedTemplate is returned when a schedule names a template which does not exist.
+type ErrUnsupportedTemplate struct {
+	Template string
+}
+
+func (e ErrUnsupportedTemplate) Error() string {
+	return fmt.Sprintf("unsupported template %q, expected one of hourly, daily or weekly", e.Template)
+}
+
+// LookupTemplate returns the template of the given name.
+func LookupTemplate(name string) (Template, error) {
+	t, ok := templates[name]
+	if !ok {
+		return Template{}, ErrUnsupportedTemplate{Template: name}
+	}
+	return t, nil
+}
+
+// Diff returns the diff of the windows of a run scheduled at the given time.
+func (t Template) Diff(run time.Time) domain.Diff {
+	run = run.UTC()
+	var end time.Time
+	if t.Window == week {
+		// weeks start on Monday rather than on the Thursday of the Unix epoch
+		day := run.Truncate(24 * time.Hour)
+		end = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	} else {
+		end = run.Truncate(t.Window)
+	}
+	start := end.Add(-t.Window)
+	return domain.NewDiff(start.Add(-t.Window), start, start, end)
+}
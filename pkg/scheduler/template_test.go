@@ -0,0 +1,51 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateDiff(t *testing.T) {
+	// a Wednesday
+	run := time.Date(2019, 1, 9, 2, 30, 0, 0, time.UTC)
+	tc := []struct {
+		template string
+		expected domain.Diff
+	}{
+		{TemplateHourly, domain.NewDiff(
+			time.Date(2019, 1, 9, 0, 0, 0, 0, time.UTC), time.Date(2019, 1, 9, 1, 0, 0, 0, time.UTC),
+			time.Date(2019, 1, 9, 1, 0, 0, 0, time.UTC), time.Date(2019, 1, 9, 2, 0, 0, 0, time.UTC),
+		)},
+		{TemplateDaily, domain.NewDiff(
+			time.Date(2019, 1, 7, 0, 0, 0, 0, time.UTC), time.Date(2019, 1, 8, 0, 0, 0, 0, time.UTC),
+			time.Date(2019, 1, 8, 0, 0, 0, 0, time.UTC), time.Date(2019, 1, 9, 0, 0, 0, 0, time.UTC),
+		)},
+		{TemplateWeekly, domain.NewDiff(
+			time.Date(2018, 12, 24, 0, 0, 0, 0, time.UTC), time.Date(2018, 12, 31, 0, 0, 0, 0, time.UTC),
+			time.Date(2018, 12, 31, 0, 0, 0, 0, time.UTC), time.Date(2019, 1, 7, 0, 0, 0, 0, time.UTC),
+		)},
+	}
+	for _, tt := range tc {
+		t.Run(tt.template, func(t *testing.T) {
+			template, err := LookupTemplate(tt.template)
+			require.Nil(t, err)
+			assert.Equal(t, tt.expected, template.Diff(run))
+		})
+	}
+}
+
+func TestTemplateDiffWeeklyOnMonday(t *testing.T) {
+	template, _ := LookupTemplate(TemplateWeekly)
+	diff := template.Diff(time.Date(2019, 1, 7, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2019, 1, 7, 0, 0, 0, 0, time.UTC), diff.NextStop)
+	assert.Equal(t, time.Date(2018, 12, 24, 0, 0, 0, 0, time.UTC), diff.PreviousStart)
+}
+
+func TestLookupTemplateUnsupported(t *testing.T) {
+	_, err := LookupTemplate("monthly")
+	assert.Equal(t, ErrUnsupportedTemplate{Template: "monthly"}, err)
+}
@@ -0,0 +1,372 @@
+package diffd
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/logevent"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/openapi"
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/require"
+)
+
+const specGraph = `digraph {
+n1 [label="10.0.0.1"]
+n2 [label="10.0.0.2"]
+n1 -> n2 [govpc_accountID="1" govpc_eniID="eni" govpc_srcPort="0" govpc_dstPort="80" govpc_protocol="6" color=green label="a"]
+}
+`
+
+// memoryModules is an in memory Storage, ObjectStorage, Marker, Queuer and Grapher, which holds diffs as the
+// built in modules would.
+type memoryModules struct {
+	lock   sync.Mutex
+	diffs  map[string][]byte
+	stored map[string]time.Time
+	meta   map[string]domain.DiffMetadata
+	marked map[string]bool
+	queued []domain.Diff
+}
+
+func newMemoryModules() *memoryModules {
+	return &memoryModules{
+		diffs:  make(map[string][]byte),
+		stored: make(map[string]time.Time),
+		meta:   make(map[string]domain.DiffMetadata),
+		marked: make(map[string]bool),
+	}
+}
+
+func (m *memoryModules) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.marked[key] {
+		return nil, domain.ErrInProgress{Key: key}
+	}
+	diff, ok := m.diffs[key]
+	if !ok {
+		return nil, domain.ErrNotFound{ID: key}
+	}
+	return ioutil.NopCloser(bytes.NewReader(diff)), nil
+}
+
+func (m *memoryModules) Exists(ctx context.Context, key string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.marked[key] {
+		return false, domain.ErrInProgress{Key: key}
+	}
+	_, ok := m.diffs[key]
+	return ok, nil
+}
+
+func (m *memoryModules) Store(ctx context.Context, key string, data io.ReadCloser) error {
+	diff, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.diffs[key] = diff
+	m.stored[key] = time.Now()
+	return nil
+}
+
+func (m *memoryModules) Stat(ctx context.Context, key string) (domain.ObjectInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.marked[key] {
+		return domain.ObjectInfo{}, domain.ErrInProgress{Key: key}
+	}
+	diff, ok := m.diffs[key]
+	if !ok {
+		return domain.ObjectInfo{}, domain.ErrNotFound{ID: key}
+	}
+	return domain.ObjectInfo{
+		Size:     int64(len(diff)),
+		Checksum: fmt.Sprintf("%x", md5.Sum(diff)),
+		Created:  m.stored[key],
+	}, nil
+}
+
+func (m *memoryModules) GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	diff, ok := m.diffs[key]
+	if !ok {
+		return nil, domain.ErrNotFound{ID: key}
+	}
+	return ioutil.NopCloser(bytes.NewReader(diff[offset:])), nil
+}
+
+func (m *memoryModules) Metadata(ctx context.Context, key string) (domain.DiffMetadata, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	meta, ok := m.meta[key]
+	if !ok {
+		return domain.DiffMetadata{}, domain.ErrNotFound{ID: key}
+	}
+	return meta, nil
+}
+
+func (m *memoryModules) Index(ctx context.Context, meta domain.DiffMetadata) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.meta[meta.Key()] = meta
+	return nil
+}
+
+func (m *memoryModules) List(ctx context.Context, filter domain.ListFilter) (domain.DiffPage, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var page domain.DiffPage
+	for _, meta := range m.meta {
+		if filter.Matches(meta) {
+			page.Diffs = append(page.Diffs, meta)
+		}
+	}
+	return page, nil
+}
+
+func (m *memoryModules) Delete(ctx context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.diffs, key)
+	delete(m.stored, key)
+	delete(m.meta, key)
+	return nil
+}
+
+func (m *memoryModules) Mark(ctx context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.marked[key] = true
+	return nil
+}
+
+func (m *memoryModules) Unmark(ctx context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.marked, key)
+	return nil
+}
+
+func (m *memoryModules) Queue(ctx context.Context, d domain.Diff) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.queued = append(m.queued, d)
+	return nil
+}
+
+func (m *memoryModules) Graph(ctx context.Context, start, stop time.Time) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewBufferString(specGraph)), nil
+}
+
+// TestServiceMatchesSpec fails if the routes of the service and the operations of api.yaml
+// diverge, or if a response of the service does not match its operation. Every operation
+// must be exercised by at least one request.
+func TestServiceMatchesSpec(t *testing.T) {
+	validator, err := openapi.NewValidator()
+	require.Nil(t, err)
+	modules := newMemoryModules()
+	s := &Service{
+		Config:  NewConfig(),
+		Storage: modules,
+		Marker:  modules,
+		Queuer:  modules,
+		Grapher: modules,
+	}
+	router := chi.NewMux()
+	require.Nil(t, s.BindRoutes(router))
+	require.ElementsMatch(t, validator.Operations(), routes(t, router))
+	defer s.backfiller.Wait()
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	hour := time.Hour
+	diff := domain.NewDiff(start, start.Add(hour), start.Add(hour), start.Add(2*hour))
+	other := domain.NewDiff(start.Add(hour), start.Add(2*hour), start.Add(2*hour), start.Add(3*hour))
+	rangeQuery := "previous_start=2019-01-01T00:00:00Z&previous_stop=2019-01-01T01:00:00Z&next_start=2019-01-01T01:00:00Z&next_stop=2019-01-01T02:00:00Z"
+	job := `{"id": "` + diff.ID + `", "previousStart": "2019-01-01T00:00:00Z", "previousStop": "2019-01-01T01:00:00Z",
+		"nextStart": "2019-01-01T01:00:00Z", "nextStop": "2019-01-01T02:00:00Z"}`
+	spec := `{"previous": {"start": "2019-01-01T01:00:00Z", "stop": "2019-01-01T02:00:00Z"},
+		"next": {"start": "2019-01-01T02:00:00Z", "stop": "2019-01-01T03:00:00Z"}}`
+	backfillLocation := ""
+
+	tc := []struct {
+		Method string
+		Target string
+		Body   string
+		Header http.Header
+		Status int
+	}{
+		{http.MethodGet, "/healthcheck", "", nil, http.StatusOK},
+		{http.MethodGet, "/ready", "", nil, http.StatusOK},
+		{http.MethodPost, "/?" + rangeQuery, "", nil, http.StatusAccepted},
+		{http.MethodPost, "/?" + rangeQuery, "", nil, http.StatusConflict},
+		{http.MethodPost, "/?previous_start=2019-01-01T00:00:00Z", "", nil, http.StatusBadRequest},
+		{http.MethodGet, "/?" + rangeQuery, "", nil, http.StatusNoContent},
+		{http.MethodPost, "/diffs/create", job, nil, http.StatusNoContent},
+		{http.MethodPost, "/diffs/create", `{"id": "` + diff.ID + `"}`, nil, http.StatusBadRequest},
+		{http.MethodGet, "/?" + rangeQuery, "", nil, http.StatusOK},
+		{http.MethodGet, "/diffs?limit=10", "", nil, http.StatusOK},
+		{http.MethodGet, "/diffs?limit=0", "", nil, http.StatusBadRequest},
+		{http.MethodPost, "/batch", `[{"previousStart": "2019-01-01T00:00:00Z", "previousStop": "2019-01-01T01:00:00Z",
+			"nextStart": "2019-01-01T01:00:00Z", "nextStop": "2019-01-01T02:00:00Z"},
+			{"previousStart": "2019-01-01T01:00:00Z", "previousStop": "2019-01-01T00:00:00Z",
+			"nextStart": "2019-01-01T01:00:00Z", "nextStop": "2019-01-01T02:00:00Z"}]`, nil, http.StatusOK},
+		{http.MethodPost, "/batch", `[]`, nil, http.StatusBadRequest},
+		{http.MethodGet, "/v2/diffs/" + diff.ID, "", nil, http.StatusOK},
+		{http.MethodGet, "/v2/diffs/" + diff.ID + "?format=gzip", "", nil, http.StatusOK},
+		{http.MethodGet, "/v2/diffs/" + diff.ID, "", http.Header{"Range": {"bytes=0-6"}}, http.StatusPartialContent},
+		{http.MethodGet, "/v2/diffs/" + diff.ID, "", http.Header{"If-Modified-Since": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}}, http.StatusNotModified},
+		{http.MethodGet, "/v2/diffs/" + diff.ID, "", http.Header{"Range": {"bytes=1000000-"}}, http.StatusRequestedRangeNotSatisfiable},
+		{http.MethodGet, "/?" + rangeQuery, "", http.Header{"Range": {"bytes=1-"}}, http.StatusPartialContent},
+		{http.MethodGet, "/v2/diffs/" + other.ID, "", nil, http.StatusNotFound},
+		{http.MethodPost, "/v2/diffs", spec, nil, http.StatusAccepted},
+		{http.MethodPost, "/v2/diffs", spec, nil, http.StatusConflict},
+		{http.MethodGet, "/v2/diffs/" + other.ID, "", nil, http.StatusConflict},
+		{http.MethodPost, "/v2/diffs", `{"previous": {}}`, nil, http.StatusBadRequest},
+		{http.MethodDelete, "/v2/diffs/" + other.ID, "", nil, http.StatusNoContent},
+		{http.MethodDelete, "/?" + rangeQuery, "", nil, http.StatusNoContent},
+		{http.MethodDelete, "/?id=digest", "", nil, http.StatusBadRequest},
+		{http.MethodGet, "/?" + rangeQuery, "", nil, http.StatusNotFound},
+		{http.MethodGet, "/schedules", "", nil, http.StatusOK},
+		{http.MethodPost, "/backfills?start=2019-01-01T00:00:00Z&stop=2019-01-01T04:00:00Z&template=hourly", "", nil, http.StatusAccepted},
+		{http.MethodPost, "/backfills?start=2019-01-01T00:00:00Z&stop=2019-01-01T04:00:00Z&template=yearly", "", nil, http.StatusBadRequest},
+		{http.MethodGet, "", "", nil, http.StatusOK},
+		{http.MethodGet, "/backfills/missing", "", nil, http.StatusNotFound},
+	}
+	covered := make(map[string]bool)
+	logger := logevent.New(logevent.Config{Output: ioutil.Discard})
+	for _, tt := range tc {
+		target := tt.Target
+		if target == "" {
+			target = backfillLocation
+		}
+		r := httptest.NewRequest(tt.Method, target, bytes.NewBufferString(tt.Body))
+		if tt.Body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		for name, values := range tt.Header {
+			r.Header[name] = values
+		}
+		r = r.WithContext(logevent.NewContext(context.Background(), logger))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		require.Equal(t, tt.Status, w.Code, "%s %s: %s", tt.Method, target, w.Body.String())
+
+		var body []byte
+		if mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type")); mediaType == "application/json" {
+			body = w.Body.Bytes()
+			require.True(t, json.Valid(body))
+		}
+		require.Nil(t, validator.ValidateResponse(r, w.Code, w.Header(), body), "%s %s", tt.Method, target)
+		route, err := validator.Route(r)
+		require.Nil(t, err)
+		covered[route] = true
+		if route == "POST /backfills" && w.Code == http.StatusAccepted {
+			backfillLocation = w.Header().Get("Location")
+		}
+	}
+	for _, operation := range validator.Operations() {
+		require.True(t, covered[operation], "%s is not exercised", operation)
+	}
+}
+
+func TestServiceMatchesSpecTenancy(t *testing.T) {
+	validator, err := openapi.NewValidator()
+	require.Nil(t, err)
+	modules := newMemoryModules()
+	conf := NewConfig()
+	conf.Tenancy.Enabled = true
+	s := &Service{
+		Config:  conf,
+		Storage: modules,
+		Marker:  modules,
+		Queuer:  modules,
+		Grapher: modules,
+	}
+	router := chi.NewMux()
+	require.Nil(t, s.BindRoutes(router))
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	hour := time.Hour
+	diff := domain.NewScopedDiff(start, start.Add(hour), start.Add(hour), start.Add(2*hour), domain.NewScope("acme", []string{"1"}))
+	rangeQuery := "previous_start=2019-01-01T00:00:00Z&previous_stop=2019-01-01T01:00:00Z&next_start=2019-01-01T01:00:00Z&next_stop=2019-01-01T02:00:00Z"
+	job := `{"id": "` + diff.ID + `", "previousStart": "2019-01-01T00:00:00Z", "previousStop": "2019-01-01T01:00:00Z",
+		"nextStart": "2019-01-01T01:00:00Z", "nextStop": "2019-01-01T02:00:00Z", "tenant": "acme", "accounts": ["1"]}`
+	spec := `{"previous": {"start": "2019-01-01T01:00:00Z", "stop": "2019-01-01T02:00:00Z"},
+		"next": {"start": "2019-01-01T02:00:00Z", "stop": "2019-01-01T03:00:00Z"}, "accounts": ["2"]}`
+	batch := `[{"previousStart": "2019-01-01T00:00:00Z", "previousStop": "2019-01-01T01:00:00Z",
+		"nextStart": "2019-01-01T01:00:00Z", "nextStop": "2019-01-01T02:00:00Z", "accounts": ["2"]}]`
+	all := http.Header{"X-Tenant-Id": {"acme"}, "X-Account-Ids": {"*"}}
+	restricted := http.Header{"X-Tenant-Id": {"acme"}, "X-Account-Ids": {"1"}}
+	other := http.Header{"X-Tenant-Id": {"acme"}, "X-Account-Ids": {"2"}}
+
+	tc := []struct {
+		Method string
+		Target string
+		Body   string
+		Header http.Header
+		Status int
+	}{
+		{http.MethodPost, "/?" + rangeQuery, "", nil, http.StatusForbidden},
+		{http.MethodPost, "/?" + rangeQuery + "&accounts=2", "", restricted, http.StatusForbidden},
+		{http.MethodPost, "/?" + rangeQuery + "&accounts=1", "", all, http.StatusAccepted},
+		{http.MethodPost, "/diffs/create", job, nil, http.StatusNoContent},
+		{http.MethodGet, "/?" + rangeQuery, "", restricted, http.StatusOK},
+		{http.MethodGet, "/?" + rangeQuery, "", nil, http.StatusForbidden},
+		{http.MethodGet, "/v2/diffs/" + diff.ID, "", restricted, http.StatusOK},
+		{http.MethodGet, "/v2/diffs/" + diff.ID, "", other, http.StatusForbidden},
+		{http.MethodGet, "/diffs", "", restricted, http.StatusOK},
+		{http.MethodGet, "/diffs?accounts=2", "", restricted, http.StatusForbidden},
+		{http.MethodPost, "/batch", batch, restricted, http.StatusOK},
+		{http.MethodPost, "/batch", batch, nil, http.StatusForbidden},
+		{http.MethodPost, "/v2/diffs", spec, restricted, http.StatusForbidden},
+		{http.MethodPost, "/v2/diffs", spec, other, http.StatusAccepted},
+		{http.MethodDelete, "/v2/diffs/" + diff.ID, "", other, http.StatusForbidden},
+		{http.MethodDelete, "/?id=" + diff.ID, "", other, http.StatusForbidden},
+		{http.MethodDelete, "/?id=" + diff.ID, "", restricted, http.StatusNoContent},
+	}
+	logger := logevent.New(logevent.Config{Output: ioutil.Discard})
+	for _, tt := range tc {
+		r := httptest.NewRequest(tt.Method, tt.Target, bytes.NewBufferString(tt.Body))
+		if tt.Body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		for name, values := range tt.Header {
+			r.Header[name] = values
+		}
+		r = r.WithContext(logevent.NewContext(context.Background(), logger))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		require.Equal(t, tt.Status, w.Code, "%s %s: %s", tt.Method, tt.Target, w.Body.String())
+
+		var body []byte
+		if mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type")); mediaType == "application/json" {
+			body = w.Body.Bytes()
+			require.True(t, json.Valid(body))
+		}
+		require.Nil(t, validator.ValidateResponse(r, w.Code, w.Header(), body), "%s %s", tt.Method, tt.Target)
+	}
+
+	// diffs are queued in the scope of their tenant and accounts, and deleted by their key
+	modules.lock.Lock()
+	defer modules.lock.Unlock()
+	require.Len(t, modules.queued, 2)
+	require.Equal(t, diff, modules.queued[0])
+	require.Equal(t, domain.NewScope("acme", []string{"2"}), modules.queued[1].Scope)
+	require.NotContains(t, modules.diffs, diff.Key())
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// EncodingGzip compresses diffs with gzip.
+	EncodingGzip = "gzip"
+
+	// EncodingZstd compresses diffs with Zstandard.
+	EncodingZstd = "zstd"
+)
+
+// ErrUnsupportedEncoding is returned when a diff is stored or fetched with an encoding
+// which is not supported.
+type ErrUnsupportedEncoding struct {
+	Encoding string
+}
+
+func (e ErrUnsupportedEncoding) Error() string {
+	return fmt.Sprintf("unsupported encoding %s", e.Encoding)
+}
+
+// ValidateEncoding returns an error if the encoding is neither empty nor supported.
+func ValidateEncoding(encoding string) error {
+	switch encoding {
+	case "", EncodingGzip, EncodingZstd:
+		return nil
+	}
+	return ErrUnsupportedEncoding{Encoding: encoding}
+}
+
+// Encode returns a reader of the data compressed with the given encoding. The data is
+// compressed as it is read rather than being buffered.
+func Encode(data io.Reader, encoding string) (io.ReadCloser, error) {
+	if err := ValidateEncoding(encoding); err != nil {
+		return nil, err
+	}
+	r, w := io.Pipe()
+	go func() {
+		var enc io.WriteCloser
+		var err error
+		switch encoding {
+		case EncodingGzip:
+			enc = gzip.NewWriter(w)
+		case EncodingZstd:
+			enc, err = zstd.NewWriter(w)
+		}
+		if err == nil {
+			_, err = io.Copy(enc, data)
+			if closeErr := enc.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		_ = w.CloseWithError(err)
+	}()
+	return r, nil
+}
+
+// Decode returns a reader of the decompressed body. Closing the returned reader closes the
+// body. An empty encoding returns the body as-is.
+func Decode(body io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case "":
+		return body, nil
+	case EncodingGzip:
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			_ = body.Close()
+			return nil, err
+		}
+		return &decoder{Reader: gz, closers: []io.Closer{gz, body}}, nil
+	case EncodingZstd:
+		zr, err := zstd.NewReader(body)
+		if err != nil {
+			_ = body.Close()
+			return nil, err
+		}
+		return &decoder{Reader: zr, closers: []io.Closer{zr.IOReadCloser(), body}}, nil
+	}
+	_ = body.Close()
+	return nil, ErrUnsupportedEncoding{Encoding: encoding}
+}
+
+type decoder struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decoder) Close() error {
+	var err error
+	for _, c := range d.closers {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
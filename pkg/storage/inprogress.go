@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"time"
@@ -17,7 +18,9 @@ const inProgressSuffix = "_in_progress"
 // InProgress is an implementation of Storage which is intended to decorate the S3 implementation.
 //
 // The decorator will check if a diff is in progress, and if so, will return domain.ErrInProgress.
-// On a successful Store operation, the decorator will remove the diff's "in progress" status.
+// A diff which is being created again still has its previous version fetched by Get,
+// GetEncoded and Stat, so that it is served until the new version replaces it. On a
+// successful Store operation, the decorator will remove the diff's "in progress" status.
 type InProgress struct {
 	Bucket  string
 	Timeout time.Duration
@@ -27,21 +30,81 @@ type InProgress struct {
 
 // Get returns the diff for the given key.
 //
-// If the diff is in the process of being created, an error will be returned of type domain.ErrInProgress
+// If the diff is in the process of being created and has no previous version, an error will
+// be returned of type domain.ErrInProgress
 func (s *InProgress) Get(ctx context.Context, key string) (io.ReadCloser, error) {
 	inProgress, err := s.isInProgress(ctx, key)
 	if err != nil {
 		return nil, err
 	}
+	body, err := s.Storage.Get(ctx, key)
 	if inProgress {
-		return nil, domain.ErrInProgress{Key: key}
+		err = previousError(key, err)
 	}
-	return s.Storage.Get(ctx, key)
+	return body, err
+}
+
+// GetEncoded returns the diff for the given key along with its encoding. If the decorated
+// Storage does not implement domain.EncodedStorage, the diff is returned by Get with an
+// empty encoding.
+//
+// If the diff is in the process of being created and has no previous version, an error will
+// be returned of type domain.ErrInProgress
+func (s *InProgress) GetEncoded(ctx context.Context, key string, accept []string) (io.ReadCloser, string, error) {
+	inProgress, err := s.isInProgress(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	var body io.ReadCloser
+	var encoding string
+	if encoded, ok := s.Storage.(domain.EncodedStorage); ok {
+		body, encoding, err = encoded.GetEncoded(ctx, key, accept)
+	} else {
+		body, err = s.Storage.Get(ctx, key)
+	}
+	if inProgress {
+		err = previousError(key, err)
+	}
+	return body, encoding, err
+}
+
+// Stat returns the ObjectInfo of the diff for the given key. If the decorated Storage does not
+// implement domain.ObjectStorage, an error of type domain.ErrUnsupported is returned.
+//
+// If the diff is in the process of being created and has no previous version, an error will
+// be returned of type domain.ErrInProgress
+func (s *InProgress) Stat(ctx context.Context, key string) (domain.ObjectInfo, error) {
+	objects, ok := s.Storage.(domain.ObjectStorage)
+	if !ok {
+		return domain.ObjectInfo{}, domain.ErrUnsupported{Operation: "Stat"}
+	}
+	inProgress, err := s.isInProgress(ctx, key)
+	if err != nil {
+		return domain.ObjectInfo{}, err
+	}
+	info, err := objects.Stat(ctx, key)
+	if inProgress {
+		err = previousError(key, err)
+	}
+	return info, err
+}
+
+// GetRange returns the diff for the given key, as it is stored, from the given offset. The
+// diff is not checked again, as its content is only fetched once it has been checked by Stat.
+// If the decorated Storage does not implement domain.ObjectStorage, an error of type
+// domain.ErrUnsupported is returned.
+func (s *InProgress) GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	objects, ok := s.Storage.(domain.ObjectStorage)
+	if !ok {
+		return nil, domain.ErrUnsupported{Operation: "GetRange"}
+	}
+	return objects.GetRange(ctx, key, offset)
 }
 
 // Exists returns true if the diff exists, but does not download the diff body.
 //
-// If the diff is in the process of being created, an error will be returned of type domain.ErrInProgress
+// If the diff is in the process of being created, an error will be returned of type
+// domain.ErrInProgress, even if a previous version of the diff exists.
 func (s *InProgress) Exists(ctx context.Context, key string) (bool, error) {
 	inProgress, err := s.isInProgress(ctx, key)
 	if err != nil {
@@ -53,6 +116,61 @@ func (s *InProgress) Exists(ctx context.Context, key string) (bool, error) {
 	return s.Storage.Exists(ctx, key)
 }
 
+// Metadata returns the metadata recorded for the diff. If the decorated Storage does not
+// implement domain.IndexedStorage, an error of type domain.ErrUnsupported is returned.
+func (s *InProgress) Metadata(ctx context.Context, key string) (domain.DiffMetadata, error) {
+	indexed, ok := s.Storage.(domain.IndexedStorage)
+	if !ok {
+		return domain.DiffMetadata{}, domain.ErrUnsupported{Operation: "Metadata"}
+	}
+	return indexed.Metadata(ctx, key)
+}
+
+// Index records the metadata of a diff. If the decorated Storage does not implement
+// domain.IndexedStorage, an error of type domain.ErrUnsupported is returned.
+func (s *InProgress) Index(ctx context.Context, meta domain.DiffMetadata) error {
+	indexed, ok := s.Storage.(domain.IndexedStorage)
+	if !ok {
+		return domain.ErrUnsupported{Operation: "Index"}
+	}
+	return indexed.Index(ctx, meta)
+}
+
+// List returns a page of the metadata of indexed diffs which match the filter. Diffs which
+// are being created, or created again, are reported with the domain.DiffStatusInProgress
+// status. If the decorated Storage does not implement domain.IndexedStorage, an error of type
+// domain.ErrUnsupported is returned.
+func (s *InProgress) List(ctx context.Context, filter domain.ListFilter) (domain.DiffPage, error) {
+	indexed, ok := s.Storage.(domain.IndexedStorage)
+	if !ok {
+		return domain.DiffPage{}, domain.ErrUnsupported{Operation: "List"}
+	}
+	page, err := indexed.List(ctx, filter)
+	if err != nil {
+		return domain.DiffPage{}, err
+	}
+	inProgress := make([]bool, len(page.Diffs))
+	err = forEach(len(page.Diffs), func(offset int) error {
+		var err error
+		inProgress[offset], err = s.isInProgress(ctx, page.Diffs[offset].Key())
+		return err
+	})
+	if err != nil {
+		return domain.DiffPage{}, err
+	}
+	for offset := range page.Diffs {
+		if inProgress[offset] {
+			page.Diffs[offset].Status = domain.DiffStatusInProgress
+		}
+	}
+	return page, nil
+}
+
+// Delete removes the diff and its metadata.
+func (s *InProgress) Delete(ctx context.Context, key string) error {
+	return s.Storage.Delete(ctx, key)
+}
+
 func (s *InProgress) isInProgress(ctx context.Context, key string) (bool, error) {
 	res, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.Bucket),
@@ -68,7 +186,29 @@ func (s *InProgress) isInProgress(ctx context.Context, key string) (bool, error)
 	if err != nil {
 		return false, err
 	}
-	ts, _ := time.Parse(time.RFC3339Nano, string(b))
 	now := time.Now()
+	// markers written by marker.LeaseMarker record their own expiry, while those written by
+	// marker.ProgressMarker record the time they were written
+	var l lease
+	if err := json.Unmarshal(b, &l); err == nil {
+		return now.Before(l.Expires), nil
+	}
+	ts, _ := time.Parse(time.RFC3339Nano, string(b))
 	return now.Before(ts.Add(s.Timeout)), nil
 }
+
+// previousError returns the error of fetching the previous version of a diff which is in
+// progress. A diff without a previous version, or whose previous version has expired, is
+// reported as in progress.
+func previousError(key string, err error) error {
+	switch err.(type) {
+	case domain.ErrNotFound, domain.ErrExpired:
+		return domain.ErrInProgress{Key: key}
+	}
+	return err
+}
+
+// lease is the part of a marker.Lease which is needed to tell whether a diff is in progress
+type lease struct {
+	Expires time.Time `json:"expires"`
+}
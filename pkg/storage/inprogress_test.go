@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"testing"
 	"time"
@@ -78,12 +79,15 @@ func TestGetInProgressBeforeTimeout(t *testing.T) {
 	}
 
 	mockClient := NewMockS3API(ctrl)
+	mockStorage := NewMockStorage(ctrl)
 	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), expectedInput).Return(getOutput, nil)
+	mockStorage.EXPECT().Get(gomock.Any(), key).Return(nil, domain.ErrNotFound{ID: key})
 
 	ip := &InProgress{
 		Timeout: time.Hour,
 		Bucket:  bucket,
 		Client:  mockClient,
+		Storage: mockStorage,
 	}
 	_, err := ip.Get(context.Background(), key)
 	assert.NotNil(t, err)
@@ -91,6 +95,52 @@ func TestGetInProgressBeforeTimeout(t *testing.T) {
 	assert.True(t, ok)
 }
 
+func TestGetInProgressPrevious(t *testing.T) {
+	tc := []struct {
+		Name     string
+		Err      error
+		Expected error
+	}{
+		{
+			Name: "previous",
+		},
+		{
+			Name:     "expired",
+			Err:      domain.ErrExpired{ID: key},
+			Expected: domain.ErrInProgress{Key: key},
+		},
+		{
+			Name:     "failure",
+			Err:      errors.New("oops"),
+			Expected: errors.New("oops"),
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			getOutput := &s3.GetObjectOutput{
+				Body: ioutil.NopCloser(bytes.NewBufferString(time.Now().Format(time.RFC3339))),
+			}
+			mockClient := NewMockS3API(ctrl)
+			mockStorage := NewMockStorage(ctrl)
+			mockClient.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(getOutput, nil)
+			// the previous version of a diff which is created again is served until it is replaced
+			mockStorage.EXPECT().Get(gomock.Any(), key).Return(ioutil.NopCloser(bytes.NewReader(nil)), tt.Err)
+
+			ip := &InProgress{
+				Timeout: time.Hour,
+				Bucket:  bucket,
+				Client:  mockClient,
+				Storage: mockStorage,
+			}
+			_, err := ip.Get(context.Background(), key)
+			assert.Equal(t, tt.Expected, err)
+		})
+	}
+}
+
 func TestGetInProgressAfterTimeout(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -122,6 +172,127 @@ func TestGetInProgressAfterTimeout(t *testing.T) {
 	assert.Equal(t, string(output), string(data))
 }
 
+func TestGetInProgressLease(t *testing.T) {
+	tc := []struct {
+		Name       string
+		Expires    time.Time
+		InProgress bool
+	}{
+		{
+			Name:       "live",
+			Expires:    time.Now().Add(time.Minute),
+			InProgress: true,
+		},
+		{
+			Name:       "expired",
+			Expires:    time.Now().Add(-time.Minute),
+			InProgress: false,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// the expiry of a lease is used instead of the timeout
+			body := fmt.Sprintf(`{"owner":"lease","expires":"%s"}`, tt.Expires.Format(time.RFC3339Nano))
+			mockClient := NewMockS3API(ctrl)
+			mockClient.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(&s3.GetObjectOutput{
+				Body: ioutil.NopCloser(bytes.NewBufferString(body)),
+			}, nil)
+			mockStorage := NewMockStorage(ctrl)
+			if !tt.InProgress {
+				mockStorage.EXPECT().Exists(gomock.Any(), key).Return(true, nil)
+			}
+
+			ip := &InProgress{
+				Timeout: time.Hour,
+				Bucket:  bucket,
+				Client:  mockClient,
+				Storage: mockStorage,
+			}
+			_, err := ip.Exists(context.Background(), key)
+			_, inProgress := err.(domain.ErrInProgress)
+			assert.Equal(t, tt.InProgress, inProgress)
+		})
+	}
+}
+
+func TestGetEncodedNotInProgress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	aErr := awserr.New(s3.ErrCodeNoSuchKey, "", errors.New(""))
+	output := []byte("diff")
+
+	mockClient := NewMockS3API(ctrl)
+	mockStorage := NewMockS3API(ctrl)
+	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, aErr)
+	mockStorage.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(&s3.GetObjectOutput{
+		Body:     ioutil.NopCloser(bytes.NewReader(output)),
+		Metadata: map[string]*string{"Encoding": aws.String(EncodingGzip)},
+	}, nil)
+
+	ip := &InProgress{
+		Bucket:  bucket,
+		Client:  mockClient,
+		Storage: &S3{Bucket: bucket, Client: mockStorage},
+	}
+	res, encoding, err := ip.GetEncoded(context.Background(), key, []string{EncodingGzip})
+	assert.Nil(t, err)
+	defer res.Close()
+	assert.Equal(t, EncodingGzip, encoding)
+	data, _ := ioutil.ReadAll(res)
+	assert.Equal(t, string(output), string(data))
+}
+
+func TestGetEncodedNotEncodedStorage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	aErr := awserr.New(s3.ErrCodeNoSuchKey, "", errors.New(""))
+	output := []byte("diff")
+
+	mockClient := NewMockS3API(ctrl)
+	mockStorage := NewMockStorage(ctrl)
+	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, aErr)
+	mockStorage.EXPECT().Get(gomock.Any(), key).Return(ioutil.NopCloser(bytes.NewReader(output)), nil)
+
+	ip := &InProgress{
+		Bucket:  bucket,
+		Client:  mockClient,
+		Storage: mockStorage,
+	}
+	res, encoding, err := ip.GetEncoded(context.Background(), key, []string{EncodingGzip})
+	assert.Nil(t, err)
+	defer res.Close()
+	assert.Equal(t, "", encoding)
+}
+
+func TestGetEncodedInProgress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	getOutput := &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewBufferString(time.Now().Format(time.RFC3339))),
+	}
+
+	mockClient := NewMockS3API(ctrl)
+	mockStorage := NewMockStorage(ctrl)
+	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(getOutput, nil)
+	mockStorage.EXPECT().Get(gomock.Any(), key).Return(nil, domain.ErrNotFound{ID: key})
+
+	ip := &InProgress{
+		Timeout: time.Hour,
+		Bucket:  bucket,
+		Client:  mockClient,
+		Storage: mockStorage,
+	}
+	_, _, err := ip.GetEncoded(context.Background(), key, nil)
+	_, ok := err.(domain.ErrInProgress)
+	assert.True(t, ok)
+}
+
 func TestExistsNotInProgress(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -221,3 +392,129 @@ func TestExistsInProgressAfterTimeout(t *testing.T) {
 	assert.Nil(t, err)
 	assert.True(t, exists)
 }
+
+func TestListInProgress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	page := domain.DiffPage{
+		Diffs: []domain.DiffMetadata{
+			{Diff: domain.Diff{ID: "a"}, Status: domain.DiffStatusComplete},
+			{Diff: domain.Diff{ID: "b"}, Status: domain.DiffStatusComplete},
+		},
+	}
+	mockClient := NewMockS3API(ctrl)
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().List(gomock.Any(), gomock.Any()).Return(page, nil)
+	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Key:    aws.String("a_in_progress"),
+		Bucket: aws.String(bucket),
+	}).Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "", errors.New("")))
+	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Key:    aws.String("b_in_progress"),
+		Bucket: aws.String(bucket),
+	}).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewBufferString(time.Now().Format(time.RFC3339))),
+	}, nil)
+
+	ip := &InProgress{
+		Timeout: time.Hour,
+		Bucket:  bucket,
+		Client:  mockClient,
+		Storage: mockStorage,
+	}
+	res, err := ip.List(context.Background(), domain.ListFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, domain.DiffStatusComplete, res.Diffs[0].Status)
+	assert.Equal(t, domain.DiffStatusInProgress, res.Diffs[1].Status)
+}
+
+func TestStatNotInProgress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	aErr := awserr.New(s3.ErrCodeNoSuchKey, "", errors.New(""))
+
+	mockClient := NewMockS3API(ctrl)
+	mockStorage := NewMockS3API(ctrl)
+	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, aErr)
+	mockStorage.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(&s3.HeadObjectOutput{
+		ContentLength: aws.Int64(4),
+		ETag:          aws.String(`"etag"`),
+	}, nil)
+
+	ip := &InProgress{
+		Bucket:  bucket,
+		Client:  mockClient,
+		Storage: &S3{Bucket: bucket, Client: mockStorage},
+	}
+	info, err := ip.Stat(context.Background(), key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), info.Size)
+	assert.Equal(t, "etag", info.Checksum)
+}
+
+func TestStatInProgress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	getOutput := &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewBufferString(time.Now().Format(time.RFC3339))),
+	}
+
+	mockClient := NewMockS3API(ctrl)
+	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(getOutput, nil)
+	mockStorage := NewMockS3API(ctrl)
+	mockStorage.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New("NotFound", "", errors.New("")))
+
+	ip := &InProgress{
+		Timeout: time.Hour,
+		Bucket:  bucket,
+		Client:  mockClient,
+		Storage: &S3{Bucket: bucket, Client: mockStorage},
+	}
+	_, err := ip.Stat(context.Background(), key)
+	assert.IsType(t, domain.ErrInProgress{}, err)
+}
+
+func TestStatInProgressPrevious(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	getOutput := &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewBufferString(time.Now().Format(time.RFC3339))),
+	}
+
+	mockClient := NewMockS3API(ctrl)
+	mockClient.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(getOutput, nil)
+	mockStorage := NewMockS3API(ctrl)
+	mockStorage.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(&s3.HeadObjectOutput{
+		ContentLength: aws.Int64(4),
+		ETag:          aws.String(`"etag"`),
+	}, nil)
+
+	ip := &InProgress{
+		Timeout: time.Hour,
+		Bucket:  bucket,
+		Client:  mockClient,
+		Storage: &S3{Bucket: bucket, Client: mockStorage},
+	}
+	info, err := ip.Stat(context.Background(), key)
+	assert.Nil(t, err)
+	assert.Equal(t, "etag", info.Checksum)
+}
+
+func TestStatNotObjectStorage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ip := &InProgress{
+		Bucket:  bucket,
+		Client:  NewMockS3API(ctrl),
+		Storage: NewMockStorage(ctrl),
+	}
+	_, err := ip.Stat(context.Background(), key)
+	assert.IsType(t, domain.ErrUnsupported{}, err)
+	_, err = ip.GetRange(context.Background(), key, 0)
+	assert.IsType(t, domain.ErrUnsupported{}, err)
+}
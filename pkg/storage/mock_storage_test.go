@@ -4,6 +4,7 @@ package storage
 
 import (
 	context "context"
+	domain "github.com/asecurityteam/vpcflow-diffd/pkg/domain"
 	gomock "github.com/golang/mock/gomock"
 	io "io"
 	reflect "reflect"
@@ -69,3 +70,53 @@ func (m *MockStorage) Store(ctx context.Context, key string, data io.ReadCloser)
 func (mr *MockStorageMockRecorder) Store(ctx, key, data interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Store", reflect.TypeOf((*MockStorage)(nil).Store), ctx, key, data)
 }
+
+// Metadata mocks base method
+func (m *MockStorage) Metadata(ctx context.Context, key string) (domain.DiffMetadata, error) {
+	ret := m.ctrl.Call(m, "Metadata", ctx, key)
+	ret0, _ := ret[0].(domain.DiffMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Metadata indicates an expected call of Metadata
+func (mr *MockStorageMockRecorder) Metadata(ctx, key interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Metadata", reflect.TypeOf((*MockStorage)(nil).Metadata), ctx, key)
+}
+
+// Index mocks base method
+func (m *MockStorage) Index(ctx context.Context, meta domain.DiffMetadata) error {
+	ret := m.ctrl.Call(m, "Index", ctx, meta)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Index indicates an expected call of Index
+func (mr *MockStorageMockRecorder) Index(ctx, meta interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Index", reflect.TypeOf((*MockStorage)(nil).Index), ctx, meta)
+}
+
+// List mocks base method
+func (m *MockStorage) List(ctx context.Context, filter domain.ListFilter) (domain.DiffPage, error) {
+	ret := m.ctrl.Call(m, "List", ctx, filter)
+	ret0, _ := ret[0].(domain.DiffPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List
+func (mr *MockStorageMockRecorder) List(ctx, filter interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockStorage)(nil).List), ctx, filter)
+}
+
+// Delete mocks base method
+func (m *MockStorage) Delete(ctx context.Context, key string) error {
+	ret := m.ctrl.Call(m, "Delete", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete
+func (mr *MockStorageMockRecorder) Delete(ctx, key interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockStorage)(nil).Delete), ctx, key)
+}
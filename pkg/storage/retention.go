@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/asecurityteam/vpcflow-diffd/pkg/logs"
+)
+
+const defaultSweepInterval = time.Hour
+
+// Retention is an implementation of Storage which decorates another Storage with a retention
+// policy.
+//
+// The decorator checks the metadata of a diff before it is fetched, and returns
+// domain.ErrExpired if the diff has expired. Expired diffs are reported as not existing so that
+// they can be created again. Diffs without metadata, including every diff of a Storage which
+// does not implement domain.IndexedStorage, are never considered expired.
+type Retention struct {
+	domain.Storage
+	Policy domain.RetentionPolicy
+}
+
+// Get returns the diff for the given key.
+//
+// If the diff has expired, an error will be returned of type domain.ErrExpired
+func (s *Retention) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	expired, err := s.isExpired(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, domain.ErrExpired{ID: key}
+	}
+	return s.Storage.Get(ctx, key)
+}
+
+// GetEncoded returns the diff for the given key along with its encoding. If the decorated
+// Storage does not implement domain.EncodedStorage, the diff is returned by Get with an
+// empty encoding.
+//
+// If the diff has expired, an error will be returned of type domain.ErrExpired
+func (s *Retention) GetEncoded(ctx context.Context, key string, accept []string) (io.ReadCloser, string, error) {
+	expired, err := s.isExpired(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	if expired {
+		return nil, "", domain.ErrExpired{ID: key}
+	}
+	if encoded, ok := s.Storage.(domain.EncodedStorage); ok {
+		return encoded.GetEncoded(ctx, key, accept)
+	}
+	body, err := s.Storage.Get(ctx, key)
+	return body, "", err
+}
+
+// Stat returns the ObjectInfo of the diff for the given key. If the decorated Storage does not
+// implement domain.ObjectStorage, an error of type domain.ErrUnsupported is returned.
+//
+// If the diff has expired, an error will be returned of type domain.ErrExpired
+func (s *Retention) Stat(ctx context.Context, key string) (domain.ObjectInfo, error) {
+	objects, ok := s.Storage.(domain.ObjectStorage)
+	if !ok {
+		return domain.ObjectInfo{}, domain.ErrUnsupported{Operation: "Stat"}
+	}
+	expired, err := s.isExpired(ctx, key)
+	if err != nil {
+		return domain.ObjectInfo{}, err
+	}
+	if expired {
+		return domain.ObjectInfo{}, domain.ErrExpired{ID: key}
+	}
+	return objects.Stat(ctx, key)
+}
+
+// GetRange returns the diff for the given key, as it is stored, from the given offset. The
+// diff is not checked again, as its content is only fetched once it has been checked by Stat.
+// If the decorated Storage does not implement domain.ObjectStorage, an error of type
+// domain.ErrUnsupported is returned.
+func (s *Retention) GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	objects, ok := s.Storage.(domain.ObjectStorage)
+	if !ok {
+		return nil, domain.ErrUnsupported{Operation: "GetRange"}
+	}
+	return objects.GetRange(ctx, key, offset)
+}
+
+// Exists returns true if the diff exists and has not expired.
+func (s *Retention) Exists(ctx context.Context, key string) (bool, error) {
+	expired, err := s.isExpired(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if expired {
+		return false, nil
+	}
+	return s.Storage.Exists(ctx, key)
+}
+
+// Metadata returns the metadata recorded for the diff. If the decorated Storage does not
+// implement domain.IndexedStorage, an error of type domain.ErrUnsupported is returned.
+func (s *Retention) Metadata(ctx context.Context, key string) (domain.DiffMetadata, error) {
+	indexed, ok := s.Storage.(domain.IndexedStorage)
+	if !ok {
+		return domain.DiffMetadata{}, domain.ErrUnsupported{Operation: "Metadata"}
+	}
+	return indexed.Metadata(ctx, key)
+}
+
+// Index records the metadata of a diff. If the decorated Storage does not implement
+// domain.IndexedStorage, an error of type domain.ErrUnsupported is returned.
+func (s *Retention) Index(ctx context.Context, meta domain.DiffMetadata) error {
+	indexed, ok := s.Storage.(domain.IndexedStorage)
+	if !ok {
+		return domain.ErrUnsupported{Operation: "Index"}
+	}
+	return indexed.Index(ctx, meta)
+}
+
+// Delete removes the diff and its metadata.
+func (s *Retention) Delete(ctx context.Context, key string) error {
+	return s.Storage.Delete(ctx, key)
+}
+
+// List returns a page of the metadata of indexed diffs which match the filter. Diffs which
+// have expired are reported with the domain.DiffStatusExpired status. If the decorated Storage
+// does not implement domain.IndexedStorage, an error of type domain.ErrUnsupported is returned.
+func (s *Retention) List(ctx context.Context, filter domain.ListFilter) (domain.DiffPage, error) {
+	indexed, ok := s.Storage.(domain.IndexedStorage)
+	if !ok {
+		return domain.DiffPage{}, domain.ErrUnsupported{Operation: "List"}
+	}
+	page, err := indexed.List(ctx, filter)
+	if err != nil {
+		return domain.DiffPage{}, err
+	}
+	now := time.Now()
+	for offset := range page.Diffs {
+		if s.Policy.Expired(page.Diffs[offset], now) {
+			page.Diffs[offset].Status = domain.DiffStatusExpired
+		}
+	}
+	return page, nil
+}
+
+func (s *Retention) isExpired(ctx context.Context, key string) (bool, error) {
+	indexed, ok := s.Storage.(domain.IndexedStorage)
+	if !ok {
+		return false, nil
+	}
+	meta, err := indexed.Metadata(ctx, key)
+	switch err.(type) {
+	case nil:
+		return s.Policy.Expired(meta, time.Now()), nil
+	case domain.ErrNotFound, domain.ErrUnsupported:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// Sweeper periodically removes the diffs which have expired, either under a retention policy or
+// at their own expiry time, along with the oldest diffs once there are more than the policy's
+// maximum count.
+//
+// The content of a removed diff is deleted, but its metadata is indexed again with the
+// domain.DiffStatusExpired status, so that it is still known to have expired. Diffs which are
+// in progress are left for a later sweep.
+//
+// The Storage must implement domain.IndexedStorage, as diffs are found by their metadata. It
+// should not be decorated with Retention, which reports the diffs to be removed as already
+// expired.
+type Sweeper struct {
+	Storage     domain.Storage
+	Policy      domain.RetentionPolicy
+	LogProvider domain.LogFn
+
+	// Interval is the time between sweeps. If unset, diffs are swept every hour.
+	Interval time.Duration
+}
+
+// Run sweeps the Storage once every interval until the context is cancelled. Failed sweeps
+// are logged and retried at the next interval.
+func (s *Sweeper) Run(ctx context.Context) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = defaultSweepInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Sweep(ctx); err != nil && ctx.Err() == nil {
+			s.LogProvider(ctx).Error(logs.DependencyFailure{Dependency: logs.DependencyStorage, Reason: err.Error()})
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep removes the diffs which have expired now. If the Storage does not implement
+// domain.IndexedStorage, an error of type domain.ErrUnsupported is returned.
+func (s *Sweeper) Sweep(ctx context.Context) error {
+	indexed, ok := s.Storage.(domain.IndexedStorage)
+	if !ok {
+		return domain.ErrUnsupported{Operation: "List"}
+	}
+	now := time.Now()
+	var live []domain.DiffMetadata
+	var expired []domain.DiffMetadata
+	filter := domain.ListFilter{}
+	for {
+		page, err := indexed.List(ctx, filter)
+		if err != nil {
+			return err
+		}
+		for _, meta := range page.Diffs {
+			switch {
+			case meta.Status == domain.DiffStatusExpired, meta.Status == domain.DiffStatusInProgress:
+			case s.Policy.Expired(meta, now):
+				expired = append(expired, meta)
+			default:
+				live = append(live, meta)
+			}
+		}
+		if page.Next == "" {
+			break
+		}
+		filter.Cursor = page.Next
+	}
+	if s.Policy.MaxCount > 0 && len(live) > s.Policy.MaxCount {
+		sort.SliceStable(live, func(i, j int) bool {
+			return live[i].Created.After(live[j].Created)
+		})
+		expired = append(expired, live[s.Policy.MaxCount:]...)
+	}
+	for _, meta := range expired {
+		if err := s.Storage.Delete(ctx, meta.Key()); err != nil {
+			return err
+		}
+		meta.Status = domain.DiffStatusExpired
+		if err := indexed.Index(ctx, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionGet(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Metadata(gomock.Any(), key).Return(domain.DiffMetadata{Created: time.Now()}, nil)
+	mockStorage.EXPECT().Get(gomock.Any(), key).Return(ioutil.NopCloser(bytes.NewReader([]byte("diff"))), nil)
+
+	s := &Retention{Storage: mockStorage, Policy: domain.RetentionPolicy{MaxAge: time.Hour}}
+	res, err := s.Get(context.Background(), key)
+	assert.Nil(t, err)
+	defer res.Close()
+	data, _ := ioutil.ReadAll(res)
+	assert.Equal(t, "diff", string(data))
+}
+
+func TestRetentionGetNoMetadata(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Metadata(gomock.Any(), key).Return(domain.DiffMetadata{}, domain.ErrNotFound{ID: key})
+	mockStorage.EXPECT().Get(gomock.Any(), key).Return(nil, domain.ErrNotFound{ID: key})
+
+	s := &Retention{Storage: mockStorage, Policy: domain.RetentionPolicy{MaxAge: time.Hour}}
+	_, err := s.Get(context.Background(), key)
+	assert.IsType(t, domain.ErrNotFound{}, err)
+}
+
+func TestRetentionGetExpired(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Meta   domain.DiffMetadata
+		Policy domain.RetentionPolicy
+	}{
+		{
+			Name:   "max age",
+			Meta:   domain.DiffMetadata{Created: time.Now().Add(-2 * time.Hour)},
+			Policy: domain.RetentionPolicy{MaxAge: time.Hour},
+		},
+		{
+			Name: "ttl",
+			Meta: domain.DiffMetadata{Created: time.Now().Add(-2 * time.Hour), Expires: time.Now().Add(-time.Hour)},
+		},
+		{
+			Name: "swept",
+			Meta: domain.DiffMetadata{Created: time.Now(), Status: domain.DiffStatusExpired},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockStorage := NewMockStorage(ctrl)
+			mockStorage.EXPECT().Metadata(gomock.Any(), key).Return(tt.Meta, nil).Times(3)
+
+			s := &Retention{Storage: mockStorage, Policy: tt.Policy}
+			_, err := s.Get(context.Background(), key)
+			assert.Equal(t, domain.ErrExpired{ID: key}, err)
+			_, _, err = s.GetEncoded(context.Background(), key, nil)
+			assert.Equal(t, domain.ErrExpired{ID: key}, err)
+			exists, err := s.Exists(context.Background(), key)
+			assert.Nil(t, err)
+			assert.False(t, exists)
+		})
+	}
+}
+
+func TestRetentionMetadataError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Metadata(gomock.Any(), key).Return(domain.DiffMetadata{}, errors.New("oops"))
+
+	s := &Retention{Storage: mockStorage}
+	_, err := s.Exists(context.Background(), key)
+	assert.NotNil(t, err)
+}
+
+func TestRetentionList(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().List(gomock.Any(), gomock.Any()).Return(domain.DiffPage{
+		Diffs: []domain.DiffMetadata{
+			{Diff: domain.Diff{ID: "old"}, Created: time.Now().Add(-2 * time.Hour), Status: domain.DiffStatusComplete},
+			{Diff: domain.Diff{ID: "new"}, Created: time.Now(), Status: domain.DiffStatusComplete},
+		},
+	}, nil)
+
+	s := &Retention{Storage: mockStorage, Policy: domain.RetentionPolicy{MaxAge: time.Hour}}
+	page, err := s.List(context.Background(), domain.ListFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, domain.DiffStatusExpired, page.Diffs[0].Status)
+	assert.Equal(t, domain.DiffStatusComplete, page.Diffs[1].Status)
+}
+
+func TestSweep(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Now()
+	mockStorage := NewMockStorage(ctrl)
+	gomock.InOrder(
+		mockStorage.EXPECT().List(gomock.Any(), domain.ListFilter{}).Return(domain.DiffPage{
+			Diffs: []domain.DiffMetadata{
+				{Diff: domain.Diff{ID: "ttl"}, Created: now, Expires: now.Add(-time.Minute), Status: domain.DiffStatusComplete},
+				{Diff: domain.Diff{ID: "newest"}, Created: now, Status: domain.DiffStatusComplete},
+				{Diff: domain.Diff{ID: "swept"}, Created: now, Status: domain.DiffStatusExpired},
+			},
+			Next: "swept",
+		}, nil),
+		mockStorage.EXPECT().List(gomock.Any(), domain.ListFilter{Cursor: "swept"}).Return(domain.DiffPage{
+			Diffs: []domain.DiffMetadata{
+				{Diff: domain.Diff{ID: "oldest"}, Created: now.Add(-2 * time.Minute), Status: domain.DiffStatusComplete},
+				{Diff: domain.Diff{ID: "older"}, Created: now.Add(-time.Minute), Status: domain.DiffStatusComplete},
+				{Diff: domain.Diff{ID: "progress"}, Created: now.Add(-time.Hour), Status: domain.DiffStatusInProgress},
+			},
+		}, nil),
+	)
+	var deleted []string
+	mockStorage.EXPECT().Delete(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, key string) error {
+		deleted = append(deleted, key)
+		return nil
+	}).Times(2)
+	mockStorage.EXPECT().Index(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, meta domain.DiffMetadata) error {
+		assert.Equal(t, domain.DiffStatusExpired, meta.Status)
+		return nil
+	}).Times(2)
+
+	s := &Sweeper{Storage: mockStorage, Policy: domain.RetentionPolicy{MaxCount: 2}}
+	assert.Nil(t, s.Sweep(context.Background()))
+	assert.Equal(t, []string{"ttl", "oldest"}, deleted)
+}
+
+func TestSweepTTL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Now()
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().List(gomock.Any(), domain.ListFilter{}).Return(domain.DiffPage{
+		Diffs: []domain.DiffMetadata{
+			{Diff: domain.Diff{ID: "ttl"}, Created: now.Add(-time.Hour), Expires: now.Add(-time.Minute), Status: domain.DiffStatusComplete},
+			{Diff: domain.Diff{ID: "live"}, Created: now.Add(-time.Hour), Expires: now.Add(time.Minute), Status: domain.DiffStatusComplete},
+			{Diff: domain.Diff{ID: "kept"}, Created: now.Add(-time.Hour), Status: domain.DiffStatusComplete},
+		},
+	}, nil)
+	mockStorage.EXPECT().Delete(gomock.Any(), "ttl").Return(nil)
+	mockStorage.EXPECT().Index(gomock.Any(), gomock.Any()).Return(nil)
+
+	// without a policy, only the diffs stored with their own ttl are swept
+	s := &Sweeper{Storage: mockStorage}
+	assert.Nil(t, s.Sweep(context.Background()))
+}
+
+func TestSweepError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().List(gomock.Any(), gomock.Any()).Return(domain.DiffPage{
+		Diffs: []domain.DiffMetadata{
+			{Diff: domain.Diff{ID: "old"}, Created: time.Now().Add(-2 * time.Hour), Status: domain.DiffStatusComplete},
+		},
+	}, nil)
+	mockStorage.EXPECT().Delete(gomock.Any(), "old").Return(errors.New("oops"))
+
+	s := &Sweeper{Storage: mockStorage, Policy: domain.RetentionPolicy{MaxAge: time.Hour}}
+	assert.NotNil(t, s.Sweep(context.Background()))
+}
+
+// objectStorage adds ObjectStorage to a mock Storage.
+type objectStorage struct {
+	*MockStorage
+	info domain.ObjectInfo
+}
+
+func (s *objectStorage) Stat(ctx context.Context, key string) (domain.ObjectInfo, error) {
+	return s.info, nil
+}
+
+func (s *objectStorage) GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(nil)), nil
+}
+
+func TestRetentionStat(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := NewMockStorage(ctrl)
+	mockStorage.EXPECT().Metadata(gomock.Any(), key).Return(domain.DiffMetadata{Created: time.Now()}, nil)
+	mockStorage.EXPECT().Metadata(gomock.Any(), key).Return(domain.DiffMetadata{Created: time.Now().Add(-2 * time.Hour)}, nil)
+
+	info := domain.ObjectInfo{Size: 4, Checksum: "etag"}
+	s := &Retention{Storage: &objectStorage{MockStorage: mockStorage, info: info}, Policy: domain.RetentionPolicy{MaxAge: time.Hour}}
+	res, err := s.Stat(context.Background(), key)
+	assert.Nil(t, err)
+	assert.Equal(t, info, res)
+	_, err = s.Stat(context.Background(), key)
+	assert.Equal(t, domain.ErrExpired{ID: key}, err)
+
+	s = &Retention{Storage: mockStorage}
+	_, err = s.Stat(context.Background(), key)
+	assert.IsType(t, domain.ErrUnsupported{}, err)
+}
@@ -1,9 +1,15 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
+	"net/url"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
 	"github.com/aws/aws-sdk-go/aws"
@@ -14,24 +20,129 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
 )
 
-const keySuffix = ".dot"
+const (
+	keySuffix = ".dot"
+
+	indexPrefix      = "index/"
+	indexSuffix      = ".json"
+	historyPrefix    = "history/"
+	defaultListLimit = 100
+
+	// listConcurrency is the number of index entries, or markers, which are fetched at once
+	// while listing diffs
+	listConcurrency = 16
+
+	// metadataEncoding is the user metadata which records the encoding of a stored diff. S3
+	// returns it as the x-amz-meta-encoding header.
+	metadataEncoding = "Encoding"
+
+	// diffs are tagged when they are stored so that the lifecycle rule which enforces the
+	// maximum age of the retention policy applies only to them, and not to their index
+	// entries
+	retentionRuleID   = "vpcflow-diffd-max-age"
+	retentionTagKey   = "vpcflow-diffd-retention"
+	retentionTagValue = "max-age"
+
+	// errCodeNoSuchLifecycleConfiguration is returned by S3 for buckets without lifecycle
+	// rules. The SDK provides no constant for it.
+	errCodeNoSuchLifecycleConfiguration = "NoSuchLifecycleConfiguration"
+)
+
+// indexEntry is the stored form of domain.DiffMetadata
+type indexEntry struct {
+	ID            string   `json:"id"`
+	PreviousStart string   `json:"previousStart"`
+	PreviousStop  string   `json:"previousStop"`
+	NextStart     string   `json:"nextStart"`
+	NextStop      string   `json:"nextStop"`
+	Created       string   `json:"created"`
+	Status        string   `json:"status"`
+	Added         int      `json:"added"`
+	Removed       int      `json:"removed"`
+	Expires       string   `json:"expires,omitempty"`
+	Tenant        string   `json:"tenant,omitempty"`
+	Accounts      []string `json:"accounts,omitempty"`
+}
 
 // S3 implements the Storage interface and uses S3 as the backing store for diffs
 type S3 struct {
-	Bucket   string
-	Client   s3iface.S3API
+	Bucket string
+	Client s3iface.S3API
+
+	// Encoding is the compression applied to diffs when they are stored, either EncodingGzip
+	// or EncodingZstd. The encoding is recorded in the x-amz-meta-encoding metadata of the
+	// object rather than as its Content-Encoding, which HTTP clients may transparently decode,
+	// so diffs stored with any encoding can be read regardless of this setting. If unset, diffs
+	// are stored uncompressed.
+	Encoding string
+
+	// Retention is the retention policy of the stored diffs. Diffs are always tagged when they
+	// are stored, so that the lifecycle rule installed by ApplyRetention removes them once they
+	// reach the maximum age of the policy. The other limits of the policy are not enforced
+	// by S3.
+	Retention domain.RetentionPolicy
+
 	uploader s3manageriface.UploaderAPI
 	once     sync.Once
 }
 
-// Get returns the diff for the given key. The diff is returned as a gzipped payload.
+// Get returns the diff for the given key. Compressed diffs are decompressed as they are read.
 // It is the caller's responsibility to call Close on the Reader when done.
 func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	body, _, err := s.GetEncoded(ctx, key, nil)
+	return body, err
+}
+
+// GetEncoded returns the diff for the given key along with its encoding. If the diff is stored
+// with one of the accepted encodings, it is returned as stored. Otherwise, it is decompressed
+// and the returned encoding is empty. It is the caller's responsibility to call Close on the
+// Reader when done.
+func (s *S3) GetEncoded(ctx context.Context, key string, accept []string) (io.ReadCloser, string, error) {
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(key + keySuffix),
 	}
 	res, err := s.Client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, "", parseNotFound(err, key)
+	}
+	encoding := aws.StringValue(res.Metadata[metadataEncoding])
+	for _, accepted := range accept {
+		if accepted == encoding {
+			return res.Body, encoding, nil
+		}
+	}
+	body, err := Decode(res.Body, encoding)
+	return body, "", err
+}
+
+// Stat returns the size, checksum, creation time and encoding of the stored diff, but does not
+// download the diff. The checksum is the ETag of the object, which is the MD5 of its content
+// unless it was uploaded in parts.
+func (s *S3) Stat(ctx context.Context, key string) (domain.ObjectInfo, error) {
+	res, err := s.Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key + keySuffix),
+	})
+	if err != nil {
+		return domain.ObjectInfo{}, parseNotFound(err, key)
+	}
+	return domain.ObjectInfo{
+		Size:     aws.Int64Value(res.ContentLength),
+		Checksum: strings.Trim(aws.StringValue(res.ETag), `"`),
+		Created:  aws.TimeValue(res.LastModified),
+		Encoding: aws.StringValue(res.Metadata[metadataEncoding]),
+	}, nil
+}
+
+// GetRange returns the diff for the given key, as it is stored, from the given offset to its
+// end. It is the caller's responsibility to call Close on the Reader when done.
+func (s *S3) GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	res, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key + keySuffix),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-", offset)),
+	})
 	if err != nil {
 		return nil, parseNotFound(err, key)
 	}
@@ -61,17 +172,276 @@ func (s *S3) Store(ctx context.Context, key string, data io.ReadCloser) error {
 		s.uploader = s3manager.NewUploaderWithClient(s.Client)
 	})
 
-	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+	input := &s3manager.UploadInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(key + keySuffix),
 		Body:   data,
+	}
+	if s.Encoding != "" {
+		body, err := Encode(data, s.Encoding)
+		if err != nil {
+			return err
+		}
+		// closing the encoded body stops the compression if the upload fails part way
+		defer body.Close()
+		input.Body = body
+		input.Metadata = map[string]*string{metadataEncoding: aws.String(s.Encoding)}
+	}
+	// diffs are tagged whatever the policy, so that a lifecycle rule installed later also
+	// covers them
+	input.Tagging = aws.String(url.Values{retentionTagKey: []string{retentionTagValue}}.Encode())
+	_, err := s.uploader.UploadWithContext(ctx, input)
+	return err
+}
+
+// ApplyRetention installs a lifecycle rule on the bucket which removes diffs once they reach
+// the maximum age of the retention policy. Only the diffs tagged by Store are removed, so their
+// index entries are kept and expired diffs can be told apart from diffs which never existed.
+// Any other lifecycle rules of the bucket are preserved. If the policy has no maximum age, the
+// bucket is left as-is.
+//
+// S3 applies lifecycle rules with a granularity of days, so the maximum age is rounded up to a
+// whole number of days.
+func (s *S3) ApplyRetention(ctx context.Context) error {
+	if s.Retention.MaxAge <= 0 {
+		return nil
+	}
+	var rules []*s3.LifecycleRule
+	res, err := s.Client.GetBucketLifecycleConfigurationWithContext(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(s.Bucket),
+	})
+	switch {
+	case err == nil:
+		for _, rule := range res.Rules {
+			if aws.StringValue(rule.ID) != retentionRuleID {
+				rules = append(rules, rule)
+			}
+		}
+	case hasCode(err, errCodeNoSuchLifecycleConfiguration):
+	default:
+		return err
+	}
+	const day = 24 * time.Hour
+	rules = append(rules, &s3.LifecycleRule{
+		ID:     aws.String(retentionRuleID),
+		Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: &s3.LifecycleRuleFilter{
+			Tag: &s3.Tag{Key: aws.String(retentionTagKey), Value: aws.String(retentionTagValue)},
+		},
+		Expiration: &s3.LifecycleExpiration{
+			Days: aws.Int64(int64((s.Retention.MaxAge + day - 1) / day)),
+		},
+	})
+	_, err = s.Client.PutBucketLifecycleConfigurationWithContext(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(s.Bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: rules},
+	})
+	return err
+}
+
+// Metadata returns the index entry of the diff.
+func (s *S3) Metadata(ctx context.Context, key string) (domain.DiffMetadata, error) {
+	meta, err := s.getIndex(ctx, indexPrefix+key+indexSuffix)
+	if _, ok := err.(domain.ErrNotFound); ok {
+		return domain.DiffMetadata{}, domain.ErrNotFound{ID: key}
+	}
+	return meta, err
+}
+
+// Index records the metadata of a stored diff as an object under the index prefix of the
+// bucket, keyed by the diff's key. If the diff was stored before, the metadata of the previous
+// version is kept under the history prefix of the bucket so that regenerated diffs can be
+// audited.
+func (s *S3) Index(ctx context.Context, meta domain.DiffMetadata) error {
+	key := indexPrefix + meta.Key() + indexSuffix
+	previous, err := s.getIndex(ctx, key)
+	switch err.(type) {
+	case nil:
+		historyKey := historyPrefix + meta.Key() + "/" + previous.Created.UTC().Format(time.RFC3339Nano) + indexSuffix
+		if err := s.putIndex(ctx, historyKey, previous); err != nil {
+			return err
+		}
+	case domain.ErrNotFound:
+	default:
+		return err
+	}
+	return s.putIndex(ctx, key, meta)
+}
+
+func (s *S3) putIndex(ctx context.Context, key string, meta domain.DiffMetadata) error {
+	entry := indexEntry{
+		ID:            meta.ID,
+		PreviousStart: meta.PreviousStart.Format(time.RFC3339Nano),
+		PreviousStop:  meta.PreviousStop.Format(time.RFC3339Nano),
+		NextStart:     meta.NextStart.Format(time.RFC3339Nano),
+		NextStop:      meta.NextStop.Format(time.RFC3339Nano),
+		Created:       meta.Created.Format(time.RFC3339Nano),
+		Status:        meta.Status,
+		Added:         meta.Added,
+		Removed:       meta.Removed,
+		Tenant:        meta.Scope.Tenant,
+		Accounts:      meta.Scope.Accounts,
+	}
+	if !meta.Expires.IsZero() {
+		entry.Expires = meta.Expires.Format(time.RFC3339Nano)
+	}
+	body, _ := json.Marshal(entry)
+	_, err := s.Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
 	})
 	return err
 }
 
+// List returns a page of the metadata of indexed diffs which match the filter, ordered by key.
+// Each index entry is fetched and filtered individually, so a page may require reading many
+// more entries than it returns if the filter is selective. Entries are fetched several at a
+// time. Only the index entries of the tenant of the filter's scope are read, if it has one.
+func (s *S3) List(ctx context.Context, filter domain.ListFilter) (domain.DiffPage, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	prefix := indexPrefix
+	if filter.Scope != nil && filter.Scope.Tenant != "" {
+		prefix = indexPrefix + filter.Scope.Key("")
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	}
+	if filter.Cursor != "" {
+		input.StartAfter = aws.String(indexPrefix + filter.Cursor + indexSuffix)
+	}
+	var page domain.DiffPage
+	for {
+		res, err := s.Client.ListObjectsV2WithContext(ctx, input)
+		if err != nil {
+			return domain.DiffPage{}, err
+		}
+		for begin := 0; begin < len(res.Contents); begin += listConcurrency {
+			objs := res.Contents[begin:]
+			if len(objs) > listConcurrency {
+				objs = objs[:listConcurrency]
+			}
+			metas := make([]domain.DiffMetadata, len(objs))
+			found := make([]bool, len(objs))
+			err := forEach(len(objs), func(offset int) error {
+				meta, err := s.getIndex(ctx, aws.StringValue(objs[offset].Key))
+				if _, ok := err.(domain.ErrNotFound); ok {
+					return nil // removed since the listing was made
+				}
+				metas[offset], found[offset] = meta, err == nil
+				return err
+			})
+			if err != nil {
+				return domain.DiffPage{}, err
+			}
+			for offset, meta := range metas {
+				if !found[offset] || !filter.Matches(meta) {
+					continue
+				}
+				if len(page.Diffs) == limit {
+					page.Next = page.Diffs[len(page.Diffs)-1].Key()
+					return page, nil
+				}
+				page.Diffs = append(page.Diffs, meta)
+			}
+		}
+		if !aws.BoolValue(res.IsTruncated) {
+			return page, nil
+		}
+		input.ContinuationToken = res.NextContinuationToken
+	}
+}
+
+func (s *S3) getIndex(ctx context.Context, key string) (domain.DiffMetadata, error) {
+	res, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return domain.DiffMetadata{}, parseNotFound(err, key)
+	}
+	defer res.Body.Close()
+	var entry indexEntry
+	if err := json.NewDecoder(res.Body).Decode(&entry); err != nil {
+		return domain.DiffMetadata{}, err
+	}
+	meta := domain.DiffMetadata{
+		Diff:    domain.Diff{ID: entry.ID, Scope: domain.Scope{Tenant: entry.Tenant, Accounts: entry.Accounts}},
+		Status:  entry.Status,
+		Added:   entry.Added,
+		Removed: entry.Removed,
+	}
+	meta.PreviousStart, _ = time.Parse(time.RFC3339Nano, entry.PreviousStart)
+	meta.PreviousStop, _ = time.Parse(time.RFC3339Nano, entry.PreviousStop)
+	meta.NextStart, _ = time.Parse(time.RFC3339Nano, entry.NextStart)
+	meta.NextStop, _ = time.Parse(time.RFC3339Nano, entry.NextStop)
+	meta.Created, _ = time.Parse(time.RFC3339Nano, entry.Created)
+	if entry.Expires != "" {
+		meta.Expires, _ = time.Parse(time.RFC3339Nano, entry.Expires)
+	}
+	return meta, nil
+}
+
+// Delete removes the diff and its index entry in a single request, so that a diff is not left
+// listed without its content, or vice versa, unless the request partially fails.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	res, err := s.Client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(s.Bucket),
+		Delete: &s3.Delete{
+			Objects: []*s3.ObjectIdentifier{
+				{Key: aws.String(key + keySuffix)},
+				{Key: aws.String(indexPrefix + key + indexSuffix)},
+			},
+			Quiet: aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range res.Errors {
+		if aws.StringValue(e.Code) != s3.ErrCodeNoSuchKey {
+			return fmt.Errorf("failed to delete %s: %s", aws.StringValue(e.Key), aws.StringValue(e.Message))
+		}
+	}
+	return nil
+}
+
+// forEach calls fn with each offset from zero to n, running up to listConcurrency calls at
+// once. The first error returned by a call is returned once every call has finished.
+func forEach(n int, fn func(offset int) error) error {
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	sem := make(chan struct{}, listConcurrency)
+	for offset := 0; offset < n; offset++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(offset int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[offset] = fn(offset)
+		}(offset)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func isNotFound(err error) bool {
+	return hasCode(err, s3.ErrCodeNoSuchKey) || hasCode(err, "NotFound") // NotFound is an undocumented error code with no provided constant
+}
+
+func hasCode(err error, code string) bool {
 	aErr, ok := err.(awserr.Error)
-	return ok && (aErr.Code() == s3.ErrCodeNoSuchKey || aErr.Code() == "NotFound") // NotFound is an undocumented error code with no provided constant
+	return ok && aErr.Code() == code
 }
 
 // If a key is not found, transform to our NotFound error, otherwise return original error
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
 	"github.com/aws/aws-sdk-go/aws"
@@ -212,3 +213,535 @@ func TestStoreError(t *testing.T) {
 	err := storage.Store(context.Background(), key, input)
 	assert.NotNil(t, err)
 }
+
+func TestStoreGetEncoded(t *testing.T) {
+	for _, encoding := range []string{EncodingGzip, EncodingZstd} {
+		t.Run(encoding, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			value := "this is a compressed graph"
+			var stored []byte
+
+			mockUploader := NewMockUploaderAPI(ctrl)
+			mockUploader.EXPECT().UploadWithContext(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, input *s3manager.UploadInput) (interface{}, error) {
+				// the encoding is recorded as metadata, as HTTP clients may decode a Content-Encoding
+				assert.Nil(t, input.ContentEncoding)
+				assert.Equal(t, encoding, aws.StringValue(input.Metadata["Encoding"]))
+				data, err := ioutil.ReadAll(input.Body)
+				stored = data
+				return &s3manager.UploadOutput{}, err
+			})
+			mockS3 := NewMockS3API(ctrl)
+			mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ *s3.GetObjectInput, _ ...interface{}) (*s3.GetObjectOutput, error) {
+				return &s3.GetObjectOutput{
+					Body:     ioutil.NopCloser(bytes.NewReader(stored)),
+					Metadata: map[string]*string{"Encoding": aws.String(encoding)},
+				}, nil
+			}).Times(2)
+
+			storage := &S3{
+				Bucket:   bucket,
+				Client:   mockS3,
+				Encoding: encoding,
+				uploader: mockUploader,
+			}
+			storage.once.Do(func() {}) // trigger the once call
+
+			err := storage.Store(context.Background(), key, ioutil.NopCloser(bytes.NewReader([]byte(value))))
+			assert.Nil(t, err)
+			assert.NotEqual(t, value, string(stored))
+
+			// fetching without accepting the encoding decompresses the diff
+			r, err := storage.Get(context.Background(), key)
+			assert.Nil(t, err)
+			data, _ := ioutil.ReadAll(r)
+			assert.Nil(t, r.Close())
+			assert.Equal(t, value, string(data))
+
+			// fetching while accepting the encoding returns the stored bytes
+			r, enc, err := storage.GetEncoded(context.Background(), key, []string{"br", encoding})
+			assert.Nil(t, err)
+			assert.Equal(t, encoding, enc)
+			data, _ = ioutil.ReadAll(r)
+			assert.Nil(t, r.Close())
+			assert.Equal(t, stored, data)
+		})
+	}
+}
+
+func TestStoreUnsupportedEncoding(t *testing.T) {
+	storage := &S3{
+		Bucket:   bucket,
+		Encoding: "br",
+	}
+	storage.once.Do(func() {}) // trigger the once call
+
+	err := storage.Store(context.Background(), key, ioutil.NopCloser(bytes.NewReader([]byte("graph"))))
+	_, ok := err.(ErrUnsupportedEncoding)
+	assert.True(t, ok)
+}
+
+func TestGetUnsupportedEncoding(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(&s3.GetObjectOutput{
+		Body:     ioutil.NopCloser(bytes.NewReader([]byte("graph"))),
+		Metadata: map[string]*string{"Encoding": aws.String("br")},
+	}, nil)
+
+	storage := &S3{
+		Bucket: bucket,
+		Client: mockS3,
+	}
+
+	_, err := storage.Get(context.Background(), key)
+	_, ok := err.(ErrUnsupportedEncoding)
+	assert.True(t, ok)
+}
+
+func indexObject(t *testing.T, meta domain.DiffMetadata) *s3.GetObjectOutput {
+	var body []byte
+	mockS3 := NewMockS3API(gomock.NewController(t))
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "", errors.New("")))
+	mockS3.EXPECT().PutObjectWithContext(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, input *s3.PutObjectInput, _ ...interface{}) (*s3.PutObjectOutput, error) {
+		body, _ = ioutil.ReadAll(input.Body)
+		return &s3.PutObjectOutput{}, nil
+	})
+	storage := &S3{Bucket: bucket, Client: mockS3}
+	assert.Nil(t, storage.Index(context.Background(), meta))
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(body))}
+}
+
+func TestIndex(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("index/" + key + ".json"),
+	}).Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "", errors.New("")))
+	mockS3.EXPECT().PutObjectWithContext(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, input *s3.PutObjectInput, _ ...interface{}) (*s3.PutObjectOutput, error) {
+		assert.Equal(t, bucket, aws.StringValue(input.Bucket))
+		assert.Equal(t, "index/"+key+".json", aws.StringValue(input.Key))
+		return &s3.PutObjectOutput{}, nil
+	})
+	storage := &S3{Bucket: bucket, Client: mockS3}
+	assert.Nil(t, storage.Index(context.Background(), domain.DiffMetadata{Diff: domain.Diff{ID: key}}))
+}
+
+func TestIndexTenant(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	meta := domain.DiffMetadata{Diff: domain.Diff{ID: key, Scope: domain.NewScope("acme", []string{"1"})}}
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "", errors.New("")))
+	mockS3.EXPECT().PutObjectWithContext(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, input *s3.PutObjectInput, _ ...interface{}) (*s3.PutObjectOutput, error) {
+		assert.Equal(t, "index/tenants/acme/"+key+".json", aws.StringValue(input.Key))
+		body, _ := ioutil.ReadAll(input.Body)
+		assert.Contains(t, string(body), `"tenant":"acme","accounts":["1"]`)
+		return &s3.PutObjectOutput{}, nil
+	})
+	storage := &S3{Bucket: bucket, Client: mockS3}
+	assert.Nil(t, storage.Index(context.Background(), meta))
+}
+
+func TestIndexKeepsHistory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	created, _ := time.Parse(time.RFC3339, "2019-01-01T00:00:00Z")
+	previous := domain.DiffMetadata{Diff: domain.Diff{ID: key}, Created: created, Added: 1}
+	current := domain.DiffMetadata{Diff: domain.Diff{ID: key}, Created: created.Add(time.Hour), Added: 2}
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(indexObject(t, previous), nil)
+	gomock.InOrder(
+		mockS3.EXPECT().PutObjectWithContext(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, input *s3.PutObjectInput, _ ...interface{}) (*s3.PutObjectOutput, error) {
+			assert.Equal(t, "history/"+key+"/2019-01-01T00:00:00Z.json", aws.StringValue(input.Key))
+			return &s3.PutObjectOutput{}, nil
+		}),
+		mockS3.EXPECT().PutObjectWithContext(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, input *s3.PutObjectInput, _ ...interface{}) (*s3.PutObjectOutput, error) {
+			assert.Equal(t, "index/"+key+".json", aws.StringValue(input.Key))
+			return &s3.PutObjectOutput{}, nil
+		}),
+	)
+	storage := &S3{Bucket: bucket, Client: mockS3}
+	assert.Nil(t, storage.Index(context.Background(), current))
+}
+
+func TestIndexError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("oops"))
+	storage := &S3{Bucket: bucket, Client: mockS3}
+	assert.NotNil(t, storage.Index(context.Background(), domain.DiffMetadata{Diff: domain.Diff{ID: key}}))
+}
+
+func TestList(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	day, _ := time.Parse(time.RFC3339, "2019-01-01T00:00:00Z")
+	newMeta := func(id string, offset int) domain.DiffMetadata {
+		start := day.Add(time.Duration(offset) * 24 * time.Hour)
+		return domain.DiffMetadata{
+			Diff: domain.Diff{
+				ID:            id,
+				PreviousStart: start,
+				PreviousStop:  start.Add(24 * time.Hour),
+				NextStart:     start.Add(24 * time.Hour),
+				NextStop:      start.Add(48 * time.Hour),
+			},
+			Created: start.Add(48 * time.Hour),
+			Status:  domain.DiffStatusComplete,
+			Added:   offset,
+			Removed: offset,
+		}
+	}
+	metas := []domain.DiffMetadata{newMeta("a", 0), newMeta("b", 10), newMeta("c", 1), newMeta("d", 2)}
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+		Bucket:     aws.String(bucket),
+		Prefix:     aws.String("index/"),
+		StartAfter: aws.String("index/0.json"),
+	}).Return(&s3.ListObjectsV2Output{
+		Contents:              []*s3.Object{{Key: aws.String("index/a.json")}, {Key: aws.String("index/b.json")}, {Key: aws.String("index/x.json")}},
+		IsTruncated:           aws.Bool(true),
+		NextContinuationToken: aws.String("next"),
+	}, nil)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(&s3.ListObjectsV2Output{
+		Contents: []*s3.Object{{Key: aws.String("index/c.json")}, {Key: aws.String("index/d.json")}},
+	}, nil)
+	for _, meta := range metas {
+		mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String("index/" + meta.ID + ".json"),
+		}).Return(indexObject(t, meta), nil)
+	}
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("index/x.json"),
+	}).Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "", errors.New("")))
+
+	storage := &S3{Bucket: bucket, Client: mockS3}
+	page, err := storage.List(context.Background(), domain.ListFilter{
+		Start:  day,
+		Stop:   day.Add(72 * time.Hour),
+		Limit:  2,
+		Cursor: "0",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "c", page.Next)
+	assert.Equal(t, []domain.DiffMetadata{metas[0], metas[2]}, page.Diffs)
+}
+
+func TestListTenant(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	scope := domain.NewScope("acme", []string{"1", "2"})
+	metas := []domain.DiffMetadata{
+		{Diff: domain.Diff{ID: "a", Scope: domain.NewScope("acme", []string{"1"})}},
+		{Diff: domain.Diff{ID: "b", Scope: domain.NewScope("acme", []string{"3"})}},
+		{Diff: domain.Diff{ID: "c", Scope: domain.NewScope("acme", []string{"2"})}},
+	}
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String("index/tenants/acme/"),
+	}).Return(&s3.ListObjectsV2Output{
+		Contents: []*s3.Object{
+			{Key: aws.String("index/tenants/acme/a.json")},
+			{Key: aws.String("index/tenants/acme/b.json")},
+			{Key: aws.String("index/tenants/acme/c.json")},
+		},
+	}, nil)
+	for _, meta := range metas {
+		mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String("index/tenants/acme/" + meta.ID + ".json"),
+		}).Return(indexObject(t, meta), nil)
+	}
+
+	storage := &S3{Bucket: bucket, Client: mockS3}
+	page, err := storage.List(context.Background(), domain.ListFilter{Limit: 1, Scope: &scope})
+	assert.Nil(t, err)
+	assert.Equal(t, "tenants/acme/a", page.Next)
+	assert.Equal(t, []domain.DiffMetadata{metas[0]}, page.Diffs)
+}
+
+func TestListError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().ListObjectsV2WithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("oops"))
+
+	storage := &S3{Bucket: bucket, Client: mockS3}
+	_, err := storage.List(context.Background(), domain.ListFilter{})
+	assert.NotNil(t, err)
+}
+
+func TestDelete(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	expectedInput := &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &s3.Delete{
+			Objects: []*s3.ObjectIdentifier{
+				{Key: aws.String(key + ".dot")},
+				{Key: aws.String("index/" + key + ".json")},
+			},
+			Quiet: aws.Bool(true),
+		},
+	}
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().DeleteObjectsWithContext(gomock.Any(), expectedInput).Return(&s3.DeleteObjectsOutput{
+		Errors: []*s3.Error{{Key: aws.String(key + ".dot"), Code: aws.String(s3.ErrCodeNoSuchKey)}},
+	}, nil)
+
+	storage := &S3{Bucket: bucket, Client: mockS3}
+	assert.Nil(t, storage.Delete(context.Background(), key))
+}
+
+func TestDeleteError(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Output *s3.DeleteObjectsOutput
+		Error  error
+	}{
+		{
+			Name:  "request",
+			Error: errors.New("oops"),
+		},
+		{
+			Name: "object",
+			Output: &s3.DeleteObjectsOutput{
+				Errors: []*s3.Error{{Key: aws.String(key + ".dot"), Code: aws.String("AccessDenied")}},
+			},
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockS3 := NewMockS3API(ctrl)
+			mockS3.EXPECT().DeleteObjectsWithContext(gomock.Any(), gomock.Any()).Return(tt.Output, tt.Error)
+
+			storage := &S3{Bucket: bucket, Client: mockS3}
+			assert.NotNil(t, storage.Delete(context.Background(), key))
+		})
+	}
+}
+
+func TestStoreRetentionTagged(t *testing.T) {
+	tc := []struct {
+		Name      string
+		Retention domain.RetentionPolicy
+	}{
+		{"max age", domain.RetentionPolicy{MaxAge: time.Hour}},
+		{"no policy", domain.RetentionPolicy{}},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockUploader := NewMockUploaderAPI(ctrl)
+			mockUploader.EXPECT().UploadWithContext(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, input *s3manager.UploadInput) (interface{}, error) {
+				assert.Equal(t, "vpcflow-diffd-retention=max-age", aws.StringValue(input.Tagging))
+				return &s3manager.UploadOutput{}, nil
+			})
+
+			storage := &S3{
+				Bucket:    bucket,
+				Retention: tt.Retention,
+				uploader:  mockUploader,
+			}
+			storage.once.Do(func() {}) // trigger the once call
+
+			input := ioutil.NopCloser(bytes.NewReader([]byte("graph")))
+			assert.Nil(t, storage.Store(context.Background(), key, input))
+		})
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	other := &s3.LifecycleRule{ID: aws.String("other")}
+	previous := &s3.LifecycleRule{ID: aws.String(retentionRuleID)}
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetBucketLifecycleConfigurationWithContext(gomock.Any(), gomock.Any()).Return(&s3.GetBucketLifecycleConfigurationOutput{
+		Rules: []*s3.LifecycleRule{other, previous},
+	}, nil)
+	mockS3.EXPECT().PutBucketLifecycleConfigurationWithContext(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, input *s3.PutBucketLifecycleConfigurationInput, _ ...interface{}) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+		assert.Equal(t, bucket, aws.StringValue(input.Bucket))
+		rules := input.LifecycleConfiguration.Rules
+		assert.Len(t, rules, 2)
+		assert.Equal(t, other, rules[0])
+		assert.Equal(t, retentionRuleID, aws.StringValue(rules[1].ID))
+		assert.Equal(t, retentionTagKey, aws.StringValue(rules[1].Filter.Tag.Key))
+		assert.Equal(t, int64(2), aws.Int64Value(rules[1].Expiration.Days))
+		return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+	})
+
+	storage := &S3{
+		Bucket:    bucket,
+		Client:    mockS3,
+		Retention: domain.RetentionPolicy{MaxAge: 25 * time.Hour},
+	}
+	assert.Nil(t, storage.ApplyRetention(context.Background()))
+}
+
+func TestApplyRetentionNoLifecycle(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetBucketLifecycleConfigurationWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New(errCodeNoSuchLifecycleConfiguration, "", errors.New("")))
+	mockS3.EXPECT().PutBucketLifecycleConfigurationWithContext(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, input *s3.PutBucketLifecycleConfigurationInput, _ ...interface{}) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+		assert.Len(t, input.LifecycleConfiguration.Rules, 1)
+		return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+	})
+
+	storage := &S3{
+		Bucket:    bucket,
+		Client:    mockS3,
+		Retention: domain.RetentionPolicy{MaxAge: time.Hour},
+	}
+	assert.Nil(t, storage.ApplyRetention(context.Background()))
+}
+
+func TestApplyRetentionError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetBucketLifecycleConfigurationWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("oops"))
+
+	storage := &S3{
+		Bucket:    bucket,
+		Client:    mockS3,
+		Retention: domain.RetentionPolicy{MaxAge: time.Hour},
+	}
+	assert.NotNil(t, storage.ApplyRetention(context.Background()))
+
+	// without a maximum age, the bucket is not touched
+	storage.Retention = domain.RetentionPolicy{}
+	assert.Nil(t, storage.ApplyRetention(context.Background()))
+}
+
+func TestMetadata(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	created, _ := time.Parse(time.RFC3339, "2019-01-01T00:00:00Z")
+	meta := domain.DiffMetadata{
+		Diff:    domain.Diff{ID: key},
+		Created: created,
+		Status:  domain.DiffStatusComplete,
+		Expires: created.Add(time.Hour),
+	}
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("index/" + key + ".json"),
+	}).Return(indexObject(t, meta), nil)
+
+	storage := &S3{Bucket: bucket, Client: mockS3}
+	res, err := storage.Metadata(context.Background(), key)
+	assert.Nil(t, err)
+	assert.Equal(t, meta.Expires, res.Expires.UTC())
+	assert.Equal(t, meta.Created, res.Created.UTC())
+}
+
+func TestMetadataNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "", errors.New("")))
+
+	storage := &S3{Bucket: bucket, Client: mockS3}
+	_, err := storage.Metadata(context.Background(), key)
+	assert.Equal(t, domain.ErrNotFound{ID: key}, err)
+}
+
+func TestStat(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	created, _ := time.Parse(time.RFC3339, "2019-01-01T00:00:00Z")
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key + ".dot"),
+	}).Return(&s3.HeadObjectOutput{
+		ContentLength: aws.Int64(42),
+		ETag:          aws.String(`"9e107d9d372bb6826bd81d3542a419d6"`),
+		LastModified:  aws.Time(created),
+		Metadata:      map[string]*string{"Encoding": aws.String(EncodingGzip)},
+	}, nil)
+
+	storage := &S3{Bucket: bucket, Client: mockS3}
+	info, err := storage.Stat(context.Background(), key)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.ObjectInfo{
+		Size:     42,
+		Checksum: "9e107d9d372bb6826bd81d3542a419d6",
+		Created:  created,
+		Encoding: EncodingGzip,
+	}, info)
+}
+
+func TestStatNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).Return(nil, awserr.New("NotFound", "", errors.New("")))
+
+	storage := &S3{Bucket: bucket, Client: mockS3}
+	_, err := storage.Stat(context.Background(), key)
+	assert.Equal(t, domain.ErrNotFound{ID: key}, err)
+}
+
+func TestGetRange(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockS3 := NewMockS3API(ctrl)
+	mockS3.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key + ".dot"),
+		Range:  aws.String("bytes=5-"),
+	}).Return(&s3.GetObjectOutput{
+		Body:     ioutil.NopCloser(bytes.NewReader([]byte("diff content"))),
+		Metadata: map[string]*string{"Encoding": aws.String(EncodingGzip)},
+	}, nil)
+
+	storage := &S3{Bucket: bucket, Client: mockS3}
+	r, err := storage.GetRange(context.Background(), key, 5)
+	assert.Nil(t, err)
+	defer r.Close()
+	// the range is returned as stored, without being decompressed
+	data, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "diff content", string(data))
+}
@@ -0,0 +1,25 @@
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Differ is an implementation of domain.Differ which traces each call to the Differ it
+// decorates.
+type Differ struct {
+	Differ domain.Differ
+	Tracer trace.Tracer
+}
+
+// Diff generates the diff of the graphs of the diff's time ranges.
+func (d *Differ) Diff(ctx context.Context, diff domain.Diff) (io.ReadCloser, error) {
+	ctx, span := d.Tracer.Start(ctx, "differ.diff", trace.WithAttributes(attribute.String(attrDiffID, diff.ID)))
+	body, err := d.Differ.Diff(ctx, diff)
+	end(span, err)
+	return body, err
+}
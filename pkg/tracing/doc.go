@@ -0,0 +1,5 @@
+// Package tracing is a container of decorators which trace the domain
+// interfaces with OpenTelemetry, along with the helpers which propagate the
+// trace context across the HTTP hops between the API, the streaming
+// appliance, the Produce handler and the grapher.
+package tracing
@@ -0,0 +1,29 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Grapher is an implementation of domain.Grapher which traces each call to the Grapher it
+// decorates.
+type Grapher struct {
+	Grapher domain.Grapher
+	Tracer  trace.Tracer
+}
+
+// Graph returns the graph of the given time range.
+func (g *Grapher) Graph(ctx context.Context, start, stop time.Time) (io.ReadCloser, error) {
+	ctx, span := g.Tracer.Start(ctx, "grapher.graph", trace.WithAttributes(
+		attribute.String("graph.start", start.Format(time.RFC3339Nano)),
+		attribute.String("graph.stop", stop.Format(time.RFC3339Nano)),
+	))
+	body, err := g.Grapher.Graph(ctx, start, stop)
+	end(span, err)
+	return body, err
+}
@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func startMarker(ctx context.Context, tracer trace.Tracer, name string, key string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attribute.String(attrDiffID, key)))
+}
+
+// Marker is an implementation of domain.Marker which traces each call to the Marker it
+// decorates.
+type Marker struct {
+	Marker domain.Marker
+	Tracer trace.Tracer
+}
+
+// Mark flags the diff identified by key as being "in progress".
+func (m *Marker) Mark(ctx context.Context, key string) error {
+	ctx, span := startMarker(ctx, m.Tracer, "marker.mark", key)
+	err := m.Marker.Mark(ctx, key)
+	end(span, err)
+	return err
+}
+
+// Unmark flags the diff identified by key as not being "in progress".
+func (m *Marker) Unmark(ctx context.Context, key string) error {
+	ctx, span := startMarker(ctx, m.Tracer, "marker.unmark", key)
+	err := m.Marker.Unmark(ctx, key)
+	end(span, err)
+	return err
+}
+
+// LeaseMarker is an implementation of domain.LeaseMarker which traces each call to the
+// LeaseMarker it decorates.
+type LeaseMarker struct {
+	LeaseMarker domain.LeaseMarker
+	Tracer      trace.Tracer
+}
+
+// Mark flags the diff identified by key as being "in progress".
+func (m *LeaseMarker) Mark(ctx context.Context, key string) error {
+	ctx, span := startMarker(ctx, m.Tracer, "marker.mark", key)
+	err := m.LeaseMarker.Mark(ctx, key)
+	end(span, err)
+	return err
+}
+
+// Unmark flags the diff identified by key as not being "in progress".
+func (m *LeaseMarker) Unmark(ctx context.Context, key string) error {
+	ctx, span := startMarker(ctx, m.Tracer, "marker.unmark", key)
+	err := m.LeaseMarker.Unmark(ctx, key)
+	end(span, err)
+	return err
+}
+
+// Acquire leases the diff to the caller.
+func (m *LeaseMarker) Acquire(ctx context.Context, d domain.Diff) (string, error) {
+	ctx, span := startMarker(ctx, m.Tracer, "marker.acquire", d.ID)
+	lease, err := m.LeaseMarker.Acquire(ctx, d)
+	end(span, err)
+	return lease, err
+}
+
+// Renew extends the lease.
+func (m *LeaseMarker) Renew(ctx context.Context, key string, lease string) error {
+	ctx, span := startMarker(ctx, m.Tracer, "marker.renew", key)
+	err := m.LeaseMarker.Renew(ctx, key, lease)
+	end(span, err)
+	return err
+}
+
+// Release ends the lease.
+func (m *LeaseMarker) Release(ctx context.Context, key string, lease string) error {
+	ctx, span := startMarker(ctx, m.Tracer, "marker.release", key)
+	err := m.LeaseMarker.Release(ctx, key, lease)
+	end(span, err)
+	return err
+}
+
+// NewMarker decorates the Marker with tracing. A domain.LeaseMarker is decorated with
+// LeaseMarker so that it can still be used to lease diffs.
+func NewMarker(m domain.Marker, tracer trace.Tracer) domain.Marker {
+	if leaser, ok := m.(domain.LeaseMarker); ok {
+		return &LeaseMarker{LeaseMarker: leaser, Tracer: tracer}
+	}
+	return &Marker{Marker: m, Tracer: tracer}
+}
@@ -0,0 +1,28 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Queuer is an implementation of domain.Queuer which traces each call to the Queuer it
+// decorates. The span is a producer span, so that the Queuer can propagate it to the worker
+// which consumes the job.
+type Queuer struct {
+	Queuer domain.Queuer
+	Tracer trace.Tracer
+}
+
+// Queue queues the diff job.
+func (q *Queuer) Queue(ctx context.Context, d domain.Diff) error {
+	ctx, span := q.Tracer.Start(ctx, "queuer.queue",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String(attrDiffID, d.ID)),
+	)
+	err := q.Queuer.Queue(ctx, d)
+	end(span, err)
+	return err
+}
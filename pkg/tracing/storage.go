@@ -0,0 +1,138 @@
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Storage is an implementation of domain.Storage which traces each call to the Storage it
+// decorates.
+type Storage struct {
+	Storage domain.Storage
+	Tracer  trace.Tracer
+}
+
+func (s *Storage) start(ctx context.Context, name string, key string) (context.Context, trace.Span) {
+	return s.Tracer.Start(ctx, name, trace.WithAttributes(attribute.String(attrDiffID, key)))
+}
+
+// Get returns the diff for the given key.
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	ctx, span := s.start(ctx, "storage.get", key)
+	body, err := s.Storage.Get(ctx, key)
+	end(span, err)
+	return body, err
+}
+
+// GetEncoded returns the diff for the given key along with its encoding. If the decorated
+// Storage does not implement domain.EncodedStorage, the diff is returned by Get with an
+// empty encoding.
+func (s *Storage) GetEncoded(ctx context.Context, key string, accept []string) (io.ReadCloser, string, error) {
+	encoded, ok := s.Storage.(domain.EncodedStorage)
+	if !ok {
+		body, err := s.Get(ctx, key)
+		return body, "", err
+	}
+	ctx, span := s.start(ctx, "storage.get", key)
+	body, encoding, err := encoded.GetEncoded(ctx, key, accept)
+	span.SetAttributes(attribute.String("storage.encoding", encoding))
+	end(span, err)
+	return body, encoding, err
+}
+
+// Stat returns the ObjectInfo of the diff for the given key. If the decorated Storage does not
+// implement domain.ObjectStorage, an error of type domain.ErrUnsupported is returned.
+func (s *Storage) Stat(ctx context.Context, key string) (domain.ObjectInfo, error) {
+	objects, ok := s.Storage.(domain.ObjectStorage)
+	if !ok {
+		return domain.ObjectInfo{}, domain.ErrUnsupported{Operation: "Stat"}
+	}
+	ctx, span := s.start(ctx, "storage.stat", key)
+	info, err := objects.Stat(ctx, key)
+	end(span, err)
+	return info, err
+}
+
+// GetRange returns the diff for the given key, as it is stored, from the given offset. If the
+// decorated Storage does not implement domain.ObjectStorage, an error of type
+// domain.ErrUnsupported is returned.
+func (s *Storage) GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	objects, ok := s.Storage.(domain.ObjectStorage)
+	if !ok {
+		return nil, domain.ErrUnsupported{Operation: "GetRange"}
+	}
+	ctx, span := s.start(ctx, "storage.get", key)
+	body, err := objects.GetRange(ctx, key, offset)
+	span.SetAttributes(attribute.Int64("storage.offset", offset))
+	end(span, err)
+	return body, err
+}
+
+// Exists returns true if the diff exists.
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	ctx, span := s.start(ctx, "storage.exists", key)
+	exists, err := s.Storage.Exists(ctx, key)
+	end(span, err)
+	return exists, err
+}
+
+// Store stores the diff.
+func (s *Storage) Store(ctx context.Context, key string, data io.ReadCloser) error {
+	ctx, span := s.start(ctx, "storage.store", key)
+	err := s.Storage.Store(ctx, key, data)
+	end(span, err)
+	return err
+}
+
+// Metadata returns the metadata recorded for the diff. If the decorated Storage does not
+// implement domain.IndexedStorage, an error of type domain.ErrUnsupported is returned.
+func (s *Storage) Metadata(ctx context.Context, key string) (domain.DiffMetadata, error) {
+	indexed, ok := s.Storage.(domain.IndexedStorage)
+	if !ok {
+		return domain.DiffMetadata{}, domain.ErrUnsupported{Operation: "Metadata"}
+	}
+	ctx, span := s.start(ctx, "storage.metadata", key)
+	meta, err := indexed.Metadata(ctx, key)
+	end(span, err)
+	return meta, err
+}
+
+// Index records the metadata of a diff. If the decorated Storage does not implement
+// domain.IndexedStorage, an error of type domain.ErrUnsupported is returned.
+func (s *Storage) Index(ctx context.Context, meta domain.DiffMetadata) error {
+	indexed, ok := s.Storage.(domain.IndexedStorage)
+	if !ok {
+		return domain.ErrUnsupported{Operation: "Index"}
+	}
+	ctx, span := s.start(ctx, "storage.index", meta.ID)
+	err := indexed.Index(ctx, meta)
+	end(span, err)
+	return err
+}
+
+// List returns a page of the metadata of indexed diffs which match the filter. If the
+// decorated Storage does not implement domain.IndexedStorage, an error of type
+// domain.ErrUnsupported is returned.
+func (s *Storage) List(ctx context.Context, filter domain.ListFilter) (domain.DiffPage, error) {
+	indexed, ok := s.Storage.(domain.IndexedStorage)
+	if !ok {
+		return domain.DiffPage{}, domain.ErrUnsupported{Operation: "List"}
+	}
+	ctx, span := s.Tracer.Start(ctx, "storage.list")
+	page, err := indexed.List(ctx, filter)
+	span.SetAttributes(attribute.Int("storage.diffs", len(page.Diffs)))
+	end(span, err)
+	return page, err
+}
+
+// Delete removes the diff and its metadata.
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	ctx, span := s.start(ctx, "storage.delete", key)
+	err := s.Storage.Delete(ctx, key)
+	end(span, err)
+	return err
+}
@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// InstrumentationName is the name of the tracer used by the service.
+	InstrumentationName = "github.com/asecurityteam/vpcflow-diffd"
+
+	attrDiffID = "diff.id"
+)
+
+// trace context is propagated in the W3C Trace Context format
+var propagator = propagation.TraceContext{}
+
+// Inject writes the trace context of the context to the HTTP headers.
+func Inject(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Extract returns a context holding the trace context read from the HTTP headers, if any.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// InjectMap returns the trace context of the context as a map which can be sent as part of
+// a payload, or nil if the context is not traced.
+func InjectMap(ctx context.Context) map[string]string {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier
+}
+
+// ExtractMap returns a context holding the trace context read from a map written by
+// InjectMap, if any.
+func ExtractMap(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// end records the error, if any, on the span, and ends it.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/asecurityteam/vpcflow-diffd/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newRecorder() (*tracetest.SpanRecorder, trace.Tracer) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	return recorder, provider.Tracer(InstrumentationName)
+}
+
+type fakeQueuer struct {
+	err error
+	ctx context.Context
+}
+
+func (q *fakeQueuer) Queue(ctx context.Context, d domain.Diff) error {
+	q.ctx = ctx
+	return q.err
+}
+
+func TestInjectMapUntraced(t *testing.T) {
+	assert.Nil(t, InjectMap(context.Background()))
+	assert.Equal(t, context.Background(), ExtractMap(context.Background(), nil))
+}
+
+func TestPropagation(t *testing.T) {
+	recorder, tracer := newRecorder()
+	ctx, span := tracer.Start(context.Background(), "parent")
+	carrier := InjectMap(ctx)
+	header := http.Header{}
+	Inject(ctx, header)
+	span.End()
+
+	fromMap := trace.SpanContextFromContext(ExtractMap(context.Background(), carrier))
+	fromHeader := trace.SpanContextFromContext(Extract(context.Background(), header))
+	assert.True(t, fromMap.IsRemote())
+	assert.Equal(t, span.SpanContext().TraceID(), fromMap.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), fromHeader.SpanID())
+	assert.Len(t, recorder.Ended(), 1)
+}
+
+func TestQueuer(t *testing.T) {
+	recorder, tracer := newRecorder()
+	inner := &fakeQueuer{err: errors.New("oops")}
+	q := &Queuer{Queuer: inner, Tracer: tracer}
+	assert.NotNil(t, q.Queue(context.Background(), domain.Diff{ID: "diff"}))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "queuer.queue", spans[0].Name())
+	assert.Equal(t, trace.SpanKindProducer, spans[0].SpanKind())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	// the decorated Queuer is given the span, so that it can propagate it
+	assert.Equal(t, spans[0].SpanContext().SpanID(), trace.SpanContextFromContext(inner.ctx).SpanID())
+}
+
+func TestNewMarker(t *testing.T) {
+	_, tracer := newRecorder()
+	_, ok := NewMarker(&Marker{}, tracer).(domain.LeaseMarker)
+	assert.False(t, ok)
+	_, ok = NewMarker(&LeaseMarker{}, tracer).(domain.LeaseMarker)
+	assert.True(t, ok)
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder, tracer := newRecorder()
+	ctx, parent := tracer.Start(context.Background(), "client")
+	parent.End()
+
+	var handled trace.SpanContext
+	handler := Middleware(tracer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/topic/event", nil)
+	Inject(ctx, r.Header)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	server := spans[1]
+	assert.Equal(t, "HTTP POST /topic/event", server.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), server.Parent().SpanID())
+	assert.Equal(t, server.SpanContext().SpanID(), handled.SpanID())
+	assert.Equal(t, codes.Error, server.Status().Code)
+}